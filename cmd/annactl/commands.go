@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iziplay/anna-api/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func searchCommand() *cobra.Command {
+	options := &client.SearchOptions{}
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search records by ISBN, identifier or free text",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := api.Search(cmd.Context(), strings.Join(args, " "), options)
+			if err != nil {
+				return err
+			}
+			return printJSON(cmd, result)
+		},
+	}
+	cmd.Flags().IntVar(&options.Limit, "limit", 20, "maximum number of results")
+	cmd.Flags().StringVar(&options.Cursor, "cursor", "", "next_cursor of the previous page")
+	cmd.Flags().StringSliceVar(&options.Languages, "language", nil, "language codes to filter on")
+	cmd.Flags().StringSliceVar(&options.Formats, "format", nil, "file formats to filter on (epub, pdf, mobi, azw3, cbz, djvu)")
+	return cmd
+}
+
+func recordCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "record <id>",
+		Short: "Show a record and its download status",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			record, err := api.GetRecord(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			status, err := api.DownloadStatus(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return printJSON(cmd, struct {
+				*client.Record
+				DownloadStatus client.DownloadStatus `json:"downloadStatus"`
+			}{record, status})
+		},
+	}
+}
+
+func prefetchCommand() *cobra.Command {
+	var wait bool
+	cmd := &cobra.Command{
+		Use:   "prefetch <id>...",
+		Short: "Start downloading the files of records in the background",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := api.PrefetchMany(cmd.Context(), args)
+			if err != nil {
+				return err
+			}
+			for _, id := range result.Queued {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Prefetching", id)
+			}
+			for _, rejected := range result.Rejected {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Cannot prefetch %s: %s\n", rejected.ID, rejected.Message)
+			}
+			if !wait {
+				return nil
+			}
+
+			for _, id := range result.Queued {
+				events, streamErr, err := api.DownloadProgress(cmd.Context(), id)
+				if err != nil {
+					return fmt.Errorf("%s: %w", id, err)
+				}
+				for event := range events {
+					if event.Status == client.DownloadStatusQueued {
+						fmt.Fprintf(cmd.ErrOrStderr(), "\r%s queued (#%d)", id, event.Position)
+						continue
+					}
+					fmt.Fprintf(cmd.ErrOrStderr(), "\r%s %5.1f%%      ", id, event.Percent)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr())
+				if err := streamErr(); err != nil {
+					return fmt.Errorf("%s: %w", id, err)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&wait, "wait", false, "show the progress and wait for the downloads to complete")
+	return cmd
+}
+
+func downloadCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "download <id>",
+		Short: "Download the file of a record",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := api.Download(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			if output == "" {
+				output = strings.ReplaceAll(filepath.Base(file.Name), ":", "_")
+				if output == "" || output == "." {
+					output = strings.ReplaceAll(args[0], ":", "_")
+				}
+			}
+			var w io.Writer = cmd.OutOrStdout()
+			if output != "-" {
+				file, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+
+			n, err := io.Copy(w, file)
+			if err != nil {
+				return err
+			}
+			if output != "-" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Saved %s (%d bytes)\n", output, n)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write, - for stdout (default <id>.<format>)")
+	return cmd
+}
+
+func syncCommand() *cobra.Command {
+	var dryRun, force, reprocess, rollback, torrents bool
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Trigger a sync with the latest metadata torrent (admin scope)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if torrents {
+				count, err := api.RefreshTorrents(cmd.Context())
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "Torrents list refreshed, %d torrents\n", count)
+				return nil
+			}
+			if rollback {
+				result, err := api.RollbackSync(cmd.Context())
+				if err != nil {
+					return err
+				}
+				return printJSON(cmd, result)
+			}
+			trigger := api.TriggerSync
+			if dryRun {
+				trigger = api.TriggerDryRunSync
+			}
+			if force {
+				trigger = api.TriggerForcedSync
+			}
+			if reprocess {
+				trigger = api.TriggerReprocessSync
+			}
+			if err := trigger(cmd.Context()); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.ErrOrStderr(), "Sync started, follow it with annactl stats --sync")
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only count the records the sync would write")
+	cmd.Flags().BoolVar(&force, "force", false, "process every metadata file again, even if the last metadata torrent was already synced")
+	cmd.Flags().BoolVar(&reprocess, "reprocess", false, "process again the metadata files of the last sync kept on disk by the server")
+	cmd.Flags().BoolVar(&rollback, "rollback", false, "restore the records as they were before the last sync instead")
+	cmd.Flags().BoolVar(&torrents, "torrents", false, "only refresh the torrents list instead")
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "force", "reprocess", "rollback", "torrents")
+	return cmd
+}
+
+func statsCommand() *cobra.Command {
+	var sync, history, torrent bool
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show database statistics",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case sync:
+				stats, err := api.SyncStatistics(cmd.Context())
+				if err != nil {
+					return err
+				}
+				return printJSON(cmd, stats)
+			case history:
+				syncs, err := api.SyncHistory(cmd.Context(), 0)
+				if err != nil {
+					return err
+				}
+				return printJSON(cmd, syncs)
+			case torrent:
+				status, err := api.TorrentStatistics(cmd.Context())
+				if err != nil {
+					return err
+				}
+				_, err = fmt.Fprint(cmd.OutOrStdout(), status)
+				return err
+			default:
+				stats, err := api.Statistics(cmd.Context())
+				if err != nil {
+					return err
+				}
+				return printJSON(cmd, stats)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&sync, "sync", false, "show the progress of the running sync instead")
+	cmd.Flags().BoolVar(&history, "history", false, "show the past syncs instead")
+	cmd.Flags().BoolVar(&torrent, "torrent", false, "show the torrent client status instead")
+	cmd.MarkFlagsMutuallyExclusive("sync", "history", "torrent")
+	return cmd
+}
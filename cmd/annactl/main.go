@@ -0,0 +1,55 @@
+// Command annactl talks to an Anna API server: search, record info, prefetch,
+// download, sync and statistics.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var api *client.Client
+
+func main() {
+	var server, token string
+	root := &cobra.Command{
+		Use:           "annactl",
+		Short:         "Command line client of the Anna API",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			api = client.New(server,
+				client.WithToken(token),
+				client.WithHTTPClient(&http.Client{Timeout: 10 * time.Minute}),
+			)
+		},
+	}
+	root.PersistentFlags().StringVar(&server, "server", envOr("ANNACTL_SERVER", "http://localhost"), "API base URL (ANNACTL_SERVER)")
+	root.PersistentFlags().StringVar(&token, "token", os.Getenv("ANNACTL_TOKEN"), "bearer token for protected operations (ANNACTL_TOKEN)")
+
+	root.AddCommand(searchCommand(), recordCommand(), prefetchCommand(), downloadCommand(), syncCommand(), statsCommand())
+
+	if err := root.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(cmd *cobra.Command, v any) error {
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -2,27 +2,44 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	anna "github.com/iziplay/anna-api"
+	annaapi "github.com/iziplay/anna-api"
+	"github.com/iziplay/anna-api/pkg/anna"
 	routing "github.com/iziplay/anna-api/pkg/api"
+	"github.com/iziplay/anna-api/pkg/auth"
+	"github.com/iziplay/anna-api/pkg/config"
 	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/export"
+	"github.com/iziplay/anna-api/pkg/rpc"
 	"github.com/iziplay/anna-api/pkg/sync"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"google.golang.org/grpc"
 	"gorm.io/plugin/opentelemetry/tracing"
 )
 
@@ -30,10 +47,8 @@ func init() {
 	database.Ping()
 }
 
-func getLogLevelFromEnv() slog.Level {
-	levelStr := os.Getenv("LOG_LEVEL")
-
-	switch strings.ToLower(levelStr) {
+func getLogLevel() slog.Level {
+	switch strings.ToLower(config.C.LogLevel) {
 	case "debug":
 		return slog.LevelDebug
 	case "warn":
@@ -45,27 +60,59 @@ func getLogLevelFromEnv() slog.Level {
 	}
 }
 
+// pprofHandler serves the net/http/pprof profiles under /debug/pprof/.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
 func main() {
-	ctx := context.Background()
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: getLogLevelFromEnv()})))
+	role := flag.String("role", config.C.Role, "what to run: all, api (no sync) or sync (sync worker) (ANNA_ROLE)")
+	forceSync := flag.Bool("force-sync", false, "sync at startup, processing every metadata file again even if the last metadata torrent was already synced")
+	flag.Parse()
+	config.C.Role = *role
+	if err := config.C.Validate(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: getLogLevel()})))
 
 	exp, err := otlptracegrpc.New(ctx)
 	if err != nil {
 		panic(err)
 	}
 
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("anna-api"),
+	)
+
 	tp := sdktrace.NewTracerProvider(
 		sdktrace.WithSampler(sdktrace.AlwaysSample()),
 		sdktrace.WithBatcher(exp),
-		sdktrace.WithResource(
-			resource.NewWithAttributes(
-				semconv.SchemaURL,
-				semconv.ServiceName("anna-api"),
-			),
-		),
+		sdktrace.WithResource(res),
+	)
+
+	metricExp, err := otlpmetricgrpc.New(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExp)),
+		sdkmetric.WithResource(res),
 	)
 
 	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
 	otel.SetTextMapPropagator(
 		propagation.NewCompositeTextMapPropagator(
 			propagation.TraceContext{},
@@ -73,60 +120,146 @@ func main() {
 		),
 	)
 
+	if err := database.Connect(); err != nil {
+		slog.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
+	}
 	database.DB.Use(tracing.NewPlugin())
 
+	if config.C.DownloadsTorrents() {
+		if err := anna.StartClient(); err != nil {
+			slog.Error("Failed to start the torrent client", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	router := chi.NewRouter()
 
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Origin", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Server"},
+		AllowedHeaders:   []string{"Origin", "Authorization", "Content-Type", "If-None-Match", "If-Modified-Since", "X-Request-ID"},
+		ExposedHeaders:   []string{"Server", "ETag", "Retry-After", "X-Request-ID", "Deprecation", "Link"},
 		AllowCredentials: false,
 	}))
 
-	addr := ":80"
-	if port, hasPort := os.LookupEnv("API_PORT"); hasPort {
+	// Compress text payloads only: epubs and covers are already compressed and
+	// SSE streams must not be buffered.
+	compressor := middleware.NewCompressor(5,
+		"application/json",
+		"application/problem+json",
+		"application/ld+json",
+		"application/x-ndjson",
+		"application/xml",
+		"application/marcxml+xml",
+		"application/x-bibtex",
+		"application/x-research-info-systems",
+		"application/vnd.oai.openapi+json",
+		"application/vnd.oai.openapi+yaml",
+		"text/html",
+		"text/plain",
+	)
+	compressor.SetEncoder("br", func(w io.Writer, level int) io.Writer {
+		return brotli.NewWriterLevel(w, level)
+	})
+	router.Use(compressor.Handler)
+
+	tlsConfig, challengeHandler, err := loadTLSConfig(config.C.TLS)
+	if err != nil {
+		slog.Error("Invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	addr, scheme := ":80", "http"
+	if tlsConfig != nil {
+		addr, scheme = ":443", "https"
+	}
+	if port := config.C.API.Port; port != "" {
 		addr = ":" + port
 	}
 
-	host := "http://localhost"
-	if hostEnv, hasHost := os.LookupEnv("API_HOST"); hasHost {
-		host = hostEnv
+	host := scheme + "://localhost"
+	if config.C.API.Host != "" {
+		host = config.C.API.Host
 	} else {
 		host += addr
 	}
 
-	config := huma.DefaultConfig("Anna API", "1.0.0")
-	config.OpenAPI.Info.Description = anna.Readme
-	config.OpenAPI.Components.SecuritySchemes = map[string]*huma.SecurityScheme{
+	humaConfig := huma.DefaultConfig("Anna API", "1.0.0")
+	humaConfig.OpenAPI.Info.Description = annaapi.Readme
+	humaConfig.OpenAPI.Components.SecuritySchemes = map[string]*huma.SecurityScheme{
 		"bearerAuth": {
 			Type:         "http",
 			Scheme:       "bearer",
 			BearerFormat: "JWT",
 		},
 	}
-	config.DocsPath = "/"
-	config.Servers = []*huma.Server{
+	humaConfig.Transformers = append(humaConfig.Transformers, routing.ErrorTransformer)
+	humaConfig.DocsPath = "/"
+	humaConfig.Servers = []*huma.Server{
 		{URL: host},
 	}
-	api := humachi.New(router, config)
+	api := humachi.New(router, humaConfig)
 
 	routing.Setup(api)
 
+	// Profiling endpoints, e.g. `go tool pprof "$HOST/debug/pprof/heap?jwt=$TOKEN"` during a sync
+	router.Handle("/debug/pprof/*", auth.RequireScope(pprofHandler(), routing.AdminScope))
+
 	server := &http.Server{
-		Addr:    addr,
-		Handler: otelhttp.NewHandler(router, "api"),
+		Addr:      addr,
+		Handler:   otelhttp.NewHandler(router, "api"),
+		TLSConfig: tlsConfig,
 	}
+	servers := []*http.Server{server}
 
 	go func() {
-		slog.Info("Starting server", "addr", addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Info("Starting server", "addr", addr, "tls", tlsConfig != nil)
+		var err error
+		if tlsConfig != nil {
+			// Certificates come from the TLS config
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("Server failed", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	if challengeHandler != nil {
+		// Let's Encrypt HTTP-01 challenges, other requests are redirected to HTTPS
+		challengeAddr := ":" + config.C.TLS.ACMEHTTPPort
+		challengeServer := &http.Server{Addr: challengeAddr, Handler: challengeHandler}
+		servers = append(servers, challengeServer)
+		go func() {
+			slog.Info("Starting ACME challenge server", "addr", challengeAddr)
+			if err := challengeServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("ACME challenge server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	var grpcServer *grpc.Server
+	if port := config.C.API.GRPCPort; port != "" && config.C.RunsAPI() {
+		grpcAddr := ":" + port
+		grpcServer = rpc.NewServer()
+		go func() {
+			listener, err := net.Listen("tcp", grpcAddr)
+			if err != nil {
+				slog.Error("gRPC server failed to listen", "error", err)
+				os.Exit(1)
+			}
+			slog.Info("Starting gRPC server", "addr", grpcAddr)
+			if err := grpcServer.Serve(listener); err != nil {
+				slog.Error("gRPC server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Run migration after the server is listening so /healthz is already live.
 	if err := database.AutoMigrate(); err != nil {
 		slog.Error("Failed to auto migrate", "error", err)
@@ -134,14 +267,67 @@ func main() {
 	}
 
 	go database.ComputeAndCacheStats(false)
+	go anna.RunJanitor(ctx)
+	go anna.RunMemoryWatchdog(ctx)
+	if config.C.RunsAPI() {
+		go anna.RunSeeder(ctx)
+		routing.ResumeDownloads(ctx)
+		go routing.RunCacheWarmer(ctx)
+	}
+	if config.C.Export.Path != "" {
+		sink, err := export.NewSink(config.C.Export)
+		if err != nil {
+			slog.Error("Invalid export configuration", "error", err)
+			os.Exit(1)
+		}
+		sync.RegisterHook("export", sink)
+	}
+	slog.Info("Running", "role", config.C.Role)
 
-	for {
-		ctx := context.Background()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The sync is forced the first time this instance leads only
+		force := *forceSync
+		sync.Lead(ctx, func(ctx context.Context) {
+			syncLoop(ctx, force)
+			force = false
+		})
+	}()
 
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down", "timeout", config.C.API.ShutdownTimeout)
+
+	shutdown(servers, grpcServer, done)
+	if err := tp.Shutdown(context.Background()); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
+	}
+	if err := mp.Shutdown(context.Background()); err != nil {
+		slog.Warn("Failed to flush metrics", "error", err)
+	}
+}
+
+const (
+	// syncRetryDelay is the delay before retrying a failed sync, doubled after
+	// each failure up to syncMaxRetryDelay.
+	syncRetryDelay    = time.Minute
+	syncMaxRetryDelay = 6 * time.Hour
+)
+
+// syncLoop runs a sync every 24 hours until ctx is done, the first one right
+// away and forced when force is set. Failed syncs are retried with an
+// exponential backoff.
+func syncLoop(ctx context.Context, force bool) {
+	failures := 0
+	for {
 		// Calculate time until next sync
 		var sleepDuration time.Duration
 		lastSync, err := sync.GetLastSync(ctx)
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			slog.Error("Failed to get last sync", "error", err)
 			os.Exit(1)
 		} else {
@@ -150,17 +336,95 @@ func main() {
 			}
 
 			// If sleep duration is negative or very small, sync immediately
-			if sleepDuration <= 0 {
+			if sleepDuration <= 0 || force {
 				sleepDuration = 0
 			}
+			// Failed syncs are not retried right away, the last successful one
+			// may be more than a day old
+			if failures > 0 && !force {
+				sleepDuration = max(sleepDuration, min(syncRetryDelay<<(failures-1), syncMaxRetryDelay))
+			}
 		}
 
 		slog.Info("Next sync scheduled", "in", sleepDuration)
-		time.Sleep(sleepDuration)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sleepDuration):
+		}
 
 		// Perform sync
-		if err := sync.Sync(ctx); err != nil {
-			slog.Error("Sync failed", "error", err)
+		run := sync.Sync
+		if force {
+			run, force = sync.Force, false
+		}
+		err = run(ctx)
+		switch {
+		case err == nil:
+			failures = 0
+		case errors.Is(err, sync.ErrAlreadyRunning):
+			slog.Info("Skipping scheduled sync, a sync is already running")
+		case ctx.Err() != nil, errors.Is(err, sync.ErrStopped):
+			return
+		default:
+			// The shift is bounded, the delay is capped long before it overflows
+			failures = min(failures+1, 20)
+			slog.Error("Sync failed", "error", err, "failures", failures)
 		}
 	}
 }
+
+// shutdown stops accepting requests, then waits for the sync loop, in-flight
+// requests (SSE progress streams end with their download) and downloads before
+// closing the torrent client and the database pool.
+func shutdown(servers []*http.Server, grpcServer *grpc.Server, syncDone <-chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.C.API.ShutdownTimeout)
+	defer cancel()
+
+	// The servers stop accepting requests at once and drain the running ones
+	// while the sync stops, each over the whole timeout
+	drained := make(chan struct{}, len(servers)+1)
+	if grpcServer != nil {
+		go func() {
+			defer func() { drained <- struct{}{} }()
+			go func() {
+				<-ctx.Done()
+				grpcServer.Stop()
+			}()
+			grpcServer.GracefulStop()
+		}()
+	} else {
+		drained <- struct{}{}
+	}
+	for _, server := range servers {
+		go func() {
+			defer func() { drained <- struct{}{} }()
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Warn("Server did not drain in time, closing connections", "addr", server.Addr, "error", err)
+				server.Close()
+			}
+		}()
+	}
+
+	// A cancelled sync saves its progress, the next start resumes it
+	if err := sync.Stop(ctx); err != nil {
+		slog.Warn("Sync did not stop in time", "error", err)
+	}
+	select {
+	case <-syncDone:
+	case <-ctx.Done():
+	}
+	for range len(servers) + 1 {
+		<-drained
+	}
+
+	if err := anna.WaitDownloads(ctx); err != nil {
+		slog.Warn("Downloads did not complete in time", "error", err)
+	}
+
+	anna.Close()
+	if err := database.Close(); err != nil {
+		slog.Warn("Failed to close database", "error", err)
+	}
+	slog.Info("Shutdown complete")
+}
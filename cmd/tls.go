@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/iziplay/anna-api/pkg/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// loadTLSConfig returns the TLS configuration of the API, or nil to serve plain HTTP.
+//
+// Certificates are either loaded from the configured files, or obtained from
+// Let's Encrypt for the ACME domains. In the latter case the returned handler
+// answers HTTP-01 challenges and redirects everything else to HTTPS.
+func loadTLSConfig(c config.TLS) (*tls.Config, http.Handler, error) {
+	switch {
+	case c.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		slog.Info("Using TLS certificate", "cert", c.CertFile)
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil, nil
+	case len(c.ACMEDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.ACMEDomains...),
+			Cache:      autocert.DirCache(c.ACMECacheDir),
+			Email:      c.ACMEEmail,
+		}
+		slog.Info("Using Let's Encrypt certificates", "domains", c.ACMEDomains)
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, manager.HTTPHandler(nil), nil
+	default:
+		return nil, nil, nil
+	}
+}
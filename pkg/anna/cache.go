@@ -0,0 +1,194 @@
+package anna
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// EpubCacheMaxSize is the maximum size in bytes of the files of the storage
+// directory, the least recently used ones are evicted beyond it. Zero means
+// unlimited.
+var EpubCacheMaxSize = int64(config.C.Anna.EpubCacheMaxSize)
+
+var meter = otel.Meter("github.com/iziplay/anna-api/pkg/anna")
+
+var cacheEvictions metric.Int64Counter
+
+func init() {
+	var err error
+	cacheEvictions, err = meter.Int64Counter("anna.epub_cache.evictions",
+		metric.WithDescription("Number of files evicted from the storage directory"))
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := meter.Int64ObservableGauge("anna.epub_cache.size",
+		metric.WithDescription("Size of the files of the storage directory"),
+		metric.WithUnit("By"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(cache.stats().Size)
+			return nil
+		})); err != nil {
+		panic(err)
+	}
+
+	if _, err := meter.Int64ObservableGauge("anna.epub_cache.files",
+		metric.WithDescription("Number of files of the storage directory"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(cache.stats().Files))
+			return nil
+		})); err != nil {
+		panic(err)
+	}
+}
+
+// CacheStats describes the files of the storage directory.
+type CacheStats struct {
+	Files     int          `json:"files"`
+	Size      int64        `json:"size"`
+	MaxSize   int64        `json:"max_size,omitempty"`
+	Evictions int64        `json:"evictions"`
+	Janitor   JanitorStats `json:"janitor"`
+}
+
+// cacheEntry is a file of the storage directory.
+type cacheEntry struct {
+	name     string
+	size     int64
+	accessed time.Time
+}
+
+// fileCache tracks the files of the storage directory by last access, to evict
+// the least recently used ones when the directory grows over EpubCacheMaxSize.
+// Access times are kept in memory, they start from the modification times of
+// the files on startup.
+type fileCache struct {
+	mu        sync.Mutex
+	loaded    bool
+	entries   map[string]*list.Element
+	order     *list.List // of *cacheEntry, most recently used first
+	size      int64
+	evictions int64
+}
+
+var cache = &fileCache{entries: map[string]*list.Element{}, order: list.New()}
+
+// load lists the storage directory on first use. It must be called with mu held.
+func (c *fileCache) load() {
+	if c.loaded || EpubStorageDir == "" {
+		return
+	}
+	c.loaded = true
+
+	dirEntries, err := os.ReadDir(EpubStorageDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to list storage directory", "dir", EpubStorageDir, "error", err)
+		}
+		return
+	}
+
+	var files []*cacheEntry
+	for _, entry := range dirEntries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, &cacheEntry{name: entry.Name(), size: info.Size(), accessed: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].accessed.After(files[j].accessed) })
+	for _, file := range files {
+		c.entries[file.name] = c.order.PushBack(file)
+		c.size += file.size
+	}
+}
+
+// touch marks a file as just read.
+func (c *fileCache) touch(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	if element, ok := c.entries[name]; ok {
+		element.Value.(*cacheEntry).accessed = time.Now()
+		c.order.MoveToFront(element)
+	}
+}
+
+// add records a file just written, then evicts files over the maximum size.
+func (c *fileCache) add(name string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	if element, ok := c.entries[name]; ok {
+		c.size -= element.Value.(*cacheEntry).size
+		c.order.Remove(element)
+	}
+	c.entries[name] = c.order.PushFront(&cacheEntry{name: name, size: size, accessed: time.Now()})
+	c.size += size
+	c.evict()
+}
+
+// remove forgets a file removed from the storage directory.
+func (c *fileCache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[name]; ok {
+		c.size -= element.Value.(*cacheEntry).size
+		c.order.Remove(element)
+		delete(c.entries, name)
+	}
+}
+
+// evict removes the least recently used files until the directory fits in
+// EpubCacheMaxSize, always keeping the most recent one and the files being
+// downloaded. It must be called with mu held.
+func (c *fileCache) evict() {
+	if EpubCacheMaxSize <= 0 {
+		return
+	}
+	for element := c.order.Back(); element != nil && element != c.order.Front() && c.size > EpubCacheMaxSize; {
+		entry := element.Value.(*cacheEntry)
+		previous := element.Prev()
+		if _, ok := activeDownloads.Load(entry.name); !ok {
+			if err := os.Remove(filepath.Join(EpubStorageDir, entry.name)); err != nil && !os.IsNotExist(err) {
+				slog.Warn("Failed to evict stored file", "name", entry.name, "error", err)
+			} else {
+				slog.Info("Evicted stored file", "name", entry.name, "size", entry.size, "accessed", entry.accessed)
+				c.size -= entry.size
+				c.order.Remove(element)
+				delete(c.entries, entry.name)
+				c.evictions++
+				cacheEvictions.Add(context.Background(), 1)
+			}
+		}
+		element = previous
+	}
+}
+
+func (c *fileCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	return CacheStats{Files: len(c.entries), Size: c.size, MaxSize: max(EpubCacheMaxSize, 0), Evictions: c.evictions}
+}
+
+// GetCacheStats returns the size of the storage directory, the number of
+// files evicted from it since startup and the state of the janitor.
+func GetCacheStats() CacheStats {
+	stats := cache.stats()
+	stats.Janitor = GetJanitorStats()
+	return stats
+}
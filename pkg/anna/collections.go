@@ -0,0 +1,46 @@
+package anna
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+// TorrentCollection returns the collection of a torrent classification of a
+// record: the directory of the torrent under its group, e.g. "zlib" for
+// "managed_by_aa/zlib/pilimi-zlib-6160000-7229999.torrent" or "libgen_li_fic"
+// for "external/libgen_li_fic/f_0.torrent".
+func TorrentCollection(torrent string) string {
+	parts := strings.Split(torrent, "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// inCollections reports whether collection is one of collections, or one of
+// their variants: libgen_li matches libgen_li_fic and libgen_li_comics. Dots
+// stand for underscores, libgen.li is libgen_li.
+func inCollections(collection string, collections []string) bool {
+	return slices.ContainsFunc(collections, func(c string) bool {
+		c = strings.ReplaceAll(c, ".", "_")
+		return collection == c || strings.HasPrefix(collection, c+"_")
+	})
+}
+
+// IsSyncedCollection reports whether the files of the given collection are
+// synced: one of ANNA_SYNC_COLLECTIONS when set, and none of
+// ANNA_SYNC_EXCLUDE_COLLECTIONS.
+func IsSyncedCollection(collection string) bool {
+	if collections := config.C.Anna.SyncCollections; len(collections) > 0 && !inCollections(collection, collections) {
+		return false
+	}
+	return !inCollections(collection, config.C.Anna.SyncExcludeCollections)
+}
+
+// FiltersCollections reports whether the synced records are filtered by
+// collection.
+func FiltersCollections() bool {
+	return len(config.C.Anna.SyncCollections) > 0 || len(config.C.Anna.SyncExcludeCollections) > 0
+}
@@ -14,14 +14,15 @@ import (
 	"path"
 	"regexp"
 	"sort"
-	"strconv"
 	"sync"
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/iziplay/anna-api/pkg/config"
+	"golang.org/x/time/rate"
 )
 
-var DataDir = "/tmp/anna-torrents"
+var DataDir = config.C.Anna.TorrentDataDir
 
 // digitPattern matches the digit in filenames like "aarecords__7.json.gz"
 var digitPattern = regexp.MustCompile(`aarecords__(\d+)\.json\.gz$`)
@@ -40,13 +41,6 @@ func ExtractFileIndex(path string) (int, error) {
 	return index, nil
 }
 
-func init() {
-	if dir, ok := os.LookupEnv("ANNA_TORRENT_DATA_DIR"); ok {
-		slog.Info("Using custom Anna torrent data dir", "dir", dir)
-		DataDir = dir
-	}
-}
-
 // RecordProcessor is a callback function that processes a single record
 type RecordProcessor func(record *Record) error
 
@@ -54,71 +48,175 @@ type RecordProcessor func(record *Record) error
 type FileResult struct {
 	FilePath    string
 	RecordCount int
+	// ParseErrors are the lines that could not be parsed
+	ParseErrors int
 	Error       error
 }
 
+// client is the torrent client, nil until StartClient is called.
 var client *torrent.Client
 
-func init() {
+// errNoClient is returned when a torrent is added without a torrent client.
+var errNoClient = errors.New("torrent client not started")
+
+// StartClient creates the torrent client, for the processes that download
+// files or metadata from torrents.
+func StartClient() error {
+	var err error
+	client, err = torrent.NewClient(clientConfig(config.C.Anna))
+	return err
+}
+
+// clientConfig returns the configuration of the torrent client, the defaults
+// of the library tuned by the ANNA_TORRENT_* settings.
+func clientConfig(c config.Anna) *torrent.ClientConfig {
 	cfg := torrent.NewDefaultClientConfig()
 	cfg.DataDir = DataDir
 	cfg.Seed = true // we drop torrents manually after processing
 
-	port := 42069
-	if p := os.Getenv("ANNA_TORRENT_PORT"); p != "" {
-		if parsed, err := strconv.Atoi(p); err == nil {
-			port = parsed
-		}
+	cfg.ListenPort = c.TorrentPort
+	cfg.NoDHT = c.TorrentDisableDHT
+	cfg.DisablePEX = c.TorrentDisablePEX
+	if c.TorrentUploadRate > 0 {
+		// A zero burst is set by the client to the largest piece request
+		cfg.UploadRateLimiter = rate.NewLimiter(rate.Limit(c.TorrentUploadRate), 0)
+	}
+	if c.TorrentMaxConnections > 0 {
+		cfg.EstablishedConnsPerTorrent = c.TorrentMaxConnections
 	}
-	cfg.ListenPort = port
+	return cfg
+}
 
-	var err error
-	client, err = torrent.NewClient(cfg)
+// addMagnet adds a torrent to the client, with the extra trackers of
+// ANNA_TORRENT_TRACKERS and the given webseeds. The webseeds of the magnet
+// link (ws parameters), if any, are used too.
+func addMagnet(magnetLink string, webseeds []string) (*torrent.Torrent, error) {
+	if client == nil {
+		return nil, errNoClient
+	}
+	t, err := client.AddMagnet(magnetLink)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if trackers := config.C.Anna.TorrentTrackers; len(trackers) > 0 {
+		t.AddTrackers([][]string{trackers})
+	}
+	if len(webseeds) > 0 {
+		t.AddWebSeeds(webseeds)
+	}
+	return t, nil
+}
+
+// Close drops all torrents and stops the torrent client, if started.
+func Close() {
+	if client != nil {
+		client.Close()
 	}
 }
 
 func GetTorrentStats() string {
+	if client == nil {
+		return errNoClient.Error()
+	}
 	status := &bytes.Buffer{}
 	client.WriteStatus(status)
 	return status.String()
 }
 
+// fileSelected returns whether the metadata file of the given index is synced,
+// according to ANNA_ARCHIVE_ID, ANNA_SYNC_FILES, ANNA_SYNC_EXCLUDE_FILES and
+// ANNA_SYNC_SHARD.
+func fileSelected(c config.Anna, index int) bool {
+	if !c.SyncShard.Contains(index) {
+		return false
+	}
+	if len(c.ArchiveID) > 0 && !c.ArchiveID.Contains(index) {
+		return false
+	}
+	if len(c.SyncFiles) > 0 && !c.SyncFiles.Contains(index) {
+		return false
+	}
+	return !c.SyncExcludeFiles.Contains(index)
+}
+
 type StatsType string
 
 const (
 	StatsTypeFileDownload   StatsType = "download"
 	StatsTypeFileProcessing StatsType = "processing"
+	// StatsTypeRecords and StatsTypeBytes report the records processed and
+	// the compressed bytes read so far, before each processing percentage
+	StatsTypeRecords StatsType = "records"
+	StatsTypeBytes   StatsType = "bytes"
+	// StatsTypeParseErrors reports the lines that could not be parsed so far
+	StatsTypeParseErrors StatsType = "parse_errors"
+	// StatsTypeVerification reports the pieces of a downloaded file that
+	// failed their hash check, 0 when it is intact
+	StatsTypeVerification StatsType = "verification"
+	// StatsTypeSwarmPeers, StatsTypeSwarmSeeders, StatsTypeSwarmRemaining and
+	// StatsTypeSwarmCompleted report the peers and seeders connected to the
+	// metadata torrent, and the bytes of its files left to download and
+	// downloaded so far, with an empty path
+	StatsTypeSwarmPeers     StatsType = "swarm_peers"
+	StatsTypeSwarmSeeders   StatsType = "swarm_seeders"
+	StatsTypeSwarmRemaining StatsType = "swarm_remaining"
+	StatsTypeSwarmCompleted StatsType = "swarm_completed"
 )
 
+// checkpointInterval is the number of lines of a file between two checkpoints
+const checkpointInterval = 100000
+
+// Checkpoint is the progress of the processing of a metadata file.
+type Checkpoint struct {
+	Lines       int  // lines read
+	Records     int  // records processed among them
+	ParseErrors int  // lines that could not be parsed among them
+	Done        bool // the whole file was processed
+}
+
 type Processor interface {
 	Files(ctx context.Context, paths []string)
 	Stats(ctx context.Context, path string, key StatsType, value float64)
-	Record(ctx context.Context, record *Record)
+	Record(ctx context.Context, path string, record *Record)
+	// Checkpoint is called every checkpointInterval lines of a file, once it
+	// is processed and when the sync is cancelled (with a context that is
+	// not). The records before it must be saved on return
+	Checkpoint(ctx context.Context, path string, checkpoint Checkpoint)
+	// Resume returns the last checkpoint of a file, the lines before it are skipped
+	Resume(ctx context.Context, path string) Checkpoint
+	// FileError is called when the processing of a file fails, the other
+	// files are still processed
+	FileError(ctx context.Context, path string, err error)
 }
 
 // DownloadAndProcessRecords downloads torrent files and processes records in parallel as they download
 func DownloadAndProcessRecords(ctx context.Context, torrentResponse *TorrentsResponse, processor Processor) ([]FileResult, error) {
-	t, err := client.AddMagnet(torrentResponse.MagnetLink)
+	t, err := addMagnet(torrentResponse.MagnetLink, config.C.Anna.MetadataWebseeds)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add magnet: %w", err)
 	}
 
 	slog.Info("Waiting for torrent info...")
-	<-t.GotInfo()
+	select {
+	case <-t.GotInfo():
+	case <-ctx.Done():
+		t.Drop()
+		return nil, ctx.Err()
+	}
 
 	filePattern := regexp.MustCompile(`elasticsearch/aarecords__\d+\.json\.gz$`)
-	if os.Getenv("ANNA_ARCHIVE_ID") != "" {
-		filePattern = regexp.MustCompile(fmt.Sprintf(`elasticsearch/aarecords__%s\.json\.gz$`, os.Getenv("ANNA_ARCHIVE_ID")))
-	}
 
 	var matchedFiles []*torrent.File
 	for _, file := range t.Files() {
-		if filePattern.MatchString(file.Path()) {
-			matchedFiles = append(matchedFiles, file)
-			slog.Info("Found matching file", "path", file.Path())
+		if !filePattern.MatchString(file.Path()) {
+			continue
 		}
+		if index, _ := ExtractFileIndex(path.Base(file.Path())); !fileSelected(config.C.Anna, index) {
+			slog.Info("Skipping excluded file", "path", file.Path())
+			continue
+		}
+		matchedFiles = append(matchedFiles, file)
+		slog.Info("Found matching file", "path", file.Path())
 	}
 
 	if len(matchedFiles) == 0 {
@@ -133,11 +231,17 @@ func DownloadAndProcessRecords(ctx context.Context, torrentResponse *TorrentsRes
 		return indexI < indexJ
 	})
 
-	// Initialize stats with file names
+	// Initialize stats with file names. The files processed by a previous
+	// run of the sync of this base are not downloaded again
 	fileNames := make([]string, len(matchedFiles))
+	var downloading []*torrent.File
 	for i, file := range matchedFiles {
 		fileNames[i] = file.Path()
+		if processor.Resume(ctx, file.Path()).Done {
+			continue
+		}
 		file.Download()
+		downloading = append(downloading, file)
 	}
 	processor.Files(ctx, fileNames)
 
@@ -149,22 +253,24 @@ func DownloadAndProcessRecords(ctx context.Context, torrentResponse *TorrentsRes
 	var wg sync.WaitGroup
 	for _, file := range matchedFiles {
 		wg.Add(1)
-		base := path.Base(file.Path())
-		index, err := ExtractFileIndex(base)
-		if err != nil {
-			panic(err)
-		}
-		go func(index int, f *torrent.File) {
+		go func(f *torrent.File) {
 			defer wg.Done()
-			result := processFileWhileDownloading(ctx, index, f, processor)
-			if result.Error != nil {
-				e := fmt.Errorf("cannot process file %d: %w", index, result.Error)
-				panic(e)
+			var result FileResult
+			if index, err := ExtractFileIndex(path.Base(f.Path())); err != nil {
+				result = FileResult{FilePath: f.Path(), Error: err}
+			} else {
+				result = processFileWhileDownloading(ctx, index, f, processor)
+			}
+			// A cancelled sync is reported through the results, without failing the file
+			if result.Error != nil && ctx.Err() == nil {
+				slog.Error("Failed to process file, continuing with the others", "path", f.Path(), "error", result.Error)
+				f.SetPriority(torrent.PiecePriorityNone)
+				processor.FileError(ctx, f.Path(), result.Error)
 			}
 			resultsMu.Lock()
 			results = append(results, result)
 			resultsMu.Unlock()
-		}(index, file)
+		}(file)
 	}
 
 	// Start progress monitoring in background
@@ -177,7 +283,7 @@ func DownloadAndProcessRecords(ctx context.Context, torrentResponse *TorrentsRes
 			case <-done:
 				return
 			case <-ticker.C:
-				updateProgress(ctx, matchedFiles, processor)
+				updateProgress(ctx, t, downloading, processor)
 			}
 		}
 	}()
@@ -189,160 +295,164 @@ func DownloadAndProcessRecords(ctx context.Context, torrentResponse *TorrentsRes
 	// Drop the torrent to free resources - files will remain on disk for any post-processing if needed
 	t.Drop()
 
-	slog.Info("All files processed successfully")
+	slog.Info("All files processed")
 
 	return results, nil
 }
 
 // processFileWhileDownloading reads and processes a gz file while it's being downloaded
 func processFileWhileDownloading(ctx context.Context, index int, file *torrent.File, processor Processor) FileResult {
-	result := FileResult{
-		FilePath: file.Path(),
+	open := func() (io.ReadCloser, error) {
+		// Get a reader from the torrent file - this will block until data is available
+		reader := file.NewReader()
+		// Unblock pending reads when the sync is cancelled
+		reader.SetContext(ctx)
+
+		// Set read-ahead to allow sequential reading with some buffer
+		reader.SetReadahead(readahead())
+		return reader, nil
 	}
-
-	slog.Info("Starting to process file", "index", index, "path", file.Path())
-
-	// Get a reader from the torrent file - this will block until data is available
-	reader := file.NewReader()
-	defer reader.Close()
-
-	// Set read-ahead to allow sequential reading with some buffer
-	reader.SetReadahead(10 * 1024 * 1024) // 10MB read-ahead
-
-	// Create gzip reader directly from torrent stream
-	gzReader, err := gzip.NewReader(reader)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to create gzip reader: %w", err)
-		return result
+	// Approximate progress based on bytes downloaded of the compressed stream
+	progress := func() float64 {
+		if file.Length() == 0 {
+			return 0
+		}
+		return float64(file.BytesCompleted()) / float64(file.Length()) * 100
 	}
-	defer gzReader.Close()
-
-	// Create buffered reader for line-by-line processing
-	bufReader := bufio.NewReaderSize(gzReader, 4*1024*1024) // 4MB buffer
-
-	recordCount := 0
-	lineCount := 0
-
-	for {
-		line, err := bufReader.ReadString('\n')
+	verify := func() error {
+		corrupt, err := verifyPieces(ctx, file)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			if errors.Is(err, io.ErrUnexpectedEOF) {
-				slog.Warn("Unexpected EOF reached, ending processing", "line", lineCount+1)
-				break
-			}
-			result.Error = fmt.Errorf("read error at line %d: %w", lineCount+1, err)
-			return result
+			return fmt.Errorf("failed to verify file: %w", err)
 		}
-
-		lineCount++
-
-		var record Record
-		if err := json.Unmarshal([]byte(line), &record); err != nil {
-			slog.Warn("Failed to parse JSON, skipping", "line", lineCount, "file", file.Path(), "error", err)
-			continue
+		processor.Stats(ctx, file.Path(), StatsTypeVerification, float64(corrupt))
+		if corrupt > 0 {
+			return fmt.Errorf("%d pieces failed their hash check, they are downloaded again by the next sync", corrupt)
 		}
+		return nil
+	}
+	return processFile(ctx, index, file.Path(), open, progress, verify, processor)
+}
 
-		processor.Record(ctx, &record)
-
-		recordCount++
-
-		// Update progress every 10000 records
-		if recordCount%10000 == 0 {
-			// Calculate approximate progress based on bytes read from compressed stream
-			bytesCompleted := file.BytesCompleted()
-			totalBytes := file.Length()
-			progress := 0.0
-			if totalBytes > 0 {
-				progress = float64(bytesCompleted) / float64(totalBytes) * 100
-			}
-			processor.Stats(ctx, file.Path(), StatsTypeFileProcessing, progress)
+// verifyPieces hashes the pieces of a downloaded file again, checking them
+// against the hashes of the torrent in case the data was corrupted on disk.
+// It returns the number of pieces that failed, to be downloaded again.
+func verifyPieces(ctx context.Context, file *torrent.File) (int, error) {
+	t := file.Torrent()
+	corrupt := 0
+	for i := file.BeginPieceIndex(); i < file.EndPieceIndex(); i++ {
+		if err := t.Piece(i).VerifyDataContext(ctx); err != nil {
+			return 0, err
+		}
+		if !t.PieceState(i).Complete {
+			corrupt++
 		}
 	}
-
-	processor.Stats(ctx, file.Path(), StatsTypeFileProcessing, 100.0)
-	processor.Stats(ctx, file.Path(), StatsTypeFileDownload, 100.0)
-
-	result.RecordCount = recordCount
-	return result
+	return corrupt, nil
 }
 
-// processFileFromDisk reads and processes a gz file after it has been fully downloaded to disk
-// This is more efficient than streaming as it avoids torrent protocol overhead during reads
-func processFileFromDisk(ctx context.Context, index int, file *torrent.File, processor Processor) FileResult {
+// processFile reads and processes the records of a gz file, opened with open,
+// resuming from its last checkpoint. progress returns the percentage of the
+// file processed, approximately. verify, when not nil, checks the file once
+// read: the records read from a file that fails it are processed again by the
+// next sync.
+func processFile(ctx context.Context, index int, filePath string, open func() (io.ReadCloser, error), progress func() float64, verify func() error, processor Processor) FileResult {
 	result := FileResult{
-		FilePath: file.Path(),
+		FilePath: filePath,
 	}
 
-	slog.Info("Waiting for file to complete download", "index", index, "path", file.Path())
-
-	// Wait for the file to be fully downloaded
-	for file.BytesCompleted() < file.Length() {
-		time.Sleep(500 * time.Millisecond)
+	checkpoint := processor.Resume(ctx, filePath)
+	if checkpoint.Done {
+		slog.Info("File already processed", "index", index, "path", filePath, "records", checkpoint.Records)
+		processor.Stats(ctx, filePath, StatsTypeRecords, float64(checkpoint.Records))
+		processor.Stats(ctx, filePath, StatsTypeParseErrors, float64(checkpoint.ParseErrors))
+		processor.Stats(ctx, filePath, StatsTypeFileProcessing, 100.0)
+		processor.Stats(ctx, filePath, StatsTypeFileDownload, 100.0)
+		result.RecordCount = checkpoint.Records
+		result.ParseErrors = checkpoint.ParseErrors
+		return result
+	}
+	if checkpoint.Lines > 0 {
+		slog.Info("Resuming file", "index", index, "path", filePath, "line", checkpoint.Lines+1)
+	} else {
+		slog.Info("Starting to process file", "index", index, "path", filePath)
 	}
 
-	slog.Info("File download complete, processing from disk", "index", index, "path", file.Path())
-
-	// Construct the full path to the file on disk
-	filePath := path.Join(DataDir, file.Path())
-
-	// Step 1: Open and decompress the gzip file
-	diskFile, err := os.Open(filePath)
+	reader, err := open()
 	if err != nil {
-		result.Error = fmt.Errorf("failed to open file from disk: %w", err)
+		result.Error = fmt.Errorf("failed to open file: %w", err)
 		return result
 	}
-	defer diskFile.Close()
+	defer reader.Close()
 
-	slog.Info("Decompressing gzip to temp file", "index", index)
+	// Under memory pressure, parsing pauses and torrent files read ahead less
+	adjustable, _ := reader.(interface{ SetReadahead(int64) })
+	throttle := func() {
+		if !memoryPressure.Load() {
+			return
+		}
+		if adjustable != nil {
+			adjustable.SetReadahead(readahead())
+		}
+		waitForMemory(ctx)
+		if adjustable != nil {
+			adjustable.SetReadahead(readahead())
+		}
+	}
 
-	gzReader, err := gzip.NewReader(diskFile)
+	read := &countingReader{ReadCloser: reader}
+	gzReader, err := gzip.NewReader(read)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to create gzip reader: %w", err)
 		return result
 	}
+	defer gzReader.Close()
 
-	// Create a temporary file for decompressed data
-	tempFile, err := os.CreateTemp("", fmt.Sprintf("anna-decompressed-%d-*.json", index))
-	if err != nil {
-		gzReader.Close()
-		result.Error = fmt.Errorf("failed to create temp file: %w", err)
-		return result
-	}
-	tempPath := tempFile.Name()
-	defer os.Remove(tempPath) // Clean up temp file when done
+	// Create buffered reader for line-by-line processing
+	bufReader := bufio.NewReaderSize(gzReader, 4*1024*1024) // 4MB buffer
 
-	// Decompress to temp file
-	written, err := io.Copy(tempFile, gzReader)
-	gzReader.Close()
-	tempFile.Close()
-	diskFile.Close()
-	if err != nil {
-		result.Error = fmt.Errorf("failed to decompress gzip: %w", err)
-		return result
-	}
+	recordCount := checkpoint.Records
+	parseErrors := checkpoint.ParseErrors
+	lineCount := 0
 
-	slog.Info("Decompressed to temp file, now parsing JSON", "index", index, "bytes", written)
+	// counted reports the records and parse errors counted so far
+	counted := func() {
+		processor.Stats(ctx, filePath, StatsTypeRecords, float64(recordCount))
+		processor.Stats(ctx, filePath, StatsTypeParseErrors, float64(parseErrors))
+	}
 
-	// Step 2: Read the JSON from the decompressed temp file
-	jsonFile, err := os.Open(tempPath)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to open temp file: %w", err)
+	// interrupted saves the progress of a cancelled sync, the next one resumes from it
+	interrupted := func() FileResult {
+		if lineCount > checkpoint.Lines {
+			processor.Checkpoint(context.WithoutCancel(ctx), filePath, Checkpoint{Lines: lineCount, Records: recordCount, ParseErrors: parseErrors})
+			slog.Info("File processing interrupted", "index", index, "path", filePath, "line", lineCount)
+		}
+		counted()
+		result.RecordCount = recordCount
+		result.ParseErrors = parseErrors
+		result.Error = ctx.Err()
 		return result
 	}
-	defer jsonFile.Close()
 
-	bufReader := bufio.NewReaderSize(jsonFile, 8*1024*1024) // 8MB buffer
+	for {
+		throttle()
+		if ctx.Err() != nil {
+			return interrupted()
+		}
 
-	recordCount := 0
-	lineCount := 0
+		if lineCount > checkpoint.Lines && lineCount%checkpointInterval == 0 {
+			processor.Checkpoint(ctx, filePath, Checkpoint{Lines: lineCount, Records: recordCount, ParseErrors: parseErrors})
+		}
 
-	for {
 		line, err := bufReader.ReadString('\n')
 		if err != nil {
-			if err == io.EOF {
+			if ctx.Err() != nil {
+				return interrupted()
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				slog.Warn("Unexpected EOF reached, ending processing", "line", lineCount+1)
 				break
 			}
 			result.Error = fmt.Errorf("read error at line %d: %w", lineCount+1, err)
@@ -351,24 +461,51 @@ func processFileFromDisk(ctx context.Context, index int, file *torrent.File, pro
 
 		lineCount++
 
+		// The lines before the checkpoint are still decompressed, but not parsed
+		if lineCount <= checkpoint.Lines {
+			continue
+		}
+
 		var record Record
 		if err := json.Unmarshal([]byte(line), &record); err != nil {
-			slog.Warn("Failed to parse JSON, skipping", "line", lineCount, "file", file.Path(), "error", err)
+			slog.Warn("Failed to parse JSON, skipping", "line", lineCount, "file", filePath, "error", err)
+			parseErrors++
 			continue
 		}
 
-		processor.Record(ctx, &record)
+		processor.Record(ctx, filePath, &record)
 
 		recordCount++
 
-		slog.Debug("File processing progress", "index", index, "records", recordCount)
+		// Update progress every 10000 records
+		if recordCount%10000 == 0 {
+			counted()
+			processor.Stats(ctx, filePath, StatsTypeBytes, float64(read.n))
+			processor.Stats(ctx, filePath, StatsTypeFileProcessing, progress())
+		}
+	}
+
+	if verify != nil {
+		if err := verify(); err != nil {
+			if ctx.Err() != nil {
+				return interrupted()
+			}
+			// The whole file is processed again
+			processor.Checkpoint(ctx, filePath, Checkpoint{})
+			result.RecordCount = recordCount
+			result.ParseErrors = parseErrors
+			result.Error = err
+			return result
+		}
 	}
 
-	processor.Stats(ctx, file.Path(), StatsTypeFileProcessing, 100.0)
-	processor.Stats(ctx, file.Path(), StatsTypeFileDownload, 100.0)
-	slog.Info("Completed file", "index", index, "path", file.Path(), "records", recordCount)
+	processor.Checkpoint(ctx, filePath, Checkpoint{Lines: lineCount, Records: recordCount, ParseErrors: parseErrors, Done: true})
+	counted()
+	processor.Stats(ctx, filePath, StatsTypeFileProcessing, 100.0)
+	processor.Stats(ctx, filePath, StatsTypeFileDownload, 100.0)
 
 	result.RecordCount = recordCount
+	result.ParseErrors = parseErrors
 	return result
 }
 
@@ -377,15 +514,24 @@ func CleanupFiles() error {
 	return os.RemoveAll(DataDir)
 }
 
-func updateProgress(ctx context.Context, files []*torrent.File, processor Processor) {
+func updateProgress(ctx context.Context, t *torrent.Torrent, files []*torrent.File, processor Processor) {
+	var completed, remaining int64
 	for _, file := range files {
-		completed := file.BytesCompleted()
+		fileCompleted := file.BytesCompleted()
 		total := file.Length()
 		percent := 0.0
 		if total > 0 {
-			percent = float64(completed) / float64(total) * 100
+			percent = float64(fileCompleted) / float64(total) * 100
 		}
+		completed += fileCompleted
+		remaining += total - fileCompleted
 
 		processor.Stats(ctx, file.Path(), StatsTypeFileDownload, percent)
 	}
+
+	stats := t.Stats()
+	processor.Stats(ctx, "", StatsTypeSwarmPeers, float64(stats.ActivePeers))
+	processor.Stats(ctx, "", StatsTypeSwarmSeeders, float64(stats.ConnectedSeeders))
+	processor.Stats(ctx, "", StatsTypeSwarmRemaining, float64(remaining))
+	processor.Stats(ctx, "", StatsTypeSwarmCompleted, float64(completed))
 }
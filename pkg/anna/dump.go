@@ -0,0 +1,170 @@
+package anna
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+// ErrNoKeptFiles is returned when the metadata files of the last synced base
+// are not on disk.
+var ErrNoKeptFiles = errors.New("no metadata files kept on disk")
+
+// KeptFilesDir returns the directory holding the metadata files of base, kept
+// on disk after its sync with ANNA_KEEP_FILES, whether they were downloaded
+// from the metadata torrent or from a metadata mirror, or ErrNoKeptFiles.
+func KeptFilesDir(base string) (string, error) {
+	name := strings.TrimSuffix(base, ".torrent")
+	for _, dir := range []string{filepath.Join(DataDir, name, "elasticsearch"), filepath.Join(DataDir, name)} {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", ErrNoKeptFiles
+}
+
+// ProcessDump processes the aarecords__N.json.gz metadata files of a local
+// directory, as DownloadAndProcessRecords does with the metadata torrent. The
+// files are read by as many goroutines as there are CPUs.
+func ProcessDump(ctx context.Context, dir string, processor Processor) ([]FileResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dump directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	fileNames := selectFiles(names)
+	if len(fileNames) == 0 {
+		slog.Warn("No matching files found in dump directory", "dir", dir)
+		return nil, nil
+	}
+	processor.Files(ctx, fileNames)
+	// Nothing to download
+	for _, name := range fileNames {
+		processor.Stats(ctx, name, StatsTypeFileDownload, 100.0)
+	}
+
+	slog.Info("Starting processing of dump files", "dir", dir, "count", len(fileNames))
+	results := processFiles(ctx, fileNames, runtime.NumCPU(), processor, func(index int, name string) FileResult {
+		return processDumpFile(ctx, index, dir, name, processor)
+	})
+	slog.Info("All files processed")
+
+	return results, nil
+}
+
+// selectFiles returns the names of metadata files to sync among names, sorted
+// by index.
+func selectFiles(names []string) []string {
+	filePattern := regexp.MustCompile(`^aarecords__\d+\.json\.gz$`)
+
+	var selected []string
+	for _, name := range names {
+		if !filePattern.MatchString(name) {
+			continue
+		}
+		if index, _ := ExtractFileIndex(name); !fileSelected(config.C.Anna, index) {
+			slog.Info("Skipping excluded file", "path", name)
+			continue
+		}
+		selected = append(selected, name)
+		slog.Info("Found matching file", "path", name)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		indexI, _ := ExtractFileIndex(selected[i])
+		indexJ, _ := ExtractFileIndex(selected[j])
+		return indexI < indexJ
+	})
+	return selected
+}
+
+// processFiles calls process for each of the metadata files named, at most n
+// at a time, and returns their results. A failed file doesn't stop the others.
+func processFiles(ctx context.Context, names []string, n int, processor Processor, process func(index int, name string) FileResult) []FileResult {
+	var results []FileResult
+	var resultsMu sync.Mutex
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(n, 1))
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			// No new file under memory pressure
+			waitForMemory(ctx)
+
+			var result FileResult
+			if index, err := ExtractFileIndex(name); err != nil {
+				result = FileResult{FilePath: name, Error: err}
+			} else {
+				result = process(index, name)
+			}
+			// A cancelled sync is reported through the results, without failing the file
+			if result.Error != nil && ctx.Err() == nil {
+				slog.Error("Failed to process file, continuing with the others", "path", name, "error", result.Error)
+				processor.FileError(ctx, name, result.Error)
+			}
+			resultsMu.Lock()
+			results = append(results, result)
+			resultsMu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	return results
+}
+
+// processDumpFile processes the file name of the dump directory dir.
+func processDumpFile(ctx context.Context, index int, dir, name string, processor Processor) FileResult {
+	var size int64
+	read := &countingReader{}
+	open := func() (io.ReadCloser, error) {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if info, err := file.Stat(); err == nil {
+			size = info.Size()
+		}
+		read.ReadCloser = file
+		return read, nil
+	}
+	// Progress based on bytes read from the compressed file
+	progress := func() float64 {
+		if size == 0 {
+			return 0
+		}
+		return float64(read.n) / float64(size) * 100
+	}
+	return processFile(ctx, index, name, open, progress, nil, processor)
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
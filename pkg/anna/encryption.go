@@ -0,0 +1,114 @@
+package anna
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+// encryptedMagic starts the files encrypted by the storage key, the files
+// stored before the key was set are read as is.
+const encryptedMagic = "ANNAENC1"
+
+// storageAEAD encrypts the files of the storage directory with AES-GCM, nil
+// when ANNA_STORAGE_KEY is not set. It is created on first use, once the
+// configuration was validated.
+var storageAEAD = sync.OnceValue(func() cipher.AEAD {
+	return newStorageAEAD(config.C.Anna.StorageKey)
+})
+
+func newStorageAEAD(key string) cipher.AEAD {
+	if key == "" {
+		return nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		panic(err)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// encryptionOverhead is the number of bytes added to encrypted files.
+func encryptionOverhead() int64 {
+	return int64(len(encryptedMagic) + storageAEAD().NonceSize() + storageAEAD().Overhead())
+}
+
+// seal encrypts the contents of a file to store, when a storage key is set.
+// The file is the magic, a random nonce and the sealed data.
+func seal(data []byte) ([]byte, error) {
+	if storageAEAD() == nil {
+		return data, nil
+	}
+	out := make([]byte, len(encryptedMagic)+storageAEAD().NonceSize(), len(data)+int(encryptionOverhead()))
+	copy(out, encryptedMagic)
+	nonce := out[len(encryptedMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return storageAEAD().Seal(out, nonce, data, []byte(encryptedMagic)), nil
+}
+
+// unseal decrypts the contents of a stored file, unless it was stored in clear.
+func unseal(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+		return data, nil
+	}
+	if storageAEAD() == nil {
+		return nil, errors.New("stored file is encrypted and no storage key is set")
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < storageAEAD().NonceSize() {
+		return nil, errors.New("stored file is truncated")
+	}
+	nonce, sealed := data[:storageAEAD().NonceSize()], data[storageAEAD().NonceSize():]
+	return storageAEAD().Open(nil, nonce, sealed, []byte(encryptedMagic))
+}
+
+// isEncrypted reports whether a stored file starts with the encryption magic.
+func isEncrypted(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	magic := make([]byte, len(encryptedMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+	return string(magic) == encryptedMagic
+}
+
+// plainFileInfo reports the size of the decrypted contents of an encrypted file.
+type plainFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i plainFileInfo) Size() int64 {
+	return i.size
+}
+
+// nopSeekCloser serves decrypted contents from memory.
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package anna
+
+import (
+	"crypto/cipher"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testStorageKey  = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	otherStorageKey = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+// withStorageKey sets the storage key for the duration of a test, none when empty.
+func withStorageKey(t *testing.T, key string) {
+	previous := storageAEAD
+	storageAEAD = func() cipher.AEAD { return newStorageAEAD(key) }
+	t.Cleanup(func() { storageAEAD = previous })
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	withStorageKey(t, testStorageKey)
+
+	for _, data := range [][]byte{[]byte("PK\x03\x04 epub contents"), {}, make([]byte, 1<<16)} {
+		sealed, err := seal(data)
+		assert.NoError(t, err)
+		assert.Equal(t, encryptedMagic, string(sealed[:len(encryptedMagic)]))
+		assert.Equal(t, int64(len(data))+encryptionOverhead(), int64(len(sealed)))
+
+		opened, err := unseal(sealed)
+		assert.NoError(t, err)
+		assert.Equal(t, string(data), string(opened))
+	}
+}
+
+func TestSealWithoutKey(t *testing.T) {
+	withStorageKey(t, "")
+
+	data := []byte("PK\x03\x04 epub contents")
+	sealed, err := seal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, data, sealed)
+}
+
+func TestUnseal(t *testing.T) {
+	withStorageKey(t, testStorageKey)
+	sealed, err := seal([]byte("PK\x03\x04 epub contents"))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		key     string
+		data    []byte
+		want    string
+		wantErr string
+	}{
+		{name: "plaintext file", key: testStorageKey, data: []byte("PK\x03\x04 stored before the key"), want: "PK\x03\x04 stored before the key"},
+		{name: "plaintext file without key", data: []byte("PK\x03\x04 stored before the key"), want: "PK\x03\x04 stored before the key"},
+		{name: "encrypted file", key: testStorageKey, data: sealed, want: "PK\x03\x04 epub contents"},
+		{name: "wrong key", key: otherStorageKey, data: sealed, wantErr: "cipher: message authentication failed"},
+		{name: "no key", data: sealed, wantErr: "stored file is encrypted and no storage key is set"},
+		{name: "truncated nonce", key: testStorageKey, data: sealed[:len(encryptedMagic)+4], wantErr: "stored file is truncated"},
+		{name: "truncated contents", key: testStorageKey, data: sealed[:len(sealed)-1], wantErr: "cipher: message authentication failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStorageKey(t, tt.key)
+
+			got, err := unseal(tt.data)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package anna
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,17 +14,19 @@ import (
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/iziplay/anna-api/pkg/config"
 	"golang.org/x/sync/singleflight"
 )
 
 var (
-	EpubStorageDir  = "/tmp/anna-epubs"
+	EpubStorageDir  = config.C.Anna.EpubStorageDir
 	g               singleflight.Group
 	activeDownloads sync.Map
 )
 
 const (
 	DownloadStatusNotStarted  DownloadStatus = "NOT_STARTED"
+	DownloadStatusQueued      DownloadStatus = "QUEUED"
 	DownloadStatusDownloading DownloadStatus = "DOWNLOADING"
 	DownloadStatusDownloaded  DownloadStatus = "DOWNLOADED"
 )
@@ -31,26 +35,43 @@ type DownloadStatus string
 
 // DownloadProgressEvent represents a download progress update
 type DownloadProgressEvent struct {
-	Status         DownloadStatus `json:"status"`
-	BytesCompleted int64          `json:"bytes_completed"`
-	TotalBytes     int64          `json:"total_bytes"`
-	Percent        float64        `json:"percent"`
+	Status DownloadStatus `json:"status"`
+	// Position is the 1-based position in the download queue while QUEUED
+	Position       int     `json:"position,omitempty"`
+	BytesCompleted int64   `json:"bytes_completed"`
+	TotalBytes     int64   `json:"total_bytes"`
+	Percent        float64 `json:"percent"`
 }
 
 type downloadTracker struct {
 	mu          sync.RWMutex
+	filename    string
 	progress    DownloadProgressEvent
 	subscribers []chan DownloadProgressEvent
+	// saved and savedStatus are when and with which status the progress was last persisted
+	saved       time.Time
+	savedStatus DownloadStatus
 }
 
-func newDownloadTracker() *downloadTracker {
+func newDownloadTracker(outputFilename string) *downloadTracker {
 	return &downloadTracker{
+		filename: outputFilename,
 		progress: DownloadProgressEvent{
 			Status: DownloadStatusDownloading,
 		},
 	}
 }
 
+// queued reports the position of a download waiting in the queue.
+func (t *downloadTracker) queued(position int) {
+	t.mu.Lock()
+	t.progress = DownloadProgressEvent{
+		Status:   DownloadStatusQueued,
+		Position: position,
+	}
+	t.publish()
+}
+
 func (t *downloadTracker) update(bytesCompleted, totalBytes int64) {
 	t.mu.Lock()
 	percent := 0.0
@@ -63,9 +84,19 @@ func (t *downloadTracker) update(bytesCompleted, totalBytes int64) {
 		TotalBytes:     totalBytes,
 		Percent:        percent,
 	}
+	t.publish()
+}
+
+// publish sends the progress to the subscribers. It must be called with mu
+// held, and unlocks it.
+func (t *downloadTracker) publish() {
 	subs := make([]chan DownloadProgressEvent, len(t.subscribers))
 	copy(subs, t.subscribers)
 	progress := t.progress
+	save := saveProgress != nil && (progress.Status != t.savedStatus || time.Since(t.saved) >= progressSaveInterval)
+	if save {
+		t.saved, t.savedStatus = time.Now(), progress.Status
+	}
 	t.mu.Unlock()
 
 	for _, ch := range subs {
@@ -74,6 +105,9 @@ func (t *downloadTracker) update(bytesCompleted, totalBytes int64) {
 		default:
 		}
 	}
+	if save {
+		queueProgress(t.filename, progress)
+	}
 }
 
 func (t *downloadTracker) complete() {
@@ -126,81 +160,233 @@ func SubscribeDownloadProgress(outputFilename string) (<-chan DownloadProgressEv
 	return tracker.subscribe()
 }
 
+// RecordFilename returns the name of the file of a record in the storage
+// directory, extension being the format of the record (e.g. epub).
+func RecordFilename(id, extension string) string {
+	return fmt.Sprintf("%s.%s", strings.ReplaceAll(id, ":", "_"), extension)
+}
+
+// StoredFilename returns the name of the file of a record in the storage
+// directory. Files are stored by md5 so that records sharing a file share the
+// stored file, the ones whose md5 is unknown by their ID.
+func StoredFilename(id, md5, extension string) string {
+	if md5 != "" {
+		return RecordFilename("md5:"+strings.ToLower(md5), extension)
+	}
+	return RecordFilename(id, extension)
+}
+
+// MoveStoredFile renames a file of the storage directory. The file is removed
+// when the target exists already, as both have the same contents.
+func MoveStoredFile(from, to string) error {
+	if EpubStorageDir == "" || from == to {
+		return nil
+	}
+	source, err := os.Stat(filepath.Join(EpubStorageDir, from))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(EpubStorageDir, to)); err == nil {
+		err = os.Remove(filepath.Join(EpubStorageDir, from))
+	} else {
+		err = os.Rename(filepath.Join(EpubStorageDir, from), filepath.Join(EpubStorageDir, to))
+		if err == nil {
+			cache.add(to, source.Size())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	cache.remove(from)
+	return nil
+}
+
+// StoredFile returns information about a downloaded file in the storage
+// directory. The size of encrypted files is the one of their contents.
+func StoredFile(outputFilename string) (os.FileInfo, error) {
+	if EpubStorageDir == "" {
+		return nil, os.ErrNotExist
+	}
+	path := filepath.Join(EpubStorageDir, outputFilename)
+	info, err := os.Stat(path)
+	if err != nil || storageAEAD() == nil || !isEncrypted(path) {
+		return info, err
+	}
+	return plainFileInfo{FileInfo: info, size: info.Size() - encryptionOverhead()}, nil
+}
+
 func GetDownloadStatus(outputFilename string) DownloadStatus {
+	return GetDownloadProgress(outputFilename).Status
+}
+
+// GetDownloadProgress returns the status of a file, with its position in the
+// queue or its progress while it is downloaded.
+func GetDownloadProgress(outputFilename string) DownloadProgressEvent {
 	if EpubStorageDir != "" {
 		path := filepath.Join(EpubStorageDir, outputFilename)
 		if _, err := os.Stat(path); err == nil {
-			return DownloadStatusDownloaded
+			return DownloadProgressEvent{Status: DownloadStatusDownloaded, Percent: 100}
 		}
 	}
 
-	if _, ok := activeDownloads.Load(outputFilename); ok {
-		return DownloadStatusDownloading
+	if val, ok := activeDownloads.Load(outputFilename); ok {
+		tracker := val.(*downloadTracker)
+		tracker.mu.RLock()
+		defer tracker.mu.RUnlock()
+		return tracker.progress
 	}
 
-	return DownloadStatusNotStarted
+	return DownloadProgressEvent{Status: DownloadStatusNotStarted}
 }
 
-func init() {
-	if dir, ok := os.LookupEnv("ANNA_EPUB_STORAGE_DIR"); ok {
-		slog.Info("Using custom Anna epub storage dir", "dir", dir)
-		EpubStorageDir = dir
-	}
+// TorrentSource is a torrent holding a file.
+type TorrentSource struct {
+	// ID identifies the source to the caller, e.g. its torrent classification
+	ID string
+	// MagnetLink is the magnet link for the torrent.
+	MagnetLink string
+	// ServerPath is the server_path identifier value (e.g., "g5/zlib1/zlib1/pilimi-zlib-6160000-7229999/7225029").
+	ServerPath string
+	// TorrentName is the torrent display name (e.g., "pilimi-zlib-6160000-7229999.torrent").
+	TorrentName string
+}
+
+// downloaded is a file downloaded by DownloadFile and the source that served it.
+type downloaded struct {
+	data   []byte
+	source string
 }
 
-// DownloadFile downloads a specific file from a torrent and returns its contents.
-// magnetLink is the magnet link for the torrent.
-// serverPath is the server_path identifier value (e.g., "g5/zlib1/zlib1/pilimi-zlib-6160000-7229999/7225029").
-// torrentName is the torrent display name (e.g., "pilimi-zlib-6160000-7229999.torrent").
+// DownloadFile downloads a specific file and returns its contents, along with
+// the ID of the torrent source or the URL of the mirror that served it (empty
+// when the file was stored already).
+// sources are the torrents holding the file, tried in order until one succeeds.
 // outputFilename is the name of the file to be saved in the storage directory.
-func DownloadFile(ctx context.Context, magnetLink, serverPath, torrentName, outputFilename string) ([]byte, error) {
+// mirrors are the HTTP URLs of the file tried in order when every torrent
+// fails or stalls (see MirrorURLs), with its size and md5 when known.
+func DownloadFile(ctx context.Context, sources []TorrentSource, outputFilename string, mirrors MirrorFile) ([]byte, string, error) {
 	// 1. Check if file exists in storage
-	if EpubStorageDir != "" {
-		path := filepath.Join(EpubStorageDir, outputFilename)
-		if data, err := os.ReadFile(path); err == nil {
-			slog.Info("File found in storage", "path", path)
-			return data, nil
-		}
+	if data, err := ReadStoredFile(outputFilename); err == nil {
+		slog.Info("File found in storage", "name", outputFilename)
+		return data, "", nil
 	}
 
 	// 2. Use singleflight to prevent multiple concurrent downloads for the same file
-	newTracker := newDownloadTracker()
+	newTracker := newDownloadTracker(outputFilename)
 	actual, _ := activeDownloads.LoadOrStore(outputFilename, newTracker)
 	tracker := actual.(*downloadTracker)
 
-	key := fmt.Sprintf("%s-%s", torrentName, outputFilename)
-	v, err, _ := g.Do(key, func() (interface{}, error) {
+	v, err, _ := g.Do(outputFilename, func() (interface{}, error) {
 		defer func() {
 			tracker.complete()
 			activeDownloads.Delete(outputFilename)
 		}()
 		// Double-check cache inside singleflight in case another goroutine just finished downloading it
-		if EpubStorageDir != "" {
-			path := filepath.Join(EpubStorageDir, outputFilename)
-			if data, err := os.ReadFile(path); err == nil {
-				return data, nil
-			}
+		if data, err := ReadStoredFile(outputFilename); err == nil {
+			return &downloaded{data: data}, nil
 		}
-		return downloadFileInternal(ctx, magnetLink, serverPath, torrentName, outputFilename, tracker)
+
+		release, err := queue.acquire(ctx, tracker.queued)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		result, err := downloadFromSources(ctx, sources, mirrors, tracker)
+		if err != nil {
+			return nil, err
+		}
+		StoreFile(outputFilename, result.data)
+		return result, nil
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return v.([]byte), nil
+	result := v.(*downloaded)
+	return result.data, result.source, nil
 }
 
-func downloadFileInternal(ctx context.Context, magnetLink, serverPath, torrentName, outputFilename string, tracker *downloadTracker) ([]byte, error) {
-	t, err := client.AddMagnet(magnetLink)
+// downloadFromSources tries each torrent source in order, each up to
+// TorrentRetries more times when it times out, then the mirrors. The error
+// wraps ErrDownloadTimeout when every source timed out or the download took
+// longer than DownloadTimeout.
+func downloadFromSources(ctx context.Context, sources []TorrentSource, mirrors MirrorFile, tracker *downloadTracker) (*downloaded, error) {
+	parent := ctx
+	if DownloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DownloadTimeout)
+		defer cancel()
+	}
+	timedOut := func() error {
+		if parent.Err() != nil {
+			return parent.Err()
+		}
+		return fmt.Errorf("%w after %s", ErrDownloadTimeout, DownloadTimeout)
+	}
+
+	var errs []error
+	allTimeouts := true
+	for _, source := range sources {
+		for attempt := 0; attempt <= TorrentRetries; attempt++ {
+			tracker.update(0, 0)
+			data, err := downloadFileInternal(ctx, source.MagnetLink, source.ServerPath, source.TorrentName, tracker)
+			if err == nil {
+				return &downloaded{data: data, source: source.ID}, nil
+			}
+			if ctx.Err() != nil {
+				return nil, timedOut()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", source.TorrentName, err))
+			if !isTimeout(err) {
+				allTimeouts = false
+				slog.Warn("Torrent download failed, trying the next source", "torrent", source.TorrentName, "error", err)
+				break
+			}
+			slog.Warn("Torrent download timed out", "torrent", source.TorrentName, "attempt", attempt+1, "error", err)
+		}
+	}
+
+	if len(mirrors.URLs) > 0 {
+		tracker.update(0, 0)
+		data, mirror, err := downloadFromMirrors(ctx, mirrors, tracker)
+		if err == nil {
+			return &downloaded{data: data, source: mirror}, nil
+		}
+		if ctx.Err() != nil {
+			return nil, timedOut()
+		}
+		allTimeouts = allTimeouts && isTimeout(err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no source to download the file from")
+	}
+	if allTimeouts {
+		return nil, fmt.Errorf("%w: %w", ErrDownloadTimeout, errors.Join(errs...))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func downloadFileInternal(ctx context.Context, magnetLink, serverPath, torrentName string, tracker *downloadTracker) ([]byte, error) {
+	t, release, err := acquireTorrent(magnetLink)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add magnet: %w", err)
 	}
-	defer t.Drop()
+	defer release()
 
 	slog.Info("Waiting for torrent info", "torrent", torrentName)
 
+	var stalled <-chan time.Time
+	if TorrentMetadataTimeout > 0 {
+		timer := time.NewTimer(TorrentMetadataTimeout)
+		defer timer.Stop()
+		stalled = timer.C
+	}
 	select {
 	case <-t.GotInfo():
+	case <-stalled:
+		return nil, fmt.Errorf("%w: no info after %s", errTorrentStalled, TorrentMetadataTimeout)
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
@@ -237,14 +423,25 @@ func downloadFileInternal(ctx context.Context, magnetLink, serverPath, torrentNa
 
 	slog.Info("Found file in torrent, downloading", "path", targetFile.Path(), "size", targetFile.Length())
 
+	if downloadLimiter != nil || DownloadRatePerFile > 0 {
+		return readThrottled(ctx, t, targetFile, tracker)
+	}
+
 	// Download only this file with highest priority
 	targetFile.Download()
 	tracker.update(0, targetFile.Length())
 
 	// Wait for download to complete
+	lastCompleted, lastProgress := int64(-1), time.Now()
 	for targetFile.BytesCompleted() < targetFile.Length() {
-		tracker.update(targetFile.BytesCompleted(), targetFile.Length())
-		slog.Debug("Downloading file", "completed", targetFile.BytesCompleted(), "total", targetFile.Length())
+		completed := targetFile.BytesCompleted()
+		tracker.update(completed, targetFile.Length())
+		slog.Debug("Downloading file", "completed", completed, "total", targetFile.Length())
+		if completed != lastCompleted {
+			lastCompleted, lastProgress = completed, time.Now()
+		} else if TorrentStallTimeout > 0 && time.Since(lastProgress) > TorrentStallTimeout {
+			return nil, fmt.Errorf("%w: no progress for %s with %d active peers", errTorrentStalled, TorrentStallTimeout, t.Stats().ActivePeers)
+		}
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -266,19 +463,163 @@ func downloadFileInternal(ctx context.Context, magnetLink, serverPath, torrentNa
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 	slog.Info("File read into memory", "size", len(data))
+	return data, nil
+}
+
+// ReadStoredFile returns the contents of a file of the storage directory,
+// decrypted, marking it as recently used.
+func ReadStoredFile(outputFilename string) ([]byte, error) {
+	if EpubStorageDir == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(EpubStorageDir, outputFilename))
+	if err != nil {
+		return nil, err
+	}
+	data, err = unseal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", outputFilename, err)
+	}
+	cache.touch(outputFilename)
+	return data, nil
+}
+
+// OpenStoredFile opens a file of the storage directory to serve it, marking
+// it as recently used. Encrypted files are decrypted in memory, the others
+// are read from disk.
+func OpenStoredFile(outputFilename string) (io.ReadSeekCloser, os.FileInfo, error) {
+	if EpubStorageDir == "" {
+		return nil, nil, os.ErrNotExist
+	}
+	if storageAEAD() != nil {
+		info, err := StoredFile(outputFilename)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := ReadStoredFile(outputFilename)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nopSeekCloser{bytes.NewReader(data)}, info, nil
+	}
 
+	file, err := os.Open(filepath.Join(EpubStorageDir, outputFilename))
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	cache.touch(outputFilename)
+	return file, info, nil
+}
+
+// StoreFile writes a file to the storage directory, if any, encrypted when
+// ANNA_STORAGE_KEY is set.
+func StoreFile(outputFilename string, data []byte) {
 	if EpubStorageDir != "" {
+		sealed, err := seal(data)
+		if err != nil {
+			slog.Warn("Failed to encrypt file", "name", outputFilename, "error", err)
+			return
+		}
 		if err := os.MkdirAll(EpubStorageDir, 0755); err != nil {
 			slog.Warn("Failed to create storage directory", "dir", EpubStorageDir, "error", err)
 		} else {
 			path := filepath.Join(EpubStorageDir, outputFilename)
-			if err := os.WriteFile(path, data, 0644); err != nil {
+			if err := os.WriteFile(path, sealed, 0644); err != nil {
 				slog.Warn("Failed to write file to storage", "path", path, "error", err)
 			} else {
 				slog.Info("File saved to storage", "path", path, "size", len(data))
+				cache.add(outputFilename, int64(len(sealed)))
 			}
 		}
 	}
+}
 
-	return data, nil
+// WaitDownloads blocks until there is no epub being downloaded, or ctx is done.
+func WaitDownloads(ctx context.Context) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		active := false
+		activeDownloads.Range(func(key, value any) bool {
+			active = true
+			return false
+		})
+		if !active {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeResult reports what PurgeStoredFiles removed.
+type PurgeResult struct {
+	Files      int   `json:"files"`
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// PurgeStoredFiles removes downloaded files from the storage directory.
+// Only files last written more than olderThan ago (when positive) and whose
+// name matches pattern (a filepath.Match pattern, when not empty) are removed.
+// Files being downloaded are left alone.
+func PurgeStoredFiles(olderThan time.Duration, pattern string) (PurgeResult, error) {
+	var result PurgeResult
+	if EpubStorageDir == "" {
+		return result, nil
+	}
+	if pattern != "" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return result, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	entries, err := os.ReadDir(EpubStorageDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return result, fmt.Errorf("failed to list storage directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if _, ok := activeDownloads.Load(entry.Name()); ok {
+			continue
+		}
+		if pattern != "" {
+			if ok, _ := filepath.Match(pattern, entry.Name()); !ok {
+				continue
+			}
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if olderThan > 0 && time.Since(info.ModTime()) < olderThan {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(EpubStorageDir, entry.Name())); err != nil {
+			slog.Warn("Failed to remove stored file", "name", entry.Name(), "error", err)
+			continue
+		}
+		cache.remove(entry.Name())
+		result.Files++
+		result.FreedBytes += info.Size()
+	}
+
+	slog.Info("Purged storage directory", "files", result.Files, "freed_bytes", result.FreedBytes)
+	return result, nil
 }
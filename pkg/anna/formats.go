@@ -0,0 +1,68 @@
+package anna
+
+import (
+	"slices"
+
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+// Formats lists the file extensions of the records that are indexed and can
+// be downloaded.
+var Formats = []string{"epub", "pdf", "mobi", "azw3", "cbz", "djvu"}
+
+var formatContentTypes = map[string]string{
+	"epub": "application/epub+zip",
+	"pdf":  "application/pdf",
+	"mobi": "application/x-mobipocket-ebook",
+	"azw3": "application/vnd.amazon.ebook",
+	"cbz":  "application/vnd.comicbook+zip",
+	"djvu": "image/vnd.djvu",
+}
+
+// otherContentTypes are the media types of the files of records synced for
+// their content type (see ANNA_SYNC_CONTENT_TYPES), such as audiobooks.
+var otherContentTypes = map[string]string{
+	"cbr": "application/vnd.comicbook-rar",
+	"cb7": "application/x-cb7",
+	"mp3": "audio/mpeg",
+	"m4a": "audio/mp4",
+	"m4b": "audio/mp4",
+	"zip": "application/zip",
+	"rar": "application/vnd.rar",
+}
+
+// IsFormat reports whether extension is one of Formats.
+func IsFormat(extension string) bool {
+	_, ok := formatContentTypes[extension]
+	return ok
+}
+
+// IsSyncedFormat reports whether the records of the given extension are
+// synced: one of ANNA_SYNC_FORMATS when set, else one of Formats.
+func IsSyncedFormat(extension string) bool {
+	if formats := config.C.Anna.SyncFormats; len(formats) > 0 {
+		return slices.Contains(formats, extension)
+	}
+	return IsFormat(extension)
+}
+
+// IsSynced reports whether a record of the given extension and content type
+// is synced: its extension is a synced format, or its content type is one of
+// ANNA_SYNC_CONTENT_TYPES (e.g. audiobook, book_comic, magazine).
+func IsSynced(extension, contentType string) bool {
+	if IsSyncedFormat(extension) {
+		return true
+	}
+	return extension != "" && slices.Contains(config.C.Anna.SyncContentTypes, contentType)
+}
+
+// FormatContentType returns the media type of files of the given extension.
+func FormatContentType(extension string) string {
+	if contentType, ok := formatContentTypes[extension]; ok {
+		return contentType
+	}
+	if contentType, ok := otherContentTypes[extension]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
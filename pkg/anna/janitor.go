@@ -0,0 +1,89 @@
+package anna
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+var (
+	// EpubRetention is how long downloaded files are kept in the storage
+	// directory, zero keeps them forever.
+	EpubRetention = config.C.Anna.EpubRetention
+	// EpubCleanupInterval is the time between two removals of expired files.
+	EpubCleanupInterval = config.C.Anna.EpubCleanupInterval
+)
+
+// JanitorStats describes the removals of expired files from the storage directory.
+type JanitorStats struct {
+	Retention      string     `json:"retention,omitempty" doc:"Retention period, absent when files are kept forever"`
+	LastCleanup    *time.Time `json:"last_cleanup,omitempty"`
+	NextCleanup    *time.Time `json:"next_cleanup,omitempty"`
+	RemovedFiles   int        `json:"removed_files"`
+	ReclaimedBytes int64      `json:"reclaimed_bytes"`
+}
+
+var (
+	janitorMu    sync.Mutex
+	janitorStats JanitorStats
+)
+
+// GetJanitorStats returns when expired files were and will be removed, and
+// how many were removed since startup.
+func GetJanitorStats() JanitorStats {
+	janitorMu.Lock()
+	defer janitorMu.Unlock()
+	return janitorStats
+}
+
+// RunJanitor removes the files downloaded longer than EpubRetention ago every
+// EpubCleanupInterval, until ctx is done. It returns right away when
+// EpubRetention is not set.
+func RunJanitor(ctx context.Context) {
+	if EpubRetention <= 0 || EpubStorageDir == "" {
+		return
+	}
+	slog.Info("Removing stored files periodically", "retention", EpubRetention, "interval", EpubCleanupInterval)
+	janitorMu.Lock()
+	janitorStats.Retention = EpubRetention.String()
+	janitorMu.Unlock()
+
+	ticker := time.NewTicker(EpubCleanupInterval)
+	defer ticker.Stop()
+	for {
+		scheduleCleanup(time.Now().Add(EpubCleanupInterval))
+
+		select {
+		case <-ctx.Done():
+			scheduleCleanup(time.Time{})
+			return
+		case <-ticker.C:
+		}
+
+		result, err := PurgeStoredFiles(EpubRetention, "")
+		if err != nil {
+			slog.Error("Failed to remove expired files", "error", err)
+			continue
+		}
+
+		now := time.Now()
+		janitorMu.Lock()
+		janitorStats.LastCleanup = &now
+		janitorStats.RemovedFiles += result.Files
+		janitorStats.ReclaimedBytes += result.FreedBytes
+		janitorMu.Unlock()
+	}
+}
+
+// scheduleCleanup records the time of the next cleanup, none when zero.
+func scheduleCleanup(next time.Time) {
+	janitorMu.Lock()
+	defer janitorMu.Unlock()
+	janitorStats.NextCleanup = nil
+	if !next.IsZero() {
+		janitorStats.NextCleanup = &next
+	}
+}
@@ -0,0 +1,80 @@
+package anna
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+// heapMetric is the memory occupied by heap objects, live or not swept yet
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// memoryPressure is set while the heap is above ANNA_SYNC_MEMORY_LIMIT: the
+// sync pauses parsing, starts no new file and reads ahead less.
+var memoryPressure atomic.Bool
+
+// RunMemoryWatchdog samples the heap every second until ctx is done, flagging
+// memory pressure above ANNA_SYNC_MEMORY_LIMIT, until it gets back under 90%
+// of it. It returns right away when no limit is set.
+func RunMemoryWatchdog(ctx context.Context) {
+	limit := uint64(config.C.Anna.SyncMemoryLimit)
+	if limit == 0 {
+		return
+	}
+	slog.Info("Watching memory during syncs", "limit", limit)
+
+	sample := []metrics.Sample{{Name: heapMetric}}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			memoryPressure.Store(false)
+			return
+		case <-ticker.C:
+		}
+
+		metrics.Read(sample)
+		heap := sample[0].Value.Uint64()
+		switch {
+		case heap > limit && !memoryPressure.Load():
+			slog.Warn("Memory above the limit, slowing down the sync", "heap", heap, "limit", limit)
+			memoryPressure.Store(true)
+			// Give the garbage back before waiting for the queued records to be written
+			debug.FreeOSMemory()
+		case heap < limit/10*9 && memoryPressure.Load():
+			slog.Info("Memory back under the limit, resuming the sync", "heap", heap, "limit", limit)
+			memoryPressure.Store(false)
+		}
+	}
+}
+
+// waitForMemory waits for the memory pressure to end, or for ctx to be done.
+func waitForMemory(ctx context.Context) {
+	if !memoryPressure.Load() {
+		return
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for memoryPressure.Load() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readahead returns the bytes to read ahead of a metadata file being
+// processed, less under memory pressure.
+func readahead() int64 {
+	if memoryPressure.Load() {
+		return 1024 * 1024 // 1MB
+	}
+	return 10 * 1024 * 1024 // 10MB
+}
@@ -0,0 +1,242 @@
+package anna
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// metadataMirrorDownloads is the number of metadata files downloaded at once
+// from a mirror
+const metadataMirrorDownloads = 4
+
+// metadataClient downloads the metadata files, without a timeout as they
+// weigh gigabytes: interrupted downloads are resumed.
+var metadataClient = &http.Client{
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// MetadataMirrorBase returns the name of the base served by a metadata mirror:
+// the directory of its checksum file.
+func MetadataMirrorBase(sumsURL string) string {
+	u, err := url.Parse(sumsURL)
+	if err != nil {
+		return sumsURL
+	}
+	return path.Base(path.Dir(u.Path))
+}
+
+// DownloadAndProcessMirror downloads the metadata files listed in the
+// checksum file at sumsURL, in the sha256sum format, from the same directory
+// of an HTTP mirror, then processes them as ProcessDump does. Interrupted
+// downloads are resumed and each file is checked against its SHA-256 before
+// being processed.
+func DownloadAndProcessMirror(ctx context.Context, sumsURL string, processor Processor) ([]FileResult, error) {
+	sums, err := fetchChecksums(ctx, sumsURL)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+
+	fileNames := selectFiles(names)
+	if len(fileNames) == 0 {
+		slog.Warn("No matching files found on metadata mirror", "url", sumsURL)
+		return nil, nil
+	}
+	processor.Files(ctx, fileNames)
+
+	dir := filepath.Join(DataDir, MetadataMirrorBase(sumsURL))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+
+	slog.Info("Starting download and processing of files from metadata mirror", "url", sumsURL, "count", len(fileNames))
+	results := processFiles(ctx, fileNames, metadataMirrorDownloads, processor, func(index int, name string) FileResult {
+		// Processed by a previous run of the sync of this base, skipped without downloading it
+		if processor.Resume(ctx, name).Done {
+			return processDumpFile(ctx, index, dir, name, processor)
+		}
+		sum := sums[name]
+		if err := downloadMetadataFile(ctx, sum.url, filepath.Join(dir, name), sum.sha256, func(percent float64) {
+			processor.Stats(ctx, name, StatsTypeFileDownload, percent)
+		}); err != nil {
+			return FileResult{FilePath: name, Error: err}
+		}
+		processor.Stats(ctx, name, StatsTypeVerification, 0)
+		return processDumpFile(ctx, index, dir, name, processor)
+	})
+	slog.Info("All files processed")
+
+	return results, nil
+}
+
+type checksum struct {
+	url    string
+	sha256 string
+}
+
+// fetchChecksums returns the files listed in the checksum file at sumsURL by
+// name, with their URL resolved from it.
+func fetchChecksums(ctx context.Context, sumsURL string) (map[string]checksum, error) {
+	base, err := url.Parse(sumsURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata mirror URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sumsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch checksums: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", sumsURL, resp.Status)
+	}
+
+	sums := map[string]checksum{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// <sha256> <name>, the name being prefixed by * in binary mode
+		sum, name, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		if !ok || len(sum) != sha256.Size*2 || name == "" {
+			continue
+		}
+		ref, err := url.Parse(name)
+		if err != nil {
+			continue
+		}
+		sums[path.Base(name)] = checksum{url: base.ResolveReference(ref).String(), sha256: strings.ToLower(sum)}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksums: %w", err)
+	}
+	return sums, nil
+}
+
+// downloadMetadataFile downloads fileURL to filePath unless it's already
+// there and intact, resuming a previous download, and checks its SHA-256. The
+// file is written to filePath.part until then.
+func downloadMetadataFile(ctx context.Context, fileURL, filePath, sum string, progress func(float64)) error {
+	if _, err := os.Stat(filePath); err == nil {
+		err := checkSHA256(filePath, sum)
+		if err == nil {
+			progress(100.0)
+			return nil
+		}
+		slog.Warn("Metadata file corrupted on disk, downloading it again", "path", filePath, "error", err)
+		os.Remove(filePath)
+	}
+
+	partPath := filePath + ".part"
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", fileURL, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		slog.Info("Resuming metadata file download", "url", fileURL, "offset", offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The previous download was complete
+	case resp.StatusCode == http.StatusOK:
+		// The mirror doesn't support ranges, the download starts over
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if offset, err = file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s returned %s", fileURL, resp.Status)
+	}
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		total := offset + max(resp.ContentLength, 0)
+		written := offset
+		buffer := make([]byte, 1024*1024)
+		for {
+			n, err := resp.Body.Read(buffer)
+			if n > 0 {
+				if _, err := file.Write(buffer[:n]); err != nil {
+					return err
+				}
+				written += int64(n)
+				if total > 0 {
+					progress(float64(written) / float64(total) * 100)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to download %s: %w", fileURL, err)
+			}
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := checkSHA256(partPath, sum); err != nil {
+		// Corrupt, the next sync downloads it again
+		os.Remove(partPath)
+		return err
+	}
+	progress(100.0)
+	return os.Rename(partPath, filePath)
+}
+
+// checkSHA256 returns an error unless the file at filePath has the given
+// hex-encoded SHA-256.
+func checkSHA256(filePath, sum string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != sum {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", filepath.Base(filePath), sum, actual)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package anna
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+var (
+	// TorrentStallTimeout is how long a torrent may make no progress before the
+	// download falls back to the mirrors. Zero waits forever.
+	TorrentStallTimeout = config.C.Anna.TorrentStallTimeout
+	// Mirrors are the URL templates of the HTTP mirrors, see MirrorURLs.
+	Mirrors = config.C.Anna.Mirrors
+	// TorrentMetadataTimeout is how long to wait for the info of a torrent. Zero waits forever.
+	TorrentMetadataTimeout = config.C.Anna.TorrentMetadataTimeout
+	// TorrentRetries is the number of extra attempts at a torrent that timed out.
+	TorrentRetries = config.C.Anna.TorrentRetries
+	// DownloadTimeout bounds the download of a file from all its sources. Zero means no limit.
+	DownloadTimeout = config.C.Anna.DownloadTimeout
+)
+
+// ErrDownloadTimeout is returned when a file could not be downloaded because
+// its sources timed out, or the download took longer than DownloadTimeout.
+var ErrDownloadTimeout = errors.New("download timed out")
+
+// errTorrentStalled is returned when a torrent gets no info within
+// TorrentMetadataTimeout or makes no progress for TorrentStallTimeout.
+var errTorrentStalled = errors.New("torrent stalled")
+
+// isTimeout reports whether a download failed because its source was too slow.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, errTorrentStalled) || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+var mirrorClient = &http.Client{
+	Timeout:   30 * time.Minute,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+const (
+	// mirrorSizeMargin is how much larger than its known size a file served
+	// by a mirror may be
+	mirrorSizeMargin = 1 << 20
+	// mirrorMaxSize bounds the files of unknown size served by the mirrors
+	mirrorMaxSize = 2 << 30
+)
+
+// MirrorFile is a file to download from the HTTP mirrors: its URLs, tried in
+// order, and its size and md5 when known. A mirror serving a larger file, or a
+// file of another md5, fails.
+type MirrorFile struct {
+	URLs []string
+	Size int64
+	MD5  string
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z0-9_]+)\}`)
+
+// MirrorURLs returns the URLs of the file of a record on the HTTP mirrors, in
+// the order of Mirrors. Templates may use the {id}, {md5}, {extension} and
+// {filename} placeholders, and any identifier type of the record (e.g.
+// {ipfs_cid}). Templates using an identifier the record doesn't have are
+// skipped.
+func MirrorURLs(id, extension string, identifiers map[string]string) []string {
+	values := map[string]string{
+		"id":        id,
+		"extension": extension,
+	}
+	if md5, ok := strings.CutPrefix(id, "md5:"); ok {
+		values["md5"] = md5
+		values["filename"] = md5 + "." + extension
+	}
+
+	var urls []string
+	for _, template := range Mirrors {
+		complete := true
+		mirror := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+			name := placeholder[1 : len(placeholder)-1]
+			value, ok := values[name]
+			if !ok {
+				value, ok = identifiers[name]
+			}
+			if !ok || value == "" {
+				complete = false
+			}
+			return url.PathEscape(value)
+		})
+		if complete {
+			urls = append(urls, mirror)
+		}
+	}
+	return urls
+}
+
+// downloadFromMirrors fetches a file from the first mirror serving it, and
+// returns the URL it came from.
+func downloadFromMirrors(ctx context.Context, file MirrorFile, tracker *downloadTracker) ([]byte, string, error) {
+	var errs []error
+	for _, mirror := range file.URLs {
+		slog.Info("Downloading file from mirror", "url", mirror)
+		data, err := downloadFromMirror(ctx, mirror, file, tracker)
+		if err == nil {
+			return data, mirror, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		slog.Warn("Failed to download file from mirror", "url", mirror, "error", err)
+		errs = append(errs, err)
+	}
+	return nil, "", fmt.Errorf("no mirror could serve the file: %w", errors.Join(errs...))
+}
+
+func downloadFromMirror(ctx context.Context, mirror string, file MirrorFile, tracker *downloadTracker) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mirror, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", mirror, resp.Status)
+	}
+
+	limit := int64(mirrorMaxSize)
+	if file.Size > 0 {
+		limit = file.Size + mirrorSizeMargin
+	}
+	total := max(resp.ContentLength, 0)
+	tracker.update(0, total)
+	data, err := io.ReadAll(io.LimitReader(&progressReader{reader: resp.Body, total: total, tracker: tracker}, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", mirror, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s served more than the %d bytes expected", mirror, limit)
+	}
+	// Gateways may answer with an error page, or another file
+	if sum := md5.Sum(data); file.MD5 != "" && !strings.EqualFold(hex.EncodeToString(sum[:]), file.MD5) {
+		return nil, fmt.Errorf("%s served a file of md5 %x instead of %s", mirror, sum, file.MD5)
+	}
+	tracker.update(int64(len(data)), int64(len(data)))
+	return data, nil
+}
+
+// progressReader reports the bytes read from a mirror to a download tracker.
+type progressReader struct {
+	reader  io.Reader
+	read    int64
+	total   int64
+	tracker *downloadTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	r.tracker.update(r.read, r.total)
+	return n, err
+}
@@ -0,0 +1,63 @@
+package anna
+
+import (
+	"sync"
+	"time"
+)
+
+// progressSaveInterval is the minimum time between two saves of the progress
+// of a download, changes of status are saved right away.
+const progressSaveInterval = 5 * time.Second
+
+// saveProgress persists the progress of the downloads, see PersistProgress.
+var saveProgress func(outputFilename string, progress DownloadProgressEvent)
+
+var (
+	// unsaved is the latest progress of each download not saved yet, saved
+	// by a single writer woken up by unsavedSignal. Trackers report their
+	// progress with locks held, the queue's included, so they never wait
+	// for the database
+	unsavedMu     sync.Mutex
+	unsaved       = map[string]DownloadProgressEvent{}
+	unsavedSignal = make(chan struct{}, 1)
+)
+
+// PersistProgress sets the function saving the progress of the downloads, so
+// that it can be restored after a restart. It must be called before any
+// download starts.
+func PersistProgress(save func(outputFilename string, progress DownloadProgressEvent)) {
+	saveProgress = save
+	go func() {
+		for range unsavedSignal {
+			unsavedMu.Lock()
+			pending := unsaved
+			unsaved = map[string]DownloadProgressEvent{}
+			unsavedMu.Unlock()
+
+			for filename, progress := range pending {
+				save(filename, progress)
+			}
+		}
+	}()
+}
+
+// queueProgress hands the progress of a download over to the writer of
+// PersistProgress, without waiting for it to be saved.
+func queueProgress(outputFilename string, progress DownloadProgressEvent) {
+	unsavedMu.Lock()
+	unsaved[outputFilename] = progress
+	unsavedMu.Unlock()
+
+	select {
+	case unsavedSignal <- struct{}{}:
+	default:
+	}
+}
+
+// RestoreProgress reports the progress of a download interrupted by a restart
+// until DownloadFile resumes it, which must be called right after.
+func RestoreProgress(outputFilename string, progress DownloadProgressEvent) {
+	tracker := newDownloadTracker(outputFilename)
+	tracker.progress = progress
+	activeDownloads.LoadOrStore(outputFilename, tracker)
+}
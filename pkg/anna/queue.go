@@ -0,0 +1,102 @@
+package anna
+
+import (
+	"context"
+	"sync"
+
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+// MaxActiveDownloads is the number of files downloaded from torrents at once,
+// the other downloads wait in a queue. Zero means unlimited.
+var MaxActiveDownloads = config.C.Anna.MaxActiveDownloads
+
+// queueTicket is a download waiting for a slot.
+type queueTicket struct {
+	ready chan struct{}
+	// position is called with the 1-based position of the ticket each time it changes
+	position func(int)
+}
+
+// downloadQueue hands out download slots in FIFO order.
+type downloadQueue struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiting []*queueTicket
+}
+
+var queue = &downloadQueue{limit: MaxActiveDownloads}
+
+// acquire waits for a download slot, calling position with the position of
+// the caller in the queue while it waits. The returned function frees the slot.
+func (q *downloadQueue) acquire(ctx context.Context, position func(int)) (func(), error) {
+	q.mu.Lock()
+	if q.limit <= 0 || (q.active < q.limit && len(q.waiting) == 0) {
+		q.active++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+
+	ticket := &queueTicket{ready: make(chan struct{}), position: position}
+	q.waiting = append(q.waiting, ticket)
+	position(len(q.waiting))
+	q.mu.Unlock()
+
+	select {
+	case <-ticket.ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-ticket.ready:
+			// The slot was handed over meanwhile, give it to the next one
+			q.active--
+			q.next()
+		default:
+			q.remove(ticket)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (q *downloadQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	q.next()
+}
+
+// next hands free slots over to the first waiting tickets. It must be called with mu held.
+func (q *downloadQueue) next() {
+	handed := false
+	for len(q.waiting) > 0 && (q.limit <= 0 || q.active < q.limit) {
+		ticket := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.active++
+		close(ticket.ready)
+		handed = true
+	}
+	if handed {
+		q.notify()
+	}
+}
+
+// remove drops a ticket from the queue. It must be called with mu held.
+func (q *downloadQueue) remove(ticket *queueTicket) {
+	for i, waiting := range q.waiting {
+		if waiting == ticket {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.notify()
+			return
+		}
+	}
+}
+
+// notify sends their position to the waiting tickets. It must be called with mu held.
+func (q *downloadQueue) notify() {
+	for i, ticket := range q.waiting {
+		ticket.position(i + 1)
+	}
+}
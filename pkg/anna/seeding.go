@@ -0,0 +1,173 @@
+package anna
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+var (
+	// Seed keeps the torrents of downloaded files in the swarm after the
+	// download, until SeedRatio or SeedTime is reached.
+	Seed = config.C.Anna.Seed
+	// SeedRatio is the ratio of uploaded to downloaded bytes after which a
+	// torrent stops being seeded. Zero means no ratio limit.
+	SeedRatio = config.C.Anna.SeedRatio
+	// SeedTime is how long a torrent is seeded at most. Zero means no time limit.
+	SeedTime = config.C.Anna.SeedTime
+)
+
+// seedCheckInterval is the time between two checks of the seeding limits.
+const seedCheckInterval = time.Minute
+
+// SeedingTorrent describes a torrent kept in the swarm after a download.
+type SeedingTorrent struct {
+	InfoHash string    `json:"info_hash"`
+	Name     string    `json:"name"`
+	Since    time.Time `json:"since" doc:"When the last download from the torrent completed"`
+	Uploaded int64     `json:"uploaded" doc:"Bytes uploaded to peers"`
+	Size     int64     `json:"size" doc:"Bytes of the torrent downloaded"`
+	Ratio    float64   `json:"ratio"`
+	Peers    int       `json:"peers" doc:"Number of connected peers"`
+}
+
+// torrentRef counts the downloads using a torrent of the client.
+type torrentRef struct {
+	t     *torrent.Torrent
+	users int
+	// seeding is when the torrent started being seeded, zero while downloads use it
+	seeding time.Time
+}
+
+var (
+	torrentsMu sync.Mutex
+	torrents   = map[metainfo.Hash]*torrentRef{}
+)
+
+// acquireTorrent adds a torrent to the client for a file download. The
+// returned function releases it once the download is done: the torrent is
+// then seeded, or dropped when seeding is disabled.
+func acquireTorrent(magnetLink string) (*torrent.Torrent, func(), error) {
+	torrentsMu.Lock()
+	defer torrentsMu.Unlock()
+
+	t, err := addMagnet(magnetLink, config.C.Anna.TorrentWebseeds)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref, ok := torrents[t.InfoHash()]
+	if !ok {
+		ref = &torrentRef{t: t}
+		torrents[t.InfoHash()] = ref
+	}
+	ref.users++
+	ref.seeding = time.Time{}
+	return t, func() { releaseTorrent(ref) }, nil
+}
+
+func releaseTorrent(ref *torrentRef) {
+	torrentsMu.Lock()
+	defer torrentsMu.Unlock()
+
+	ref.users--
+	if ref.users > 0 {
+		return
+	}
+	if !Seed || ref.t.Info() == nil || ref.t.BytesCompleted() == 0 {
+		dropTorrent(ref)
+		return
+	}
+	slog.Info("Seeding torrent", "torrent", ref.t.Name())
+	ref.seeding = time.Now()
+}
+
+// dropTorrent removes a torrent from the client. It must be called with torrentsMu held.
+// When a storage key is set, the data of the torrent is deleted from DataDir
+// too, so that no plaintext copy of the downloaded files is left behind.
+func dropTorrent(ref *torrentRef) {
+	delete(torrents, ref.t.InfoHash())
+	info := ref.t.Info()
+	ref.t.Drop()
+	if storageAEAD() == nil || info == nil {
+		return
+	}
+	dir := filepath.Join(DataDir, info.BestName())
+	if err := os.RemoveAll(dir); err != nil {
+		slog.Warn("Failed to remove torrent data", "path", dir, "error", err)
+	}
+}
+
+// ratio returns the ratio of uploaded to downloaded bytes of a torrent.
+func (ref *torrentRef) ratio() float64 {
+	completed := ref.t.BytesCompleted()
+	if completed <= 0 {
+		return 0
+	}
+	stats := ref.t.Stats()
+	return float64(stats.BytesWrittenData.Int64()) / float64(completed)
+}
+
+// GetSeedingTorrents returns the torrents being seeded, the oldest first.
+func GetSeedingTorrents() []SeedingTorrent {
+	torrentsMu.Lock()
+	defer torrentsMu.Unlock()
+
+	seeding := []SeedingTorrent{}
+	for hash, ref := range torrents {
+		if ref.seeding.IsZero() {
+			continue
+		}
+		stats := ref.t.Stats()
+		seeding = append(seeding, SeedingTorrent{
+			InfoHash: hash.HexString(),
+			Name:     ref.t.Name(),
+			Since:    ref.seeding,
+			Uploaded: stats.BytesWrittenData.Int64(),
+			Size:     ref.t.BytesCompleted(),
+			Ratio:    ref.ratio(),
+			Peers:    stats.ActivePeers,
+		})
+	}
+	sort.Slice(seeding, func(i, j int) bool { return seeding[i].Since.Before(seeding[j].Since) })
+	return seeding
+}
+
+// RunSeeder drops the seeded torrents that reached SeedRatio or SeedTime every
+// minute, until ctx is done. It returns right away when seeding is disabled.
+func RunSeeder(ctx context.Context) {
+	if !Seed {
+		return
+	}
+	slog.Info("Seeding downloaded torrents", "ratio", SeedRatio, "time", SeedTime)
+
+	ticker := time.NewTicker(seedCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		torrentsMu.Lock()
+		for _, ref := range torrents {
+			if ref.seeding.IsZero() {
+				continue
+			}
+			ratio := ref.ratio()
+			if (SeedRatio > 0 && ratio >= SeedRatio) || (SeedTime > 0 && time.Since(ref.seeding) >= SeedTime) {
+				slog.Info("Stopped seeding torrent", "torrent", ref.t.Name(), "ratio", ratio, "since", ref.seeding)
+				dropTorrent(ref)
+			}
+		}
+		torrentsMu.Unlock()
+	}
+}
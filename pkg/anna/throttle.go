@@ -0,0 +1,97 @@
+package anna
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/iziplay/anna-api/pkg/config"
+	"golang.org/x/time/rate"
+)
+
+// throttleChunkSize is the size of the reads of throttled downloads, and the
+// burst of their limiters.
+const throttleChunkSize = 64 << 10
+
+var (
+	// DownloadRate caps the bandwidth per second of all the file downloads
+	// from torrents. Zero means unlimited.
+	DownloadRate = int64(config.C.Anna.DownloadRate)
+	// DownloadRatePerFile caps the bandwidth per second of each file
+	// download from torrents. Zero means unlimited.
+	DownloadRatePerFile = int64(config.C.Anna.DownloadRatePerFile)
+)
+
+// downloadLimiter is shared by the file downloads, nil when DownloadRate is not set.
+var downloadLimiter = newLimiter(DownloadRate)
+
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), throttleChunkSize)
+}
+
+// readThrottled downloads a file of a torrent by reading it no faster than
+// the download limits allow. The file is not marked for download: the client
+// only fetches the pieces ahead of the reader, so the limits apply to the
+// swarm traffic and the torrent client (shared with the metadata sync) is not
+// limited itself.
+func readThrottled(ctx context.Context, t *torrent.Torrent, file *torrent.File, tracker *downloadTracker) ([]byte, error) {
+	limiters := []*rate.Limiter{downloadLimiter, newLimiter(DownloadRatePerFile)}
+
+	readCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var stall *time.Timer
+	if TorrentStallTimeout > 0 {
+		stall = time.AfterFunc(TorrentStallTimeout, func() { cancel(errTorrentStalled) })
+		defer stall.Stop()
+	}
+
+	reader := file.NewReader()
+	defer reader.Close()
+	reader.SetContext(readCtx)
+
+	length := file.Length()
+	data := make([]byte, length)
+	tracker.update(0, length)
+	slog.Info("Reading file from torrent with download limits", "path", file.Path(), "rate", DownloadRate, "rate_per_file", DownloadRatePerFile)
+
+	for read := int64(0); read < length; {
+		n := min(int64(throttleChunkSize), length-read)
+		// Waiting for the limiters is not a stall
+		if stall != nil {
+			stall.Stop()
+		}
+		for _, limiter := range limiters {
+			if limiter == nil {
+				continue
+			}
+			if err := limiter.WaitN(ctx, int(n)); err != nil {
+				return nil, err
+			}
+		}
+		if stall != nil {
+			stall.Reset(TorrentStallTimeout)
+		}
+
+		if _, err := io.ReadFull(reader, data[read:read+n]); err != nil {
+			if errors.Is(context.Cause(readCtx), errTorrentStalled) {
+				return nil, fmt.Errorf("%w: no progress for %s with %d active peers", errTorrentStalled, TorrentStallTimeout, t.Stats().ActivePeers)
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		read += n
+		tracker.update(read, length)
+	}
+
+	slog.Info("File read into memory", "size", len(data))
+	return data, nil
+}
@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+
+	"github.com/iziplay/anna-api/pkg/config"
 )
 
 type TorrentsResponse struct {
@@ -19,7 +20,7 @@ type TorrentsResponse struct {
 }
 
 func FetchTorrentsList() ([]TorrentsResponse, error) {
-	resp, err := http.Get("https://" + os.Getenv("ANNA_DOMAIN") + "/dyn/torrents.json")
+	resp, err := http.Get("https://" + config.C.Anna.Domain + "/dyn/torrents.json")
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch torrents: %w", err)
 	}
@@ -0,0 +1,219 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/sync"
+)
+
+// AdminScope is the token scope required by operator endpoints.
+const AdminScope = "admin"
+
+var adminSecurity = []map[string][]string{
+	{"bearerAuth": {AdminScope}},
+}
+
+type TriggerSyncOutput struct {
+	Body struct {
+		Message string `json:"message"`
+	}
+}
+
+type TriggerSyncInput struct {
+	DryRun    bool `query:"dry_run" doc:"Only count the records the sync would insert, update or skip, without writing anything. The counts are reported in dryRun on /v1/statistics/sync"`
+	Force     bool `query:"force" doc:"Process every metadata file again, even if the last metadata torrent was already synced, e.g. after an upgrade changing how records are stored"`
+	Reprocess bool `query:"reprocess" doc:"Process again the metadata files of the last synced base kept on disk with ANNA_KEEP_FILES, without downloading anything, e.g. after fixing how records are ingested"`
+}
+
+type RollbackSyncOutput struct {
+	Body sync.RollbackResult
+}
+
+type RefreshTorrentsOutput struct {
+	Body struct {
+		Torrents int `json:"torrents" doc:"Torrents listed by Anna's Archive"`
+	}
+}
+
+type PurgeEpubsInput struct {
+	OlderThan string `query:"older_than" doc:"Only remove epubs downloaded longer ago than this duration (e.g. 720h)"`
+	Pattern   string `query:"pattern" doc:"Only remove epubs whose file name matches this glob pattern (e.g. md5_a*)"`
+}
+
+type PurgeEpubsOutput struct {
+	Body anna.PurgeResult
+}
+
+type EpubCacheOutput struct {
+	Body anna.CacheStats
+}
+
+type SeedingOutput struct {
+	Body struct {
+		Torrents []anna.SeedingTorrent `json:"torrents"`
+	}
+}
+
+// setupAdmin registers the operator endpoints, which require a token with the admin scope.
+func setupAdmin(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "TriggerSync",
+		Method:        "POST",
+		Path:          "/v1/admin/sync",
+		Summary:       "Trigger a sync",
+		Description:   "Start a sync with Anna's Archive now instead of waiting for the daily one. Progress can be followed on /v1/statistics/sync",
+		Tags:          []string{"Admin"},
+		DefaultStatus: http.StatusAccepted,
+		Security:      adminSecurity,
+	}, func(ctx context.Context, input *TriggerSyncInput) (*TriggerSyncOutput, error) {
+		if sync.Disabled() {
+			return nil, huma.Error409Conflict("sync is disabled", codeSyncDisabled)
+		}
+		if (input.DryRun && input.Force) || (input.Reprocess && (input.DryRun || input.Force)) {
+			return nil, huma.Error400BadRequest("dry_run, force and reprocess cannot be combined")
+		}
+		start, message := sync.Start, "sync started"
+		switch {
+		case input.DryRun:
+			start, message = sync.StartDryRun, "dry-run sync started"
+		case input.Force:
+			start, message = sync.StartForced, "forced sync started"
+		case input.Reprocess:
+			start, message = sync.StartReprocess, "reprocessing sync started"
+		}
+		if err := start(context.WithoutCancel(ctx)); err != nil {
+			if errors.Is(err, sync.ErrAlreadyRunning) {
+				return nil, huma.Error409Conflict("a sync is already running", codeSyncRunning)
+			}
+			if errors.Is(err, sync.ErrNotLeader) {
+				return nil, huma.Error409Conflict("syncs run on another instance", codeSyncNotLeader)
+			}
+			if errors.Is(err, anna.ErrNoKeptFiles) {
+				return nil, huma.Error409Conflict("the metadata files of the last sync were not kept on disk", codeNoKeptFiles)
+			}
+			return nil, huma.Error500InternalServerError("failed to start sync", err)
+		}
+		resp := &TriggerSyncOutput{}
+		resp.Body.Message = message
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "RollbackSync",
+		Method:      "POST",
+		Path:        "/v1/admin/sync/rollback",
+		Summary:     "Roll back the last sync",
+		Description: "Restore the records as they were before the last complete sync, if the new base turns out to be broken. The previous version of the records is only kept by syncs run with ANNA_SYNC_RETAIN_PREVIOUS set, and the rolled back base isn't synced again",
+		Tags:        []string{"Admin"},
+		Security:    adminSecurity,
+	}, func(ctx context.Context, input *struct{}) (*RollbackSyncOutput, error) {
+		result, err := sync.Rollback(context.WithoutCancel(ctx))
+		if err != nil {
+			if errors.Is(err, sync.ErrAlreadyRunning) {
+				return nil, huma.Error409Conflict("a sync is running", codeSyncRunning)
+			}
+			if errors.Is(err, sync.ErrNotLeader) {
+				return nil, huma.Error409Conflict("syncs run on another instance", codeSyncNotLeader)
+			}
+			if errors.Is(err, database.ErrNoPreviousGeneration) {
+				return nil, huma.Error409Conflict("the last sync cannot be rolled back", codeNoPreviousSync)
+			}
+			return nil, huma.Error500InternalServerError("failed to roll back the last sync", err)
+		}
+		return &RollbackSyncOutput{Body: *result}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "RefreshTorrents",
+		Method:      "POST",
+		Path:        "/v1/admin/torrents/refresh",
+		Summary:     "Refresh the torrents list",
+		Description: "Fetch the torrents list of Anna's Archive and save it without syncing the metadata, so that the files of newly released torrents can be downloaded right away",
+		Tags:        []string{"Admin"},
+		Security:    adminSecurity,
+	}, func(ctx context.Context, input *struct{}) (*RefreshTorrentsOutput, error) {
+		count, err := sync.RefreshTorrents(ctx)
+		if err != nil {
+			return nil, huma.Error502BadGateway("failed to refresh the torrents list", err)
+		}
+		resp := &RefreshTorrentsOutput{}
+		resp.Body.Torrents = count
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "RefreshStatistics",
+		Method:      "POST",
+		Path:        "/v1/admin/stats/refresh",
+		Summary:     "Refresh statistics",
+		Description: "Recompute the cached statistics from the database and return them",
+		Tags:        []string{"Admin"},
+		Security:    adminSecurity,
+	}, func(ctx context.Context, input *struct{}) (*StatsOutput, error) {
+		stats := database.ComputeAndCacheStats(true)
+		if stats == nil {
+			return nil, huma.Error409Conflict("no complete sync yet, statistics cannot be computed", codeStatsUnavailable)
+		}
+		return &StatsOutput{Body: *stats}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetEpubCache",
+		Method:      "GET",
+		Path:        "/v1/admin/epubs",
+		Summary:     "Get stored epubs",
+		Description: "Get the number and size of the files of the storage directory, how many were evicted since startup to keep it under ANNA_EPUB_CACHE_MAX_SIZE, and when files older than ANNA_EPUB_RETENTION were and will be removed",
+		Tags:        []string{"Admin"},
+		Security:    adminSecurity,
+	}, func(ctx context.Context, input *struct{}) (*EpubCacheOutput, error) {
+		return &EpubCacheOutput{Body: anna.GetCacheStats()}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "ListSeedingTorrents",
+		Method:      "GET",
+		Path:        "/v1/admin/seeding",
+		Summary:     "List seeding torrents",
+		Description: "List the torrents kept in the swarm after a download when ANNA_SEED is set, with their upload ratio. They are dropped once they reach ANNA_SEED_RATIO or have been seeded for ANNA_SEED_TIME",
+		Tags:        []string{"Admin"},
+		Security:    adminSecurity,
+	}, func(ctx context.Context, input *struct{}) (*SeedingOutput, error) {
+		resp := &SeedingOutput{}
+		resp.Body.Torrents = anna.GetSeedingTorrents()
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "PurgeEpubs",
+		Method:      "DELETE",
+		Path:        "/v1/admin/epubs",
+		Summary:     "Purge stored epubs",
+		Description: "Remove downloaded epubs from the storage directory to reclaim disk space. Epubs being downloaded are kept",
+		Tags:        []string{"Admin"},
+		Security:    adminSecurity,
+	}, func(ctx context.Context, input *PurgeEpubsInput) (*PurgeEpubsOutput, error) {
+		var olderThan time.Duration
+		if input.OlderThan != "" {
+			var err error
+			if olderThan, err = time.ParseDuration(input.OlderThan); err != nil {
+				return nil, huma.Error400BadRequest("invalid older_than duration", err)
+			}
+		}
+
+		result, err := anna.PurgeStoredFiles(olderThan, input.Pattern)
+		if err != nil {
+			if errors.Is(err, filepath.ErrBadPattern) {
+				return nil, huma.Error400BadRequest("invalid pattern", err)
+			}
+			return nil, huma.Error500InternalServerError("failed to purge epubs", err)
+		}
+		return &PurgeEpubsOutput{Body: result}, nil
+	})
+}
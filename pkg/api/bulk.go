@@ -0,0 +1,144 @@
+package routing
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/webhook"
+)
+
+type BulkDownloadInput struct {
+	Body struct {
+		IDs    []string `json:"ids" minItems:"1" maxItems:"50" uniqueItems:"true" doc:"Record IDs whose files are put in the archive"`
+		Format string   `json:"format,omitempty" enum:"epub,pdf,mobi,azw3,cbz,djvu" doc:"Expected format of the files, requests including a record in another format fail with FORMAT_UNAVAILABLE"`
+	}
+}
+
+type BulkDownloadOutput struct {
+	ContentType        string `header:"Content-Type"`
+	ContentDisposition string `header:"Content-Disposition"`
+	Body               func(ctx huma.Context)
+}
+
+// bulkDownload is a file to put in a bulk download archive.
+type bulkDownload struct {
+	id      string
+	info    *database.RecordDownloadInfo
+	sources []anna.TorrentSource
+}
+
+// setupBulkDownload registers the endpoint downloading several files as a ZIP archive.
+func setupBulkDownload(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "BulkDownloadRecords",
+		Method:      http.MethodPost,
+		Path:        "/v1/records/download",
+		Summary:     "Download files as ZIP",
+		Description: "Download the files of several records in a single ZIP archive, downloading the missing ones from their source torrents first. The archive is streamed as files become available; files that fail to download are listed in an errors.txt entry instead. Each file counts towards the daily quota of the tenant of the token, if any, and the files over the quota are listed in errors.txt too",
+		Tags:        []string{"Download"},
+		Metadata:    map[string]any{downloadMetadata: true},
+		Security: []map[string][]string{
+			{"bearerAuth": {}},
+		},
+	}, func(ctx context.Context, input *BulkDownloadInput) (*BulkDownloadOutput, error) {
+		// Resolve every record before streaming, errors can't be reported afterwards
+		downloads := make([]bulkDownload, 0, len(input.Body.IDs))
+		for _, id := range input.Body.IDs {
+			download, err := resolveBulkDownload(ctx, id, input.Body.Format)
+			if err != nil {
+				return nil, err
+			}
+			downloads = append(downloads, *download)
+		}
+
+		// Each file is counted before it is written to the archive, the ones
+		// over the quota are skipped
+		if err := checkTenantQuota(ctx, 1); err != nil {
+			return nil, err
+		}
+
+		// Files are downloaded one at a time, the archive takes a single slot
+		release, err := acquireDownload(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &BulkDownloadOutput{
+			ContentType:        "application/zip",
+			ContentDisposition: fmt.Sprintf(`attachment; filename="records-%s.zip"`, time.Now().UTC().Format("20060102-150405")),
+			Body: func(hctx huma.Context) {
+				defer release()
+				writeBulkDownload(ctx, hctx.BodyWriter(), downloads)
+			},
+		}, nil
+	})
+}
+
+// writeBulkDownload writes the files of downloads as a ZIP archive, in order,
+// flushing after each of them. Each file is counted towards the quota of the
+// tenant of the request before it is written, and skipped when over it.
+func writeBulkDownload(ctx context.Context, w io.Writer, downloads []bulkDownload) {
+	archive := zip.NewWriter(w)
+	var failures []string
+
+	for _, download := range downloads {
+		if ctx.Err() != nil {
+			// The client is gone, downloads still complete in the background
+			return
+		}
+
+		filename := anna.RecordFilename(download.id, download.info.Extension)
+		data, err := fetchRecord(context.WithoutCancel(ctx), download.id, download.info, download.sources)
+		if err != nil {
+			slog.Error("Failed to download file for archive", "id", download.id, "error", err)
+			webhook.Publish(ctx, webhook.EventDownloadFailed, map[string]any{"id": download.id, "error": err.Error()})
+			failures = append(failures, fmt.Sprintf("%s: %v", download.id, err))
+			continue
+		}
+
+		if err := countTenantDownload(context.WithoutCancel(ctx)); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", download.id, err))
+			continue
+		}
+
+		// Ebooks are compressed already, storing them is about as small and much cheaper
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     filename,
+			Method:   zip.Store,
+			Modified: time.Now(),
+		})
+		if err == nil {
+			_, err = entry.Write(data)
+		}
+		if err == nil {
+			err = archive.Flush()
+		}
+		if err != nil {
+			// Headers are already sent, the client sees a truncated archive
+			slog.Error("Failed to write archive", "error", err)
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		countRecordDownload(context.WithoutCancel(ctx), download.id)
+	}
+
+	if len(failures) > 0 {
+		if entry, err := archive.Create("errors.txt"); err == nil {
+			_, _ = entry.Write([]byte(strings.Join(failures, "\n") + "\n"))
+		}
+	}
+	if err := archive.Close(); err != nil {
+		slog.Error("Failed to write archive", "error", err)
+	}
+}
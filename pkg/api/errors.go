@@ -0,0 +1,130 @@
+package routing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// errorCode is a machine-readable error code. Passed among the errors of a
+// huma error (e.g. huma.Error404NotFound("record not found", codeRecordNotFound))
+// it becomes the code of the response instead of an error detail.
+type errorCode string
+
+func (c errorCode) Error() string {
+	return string(c)
+}
+
+const (
+	codeRecordNotFound     errorCode = "RECORD_NOT_FOUND"
+	codeTorrentUnavailable errorCode = "TORRENT_UNAVAILABLE"
+	codeDownloadFailed     errorCode = "DOWNLOAD_FAILED"
+	codeDownloadTimeout    errorCode = "DOWNLOAD_TIMEOUT"
+	codeEpubNotDownloaded  errorCode = "EPUB_NOT_DOWNLOADED"
+	codeFormatUnavailable  errorCode = "FORMAT_UNAVAILABLE"
+	codeCoverUnavailable   errorCode = "COVER_UNAVAILABLE"
+	codeInvalidQuery       errorCode = "INVALID_QUERY"
+	codeStatsUnavailable   errorCode = "STATS_UNAVAILABLE"
+	codeNotReady           errorCode = "NOT_READY"
+	codeInvalidToken       errorCode = "INVALID_TOKEN"
+	codeMissingScope       errorCode = "MISSING_SCOPE"
+	codeAuthDisabled       errorCode = "AUTHENTICATION_DISABLED"
+	codeRateLimited        errorCode = "RATE_LIMITED"
+	codeTooManyDownloads   errorCode = "TOO_MANY_DOWNLOADS"
+	codeQuotaExceeded      errorCode = "QUOTA_EXCEEDED"
+	codeSyncRunning        errorCode = "SYNC_RUNNING"
+	codeSyncDisabled       errorCode = "SYNC_DISABLED"
+	codeSyncNotLeader      errorCode = "SYNC_NOT_LEADER"
+	codeNoPreviousSync     errorCode = "NO_PREVIOUS_SYNC"
+	codeNoKeptFiles        errorCode = "NO_KEPT_FILES"
+	codeWebhookNotFound    errorCode = "WEBHOOK_NOT_FOUND"
+)
+
+// APIError is the body of every error response: an RFC 9457 problem with a
+// machine-readable code and the ID of the request.
+type APIError struct {
+	huma.ErrorModel
+	Code      string `json:"code" doc:"Machine-readable error code, e.g. RECORD_NOT_FOUND. Defaults to the HTTP status text, e.g. NOT_FOUND" example:"RECORD_NOT_FOUND"`
+	RequestID string `json:"request_id,omitempty" doc:"ID of the request, as sent in the X-Request-ID header"`
+}
+
+var humaNewError = huma.NewError
+
+// newError builds an APIError, using the errorCode found among errs as its code.
+func newError(status int, msg string, errs ...error) huma.StatusError {
+	code := strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+
+	details := make([]error, 0, len(errs))
+	for _, err := range errs {
+		if c, ok := err.(errorCode); ok {
+			code = string(c)
+			continue
+		}
+		details = append(details, err)
+	}
+
+	model, ok := humaNewError(status, msg, details...).(*huma.ErrorModel)
+	if !ok {
+		return humaNewError(status, msg, details...)
+	}
+	return &APIError{ErrorModel: *model, Code: code}
+}
+
+// requestIDHeader carries the ID of a request. Clients and proxies can set it,
+// otherwise one is generated, and it is sent back in responses.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestIDMiddleware propagates or generates the request ID and exposes it in
+// the context and the response headers.
+func requestIDMiddleware(ctx huma.Context, next func(huma.Context)) {
+	id := ctx.Header(requestIDHeader)
+	if !validRequestID(id) {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	ctx.SetHeader(requestIDHeader, id)
+	next(huma.WithValue(ctx, requestIDKey{}, id))
+}
+
+// validRequestID accepts short IDs of printable ASCII characters, so that
+// client-provided IDs are safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID returns the ID of the request being handled, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ErrorTransformer adds the request ID to error responses, and wraps them in
+// an ErrorEnvelope on v2 operations. It must be registered in the huma
+// configuration transformers.
+func ErrorTransformer(ctx huma.Context, status string, v any) (any, error) {
+	err, ok := v.(*APIError)
+	if !ok {
+		return v, nil
+	}
+	err.RequestID = RequestID(ctx.Context())
+	if op := ctx.Operation(); op != nil && strings.HasPrefix(op.Path, "/v2/") {
+		return &ErrorEnvelope{Errors: []*APIError{err}, Meta: Meta{RequestID: err.RequestID}}, nil
+	}
+	return v, nil
+}
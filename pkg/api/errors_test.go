@@ -0,0 +1,119 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/humatest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newErrorTestAPI returns an API with the error handling of Setup and
+// operations failing in different ways.
+func newErrorTestAPI(t *testing.T) humatest.TestAPI {
+	previous := huma.NewError
+	huma.NewError = newError
+	t.Cleanup(func() { huma.NewError = previous })
+
+	config := huma.DefaultConfig("Test", "1.0.0")
+	config.Transformers = append(config.Transformers, ErrorTransformer)
+	_, api := humatest.New(t, config)
+	api.UseMiddleware(requestIDMiddleware)
+
+	huma.Get(api, "/v1/records/{id}", func(ctx context.Context, input *struct {
+		ID string `path:"id"`
+	}) (*struct{}, error) {
+		return nil, huma.Error404NotFound("record not found", codeRecordNotFound)
+	})
+	huma.Get(api, "/v1/search", func(ctx context.Context, input *struct {
+		Limit int `query:"limit" minimum:"1" maximum:"100"`
+	}) (*struct{}, error) {
+		return &struct{}{}, nil
+	})
+	huma.Get(api, "/v1/statistics", func(ctx context.Context, input *struct{}) (*struct{}, error) {
+		return nil, huma.Error500InternalServerError("failed to get statistics", errors.New("connection refused"))
+	})
+	huma.Get(api, "/v2/records/{id}", func(ctx context.Context, input *struct {
+		ID string `path:"id"`
+	}) (*struct{}, error) {
+		return nil, huma.Error404NotFound("record not found", codeRecordNotFound)
+	})
+	return api
+}
+
+// apiError decodes the error body of a response.
+func apiError(t *testing.T, body []byte) map[string]any {
+	var v map[string]any
+	assert.NoError(t, json.Unmarshal(body, &v))
+	return v
+}
+
+func TestNotFoundError(t *testing.T) {
+	api := newErrorTestAPI(t)
+
+	resp := api.Get("/v1/records/md5:unknown", "X-Request-ID: req-404")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, "application/problem+json", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "req-404", resp.Header().Get(requestIDHeader))
+
+	body := apiError(t, resp.Body.Bytes())
+	assert.Equal(t, float64(http.StatusNotFound), body["status"])
+	assert.Equal(t, "Not Found", body["title"])
+	assert.Equal(t, "record not found", body["detail"])
+	assert.Equal(t, "RECORD_NOT_FOUND", body["code"])
+	assert.Equal(t, "req-404", body["request_id"])
+	assert.NotContains(t, body, "errors")
+}
+
+func TestValidationError(t *testing.T) {
+	api := newErrorTestAPI(t)
+
+	resp := api.Get("/v1/search?limit=1000")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+	body := apiError(t, resp.Body.Bytes())
+	assert.Equal(t, float64(http.StatusUnprocessableEntity), body["status"])
+	assert.Equal(t, "validation failed", body["detail"])
+	assert.Equal(t, "UNPROCESSABLE_ENTITY", body["code"])
+	assert.NotEmpty(t, body["request_id"])
+	if errs, ok := body["errors"].([]any); assert.True(t, ok) && assert.Len(t, errs, 1) {
+		detail := errs[0].(map[string]any)
+		assert.Equal(t, "query.limit", detail["location"])
+		assert.Equal(t, float64(1000), detail["value"])
+	}
+}
+
+func TestInternalError(t *testing.T) {
+	api := newErrorTestAPI(t)
+
+	resp := api.Get("/v1/statistics")
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	body := apiError(t, resp.Body.Bytes())
+	assert.Equal(t, float64(http.StatusInternalServerError), body["status"])
+	assert.Equal(t, "failed to get statistics", body["detail"])
+	assert.Equal(t, "INTERNAL_SERVER_ERROR", body["code"])
+	if errs, ok := body["errors"].([]any); assert.True(t, ok) && assert.Len(t, errs, 1) {
+		assert.Equal(t, "connection refused", errs[0].(map[string]any)["message"])
+	}
+}
+
+func TestEnvelopedError(t *testing.T) {
+	api := newErrorTestAPI(t)
+
+	resp := api.Get("/v2/records/md5:unknown", "X-Request-ID: req-v2")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	var body ErrorEnvelope
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	if assert.Len(t, body.Errors, 1) {
+		assert.Equal(t, "RECORD_NOT_FOUND", body.Errors[0].Code)
+		assert.Equal(t, http.StatusNotFound, body.Errors[0].Status)
+		assert.Equal(t, "req-v2", body.Errors[0].RequestID)
+	}
+	assert.Equal(t, "req-v2", body.Meta.RequestID)
+}
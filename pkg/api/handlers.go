@@ -0,0 +1,443 @@
+package routing
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/cover"
+	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/kepub"
+	"gorm.io/gorm"
+)
+
+// The operations served by several API versions are implemented below, the
+// versions only differ by how they read inputs and render results.
+
+// searchResult is a page of search results.
+type searchResult struct {
+	Mode       database.SearchMode
+	Records    []database.Record
+	Total      *int64
+	Estimated  bool
+	NextCursor string
+	Matches    map[string][]string
+}
+
+func newSearchResult(records []database.Record, total int64, page database.Page) *searchResult {
+	result := &searchResult{Records: records}
+	if page.Cursors() {
+		result.NextCursor = database.NextCursor(records, page.Limit)
+	}
+	switch page.Count {
+	case database.CountModeNone:
+	case database.CountModeEstimated:
+		result.Estimated = true
+		result.Total = &total
+	default:
+		result.Total = &total
+	}
+	return result
+}
+
+// searchError maps search errors to 400 for invalid queries, 500 otherwise.
+func searchError(err error, message string) error {
+	if database.IsValidationError(err) {
+		return huma.Error400BadRequest(err.Error(), codeInvalidQuery)
+	}
+	return huma.Error500InternalServerError(message, err)
+}
+
+func search(ctx context.Context, query string, filters database.SearchFilters, page database.Page, projection database.Projection) (*searchResult, error) {
+	records, total, mode, err := database.Search(ctx, query, filters, page, projection)
+	if err != nil {
+		return nil, searchError(err, "failed to search")
+	}
+	result := newSearchResult(records, total, page)
+	result.Mode = mode
+	return result, nil
+}
+
+// searchByISBN searches ISBNs given as repeated or comma-separated values.
+func searchByISBN(ctx context.Context, values []string, filters database.SearchFilters, page database.Page, projection database.Projection) (*searchResult, error) {
+	var isbns []string
+	for _, value := range values {
+		isbns = append(isbns, strings.Split(value, ",")...)
+	}
+
+	records, total, matches, err := database.SearchByISBNs(ctx, isbns, filters, page, projection)
+	if err != nil {
+		return nil, searchError(err, "failed to search by ISBN")
+	}
+	result := newSearchResult(records, total, page)
+	if len(isbns) > 1 {
+		result.Matches = matches
+	}
+	return result, nil
+}
+
+func searchByText(ctx context.Context, query database.TextQuery, filters database.SearchFilters, page database.Page, projection database.Projection) (*searchResult, error) {
+	records, total, err := database.SearchByText(ctx, query, filters, page, projection)
+	if err != nil {
+		return nil, searchError(err, "failed to search by text")
+	}
+	result := newSearchResult(records, total, page)
+	if query.Fuzzy {
+		// Fuzzy results are ordered by similarity and can only be paginated with offsets
+		result.NextCursor = ""
+	}
+	return result, nil
+}
+
+func suggest(ctx context.Context, query string, limit int) ([]database.Suggestion, error) {
+	suggestions, err := database.Suggest(ctx, query, limit)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("failed to get suggestions", err)
+	}
+	return suggestions, nil
+}
+
+func getRecord(ctx context.Context, id string) (*database.Record, error) {
+	record, err := database.GetRecordByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, huma.Error404NotFound("record not found", codeRecordNotFound)
+		}
+		return nil, huma.Error500InternalServerError("failed to get record", err)
+	}
+	return record, nil
+}
+
+// getStats returns the cached statistics, or a 503 while they are computed.
+func getStats() (*database.CachedStats, error) {
+	stats := database.GetCachedStats()
+	if stats == nil {
+		go database.ComputeAndCacheStats(false)
+		return nil, huma.Error503ServiceUnavailable("sync in progress or stats are being computed, please retry later", codeStatsUnavailable)
+	}
+	return stats, nil
+}
+
+// yearHistogram returns the year statistics for the year or decade bucket.
+func yearHistogram(bucket string) ([]database.YearCount, error) {
+	stats, err := getStats()
+	if err != nil {
+		return nil, err
+	}
+	if bucket == "decade" {
+		return stats.YearHistogram(10), nil
+	}
+	return stats.YearHistogram(1), nil
+}
+
+// torrentSources resolves the torrents of the sources of a record, skipping
+// the unknown ones and trying obsolete torrents last, unless one last served
+// the file. It fails with a 404 when no torrent is found.
+func torrentSources(ctx context.Context, id string, info *database.RecordDownloadInfo) ([]anna.TorrentSource, error) {
+	var sources, obsolete []anna.TorrentSource
+	var lastErr error
+	for _, source := range info.Sources {
+		torrent, err := database.GetTorrentByClassification(ctx, source.TorrentClassification)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		torrentSource := anna.TorrentSource{
+			ID:          source.TorrentClassification,
+			MagnetLink:  torrent.MagnetLink,
+			ServerPath:  source.ServerPath,
+			TorrentName: torrent.DisplayName,
+		}
+		if torrent.Obsolete && source.TorrentClassification != info.LastSource {
+			obsolete = append(obsolete, torrentSource)
+		} else {
+			sources = append(sources, torrentSource)
+		}
+	}
+	sources = append(sources, obsolete...)
+	if len(sources) == 0 {
+		return nil, huma.Error404NotFound(fmt.Sprintf("torrent not found: %s", id), codeTorrentUnavailable, lastErr)
+	}
+	return sources, nil
+}
+
+// httpTime formats a time for the Last-Modified header, empty when unknown so
+// that the header is left out.
+func httpTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// downloadError is the response to a failed download: a 504 when its sources
+// timed out, a 500 otherwise.
+func downloadError(err error) error {
+	if errors.Is(err, anna.ErrDownloadTimeout) {
+		return huma.Error504GatewayTimeout("file download timed out", codeDownloadTimeout, err)
+	}
+	return huma.Error500InternalServerError("failed to download file", codeDownloadFailed, err)
+}
+
+// countRecordDownload counts a download of a record served to a client, for
+// the statistics. Prefetches and resumed downloads are not counted.
+func countRecordDownload(ctx context.Context, id string) {
+	if err := database.CountRecordDownload(ctx, id); err != nil {
+		slog.Warn("Failed to count download", "id", id, "error", err)
+	}
+}
+
+// fetchRecord downloads the file of a record from its torrents, then its
+// mirrors, and records which source served it to try it first next time.
+// Downloads are saved until they complete, to be resumed after a restart.
+// Downloaded files are stored by md5, info.Blob is set to the md5 of the file.
+func fetchRecord(ctx context.Context, id string, info *database.RecordDownloadInfo, sources []anna.TorrentSource) ([]byte, error) {
+	filename := anna.StoredFilename(id, info.Blob, info.Extension)
+	if _, err := anna.StoredFile(filename); err != nil {
+		if err := database.StartDownload(ctx, filename, id); err != nil {
+			slog.Warn("Failed to save download", "id", id, "error", err)
+		}
+		defer func() {
+			if err := database.FinishDownload(ctx, filename); err != nil {
+				slog.Warn("Failed to remove saved download", "id", id, "error", err)
+			}
+		}()
+	}
+
+	mirrors := anna.MirrorFile{URLs: anna.MirrorURLs(id, info.Extension, info.Identifiers), Size: info.Filesize, MD5: info.Blob}
+	data, source, err := anna.DownloadFile(ctx, sources, filename, mirrors)
+	if err != nil {
+		return nil, err
+	}
+	if source != "" {
+		if err := database.SetRecordSource(ctx, id, source); err != nil {
+			slog.Warn("Failed to record download source", "id", id, "source", source, "error", err)
+		}
+		storeBlob(ctx, id, info, filename, data)
+	}
+	return data, nil
+}
+
+// storeBlob records the md5 of a file just downloaded for a record, moving
+// the file when it was stored under another name, i.e. when its md5 was
+// unknown or wrong.
+func storeBlob(ctx context.Context, id string, info *database.RecordDownloadInfo, filename string, data []byte) {
+	sum := md5.Sum(data)
+	blob := hex.EncodeToString(sum[:])
+	if blob != strings.ToLower(info.Blob) {
+		if err := anna.MoveStoredFile(filename, anna.StoredFilename(id, blob, info.Extension)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to move stored file", "id", id, "file", filename, "error", err)
+			return
+		}
+	}
+	info.Blob = blob
+	if err := database.SetRecordBlob(ctx, id, blob); err != nil {
+		slog.Warn("Failed to record file md5", "id", id, "error", err)
+	}
+}
+
+// ResumeDownloads saves the progress of the downloads from now on, and
+// resumes in the background the downloads interrupted by the last shutdown,
+// so that their status and progress events stay available.
+func ResumeDownloads(ctx context.Context) {
+	anna.PersistProgress(func(filename string, progress anna.DownloadProgressEvent) {
+		if err := database.UpdateDownload(context.Background(), filename, progress); err != nil {
+			slog.Warn("Failed to save download progress", "file", filename, "error", err)
+		}
+	})
+
+	downloads, err := database.ListDownloads(ctx)
+	if err != nil {
+		slog.Error("Failed to list interrupted downloads", "error", err)
+		return
+	}
+	for _, download := range downloads {
+		info, err := database.GetRecordDownloadInfo(ctx, download.Record)
+		var sources []anna.TorrentSource
+		if err == nil {
+			sources, err = torrentSources(ctx, download.Record, info)
+		}
+		if err != nil || anna.StoredFilename(download.Record, info.Blob, info.Extension) != download.File {
+			slog.Warn("Dropping interrupted download", "id", download.Record, "error", err)
+			if err := database.FinishDownload(ctx, download.File); err != nil {
+				slog.Warn("Failed to remove saved download", "id", download.Record, "error", err)
+			}
+			continue
+		}
+
+		slog.Info("Resuming interrupted download", "id", download.Record, "status", download.Status, "completed", download.BytesCompleted)
+		anna.RestoreProgress(download.File, anna.DownloadProgressEvent{
+			Status:         anna.DownloadStatus(download.Status),
+			BytesCompleted: download.BytesCompleted,
+			TotalBytes:     download.TotalBytes,
+			Percent:        percent(download.BytesCompleted, download.TotalBytes),
+		})
+		go func() {
+			// Like prefetches, resumed downloads outlive the shutdown and are resumed again on the next start
+			if _, err := fetchRecord(context.WithoutCancel(ctx), download.Record, info, sources); err != nil {
+				slog.Error("Failed to resume download", "id", download.Record, "error", err)
+			}
+		}()
+	}
+}
+
+// percent returns completed as a percentage of total, 0 when total is unknown.
+func percent(completed, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(completed) / float64(total) * 100
+}
+
+// checkFormat fails when a format is requested and the record, whose file
+// has the given extension, is in another one. kepubs are converted from epubs.
+func checkFormat(extension, format string) error {
+	if format == "kepub" && extension == "epub" {
+		return nil
+	}
+	if format != "" && format != extension {
+		return huma.Error404NotFound(fmt.Sprintf("record is only available as %s", extension), codeFormatUnavailable)
+	}
+	return nil
+}
+
+// recordFilename returns the name of the stored file of a record and its
+// extension, checking the requested format if any.
+func recordFilename(ctx context.Context, id, format string) (string, string, error) {
+	extension, err := database.GetRecordExtension(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", huma.Error404NotFound("record not found", codeRecordNotFound)
+		}
+		return "", "", huma.Error500InternalServerError("failed to get record", err)
+	}
+	if err := checkFormat(extension, format); err != nil {
+		return "", "", err
+	}
+	blob, err := database.GetRecordBlob(ctx, id)
+	if err != nil {
+		return "", "", huma.Error500InternalServerError("failed to get record", err)
+	}
+	return anna.StoredFilename(id, blob, extension), extension, nil
+}
+
+// derivedFilename returns the name of a file derived from a stored epub, such
+// as its cover or kepub, stored next to it.
+func derivedFilename(epub, extension string) string {
+	return strings.TrimSuffix(epub, ".epub") + "." + extension
+}
+
+// epubCover returns the cover of a stored epub, scaled down to width pixels.
+// Extracted covers are stored next to the epub.
+func epubCover(epub string, width int) ([]byte, error) {
+	name := derivedFilename(epub, fmt.Sprintf("cover-%d.jpg", width))
+	if data, err := anna.ReadStoredFile(name); err == nil {
+		return data, nil
+	}
+
+	if _, err := anna.StoredFile(epub); err != nil {
+		return nil, fmt.Errorf("%w: epub not downloaded", cover.ErrUnavailable)
+	}
+	data, err := anna.ReadStoredFile(epub)
+	if err != nil {
+		return nil, err
+	}
+	data, err = cover.FromEpub(data, width)
+	if err != nil {
+		return nil, err
+	}
+	anna.StoreFile(name, data)
+	return data, nil
+}
+
+// kepubFile converts a stored epub to a kepub, stored next to the epub,
+// unless it is stored already. The kepub is returned when it was converted;
+// the epub is read from the storage directory when data is nil.
+func kepubFile(epub string, data []byte) ([]byte, error) {
+	name := derivedFilename(epub, kepub.Extension)
+	if _, err := anna.StoredFile(name); err == nil {
+		return nil, nil
+	}
+	if data == nil {
+		stored, err := anna.ReadStoredFile(epub)
+		if err != nil {
+			return nil, err
+		}
+		data = stored
+	}
+	data, err := kepub.Convert(data)
+	if err != nil {
+		return nil, err
+	}
+	anna.StoreFile(name, data)
+	return data, nil
+}
+
+// serveStoredFile returns a response body sending a file of the storage
+// directory with http.ServeContent, which handles range requests and uses
+// sendfile when the connection allows it. Behind nginx, the file is left to
+// nginx with X-Accel-Redirect when API_ACCEL_REDIRECT is set.
+func serveStoredFile(file io.ReadSeekCloser, info os.FileInfo) func(huma.Context) {
+	return func(hctx huma.Context) {
+		defer file.Close()
+		r, w := humachi.Unwrap(hctx)
+		if location := config.C.API.AccelRedirect; location != "" {
+			w.Header().Set("X-Accel-Redirect", path.Join(location, url.PathEscape(info.Name())))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	}
+}
+
+// progressFilter throttles the progress events of a download: an event is
+// sent at least interval after the previous one and when the percentage
+// changed by delta or more. Status changes are always sent.
+type progressFilter struct {
+	interval time.Duration
+	delta    float64
+	last     anna.DownloadProgressEvent
+	sentAt   time.Time
+	pending  *anna.DownloadProgressEvent
+}
+
+// accept reports whether event is to be sent now, otherwise it is kept to be
+// sent by flush.
+func (f *progressFilter) accept(event anna.DownloadProgressEvent) bool {
+	if f.sentAt.IsZero() || event.Status != f.last.Status ||
+		(time.Since(f.sentAt) >= f.interval && math.Abs(event.Percent-f.last.Percent) >= f.delta) {
+		f.last, f.sentAt, f.pending = event, time.Now(), nil
+		return true
+	}
+	f.pending = &event
+	return false
+}
+
+// wait returns the time left before the next event can be sent.
+func (f *progressFilter) wait() time.Duration {
+	return max(f.interval-time.Since(f.sentAt), 0)
+}
+
+// flush returns the last event not sent, if it is to be sent now.
+func (f *progressFilter) flush() (anna.DownloadProgressEvent, bool) {
+	if f.pending == nil {
+		return anna.DownloadProgressEvent{}, false
+	}
+	event := *f.pending
+	return event, f.accept(event)
+}
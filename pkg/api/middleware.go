@@ -1,13 +1,17 @@
 package routing
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/danielgtaylor/huma/v2"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/iziplay/anna-api/pkg/auth"
+	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/sync"
 )
 
 func authMiddleware(api huma.API) func(ctx huma.Context, next func(huma.Context)) {
@@ -21,7 +25,6 @@ func authMiddleware(api huma.API) func(ctx huma.Context, next func(huma.Context)
 				break
 			}
 		}
-		_ = anyOfNeededScopes // unused, but kept for future scope checking
 
 		if !isAuthorizationRequired {
 			next(ctx)
@@ -34,24 +37,102 @@ func authMiddleware(api huma.API) func(ctx huma.Context, next func(huma.Context)
 			tokenString = ctx.Query("jwt")
 		}
 
-		secret := os.Getenv("ANNA_JWT_SECRET")
-		if secret == "" {
+		if !auth.Enabled() {
+			// Scoped operations (e.g. admin ones) are never left open
+			if len(anyOfNeededScopes) > 0 {
+				huma.WriteErr(api, ctx, http.StatusForbidden, "authentication must be enabled for this operation", codeAuthDisabled)
+				return
+			}
 			next(ctx)
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(secret), nil
-		})
+		token, err := auth.ParseToken(tokenString)
+		if err != nil {
+			huma.WriteErr(api, ctx, http.StatusUnauthorized, "invalid token", codeInvalidToken, err)
+			return
+		}
 
-		if err != nil || !token.Valid {
-			huma.WriteErr(api, ctx, http.StatusUnauthorized, "invalid token", err)
+		if len(anyOfNeededScopes) > 0 && !auth.HasAnyScope(token, anyOfNeededScopes) {
+			huma.WriteErr(api, ctx, http.StatusForbidden, "missing scope", codeMissingScope, fmt.Errorf("one of %v is required", anyOfNeededScopes))
+			return
+		}
+
+		next(huma.WithContext(ctx, auth.NewContext(ctx.Context(), token)))
+	}
+}
+
+// conditionalMetadata marks operations whose responses only change with the
+// dataset, so they can be served with an ETag and answered with 304.
+const conditionalMetadata = "conditional"
+
+// conditionalMiddleware computes a weak ETag from the last sync and the request
+// (path, query and Accept header) and honors If-None-Match on conditional operations.
+func conditionalMiddleware(api huma.API) func(ctx huma.Context, next func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		if enabled, _ := ctx.Operation().Metadata[conditionalMetadata].(bool); !enabled || ctx.Method() != http.MethodGet {
+			next(ctx)
+			return
+		}
+
+		// Data changes while a sync is running and stats are recomputed after it,
+		// so there is no stable version to tag the response with.
+		stats := database.GetCachedStats()
+		if stats == nil || sync.GetStats().IsRunning {
+			next(ctx)
 			return
 		}
 
-		next(ctx)
+		u := ctx.URL()
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s\n%s\n%s\n%s\n%s", stats.LastSync, stats.Base, u.Path, u.Query().Encode(), ctx.Header("Accept"))
+		etag := `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+		next(&conditionalContext{humaContext: ctx, etag: etag})
+	}
+}
+
+// conditionalContext tags successful responses with the ETag once their status
+// is known, answering 304 instead when If-None-Match matches. Error responses
+// are passed through untagged.
+type conditionalContext struct {
+	humaContext
+	etag        string
+	notModified bool
+}
+
+func (c *conditionalContext) SetStatus(code int) {
+	if code < 200 || code >= 300 {
+		c.humaContext.SetStatus(code)
+		return
+	}
+
+	c.humaContext.SetHeader("ETag", c.etag)
+	if etagMatches(c.humaContext.Header("If-None-Match"), c.etag) {
+		c.notModified = true
+		c.humaContext.SetStatus(http.StatusNotModified)
+		return
+	}
+	c.humaContext.SetStatus(code)
+}
+
+func (c *conditionalContext) BodyWriter() io.Writer {
+	if c.notModified {
+		return io.Discard
+	}
+	return c.humaContext.BodyWriter()
+}
+
+// etagMatches reports whether an If-None-Match header matches the etag using weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
 	}
+	return false
 }
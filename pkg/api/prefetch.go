@@ -0,0 +1,131 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/webhook"
+)
+
+type BulkPrefetchInput struct {
+	Body struct {
+		IDs    []string `json:"ids" minItems:"1" maxItems:"200" uniqueItems:"true" doc:"Record IDs whose files are downloaded"`
+		Format string   `json:"format,omitempty" enum:"epub,pdf,mobi,azw3,cbz,djvu" doc:"Expected format of the files, records in another format are rejected with FORMAT_UNAVAILABLE"`
+	}
+}
+
+// PrefetchRejection is a record of a bulk prefetch that can't be downloaded.
+type PrefetchRejection struct {
+	ID      string `json:"id"`
+	Code    string `json:"code" doc:"Machine-readable error code, e.g. RECORD_NOT_FOUND"`
+	Message string `json:"message"`
+}
+
+type BulkPrefetchOutput struct {
+	Body struct {
+		Queued   []string            `json:"queued" doc:"Records whose files are being downloaded, their status can be followed on /v1/records/{id}/status"`
+		Rejected []PrefetchRejection `json:"rejected" doc:"Records that can't be downloaded"`
+	}
+}
+
+// setupBulkPrefetch registers the endpoint prefetching several files.
+func setupBulkPrefetch(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "BulkPrefetchRecords",
+		Method:        http.MethodPost,
+		Path:          "/v1/records/prefetch",
+		Summary:       "Prefetch files",
+		Description:   "Start downloading the files of several records in background, e.g. to warm the cache for a reading list. Files wait in the download queue beyond ANNA_MAX_ACTIVE_DOWNLOADS, and each takes a slot of ANNA_DOWNLOAD_CONCURRENCY: records beyond the free slots of the token subject are rejected with TOO_MANY_DOWNLOADS, and the request fails with 429 when no slot is free. Records that can't be downloaded are listed in rejected while the others are queued",
+		Tags:          []string{"Download"},
+		DefaultStatus: http.StatusAccepted,
+		Metadata:      map[string]any{downloadMetadata: true},
+		Security: []map[string][]string{
+			{"bearerAuth": {}},
+		},
+	}, func(ctx context.Context, input *BulkPrefetchInput) (*BulkPrefetchOutput, error) {
+		resp := &BulkPrefetchOutput{}
+		resp.Body.Queued = []string{}
+		resp.Body.Rejected = []PrefetchRejection{}
+
+		downloads := make([]bulkDownload, 0, len(input.Body.IDs))
+		for _, id := range input.Body.IDs {
+			download, err := resolveBulkDownload(ctx, id, input.Body.Format)
+			if err != nil {
+				resp.Body.Rejected = append(resp.Body.Rejected, prefetchRejection(id, err))
+				continue
+			}
+			downloads = append(downloads, *download)
+		}
+		if len(downloads) == 0 {
+			return resp, nil
+		}
+
+		if err := checkTenantQuota(ctx, 1); err != nil {
+			return nil, err
+		}
+
+		// Each file takes a download slot of the subject, the first one
+		// failing the request
+		var queued []bulkDownload
+		var releases []func()
+		for _, download := range downloads {
+			release, err := acquireDownload(ctx)
+			if err != nil && len(releases) == 0 {
+				return nil, err
+			}
+			if err != nil {
+				resp.Body.Rejected = append(resp.Body.Rejected, prefetchRejection(download.id, err))
+				continue
+			}
+			queued = append(queued, download)
+			releases = append(releases, release)
+			resp.Body.Queued = append(resp.Body.Queued, download.id)
+		}
+
+		bgCtx := context.WithoutCancel(ctx)
+		for i, download := range queued {
+			go func() {
+				defer releases[i]()
+				if _, err := fetchRecord(bgCtx, download.id, download.info, download.sources); err != nil {
+					slog.Error("Failed to prefetch file", "id", download.id, "error", err)
+					webhook.Publish(bgCtx, webhook.EventDownloadFailed, map[string]any{"id": download.id, "error": err.Error()})
+					return
+				}
+				webhook.Publish(bgCtx, webhook.EventPrefetchCompleted, map[string]any{"id": download.id})
+			}()
+		}
+
+		return resp, nil
+	})
+}
+
+// resolveBulkDownload looks up what is needed to download the file of a record.
+func resolveBulkDownload(ctx context.Context, id, format string) (*bulkDownload, error) {
+	info, err := database.GetRecordDownloadInfo(ctx, id)
+	if err != nil {
+		return nil, huma.Error404NotFound(fmt.Sprintf("record download info not found: %s", id), codeRecordNotFound, err)
+	}
+	if err := checkFormat(info.Extension, format); err != nil {
+		return nil, err
+	}
+	sources, err := torrentSources(ctx, id, info)
+	if err != nil {
+		return nil, err
+	}
+	return &bulkDownload{id: id, info: info, sources: sources}, nil
+}
+
+func prefetchRejection(id string, err error) PrefetchRejection {
+	rejection := PrefetchRejection{ID: id, Code: "INTERNAL_SERVER_ERROR", Message: err.Error()}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		rejection.Code = apiErr.Code
+		rejection.Message = apiErr.Detail
+	}
+	return rejection
+}
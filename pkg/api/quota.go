@@ -0,0 +1,69 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/iziplay/anna-api/pkg/auth"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// tenantQuota returns the daily download quota of a tenant, 0 means unlimited.
+func tenantQuota(tenant string) int {
+	if quota, ok := config.C.Quota.Tenants[tenant]; ok {
+		return quota
+	}
+	return config.C.Quota.Daily
+}
+
+// quotaExceeded returns the 429 error sent to tenants that used up their quota,
+// telling them to come back at midnight UTC when quotas reset.
+func quotaExceeded() error {
+	now := time.Now().UTC()
+	reset := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	return huma.ErrorWithHeaders(
+		huma.NewError(http.StatusTooManyRequests, "daily download quota exceeded", codeQuotaExceeded),
+		http.Header{"Retry-After": {strconv.Itoa(int(reset.Sub(now).Seconds()) + 1)}},
+	)
+}
+
+// countTenantDownload records a download for the tenant of the request, if any,
+// and fails when the tenant exceeded its daily quota.
+func countTenantDownload(ctx context.Context) error {
+	tenant := auth.Tenant(ctx)
+	if tenant == "" {
+		return nil
+	}
+
+	if _, err := database.CountTenantDownload(ctx, tenant, tenantQuota(tenant)); err != nil {
+		if errors.Is(err, database.ErrQuotaExceeded) {
+			return quotaExceeded()
+		}
+		return huma.Error500InternalServerError("failed to check download quota", err)
+	}
+	return nil
+}
+
+// checkTenantQuota fails when the tenant of the request, if any, has fewer
+// than n downloads left today.
+func checkTenantQuota(ctx context.Context, n int) error {
+	tenant := auth.Tenant(ctx)
+	quota := tenantQuota(tenant)
+	if tenant == "" || quota <= 0 {
+		return nil
+	}
+
+	downloads, err := database.TenantDownloads(ctx, tenant)
+	if err != nil {
+		return huma.Error500InternalServerError("failed to check download quota", err)
+	}
+	if downloads+n > quota {
+		return quotaExceeded()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package routing
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/iziplay/anna-api/pkg/auth"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/ratelimit"
+)
+
+// downloadMetadata marks operations that transfer or fetch epubs, which get
+// their own, usually stricter, rate limit.
+const downloadMetadata = "download"
+
+var apiLimiter, downloadLimiter *ratelimit.Limiter
+
+// downloadConcurrency limits the downloads each JWT subject runs at once.
+var downloadConcurrency *ratelimit.Concurrency
+
+func init() {
+	limits := config.C.RateLimit
+	apiLimiter = newLimiter("api", limits.PerSecond, limits.Burst)
+	downloadLimiter = newLimiter("download", limits.DownloadPerSecond, limits.DownloadBurst)
+	downloadConcurrency = ratelimit.NewConcurrency(limits.DownloadConcurrency)
+	if downloadConcurrency.Enabled() {
+		slog.Info("Download concurrency limited", "perSubject", limits.DownloadConcurrency)
+	}
+}
+
+// acquireDownload starts a download for the JWT subject of the request and
+// returns the function to call once it is over. It fails with 429 when the
+// subject already runs as many downloads as allowed. Anonymous downloads are
+// not limited.
+func acquireDownload(ctx context.Context) (func(), error) {
+	subject := auth.ContextSubject(ctx)
+	if subject == "" {
+		return func() {}, nil
+	}
+	release, ok := downloadConcurrency.Acquire(subject)
+	if !ok {
+		return nil, huma.NewError(http.StatusTooManyRequests, "too many downloads in progress, wait for one to complete", codeTooManyDownloads)
+	}
+	return release, nil
+}
+
+// newLimiter builds a limiter from a requests per second and a burst.
+func newLimiter(name string, perSecond float64, burst int) *ratelimit.Limiter {
+	if perSecond > 0 {
+		slog.Info("Rate limiting enabled", "limiter", name, "perSecond", perSecond, "burst", burst)
+	}
+	return ratelimit.New(perSecond, burst)
+}
+
+// rateLimitMiddleware limits requests per JWT subject, or per client IP for
+// anonymous requests, and answers 429 with Retry-After when a client exceeds its limit.
+func rateLimitMiddleware(api huma.API) func(ctx huma.Context, next func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		limiter := apiLimiter
+		if download, _ := ctx.Operation().Metadata[downloadMetadata].(bool); download {
+			limiter = downloadLimiter
+		}
+		if !limiter.Enabled() {
+			next(ctx)
+			return
+		}
+
+		ok, retryAfter := limiter.Allow(clientKey(ctx))
+		if !ok {
+			ctx.SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			huma.WriteErr(api, ctx, http.StatusTooManyRequests, "rate limit exceeded", codeRateLimited)
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+// clientKey identifies the client of a request for rate limiting.
+func clientKey(ctx huma.Context) string {
+	tokenString := strings.TrimPrefix(ctx.Header("Authorization"), "Bearer ")
+	if tokenString == "" {
+		tokenString = ctx.Query("jwt")
+	}
+	if subject := auth.Subject(tokenString); subject != "" {
+		return "sub:" + subject
+	}
+
+	host, _, err := net.SplitHostPort(ctx.RemoteAddr())
+	if err != nil {
+		host = ctx.RemoteAddr()
+	}
+	return "ip:" + host
+}
@@ -2,17 +2,27 @@ package routing
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
-	"strings"
+	"reflect"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/negotiation"
 	"github.com/danielgtaylor/huma/v2/sse"
 	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/auth"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/cover"
 	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/export"
+	"github.com/iziplay/anna-api/pkg/kepub"
 	"github.com/iziplay/anna-api/pkg/sync"
+	"github.com/iziplay/anna-api/pkg/webhook"
+	"gorm.io/gorm"
 )
 
 type BooksOutput struct {
@@ -25,6 +35,30 @@ type StatsOutput struct {
 	Body database.CachedStats
 }
 
+type YearStatsInput struct {
+	Bucket string `query:"bucket" default:"year" enum:"year,decade" doc:"Width of the histogram buckets"`
+}
+
+type YearStatsOutput struct {
+	Body struct {
+		Years []database.YearCount `json:"years" doc:"Record counts by bucket, keyed by the first year of the bucket"`
+	}
+}
+
+type DownloadStatsInput struct {
+	Days  int `query:"days" default:"30" minimum:"1" maximum:"365" doc:"Number of days to cover, today included"`
+	Limit int `query:"limit" default:"10" minimum:"1" maximum:"100" doc:"Number of most downloaded records"`
+}
+
+type DownloadStatsOutput struct {
+	Body struct {
+		Since string                         `json:"since" doc:"First day covered (UTC), as YYYY-MM-DD"`
+		Total int64                          `json:"total" doc:"Number of downloads since then"`
+		Top   []database.RecordDownloadCount `json:"top" doc:"Most downloaded records, most downloaded first"`
+		Days  []database.DayDownloadCount    `json:"days" doc:"Downloads per day, days without downloads omitted"`
+	}
+}
+
 type PlainOutput struct {
 	ContentType string `header:"Content-Type"`
 	Body        []byte
@@ -34,22 +68,52 @@ type SyncStatsOutput struct {
 	Body sync.SyncStats
 }
 
+type SyncHistoryInput struct {
+	Limit int `query:"limit" default:"30" minimum:"1" maximum:"365" doc:"Maximum number of syncs"`
+}
+
+type SyncHistoryOutput struct {
+	Body struct {
+		Syncs []database.SyncHistoryEntry `json:"syncs" doc:"Past syncs, most recent first"`
+	}
+}
+
 type DownloadInput struct {
-	ID string `path:"id" doc:"Record ID (e.g. md5:abc123)" required:"true"`
+	ID     string `path:"id" doc:"Record ID (e.g. md5:abc123)" required:"true"`
+	Format string `query:"format" enum:"epub,kepub,pdf,mobi,azw3,cbz,djvu" doc:"Expected format of the file, defaults to the format of the record. Requests for another format fail with FORMAT_UNAVAILABLE. kepub downloads epubs converted for Kobo e-readers"`
+}
+
+type DownloadRecordInput struct {
+	DownloadInput
+	IfModifiedSince string `header:"If-Modified-Since" doc:"Only download the file if it was cached after this HTTP date"`
 }
 
 type DownloadOutput struct {
 	ContentType        string `header:"Content-Type"`
 	ContentDisposition string `header:"Content-Disposition"`
-	Body               []byte
+	LastModified       string `header:"Last-Modified"`
+	Body               func(ctx huma.Context)
+}
+
+type DownloadHeadOutput struct {
+	ContentType   string `header:"Content-Type"`
+	ContentLength int64  `header:"Content-Length"`
+	LastModified  string `header:"Last-Modified"`
 }
 
 type DownloadStatusOutput struct {
 	Body struct {
-		Status anna.DownloadStatus `json:"status" enum:"NOT_STARTED,DOWNLOADING,DOWNLOADED" doc:"Download status"`
+		Status   anna.DownloadStatus `json:"status" enum:"NOT_STARTED,QUEUED,DOWNLOADING,DOWNLOADED" doc:"Download status"`
+		Position int                 `json:"position,omitempty" doc:"Position in the download queue, only set while QUEUED"`
 	}
 }
 
+type DownloadEventsInput struct {
+	DownloadInput
+	Interval int     `query:"interval" minimum:"0" maximum:"60000" doc:"Minimum number of milliseconds between two progress events, 0 sends every update. Status changes are always sent"`
+	MinDelta float64 `query:"min_delta" minimum:"0" maximum:"100" doc:"Minimum change of the percentage between two progress events. Status changes are always sent"`
+}
+
 // SSE event types for download progress streaming
 type DownloadProgressSSE anna.DownloadProgressEvent
 
@@ -57,43 +121,210 @@ type DownloadErrorSSE struct {
 	Message string `json:"message"`
 }
 
+// SearchFiltersInput holds the query parameters shared by every search endpoint.
+type SearchFiltersInput struct {
+	Languages           []string  `query:"languages" doc:"Filter by language, see language_mode"`
+	Formats             []string  `query:"format" enum:"epub,pdf,mobi,azw3,cbz,djvu" doc:"Only return records in these file formats"`
+	LanguageMode        string    `query:"language_mode" default:"exact" enum:"exact,any,all" doc:"How languages are matched: exact array equality, any of the languages, or all of them"`
+	ContentTypes        []string  `query:"content_type" doc:"Only return records of these content types (e.g. book_fiction, book_nonfiction, audiobook, book_comic, magazine)"`
+	ExcludeContentTypes []string  `query:"exclude_content_type" doc:"Exclude records of these content types (e.g. magazine)"`
+	Series              string    `query:"series" doc:"Only return records of this series (case-insensitive)"`
+	ClassificationType  string    `query:"classification_type" doc:"Only return records having a classification of this type (e.g. ddc, lcc)"`
+	ClassificationValue string    `query:"classification_value" doc:"Only return records having a classification with this value (e.g. 823.912)"`
+	AddedAfter          time.Time `query:"added_after" timeFormat:"2006-01-02" doc:"Only return records added to Anna's Archive on or after this date (e.g. 2024-01-31)"`
+	AddedBefore         time.Time `query:"added_before" timeFormat:"2006-01-02" doc:"Only return records added to Anna's Archive before this date"`
+}
+
+func (i SearchFiltersInput) filters() database.SearchFilters {
+	return database.SearchFilters{
+		Languages:           i.Languages,
+		LanguageMode:        database.LanguageMode(i.LanguageMode),
+		ContentTypes:        i.ContentTypes,
+		ExcludeContentTypes: i.ExcludeContentTypes,
+		Formats:             i.Formats,
+		Series:              i.Series,
+		ClassificationType:  i.ClassificationType,
+		ClassificationValue: i.ClassificationValue,
+		AddedAfter:          i.AddedAfter,
+		AddedBefore:         i.AddedBefore,
+	}
+}
+
+// PageInput holds the pagination query parameters shared by every search endpoint.
+type PageInput struct {
+	Limit  int    `query:"limit" default:"20" minimum:"1" maximum:"100" doc:"Maximum number of results"`
+	Offset int    `query:"offset" default:"0" minimum:"0" doc:"Offset for pagination, ignored when cursor is set"`
+	Cursor string `query:"cursor" doc:"Opaque cursor returned as next_cursor by the previous page"`
+	Total  string `query:"total" default:"exact" enum:"exact,estimated,none" doc:"How the total is computed: exact count, query planner estimate (much faster on broad searches) or not at all"`
+	Sort   string `query:"sort" default:"id" enum:"id,added" doc:"Order of the results: by ID, or recently added to Anna's Archive first (paginated with offset only). Fuzzy results are ordered by similarity"`
+}
+
+func (i PageInput) page() database.Page {
+	return database.Page{
+		Limit:  i.Limit,
+		Offset: i.Offset,
+		Cursor: i.Cursor,
+		Count:  database.CountMode(i.Total),
+		Sort:   database.Sort(i.Sort),
+	}
+}
+
+// ProjectionInput holds the sparse field selection query parameter shared by every search endpoint.
+type ProjectionInput struct {
+	Fields []string `query:"fields" doc:"Record fields to return (e.g. title,author,coverURL), identifiers and classifications are only loaded when listed. All fields are returned when empty"`
+}
+
+func (i ProjectionInput) projection() database.Projection {
+	return database.Projection{Fields: i.Fields}
+}
+
 type SearchByISBNInput struct {
-	ISBN      string   `query:"isbn" required:"true" doc:"ISBN10 or ISBN13 code to search for"`
-	Languages []string `query:"languages" doc:"Filter by language (strict equality)"`
-	Limit     int      `query:"limit" default:"20" minimum:"1" maximum:"100" doc:"Maximum number of results"`
-	Offset    int      `query:"offset" default:"0" minimum:"0" doc:"Offset for pagination"`
+	ISBN []string `query:"isbn,explode" required:"true" minItems:"1" doc:"ISBN10 or ISBN13 codes to search for, as a comma-separated list or a repeated parameter"`
+	SearchFiltersInput
+	PageInput
+	ProjectionInput
+}
+
+// TextQueryInput holds the criteria of text searches.
+type TextQueryInput struct {
+	Title       string  `query:"title" doc:"Filter by title (case-insensitive)"`
+	Author      string  `query:"author" doc:"Filter by author (case-insensitive)"`
+	Publisher   string  `query:"publisher" doc:"Filter by publisher (case-insensitive)"`
+	Description string  `query:"description" doc:"Filter by words of the description (case-insensitive), never fuzzy"`
+	Fuzzy       bool    `query:"fuzzy" default:"false" doc:"Tolerate typos using trigram similarity, results are ordered by similarity"`
+	Threshold   float64 `query:"threshold" default:"0.3" minimum:"0" maximum:"1" doc:"Minimum word similarity for fuzzy matches, 0 matches anything"`
 }
 
 type SearchByTextInput struct {
-	Title     string   `query:"title" required:"true" doc:"Filter by title (case-insensitive)"`
-	Author    string   `query:"author" required:"true" doc:"Filter by author (case-insensitive)"`
-	Publisher string   `query:"publisher" doc:"Filter by publisher (case-insensitive)"`
-	Languages []string `query:"languages" doc:"Filter by language (strict equality)"`
-	Limit     int      `query:"limit" default:"20" minimum:"1" maximum:"100" doc:"Maximum number of results"`
-	Offset    int      `query:"offset" default:"0" minimum:"0" doc:"Offset for pagination"`
+	TextQueryInput
+	SearchFiltersInput
+	PageInput
+	ProjectionInput
+}
+
+func (i TextQueryInput) textQuery() database.TextQuery {
+	return database.TextQuery{
+		Title:       i.Title,
+		Author:      i.Author,
+		Publisher:   i.Publisher,
+		Description: i.Description,
+		Fuzzy:       i.Fuzzy,
+		Threshold:   &i.Threshold,
+	}
+}
+
+type SearchInput struct {
+	Query string `query:"q" required:"true" doc:"ISBN, identifier (e.g. oclc:1234567) or free text matched against title and author"`
+	SearchFiltersInput
+	PageInput
+	ProjectionInput
 }
 
 type SearchOutput struct {
 	Body struct {
-		Total   int64             `json:"total"`
-		Results []database.Record `json:"results"`
+		Mode       database.SearchMode `json:"mode,omitempty" enum:"isbn,identifier,text" doc:"Search strategy used to resolve the query"`
+		Total      *int64              `json:"total,omitempty" doc:"Total number of matches, absent when total=none"`
+		Estimated  bool                `json:"total_estimated,omitempty" doc:"Whether total is a query planner estimate"`
+		Results    []database.Record   `json:"results"`
+		NextCursor string              `json:"next_cursor,omitempty" doc:"Cursor of the next page, absent on the last page"`
+		Matches    map[string][]string `json:"matches,omitempty" doc:"IDs of the records matching each input ISBN, only set when searching several ISBNs"`
+	}
+}
+
+type SuggestInput struct {
+	Query string `query:"q" required:"true" minLength:"2" doc:"Partial title or author typed by the user"`
+	Limit int    `query:"limit" default:"10" minimum:"1" maximum:"10" doc:"Maximum number of suggestions"`
+}
+
+type SuggestOutput struct {
+	Body struct {
+		Suggestions []database.Suggestion `json:"suggestions"`
+	}
+}
+
+type ListSeriesInput struct {
+	Name   string `path:"name" doc:"Series name (case-insensitive)"`
+	Limit  int    `query:"limit" default:"20" minimum:"1" maximum:"100" doc:"Maximum number of results"`
+	Offset int    `query:"offset" default:"0" minimum:"0" doc:"Offset for pagination"`
+	SearchFiltersInput
+	ProjectionInput
+}
+
+func newSearchOutput(result *searchResult) *SearchOutput {
+	resp := &SearchOutput{}
+	resp.Body.Mode = result.Mode
+	resp.Body.Total = result.Total
+	resp.Body.Estimated = result.Estimated
+	resp.Body.Results = result.Records
+	resp.Body.NextCursor = result.NextCursor
+	resp.Body.Matches = result.Matches
+	return resp
+}
+
+type ExportRecordInput struct {
+	ID     string `path:"id" doc:"Record ID" required:"true"`
+	Format string `query:"format" default:"bibtex" enum:"bibtex,ris,marcxml,onix,jsonld" doc:"Export format: BibTeX or RIS citations, MARC21 XML, ONIX 3.0 or schema.org JSON-LD"`
+}
+
+type ExportRecordsInput struct {
+	Body struct {
+		IDs    []string `json:"ids" minItems:"1" maxItems:"500" doc:"Record IDs to export"`
+		Format string   `json:"format" default:"bibtex" enum:"bibtex,ris,marcxml,onix,jsonld" doc:"Export format: BibTeX or RIS citations, MARC21 XML collection, ONIX 3.0 message or schema.org JSON-LD graph"`
 	}
 }
 
+type ExportOutput struct {
+	ContentType        string `header:"Content-Type"`
+	ContentDisposition string `header:"Content-Disposition"`
+	Body               []byte
+}
+
+type CoverInput struct {
+	ID    string `path:"id" doc:"Record ID" required:"true"`
+	Width int    `query:"width" default:"320" enum:"0,160,320,640" doc:"Width of the cover in pixels, 0 keeps the original size"`
+}
+
+type CoverOutput struct {
+	ContentType  string `header:"Content-Type"`
+	CacheControl string `header:"Cache-Control"`
+	Body         []byte
+}
+
+type RecordChangesInput struct {
+	Since time.Time `query:"since" required:"true" doc:"Only list records created, updated or deleted after this RFC 3339 time"`
+}
+
+type RecordChangesOutput struct {
+	ContentType string `header:"Content-Type"`
+	Body        func(ctx huma.Context)
+}
+
 type GetRecordInput struct {
-	ID string `path:"id" doc:"Record ID" required:"true"`
+	ID     string `path:"id" doc:"Record ID" required:"true"`
+	Accept string `header:"Accept" doc:"Use application/ld+json to get a schema.org Book document"`
 }
 
+// GetRecordOutput holds either a database.Record or, when negotiated, a JSON-LD export.Book document.
 type GetRecordOutput struct {
-	Body database.Record
+	ContentType string `header:"Content-Type"`
+	Body        any
 }
 
 func Setup(api huma.API) {
-	if os.Getenv("ANNA_JWT_SECRET") == "" {
-		slog.Warn("ANNA_JWT_SECRET not set, authentication will be disabled")
+	if !auth.Enabled() {
+		slog.Warn("Neither ANNA_JWT_SECRET nor ANNA_JWKS_URL set, authentication will be disabled")
 	}
 
+	// Errors are APIError, with a code and the request ID
+	huma.NewError = newError
+
+	registry := api.OpenAPI().Components.Schemas
+
+	api.UseMiddleware(requestIDMiddleware)
+	api.UseMiddleware(versionMiddleware)
+	api.UseMiddleware(rateLimitMiddleware(api))
 	api.UseMiddleware(authMiddleware(api))
+	api.UseMiddleware(conditionalMiddleware(api))
 
 	huma.Register(api, huma.Operation{
 		OperationID: "LivenessCheck",
@@ -118,10 +349,10 @@ func Setup(api huma.API) {
 		Tags:        []string{"Health"},
 	}, func(ctx context.Context, input *struct{}) (*PlainOutput, error) {
 		if !database.Ready() {
-			return nil, huma.Error503ServiceUnavailable("not ready")
+			return nil, huma.Error503ServiceUnavailable("not ready", codeNotReady)
 		}
 		if err := database.Ping(); err != nil {
-			return nil, huma.Error503ServiceUnavailable("database not reachable")
+			return nil, huma.Error503ServiceUnavailable("database not reachable", codeNotReady)
 		}
 		return &PlainOutput{
 			ContentType: "text/plain",
@@ -129,6 +360,17 @@ func Setup(api huma.API) {
 		}, nil
 	})
 
+	setupAdmin(api)
+
+	// Sync workers only serve the health checks and the admin operations
+	if !config.C.RunsAPI() {
+		return
+	}
+
+	setupWebhooks(api)
+	setupBulkDownload(api)
+	setupBulkPrefetch(api)
+
 	huma.Register(api, huma.Operation{
 		OperationID: "GetStatistics",
 		Method:      "GET",
@@ -137,16 +379,53 @@ func Setup(api huma.API) {
 		Description: "Get statistics about current data set",
 		Tags:        []string{"Statistics"},
 	}, func(ctx context.Context, input *struct{}) (*StatsOutput, error) {
-		stats := database.GetCachedStats()
-		if stats == nil {
-			go database.ComputeAndCacheStats(false)
-			return nil, huma.Error503ServiceUnavailable("sync in progress or stats are being computed, please retry later")
+		stats, err := getStats()
+		if err != nil {
+			return nil, err
 		}
 		return &StatsOutput{
 			Body: *stats,
 		}, nil
 	})
 
+	huma.Register(api, huma.Operation{
+		OperationID: "GetYearStatistics",
+		Method:      "GET",
+		Path:        "/v1/statistics/years",
+		Summary:     "Get publication year statistics",
+		Description: "Get the number of records by publication year or decade, for charting the catalog over time",
+		Tags:        []string{"Statistics"},
+	}, func(ctx context.Context, input *YearStatsInput) (*YearStatsOutput, error) {
+		years, err := yearHistogram(input.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		resp := &YearStatsOutput{}
+		resp.Body.Years = years
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetDownloadStatistics",
+		Method:      "GET",
+		Path:        "/v1/statistics/downloads",
+		Summary:     "Get download statistics",
+		Description: "Get the most downloaded records and the number of completed downloads per day over the last days",
+		Tags:        []string{"Statistics"},
+	}, func(ctx context.Context, input *DownloadStatsInput) (*DownloadStatsOutput, error) {
+		since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-input.Days)
+		top, days, total, err := database.DownloadStatistics(ctx, since, input.Limit)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("failed to get download statistics", err)
+		}
+		resp := &DownloadStatsOutput{}
+		resp.Body.Since = since.Format(time.DateOnly)
+		resp.Body.Total = total
+		resp.Body.Top = top
+		resp.Body.Days = days
+		return resp, nil
+	})
+
 	huma.Register(api, huma.Operation{
 		OperationID: "GetSyncStatistics",
 		Method:      "GET",
@@ -160,6 +439,23 @@ func Setup(api huma.API) {
 		return resp, nil
 	})
 
+	huma.Register(api, huma.Operation{
+		OperationID: "GetSyncHistory",
+		Method:      "GET",
+		Path:        "/v1/statistics/sync/history",
+		Summary:     "Get sync history",
+		Description: "List the past syncs with their duration, the number of files and records processed and why they failed, if they did. Syncs skipped because the base was already synced are listed without duration, interrupted syncs are listed with an error",
+		Tags:        []string{"Statistics"},
+	}, func(ctx context.Context, input *SyncHistoryInput) (*SyncHistoryOutput, error) {
+		history, err := database.SyncHistory(ctx, input.Limit)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("failed to get sync history", err)
+		}
+		resp := &SyncHistoryOutput{}
+		resp.Body.Syncs = history
+		return resp, nil
+	})
+
 	huma.Register(api, huma.Operation{
 		OperationID: "GetTorrentStatistics",
 		Method:      "GET",
@@ -180,16 +476,20 @@ func Setup(api huma.API) {
 		Method:      "GET",
 		Path:        "/v1/records/{id}/status",
 		Summary:     "Check download status",
-		Description: "Check the current status of the epub download for a record",
+		Description: "Check the current status of the file download for a record",
 		Tags:        []string{"Download"},
 		Security: []map[string][]string{
 			{"bearerAuth": {}},
 		},
 	}, func(ctx context.Context, input *DownloadInput) (*DownloadStatusOutput, error) {
-		filename := fmt.Sprintf("%s.epub", strings.ReplaceAll(input.ID, ":", "_"))
-		status := anna.GetDownloadStatus(filename)
+		filename, _, err := recordFilename(ctx, input.ID, input.Format)
+		if err != nil {
+			return nil, err
+		}
+		progress := anna.GetDownloadProgress(filename)
 		resp := &DownloadStatusOutput{}
-		resp.Body.Status = status
+		resp.Body.Status = progress.Status
+		resp.Body.Position = progress.Position
 		return resp, nil
 	})
 
@@ -198,7 +498,7 @@ func Setup(api huma.API) {
 		Method:      http.MethodGet,
 		Path:        "/v1/records/{id}/download/events",
 		Summary:     "Stream download progress",
-		Description: "Stream real-time download progress events via Server-Sent Events",
+		Description: "Stream real-time download progress events via Server-Sent Events. Use interval and min_delta to receive fewer events on fast downloads",
 		Tags:        []string{"Download"},
 		Security: []map[string][]string{
 			{"bearerAuth": {}},
@@ -206,8 +506,12 @@ func Setup(api huma.API) {
 	}, map[string]any{
 		"progress": DownloadProgressSSE{},
 		"error":    DownloadErrorSSE{},
-	}, func(ctx context.Context, input *DownloadInput, send sse.Sender) {
-		filename := fmt.Sprintf("%s.epub", strings.ReplaceAll(input.ID, ":", "_"))
+	}, func(ctx context.Context, input *DownloadEventsInput, send sse.Sender) {
+		filename, _, err := recordFilename(ctx, input.ID, input.Format)
+		if err != nil {
+			send.Data(DownloadErrorSSE{Message: err.Error()})
+			return
+		}
 
 		// If already downloaded, send a completed event immediately
 		status := anna.GetDownloadStatus(filename)
@@ -226,14 +530,27 @@ func Setup(api huma.API) {
 		}
 		defer cleanup()
 
+		filter := &progressFilter{interval: time.Duration(input.Interval) * time.Millisecond, delta: input.MinDelta}
+		var flush <-chan time.Time
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case <-flush:
+				flush = nil
+				if event, ok := filter.flush(); ok {
+					send.Data(event)
+				}
 			case event, ok := <-progressCh:
 				if !ok {
 					return
 				}
+				if !filter.accept(event) {
+					if flush == nil {
+						flush = time.After(filter.wait())
+					}
+					continue
+				}
 				send.Data(event)
 				if event.Status == anna.DownloadStatusDownloaded {
 					return
@@ -246,30 +563,45 @@ func Setup(api huma.API) {
 		OperationID: "PrefetchRecord",
 		Method:      "POST",
 		Path:        "/v1/records/{id}/prefetch",
-		Summary:     "Prefetch epub",
-		Description: "Start downloading the epub file in background",
+		Summary:     "Prefetch file",
+		Description: "Start downloading the file of a record in background. Running prefetches and downloads are limited per token subject by ANNA_DOWNLOAD_CONCURRENCY",
 		Tags:        []string{"Download"},
+		Metadata:    map[string]any{downloadMetadata: true},
 		Security: []map[string][]string{
 			{"bearerAuth": {}},
 		},
 	}, func(ctx context.Context, input *DownloadInput) (*struct{}, error) {
 		info, err := database.GetRecordDownloadInfo(ctx, input.ID)
 		if err != nil {
-			return nil, huma.Error404NotFound("record download info not found", err)
+			return nil, huma.Error404NotFound("record download info not found", codeRecordNotFound, err)
+		}
+		if err := checkFormat(info.Extension, input.Format); err != nil {
+			return nil, err
 		}
 
-		torrent, err := database.GetTorrentByClassification(ctx, info.TorrentClassification)
+		sources, err := torrentSources(ctx, input.ID, info)
 		if err != nil {
-			return nil, huma.Error404NotFound("torrent not found", err)
+			return nil, err
 		}
 
-		filename := fmt.Sprintf("%s.epub", strings.ReplaceAll(input.ID, ":", "_"))
+		if err := checkTenantQuota(ctx, 1); err != nil {
+			return nil, err
+		}
+
+		release, err := acquireDownload(ctx)
+		if err != nil {
+			return nil, err
+		}
 
 		bgCtx := context.WithoutCancel(ctx)
 		go func() {
-			if _, err := anna.DownloadFile(bgCtx, torrent.MagnetLink, info.ServerPath, torrent.DisplayName, filename); err != nil {
+			defer release()
+			if _, err := fetchRecord(bgCtx, input.ID, info, sources); err != nil {
 				slog.Error("Failed to prefetch file", "id", input.ID, "error", err)
+				webhook.Publish(bgCtx, webhook.EventDownloadFailed, map[string]any{"id": input.ID, "error": err.Error()})
+				return
 			}
+			webhook.Publish(bgCtx, webhook.EventPrefetchCompleted, map[string]any{"id": input.ID})
 		}()
 
 		return nil, nil
@@ -279,55 +611,165 @@ func Setup(api huma.API) {
 		OperationID: "DownloadRecord",
 		Method:      "GET",
 		Path:        "/v1/records/{id}/download",
-		Summary:     "Download epub",
-		Description: "Download the epub file for a record from its source torrent",
+		Summary:     "Download file",
+		Description: "Download the file (epub, pdf, mobi, azw3, cbz or djvu) of a record from its source torrents, trying the other torrents of the record then the HTTP mirrors of ANNA_MIRRORS when one fails or stalls. Stored files are served from disk and support range requests. Downloads count towards the daily quota of the tenant of the token, if any, and running ones are limited per token subject by ANNA_DOWNLOAD_CONCURRENCY",
 		Tags:        []string{"Download"},
+		Metadata:    map[string]any{downloadMetadata: true},
 		Security: []map[string][]string{
 			{"bearerAuth": {}},
 		},
-	}, func(ctx context.Context, input *DownloadInput) (*DownloadOutput, error) {
+	}, func(ctx context.Context, input *DownloadRecordInput) (*DownloadOutput, error) {
 		info, err := database.GetRecordDownloadInfo(ctx, input.ID)
 		if err != nil {
-			return nil, huma.Error404NotFound("record download info not found", err)
+			return nil, huma.Error404NotFound("record download info not found", codeRecordNotFound, err)
+		}
+		if err := checkFormat(info.Extension, input.Format); err != nil {
+			return nil, err
+		}
+
+		filename := anna.StoredFilename(input.ID, info.Blob, info.Extension)
+
+		if since, err := http.ParseTime(input.IfModifiedSince); err == nil {
+			// Last-Modified is sent with a precision of one second
+			if stored, err := anna.StoredFile(filename); err == nil && !stored.ModTime().IsZero() && !stored.ModTime().Truncate(time.Second).After(since) {
+				return nil, huma.Status304NotModified()
+			}
 		}
 
-		torrent, err := database.GetTorrentByClassification(ctx, info.TorrentClassification)
+		sources, err := torrentSources(ctx, input.ID, info)
 		if err != nil {
-			return nil, huma.Error404NotFound("torrent not found", err)
+			return nil, err
+		}
+
+		// The download is only counted once the file is available
+		if err := checkTenantQuota(ctx, 1); err != nil {
+			return nil, err
 		}
 
-		filename := fmt.Sprintf("%s.epub", strings.ReplaceAll(input.ID, ":", "_"))
-		data, err := anna.DownloadFile(context.WithoutCancel(ctx), torrent.MagnetLink, info.ServerPath, torrent.DisplayName, filename)
+		release, err := acquireDownload(ctx)
 		if err != nil {
-			return nil, huma.Error500InternalServerError("failed to download file", err)
+			return nil, err
+		}
+		defer release()
+
+		// Stored files are served from disk, only the files downloaded without a
+		// storage directory are kept in memory
+		var data []byte
+		if _, err := anna.StoredFile(filename); err != nil {
+			data, err = fetchRecord(context.WithoutCancel(ctx), input.ID, info, sources)
+			if err != nil {
+				webhook.Publish(ctx, webhook.EventDownloadFailed, map[string]any{"id": input.ID, "error": err.Error()})
+				return nil, downloadError(err)
+			}
 		}
 
-		return &DownloadOutput{
-			ContentType:        "application/epub+zip",
-			ContentDisposition: fmt.Sprintf(`attachment; filename="%s.epub"`, input.ID),
-			Body:               data,
+		resp := &DownloadOutput{
+			ContentType:        anna.FormatContentType(info.Extension),
+			ContentDisposition: fmt.Sprintf(`attachment; filename="%s.%s"`, input.ID, info.Extension),
+		}
+		// The file is renamed after its md5 once downloaded
+		filename = anna.StoredFilename(input.ID, info.Blob, info.Extension)
+		if input.Format == "kepub" {
+			data, err = kepubFile(filename, data)
+			if err != nil {
+				return nil, huma.Error500InternalServerError("failed to convert file to kepub", codeDownloadFailed, err)
+			}
+			filename = derivedFilename(filename, kepub.Extension)
+			resp.ContentType = kepub.ContentType
+			resp.ContentDisposition = fmt.Sprintf(`attachment; filename="%s.%s"`, input.ID, kepub.Extension)
+		}
+
+		if err := countTenantDownload(ctx); err != nil {
+			return nil, err
+		}
+		countRecordDownload(ctx, input.ID)
+		if file, stored, err := anna.OpenStoredFile(filename); err == nil {
+			resp.LastModified = httpTime(stored.ModTime())
+			resp.Body = serveStoredFile(file, stored)
+		} else if data != nil {
+			resp.Body = func(hctx huma.Context) {
+				_, _ = hctx.BodyWriter().Write(data)
+			}
+		} else {
+			return nil, huma.Error500InternalServerError("failed to read stored file", err)
+		}
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "HeadDownloadRecord",
+		Method:      "HEAD",
+		Path:        "/v1/records/{id}/download",
+		Summary:     "Check file availability",
+		Description: "Get the size and modification date of a downloaded file without transferring it. Files that are not downloaded yet are reported as not found, see the prefetch operation",
+		Tags:        []string{"Download"},
+		Security: []map[string][]string{
+			{"bearerAuth": {}},
+		},
+	}, func(ctx context.Context, input *DownloadInput) (*DownloadHeadOutput, error) {
+		filename, extension, err := recordFilename(ctx, input.ID, input.Format)
+		if err != nil {
+			return nil, err
+		}
+		stored, err := anna.StoredFile(filename)
+		if err != nil {
+			return nil, huma.Error404NotFound("file not downloaded", codeEpubNotDownloaded)
+		}
+		return &DownloadHeadOutput{
+			ContentType:   anna.FormatContentType(extension),
+			ContentLength: stored.Size(),
+			LastModified:  httpTime(stored.ModTime()),
 		}, nil
 	})
 
+	huma.Register(api, huma.Operation{
+		OperationID: "Search",
+		Method:      "GET",
+		Path:        "/v1/search",
+		Summary:     "Search",
+		Description: "Search for records with a single query: ISBNs and identifiers (type:value) are detected automatically, anything else is matched against title and author",
+		Tags:        []string{"Search"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *SearchInput) (*SearchOutput, error) {
+		result, err := search(ctx, input.Query, input.filters(), input.page(), input.projection())
+		if err != nil {
+			return nil, err
+		}
+		return newSearchOutput(result), nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "SearchSuggest",
+		Method:      "GET",
+		Path:        "/v1/search/suggest",
+		Summary:     "Search suggestions",
+		Description: "Get title and author completions for a partial query, for typeahead UIs",
+		Tags:        []string{"Search"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *SuggestInput) (*SuggestOutput, error) {
+		suggestions, err := suggest(ctx, input.Query, input.Limit)
+		if err != nil {
+			return nil, err
+		}
+		resp := &SuggestOutput{}
+		resp.Body.Suggestions = suggestions
+		return resp, nil
+	})
+
 	huma.Register(api, huma.Operation{
 		OperationID: "SearchByISBN",
 		Method:      "GET",
 		Path:        "/v1/search/isbn",
 		Summary:     "Search by ISBN",
-		Description: "Search for records matching an ISBN10 or ISBN13 code",
+		Description: "Search for records matching ISBN10 or ISBN13 codes. Up to 100 codes can be resolved at once, the records matching each of them are then listed in `matches`",
 		Tags:        []string{"Search"},
+		Metadata:    map[string]any{conditionalMetadata: true},
 	}, func(ctx context.Context, input *SearchByISBNInput) (*SearchOutput, error) {
-		records, total, err := database.SearchByISBN(ctx, input.ISBN, input.Languages, input.Limit, input.Offset)
+		result, err := searchByISBN(ctx, input.ISBN, input.filters(), input.page(), input.projection())
 		if err != nil {
-			if database.IsValidationError(err) {
-				return nil, huma.Error400BadRequest(err.Error())
-			}
-			return nil, huma.Error500InternalServerError("failed to search by ISBN", err)
+			return nil, err
 		}
-		resp := &SearchOutput{}
-		resp.Body.Total = total
-		resp.Body.Results = records
-		return resp, nil
+		return newSearchOutput(result), nil
 	})
 
 	huma.Register(api, huma.Operation{
@@ -335,17 +777,175 @@ func Setup(api huma.API) {
 		Method:      "GET",
 		Path:        "/v1/search/text",
 		Summary:     "Search by text",
-		Description: "Search for records by title, author, and publisher",
+		Description: "Search for records by title, author, publisher and description. At least one of them is required",
 		Tags:        []string{"Search"},
+		Metadata:    map[string]any{conditionalMetadata: true},
 	}, func(ctx context.Context, input *SearchByTextInput) (*SearchOutput, error) {
-		records, total, err := database.SearchByText(ctx, input.Title, input.Author, input.Publisher, input.Languages, input.Limit, input.Offset)
+		result, err := searchByText(ctx, input.textQuery(), input.filters(), input.page(), input.projection())
 		if err != nil {
-			return nil, huma.Error500InternalServerError("failed to search by text", err)
+			return nil, err
 		}
-		resp := &SearchOutput{}
-		resp.Body.Total = total
-		resp.Body.Results = records
-		return resp, nil
+		return newSearchOutput(result), nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "ListSeries",
+		Method:      "GET",
+		Path:        "/v1/series/{name}",
+		Summary:     "List series",
+		Description: "List the records of a series, ordered by their position in the series",
+		Tags:        []string{"Records"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *ListSeriesInput) (*SearchOutput, error) {
+		records, total, err := database.ListSeries(ctx, input.Name, input.filters(), input.projection(), input.Limit, input.Offset)
+		if err != nil {
+			return nil, searchError(err, "failed to list series")
+		}
+		return newSearchOutput(&searchResult{Records: records, Total: &total}), nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "ExportRecord",
+		Method:      "GET",
+		Path:        "/v1/records/{id}/export",
+		Summary:     "Export record citation",
+		Description: "Export a record as a BibTeX or RIS citation entry for reference managers, or as MARC21 XML or ONIX 3.0 for library systems",
+		Tags:        []string{"Records"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *ExportRecordInput) (*ExportOutput, error) {
+		record, err := database.GetRecordByID(ctx, input.ID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, huma.Error404NotFound("record not found", codeRecordNotFound)
+			}
+			return nil, huma.Error500InternalServerError("failed to get record", err)
+		}
+
+		format := export.Format(input.Format)
+		data, err := export.Record(record, format)
+		if err != nil {
+			return nil, huma.Error400BadRequest(err.Error(), codeInvalidQuery)
+		}
+
+		return &ExportOutput{
+			ContentType:        format.ContentType(),
+			ContentDisposition: fmt.Sprintf(`attachment; filename="%s.%s"`, input.ID, format.Extension()),
+			Body:               data,
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "ExportRecords",
+		Method:      "POST",
+		Path:        "/v1/records/export",
+		Summary:     "Export records",
+		Description: "Export several records at once in a single document, unknown IDs are skipped",
+		Tags:        []string{"Records"},
+	}, func(ctx context.Context, input *ExportRecordsInput) (*ExportOutput, error) {
+		records, err := database.GetRecordsByIDs(ctx, input.Body.IDs)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("failed to get records", err)
+		}
+		if len(records) == 0 {
+			return nil, huma.Error404NotFound("no record found", codeRecordNotFound)
+		}
+
+		format := export.Format(input.Body.Format)
+		data, err := export.Records(records, format)
+		if err != nil {
+			return nil, huma.Error400BadRequest(err.Error(), codeInvalidQuery)
+		}
+
+		return &ExportOutput{
+			ContentType:        format.ContentType(),
+			ContentDisposition: fmt.Sprintf(`attachment; filename="records.%s"`, format.Extension()),
+			Body:               data,
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetRecordCover",
+		Method:      "GET",
+		Path:        "/v1/records/{id}/cover",
+		Summary:     "Get record cover",
+		Description: "Get the cover image of a record as a JPEG. Covers are fetched from their external host once, resized and cached by the API. Records without a cover URL get the cover of their epub once it has been downloaded",
+		Tags:        []string{"Records"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *CoverInput) (*CoverOutput, error) {
+		record, err := database.GetRecordByID(ctx, input.ID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, huma.Error404NotFound("record not found", codeRecordNotFound)
+			}
+			return nil, huma.Error500InternalServerError("failed to get record", err)
+		}
+		if record.CoverURL == "" {
+			if record.Extension != "epub" {
+				return nil, huma.Error404NotFound("record has no cover", codeCoverUnavailable)
+			}
+			blob, err := database.GetRecordBlob(ctx, record.ID)
+			if err != nil {
+				return nil, huma.Error500InternalServerError("failed to get record", err)
+			}
+			data, err := epubCover(anna.StoredFilename(record.ID, blob, record.Extension), input.Width)
+			if err != nil {
+				if errors.Is(err, cover.ErrUnavailable) {
+					return nil, huma.Error404NotFound("record has no cover", codeCoverUnavailable, err)
+				}
+				return nil, huma.Error500InternalServerError("failed to get cover", err)
+			}
+			return &CoverOutput{
+				ContentType:  cover.ContentType,
+				CacheControl: "public, max-age=2592000",
+				Body:         data,
+			}, nil
+		}
+
+		data, err := cover.Get(ctx, record.CoverURL, input.Width)
+		if err != nil {
+			if errors.Is(err, cover.ErrUnavailable) {
+				return nil, huma.Error502BadGateway("failed to fetch cover", codeCoverUnavailable, err)
+			}
+			return nil, huma.Error500InternalServerError("failed to get cover", err)
+		}
+
+		return &CoverOutput{
+			ContentType:  cover.ContentType,
+			CacheControl: "public, max-age=2592000",
+			Body:         data,
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetRecordChanges",
+		Method:      "GET",
+		Path:        "/v1/records/changes",
+		Summary:     "Record changes feed",
+		Description: "Stream the IDs of records created, updated or deleted after a given time as newline-delimited JSON, ordered by update time. Records deleted by a prune or a rollback are listed with `deleted` set. Mirrors can pass the last `updatedAt` they received as `since` to replicate incrementally",
+		Tags:        []string{"Records"},
+	}, func(ctx context.Context, input *RecordChangesInput) (*RecordChangesOutput, error) {
+		return &RecordChangesOutput{
+			ContentType: "application/x-ndjson",
+			Body: func(hctx huma.Context) {
+				w := hctx.BodyWriter()
+				encoder := json.NewEncoder(w)
+				err := database.RecordChanges(ctx, input.Since, func(changes []database.RecordChange) error {
+					for _, change := range changes {
+						if err := encoder.Encode(change); err != nil {
+							return err
+						}
+					}
+					if f, ok := w.(http.Flusher); ok {
+						f.Flush()
+					}
+					return nil
+				})
+				if err != nil {
+					// Headers are already sent, the client sees a truncated stream
+					slog.Error("Failed to stream record changes", "error", err)
+				}
+			},
+		}, nil
 	})
 
 	huma.Register(api, huma.Operation{
@@ -353,13 +953,34 @@ func Setup(api huma.API) {
 		Method:      "GET",
 		Path:        "/v1/records/{id}",
 		Summary:     "Get record by ID",
-		Description: "Get a single record by its ID",
+		Description: "Get a single record by its ID, including its identifiers and classifications. Send `Accept: application/ld+json` to get a schema.org Book instead",
 		Tags:        []string{"Records"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+		Responses: map[string]*huma.Response{
+			"200": {
+				Description: "OK",
+				Content: map[string]*huma.MediaType{
+					"application/json":    {Schema: registry.Schema(reflect.TypeOf(database.Record{}), true, "")},
+					"application/ld+json": {Schema: registry.Schema(reflect.TypeOf(export.Book{}), true, "")},
+				},
+			},
+		},
 	}, func(ctx context.Context, input *GetRecordInput) (*GetRecordOutput, error) {
-		record, err := database.GetRecordByID(ctx, input.ID)
+		record, err := getRecord(ctx, input.ID)
 		if err != nil {
-			return nil, huma.Error404NotFound("record not found")
+			return nil, err
 		}
+
+		if negotiation.SelectQValueFast(input.Accept, []string{"application/json", "application/ld+json"}) == "application/ld+json" {
+			data, err := json.Marshal(export.JSONLD(record))
+			if err != nil {
+				return nil, huma.Error500InternalServerError("failed to render JSON-LD", err)
+			}
+			return &GetRecordOutput{ContentType: "application/ld+json", Body: data}, nil
+		}
+
 		return &GetRecordOutput{Body: *record}, nil
 	})
+
+	setupV2(api)
 }
@@ -0,0 +1,297 @@
+package routing
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// The v2 API serves the same operations as v1 with a consistent response
+// envelope: results are under data, request details and pagination under meta,
+// and errors are listed under errors. Pagination is cursor based only.
+
+// v1Deprecation is the date v1 operations having a v2 successor were
+// deprecated, as an RFC 9745 Deprecation header value (2026-10-15 UTC).
+const v1Deprecation = "@1792022400"
+
+// Meta holds the details of a v2 response besides its data.
+type Meta struct {
+	RequestID  string              `json:"request_id,omitempty" doc:"ID of the request, as sent in the X-Request-ID header"`
+	Mode       database.SearchMode `json:"mode,omitempty" enum:"isbn,identifier,text" doc:"Search strategy used to resolve the query"`
+	Total      *int64              `json:"total,omitempty" doc:"Total number of matches, absent when total=none"`
+	Estimated  bool                `json:"total_estimated,omitempty" doc:"Whether total is a query planner estimate"`
+	NextCursor string              `json:"next_cursor,omitempty" doc:"Cursor of the next page, absent on the last page"`
+	Matches    map[string][]string `json:"matches,omitempty" doc:"IDs of the records matching each input ISBN, only set when searching several ISBNs"`
+}
+
+// Envelope is the body of every successful v2 response.
+type Envelope[T any] struct {
+	Data T    `json:"data"`
+	Meta Meta `json:"meta"`
+}
+
+type EnvelopeOutput[T any] struct {
+	Body Envelope[T]
+}
+
+// ErrorEnvelope is the body of every v2 error response.
+type ErrorEnvelope struct {
+	Errors []*APIError `json:"errors"`
+	Meta   Meta        `json:"meta"`
+}
+
+func envelope[T any](ctx context.Context, data T, meta Meta) *EnvelopeOutput[T] {
+	meta.RequestID = RequestID(ctx)
+	return &EnvelopeOutput[T]{Body: Envelope[T]{Data: data, Meta: meta}}
+}
+
+func searchEnvelope(ctx context.Context, result *searchResult) *EnvelopeOutput[[]database.Record] {
+	return envelope(ctx, result.Records, Meta{
+		Mode:       result.Mode,
+		Total:      result.Total,
+		Estimated:  result.Estimated,
+		NextCursor: result.NextCursor,
+		Matches:    result.Matches,
+	})
+}
+
+// CursorPageInput holds the pagination query parameters of v2 searches.
+type CursorPageInput struct {
+	Limit  int    `query:"limit" default:"20" minimum:"1" maximum:"100" doc:"Maximum number of results"`
+	Cursor string `query:"cursor" doc:"Opaque cursor returned as meta.next_cursor by the previous page"`
+	Total  string `query:"total" default:"exact" enum:"exact,estimated,none" doc:"How the total is computed: exact count, query planner estimate (much faster on broad searches) or not at all"`
+}
+
+func (i CursorPageInput) page() database.Page {
+	return database.Page{
+		Limit:  i.Limit,
+		Cursor: i.Cursor,
+		Count:  database.CountMode(i.Total),
+	}
+}
+
+type SearchInputV2 struct {
+	Query string `query:"q" required:"true" doc:"ISBN, identifier (e.g. oclc:1234567) or free text matched against title and author"`
+	SearchFiltersInput
+	CursorPageInput
+	ProjectionInput
+}
+
+type SearchByISBNInputV2 struct {
+	ISBN []string `query:"isbn,explode" required:"true" minItems:"1" doc:"ISBN10 or ISBN13 codes to search for, as a comma-separated list or a repeated parameter"`
+	SearchFiltersInput
+	CursorPageInput
+	ProjectionInput
+}
+
+type SearchByTextInputV2 struct {
+	TextQueryInput
+	SearchFiltersInput
+	CursorPageInput
+	ProjectionInput
+}
+
+type GetRecordInputV2 struct {
+	ID string `path:"id" doc:"Record ID" required:"true"`
+}
+
+// DownloadState is the download status of a record.
+type DownloadState struct {
+	Status   anna.DownloadStatus `json:"status" enum:"NOT_STARTED,QUEUED,DOWNLOADING,DOWNLOADED" doc:"Download status"`
+	Position int                 `json:"position,omitempty" doc:"Position in the download queue, only set while QUEUED"`
+}
+
+// v1Successors holds the v1 operations having a v2 successor, as "METHOD path".
+var v1Successors = map[string]bool{}
+
+// registerV2 registers a v2 operation and deprecates its v1 counterpart, whose
+// path is the same with a /v1 prefix.
+func registerV2[I, O any](api huma.API, op huma.Operation, handler func(context.Context, *I) (*O, error)) {
+	registry := api.OpenAPI().Components.Schemas
+	op.Responses = map[string]*huma.Response{
+		"default": {
+			Description: "Error",
+			Content: map[string]*huma.MediaType{
+				"application/json": {Schema: registry.Schema(reflect.TypeOf(ErrorEnvelope{}), true, "")},
+			},
+		},
+	}
+	huma.Register(api, op, handler)
+
+	v1Path := "/v1" + strings.TrimPrefix(op.Path, "/v2")
+	if item := api.OpenAPI().Paths[v1Path]; item != nil && item.Get != nil && op.Method == http.MethodGet {
+		item.Get.Deprecated = true
+		v1Successors[op.Method+" "+v1Path] = true
+	}
+}
+
+// versionMiddleware adds deprecation headers to v1 operations having a v2
+// successor, and has errors of v2 operations sent as JSON envelopes instead of
+// problem documents.
+func versionMiddleware(ctx huma.Context, next func(huma.Context)) {
+	op := ctx.Operation()
+	if strings.HasPrefix(op.Path, "/v2/") {
+		next(&envelopeContext{humaContext: ctx})
+		return
+	}
+
+	if v1Successors[op.Method+" "+op.Path] {
+		successor := "/v2" + strings.TrimPrefix(ctx.URL().Path, "/v1")
+		ctx.SetHeader("Deprecation", v1Deprecation)
+		ctx.SetHeader("Link", "<"+successor+`>; rel="successor-version"`)
+	}
+	next(ctx)
+}
+
+// humaContext lets envelopeContext embed huma.Context, whose Context method
+// would clash with the name of the embedded field.
+type humaContext = huma.Context
+
+// envelopeContext sends problem documents as plain JSON, since ErrorTransformer
+// wraps them in an ErrorEnvelope.
+type envelopeContext struct {
+	humaContext
+}
+
+func (c *envelopeContext) SetHeader(name, value string) {
+	if strings.EqualFold(name, "Content-Type") && value == "application/problem+json" {
+		value = "application/json"
+	}
+	c.humaContext.SetHeader(name, value)
+}
+
+func setupV2(api huma.API) {
+	registerV2(api, huma.Operation{
+		OperationID: "SearchV2",
+		Method:      http.MethodGet,
+		Path:        "/v2/search",
+		Summary:     "Search",
+		Description: "Search for records with a single query: ISBNs and identifiers (type:value) are detected automatically, anything else is matched against title and author",
+		Tags:        []string{"Search"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *SearchInputV2) (*EnvelopeOutput[[]database.Record], error) {
+		result, err := search(ctx, input.Query, input.filters(), input.page(), input.projection())
+		if err != nil {
+			return nil, err
+		}
+		return searchEnvelope(ctx, result), nil
+	})
+
+	registerV2(api, huma.Operation{
+		OperationID: "SearchSuggestV2",
+		Method:      http.MethodGet,
+		Path:        "/v2/search/suggest",
+		Summary:     "Search suggestions",
+		Description: "Get title and author completions for a partial query, for typeahead UIs",
+		Tags:        []string{"Search"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *SuggestInput) (*EnvelopeOutput[[]database.Suggestion], error) {
+		suggestions, err := suggest(ctx, input.Query, input.Limit)
+		if err != nil {
+			return nil, err
+		}
+		return envelope(ctx, suggestions, Meta{}), nil
+	})
+
+	registerV2(api, huma.Operation{
+		OperationID: "SearchByISBNV2",
+		Method:      http.MethodGet,
+		Path:        "/v2/search/isbn",
+		Summary:     "Search by ISBN",
+		Description: "Search for records matching ISBN10 or ISBN13 codes. Up to 100 codes can be resolved at once, the records matching each of them are then listed in `meta.matches`",
+		Tags:        []string{"Search"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *SearchByISBNInputV2) (*EnvelopeOutput[[]database.Record], error) {
+		result, err := searchByISBN(ctx, input.ISBN, input.filters(), input.page(), input.projection())
+		if err != nil {
+			return nil, err
+		}
+		return searchEnvelope(ctx, result), nil
+	})
+
+	registerV2(api, huma.Operation{
+		OperationID: "SearchByTextV2",
+		Method:      http.MethodGet,
+		Path:        "/v2/search/text",
+		Summary:     "Search by text",
+		Description: "Search for records by title, author, publisher and description. At least one of them is required. Fuzzy results are ordered by similarity and only the first page can be fetched",
+		Tags:        []string{"Search"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *SearchByTextInputV2) (*EnvelopeOutput[[]database.Record], error) {
+		result, err := searchByText(ctx, input.textQuery(), input.filters(), input.page(), input.projection())
+		if err != nil {
+			return nil, err
+		}
+		return searchEnvelope(ctx, result), nil
+	})
+
+	registerV2(api, huma.Operation{
+		OperationID: "GetRecordByIDV2",
+		Method:      http.MethodGet,
+		Path:        "/v2/records/{id}",
+		Summary:     "Get record by ID",
+		Description: "Get a single record by its ID, including its identifiers and classifications",
+		Tags:        []string{"Records"},
+		Metadata:    map[string]any{conditionalMetadata: true},
+	}, func(ctx context.Context, input *GetRecordInputV2) (*EnvelopeOutput[database.Record], error) {
+		record, err := getRecord(ctx, input.ID)
+		if err != nil {
+			return nil, err
+		}
+		return envelope(ctx, *record, Meta{}), nil
+	})
+
+	registerV2(api, huma.Operation{
+		OperationID: "CheckDownloadStatusV2",
+		Method:      http.MethodGet,
+		Path:        "/v2/records/{id}/status",
+		Summary:     "Check download status",
+		Description: "Check the current status of the file download for a record",
+		Tags:        []string{"Download"},
+		Security: []map[string][]string{
+			{"bearerAuth": {}},
+		},
+	}, func(ctx context.Context, input *DownloadInput) (*EnvelopeOutput[DownloadState], error) {
+		filename, _, err := recordFilename(ctx, input.ID, input.Format)
+		if err != nil {
+			return nil, err
+		}
+		progress := anna.GetDownloadProgress(filename)
+		return envelope(ctx, DownloadState{Status: progress.Status, Position: progress.Position}, Meta{}), nil
+	})
+
+	registerV2(api, huma.Operation{
+		OperationID: "GetStatisticsV2",
+		Method:      http.MethodGet,
+		Path:        "/v2/statistics",
+		Summary:     "Get statistics",
+		Description: "Get statistics about current data set",
+		Tags:        []string{"Statistics"},
+	}, func(ctx context.Context, input *struct{}) (*EnvelopeOutput[database.CachedStats], error) {
+		stats, err := getStats()
+		if err != nil {
+			return nil, err
+		}
+		return envelope(ctx, *stats, Meta{}), nil
+	})
+
+	registerV2(api, huma.Operation{
+		OperationID: "GetYearStatisticsV2",
+		Method:      http.MethodGet,
+		Path:        "/v2/statistics/years",
+		Summary:     "Get publication year statistics",
+		Description: "Get the number of records by publication year or decade, keyed by the first year of the bucket",
+		Tags:        []string{"Statistics"},
+	}, func(ctx context.Context, input *YearStatsInput) (*EnvelopeOutput[[]database.YearCount], error) {
+		years, err := yearHistogram(input.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		return envelope(ctx, years, Meta{}), nil
+	})
+}
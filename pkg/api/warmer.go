@@ -0,0 +1,77 @@
+package routing
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// RunCacheWarmer prefetches the ANNA_WARM_CACHE_TOP most downloaded records
+// of the last ANNA_WARM_CACHE_DAYS every day at ANNA_WARM_CACHE_HOUR (UTC),
+// until ctx is done, so that popular files are always served from the
+// storage directory. It returns right away when ANNA_WARM_CACHE_TOP is not set.
+func RunCacheWarmer(ctx context.Context) {
+	c := config.C.Anna
+	if c.WarmCacheTop <= 0 || anna.EpubStorageDir == "" {
+		return
+	}
+
+	for {
+		now := time.Now().UTC()
+		next := now.Truncate(24 * time.Hour).Add(time.Duration(c.WarmCacheHour) * time.Hour)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		slog.Info("Next cache warming scheduled", "at", next)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		warmCache(ctx, c.WarmCacheTop, c.WarmCacheDays)
+	}
+}
+
+// warmCache downloads the files of the top most downloaded records of the
+// last days that are not stored, one at a time. Warming downloads are not
+// counted, they would otherwise keep the same records on top.
+func warmCache(ctx context.Context, top, days int) {
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	records, _, _, err := database.DownloadStatistics(ctx, since, top)
+	if err != nil {
+		slog.Error("Failed to get the most downloaded records", "error", err)
+		return
+	}
+
+	warmed := 0
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return
+		}
+		info, err := database.GetRecordDownloadInfo(ctx, record.ID)
+		if err != nil {
+			slog.Warn("Skipping record of cache warming", "id", record.ID, "error", err)
+			continue
+		}
+		if _, err := anna.StoredFile(anna.StoredFilename(record.ID, info.Blob, info.Extension)); err == nil {
+			continue
+		}
+		sources, err := torrentSources(ctx, record.ID, info)
+		if err != nil {
+			slog.Warn("Skipping record of cache warming", "id", record.ID, "error", err)
+			continue
+		}
+		if _, err := fetchRecord(ctx, record.ID, info, sources); err != nil {
+			slog.Warn("Failed to warm cache", "id", record.ID, "error", err)
+			continue
+		}
+		warmed++
+	}
+	slog.Info("Cache warmed", "records", len(records), "downloaded", warmed)
+}
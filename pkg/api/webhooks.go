@@ -0,0 +1,185 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/webhook"
+	"gorm.io/gorm"
+)
+
+var webhooksSecurity = []map[string][]string{
+	{"bearerAuth": {AdminScope, "webhooks"}},
+}
+
+type WebhookBody struct {
+	URL    string   `json:"url" format:"uri" doc:"HTTP(S) endpoint receiving the events as POST requests"`
+	Events []string `json:"events" minItems:"1" enum:"sync.started,sync.completed,prefetch.completed,download.failed" doc:"Events to subscribe to"`
+	Secret string   `json:"secret,omitempty" doc:"Key of the HMAC-SHA256 X-Anna-Signature header, generated when empty"`
+	Active *bool    `json:"active,omitempty" doc:"Whether events are delivered, defaults to true"`
+}
+
+// validate checks the fields that the schema cannot.
+func (b WebhookBody) validate() error {
+	u, err := url.Parse(b.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return huma.Error400BadRequest("url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+type CreateWebhookInput struct {
+	Body WebhookBody
+}
+
+type WebhookInput struct {
+	ID uint `path:"id" doc:"Webhook ID"`
+}
+
+type UpdateWebhookInput struct {
+	WebhookInput
+	Body WebhookBody
+}
+
+type WebhookOutput struct {
+	Body database.Webhook
+}
+
+type ListWebhooksOutput struct {
+	Body struct {
+		Webhooks []database.Webhook `json:"webhooks"`
+	}
+}
+
+// setupWebhooks registers the webhook subscription endpoints, which require a
+// token with the admin or webhooks scope. Secrets are only returned on creation.
+func setupWebhooks(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "CreateWebhook",
+		Method:        "POST",
+		Path:          "/v1/webhooks",
+		Summary:       "Create webhook",
+		Description:   "Subscribe an endpoint to events. Deliveries are signed with the secret, which is only returned by this operation, and retried with exponential backoff",
+		Tags:          []string{"Webhooks"},
+		DefaultStatus: http.StatusCreated,
+		Security:      webhooksSecurity,
+	}, func(ctx context.Context, input *CreateWebhookInput) (*WebhookOutput, error) {
+		if err := input.Body.validate(); err != nil {
+			return nil, err
+		}
+
+		w := database.Webhook{
+			URL:    input.Body.URL,
+			Events: input.Body.Events,
+			Secret: input.Body.Secret,
+			Active: input.Body.Active == nil || *input.Body.Active,
+		}
+		if w.Secret == "" {
+			w.Secret = webhook.NewSecret()
+		}
+		if err := database.CreateWebhook(ctx, &w); err != nil {
+			return nil, huma.Error500InternalServerError("failed to create webhook", err)
+		}
+		return &WebhookOutput{Body: w}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "ListWebhooks",
+		Method:      "GET",
+		Path:        "/v1/webhooks",
+		Summary:     "List webhooks",
+		Tags:        []string{"Webhooks"},
+		Security:    webhooksSecurity,
+	}, func(ctx context.Context, input *struct{}) (*ListWebhooksOutput, error) {
+		webhooks, err := database.ListWebhooks(ctx)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("failed to list webhooks", err)
+		}
+		for i := range webhooks {
+			webhooks[i].Secret = ""
+		}
+		resp := &ListWebhooksOutput{}
+		resp.Body.Webhooks = webhooks
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetWebhook",
+		Method:      "GET",
+		Path:        "/v1/webhooks/{id}",
+		Summary:     "Get webhook",
+		Tags:        []string{"Webhooks"},
+		Security:    webhooksSecurity,
+	}, func(ctx context.Context, input *WebhookInput) (*WebhookOutput, error) {
+		w, err := getWebhook(ctx, input.ID)
+		if err != nil {
+			return nil, err
+		}
+		w.Secret = ""
+		return &WebhookOutput{Body: *w}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "UpdateWebhook",
+		Method:      "PUT",
+		Path:        "/v1/webhooks/{id}",
+		Summary:     "Update webhook",
+		Description: "Replace the URL and events of a webhook. The secret is kept unless a new one is given",
+		Tags:        []string{"Webhooks"},
+		Security:    webhooksSecurity,
+	}, func(ctx context.Context, input *UpdateWebhookInput) (*WebhookOutput, error) {
+		if err := input.Body.validate(); err != nil {
+			return nil, err
+		}
+
+		w, err := getWebhook(ctx, input.ID)
+		if err != nil {
+			return nil, err
+		}
+		w.URL = input.Body.URL
+		w.Events = input.Body.Events
+		w.Active = input.Body.Active == nil || *input.Body.Active
+		if input.Body.Secret != "" {
+			w.Secret = input.Body.Secret
+		}
+		if err := database.UpdateWebhook(ctx, w); err != nil {
+			return nil, huma.Error500InternalServerError("failed to update webhook", err)
+		}
+		w.Secret = ""
+		return &WebhookOutput{Body: *w}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "DeleteWebhook",
+		Method:        "DELETE",
+		Path:          "/v1/webhooks/{id}",
+		Summary:       "Delete webhook",
+		Tags:          []string{"Webhooks"},
+		DefaultStatus: http.StatusNoContent,
+		Security:      webhooksSecurity,
+	}, func(ctx context.Context, input *WebhookInput) (*struct{}, error) {
+		deleted, err := database.DeleteWebhook(ctx, input.ID)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("failed to delete webhook", err)
+		}
+		if !deleted {
+			return nil, huma.Error404NotFound("webhook not found", codeWebhookNotFound)
+		}
+		return nil, nil
+	})
+}
+
+func getWebhook(ctx context.Context, id uint) (*database.Webhook, error) {
+	w, err := database.GetWebhook(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, huma.Error404NotFound("webhook not found", codeWebhookNotFound)
+		}
+		return nil, huma.Error500InternalServerError("failed to get webhook", err)
+	}
+	return w, nil
+}
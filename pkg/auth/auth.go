@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/iziplay/anna-api/pkg/config"
+)
+
+// jwks holds the keys of ANNA_JWKS_URL, nil when only shared secrets are accepted.
+var jwks *keySet
+
+func init() {
+	if url := config.C.Auth.JWKSURL; url != "" {
+		refresh := config.C.Auth.JWKSRefreshInterval
+		slog.Info("Verifying tokens against JWKS", "url", url, "refresh", refresh)
+		jwks = newKeySet(url, refresh)
+	}
+}
+
+// Enabled returns true when ANNA_JWT_SECRET or ANNA_JWKS_URL is set, i.e. protected operations require a token.
+func Enabled() bool {
+	return config.C.Auth.JWTSecret != "" || jwks != nil
+}
+
+// ParseToken verifies a JWT, either signed with ANNA_JWT_SECRET (HMAC) or with
+// one of the RSA or ECDSA keys served at ANNA_JWKS_URL, and returns it.
+// ANNA_JWT_ISSUER and ANNA_JWT_AUDIENCE, when set, are checked as well.
+func ParseToken(tokenString string) (*jwt.Token, error) {
+	secret := config.C.Auth.JWTSecret
+
+	var methods []string
+	if secret != "" {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if jwks != nil {
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods)}
+	if issuer := config.C.Auth.JWTIssuer; issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience := config.C.Auth.JWTAudience; audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			return []byte(secret), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			kid, _ := token.Header["kid"].(string)
+			return jwks.key(context.Background(), kid)
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return token, nil
+}
+
+// HasAnyScope returns whether a token grants one of the given scopes, read from
+// the space-delimited "scope" claim (RFC 8693) or the "scp" list.
+func HasAnyScope(token *jwt.Token, scopes []string) bool {
+	claims, _ := token.Claims.(jwt.MapClaims)
+
+	var granted []string
+	if scope, ok := claims["scope"].(string); ok {
+		granted = strings.Fields(scope)
+	}
+	if scp, ok := claims["scp"].([]any); ok {
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				granted = append(granted, s)
+			}
+		}
+	}
+
+	for _, scope := range scopes {
+		if slices.Contains(granted, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx carrying the claims of a verified token.
+func NewContext(ctx context.Context, token *jwt.Token) context.Context {
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// Tenant returns the "tenant" claim of the verified token carried by ctx, or
+// an empty string for anonymous requests and tokens without tenant.
+func Tenant(ctx context.Context) string {
+	claims, _ := ctx.Value(claimsKey{}).(jwt.MapClaims)
+	tenant, _ := claims["tenant"].(string)
+	return tenant
+}
+
+// ContextSubject returns the subject of the verified token carried by ctx, or
+// an empty string for anonymous requests.
+func ContextSubject(ctx context.Context) string {
+	claims, _ := ctx.Value(claimsKey{}).(jwt.MapClaims)
+	subject, _ := claims["sub"].(string)
+	return subject
+}
+
+// Subject returns the subject of a valid token, or an empty string.
+func Subject(tokenString string) string {
+	if !Enabled() || tokenString == "" {
+		return ""
+	}
+	token, err := ParseToken(tokenString)
+	if err != nil {
+		return ""
+	}
+	subject, _ := token.Claims.GetSubject()
+	return subject
+}
+
+// RequireScope protects a plain HTTP handler, for routes living outside of the
+// huma API, with a token granting one of the given scopes. The token is read
+// from the Authorization header or the jwt query parameter. Requests are
+// refused when authentication is disabled.
+func RequireScope(next http.Handler, scopes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled() {
+			http.Error(w, "authentication must be enabled for this operation", http.StatusForbidden)
+			return
+		}
+
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if tokenString == "" {
+			tokenString = r.URL.Query().Get("jwt")
+		}
+
+		token, err := ParseToken(tokenString)
+		if err != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		if !HasAnyScope(token, scopes) {
+			http.Error(w, "missing scope", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), token)))
+	})
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasAnyScope(t *testing.T) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"scope": "read admin"})
+	assert.True(t, HasAnyScope(token, []string{"admin"}))
+	assert.False(t, HasAnyScope(token, []string{"write"}))
+
+	token = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"scp": []any{"admin"}})
+	assert.True(t, HasAnyScope(token, []string{"write", "admin"}))
+
+	token = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{})
+	assert.False(t, HasAnyScope(token, []string{"admin"}))
+}
+
+func TestRequireScope(t *testing.T) {
+	config.C.Auth.JWTSecret = "secret"
+	defer func() { config.C.Auth.JWTSecret = "" }()
+	handler := RequireScope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "admin")
+
+	sign := func(claims jwt.MapClaims) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		assert.NoError(t, err)
+		return signed
+	}
+
+	for token, code := range map[string]int{
+		"":                                   http.StatusUnauthorized,
+		sign(jwt.MapClaims{"scope": "read"}): http.StatusForbidden,
+		sign(jwt.MapClaims{"scope": "read admin"}): http.StatusOK,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/pprof/?jwt="+token, nil))
+		assert.Equal(t, code, recorder.Code)
+	}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// minRefreshInterval bounds how often an unknown key ID can trigger a JWKS refresh.
+const minRefreshInterval = time.Minute
+
+// jwk is a JSON Web Key as served by identity providers (RFC 7517).
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keySet caches the public keys of a JWKS URL. Keys are refreshed periodically
+// and when a token references an unknown key ID, so that key rotations are
+// picked up without a restart.
+type keySet struct {
+	url             string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	group singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newKeySet(url string, refreshInterval time.Duration) *keySet {
+	return &keySet{
+		url:             url,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// key returns the public key with the given ID.
+func (s *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetchedAt)
+	s.mu.Unlock()
+
+	switch {
+	case ok && age <= s.refreshInterval:
+		return key, nil
+	case ok:
+		// Serve the cached key while the set is refreshed in the background
+		go s.refresh(context.WithoutCancel(ctx))
+		return key, nil
+	case age > minRefreshInterval:
+		s.refresh(ctx)
+		s.mu.Lock()
+		key, ok = s.keys[kid]
+		s.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// refresh downloads the key set, sharing a single request between concurrent
+// callers. The keys already known are kept when the provider is unreachable.
+func (s *keySet) refresh(ctx context.Context) {
+	s.group.Do("", func() (any, error) {
+		if err := s.fetch(ctx); err != nil {
+			slog.Warn("Failed to refresh JWKS", "url", s.url, "error", err)
+		}
+		return nil, nil
+	})
+}
+
+// fetch downloads the key set without holding s.mu, and swaps the keys once
+// they are decoded.
+func (s *keySet) fetch(ctx context.Context) error {
+	// Even failed attempts count, to not hammer a broken provider
+	s.mu.Lock()
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(body.Keys))
+	for _, k := range body.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Warn("Skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// publicKey decodes an RSA or EC public key.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter: %w", err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func encode(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestParseTokenJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jwk{
+			{Kid: "rsa", Kty: "RSA", Use: "sig", N: encode(rsaKey.N), E: encode(big.NewInt(int64(rsaKey.E)))},
+			{Kid: "ec", Kty: "EC", Crv: "P-256", X: encode(ecKey.X), Y: encode(ecKey.Y)},
+		}})
+	}))
+	defer server.Close()
+
+	jwks = newKeySet(server.URL, time.Hour)
+	defer func() { jwks = nil }()
+	assert.True(t, Enabled())
+
+	claims := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "rsa"
+	signed, err := token.SignedString(rsaKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", Subject(signed))
+
+	token = jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = "ec"
+	signed, err = token.SignedString(ecKey)
+	assert.NoError(t, err)
+	_, err = ParseToken(signed)
+	assert.NoError(t, err)
+
+	token = jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "unknown"
+	signed, err = token.SignedString(rsaKey)
+	assert.NoError(t, err)
+	_, err = ParseToken(signed)
+	assert.Error(t, err)
+
+	// Shared secret tokens are refused when no secret is configured
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(""))
+	assert.NoError(t, err)
+	_, err = ParseToken(signed)
+	assert.Error(t, err)
+}
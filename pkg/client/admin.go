@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Statistics returns the cached statistics of the database.
+func (c *Client) Statistics(ctx context.Context) (*Statistics, error) {
+	stats := &Statistics{}
+	if err := c.do(ctx, http.MethodGet, "/v1/statistics", nil, nil, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// YearStatistics returns the number of records by publication year, or by
+// decade when decades is set.
+func (c *Client) YearStatistics(ctx context.Context, decades bool) ([]YearCount, error) {
+	bucket := "year"
+	if decades {
+		bucket = "decade"
+	}
+	var result struct {
+		Years []YearCount `json:"years"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/statistics/years", url.Values{"bucket": {bucket}}, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Years, nil
+}
+
+// DownloadStatistics returns the limit most downloaded records and the
+// number of downloads per day over the last days, zero values meaning the
+// server defaults (30 days, 10 records).
+func (c *Client) DownloadStatistics(ctx context.Context, days, limit int) (*DownloadStatistics, error) {
+	values := url.Values{}
+	if days > 0 {
+		values.Set("days", strconv.Itoa(days))
+	}
+	if limit > 0 {
+		values.Set("limit", strconv.Itoa(limit))
+	}
+	stats := &DownloadStatistics{}
+	if err := c.do(ctx, http.MethodGet, "/v1/statistics/downloads", values, nil, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// SyncStatistics returns the progress of the running sync.
+func (c *Client) SyncStatistics(ctx context.Context) (*SyncStatistics, error) {
+	stats := &SyncStatistics{}
+	if err := c.do(ctx, http.MethodGet, "/v1/statistics/sync", nil, nil, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// SyncHistory returns the last limit syncs, most recent first, zero meaning
+// the server default (30).
+func (c *Client) SyncHistory(ctx context.Context, limit int) ([]SyncHistoryEntry, error) {
+	values := url.Values{}
+	if limit > 0 {
+		values.Set("limit", strconv.Itoa(limit))
+	}
+	var result struct {
+		Syncs []SyncHistoryEntry `json:"syncs"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/statistics/sync/history", values, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Syncs, nil
+}
+
+// TorrentStatistics returns the status of the torrent client of the server, as text.
+func (c *Client) TorrentStatistics(ctx context.Context) (string, error) {
+	data, err := c.raw(ctx, http.MethodGet, "/v1/statistics/torrent", nil, nil)
+	return string(data), err
+}
+
+// TriggerSync starts a sync on the server (admin scope).
+func (c *Client) TriggerSync(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/admin/sync", nil, nil, nil)
+}
+
+// TriggerDryRunSync starts a sync that only counts the records it would
+// write, reported in the DryRun of SyncStatistics (admin scope).
+func (c *Client) TriggerDryRunSync(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/admin/sync", url.Values{"dry_run": {"true"}}, nil, nil)
+}
+
+// RollbackSync restores the records as they were before the last complete
+// sync (admin scope).
+func (c *Client) RollbackSync(ctx context.Context) (*RollbackResult, error) {
+	result := &RollbackResult{}
+	if err := c.do(ctx, http.MethodPost, "/v1/admin/sync/rollback", nil, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RefreshTorrents fetches and saves the torrents list of Anna's Archive
+// without syncing, and returns the number of torrents listed (admin scope).
+func (c *Client) RefreshTorrents(ctx context.Context) (int, error) {
+	var result struct {
+		Torrents int `json:"torrents"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/v1/admin/torrents/refresh", nil, nil, &result); err != nil {
+		return 0, err
+	}
+	return result.Torrents, nil
+}
+
+// TriggerForcedSync starts a sync processing every metadata file again, even
+// if the last metadata torrent was already synced (admin scope).
+func (c *Client) TriggerForcedSync(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/admin/sync", url.Values{"force": {"true"}}, nil, nil)
+}
+
+// TriggerReprocessSync starts a sync processing again the metadata files of
+// the last synced base kept on disk by the server, without downloading them
+// (admin scope).
+func (c *Client) TriggerReprocessSync(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/admin/sync", url.Values{"reprocess": {"true"}}, nil, nil)
+}
+
+// RefreshStatistics recomputes the cached statistics and returns them (admin scope).
+func (c *Client) RefreshStatistics(ctx context.Context) (*Statistics, error) {
+	stats := &Statistics{}
+	if err := c.do(ctx, http.MethodPost, "/v1/admin/stats/refresh", nil, nil, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// EpubCache returns the number and size of the files stored on the server
+// (admin scope).
+func (c *Client) EpubCache(ctx context.Context) (*EpubCache, error) {
+	cache := &EpubCache{}
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/epubs", nil, nil, cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// SeedingTorrents returns the torrents the server keeps seeding after
+// downloads (admin scope).
+func (c *Client) SeedingTorrents(ctx context.Context) ([]SeedingTorrent, error) {
+	var resp struct {
+		Torrents []SeedingTorrent `json:"torrents"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/seeding", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Torrents, nil
+}
+
+// PurgeEpubs removes the epubs stored on the server downloaded longer than
+// olderThan ago (when positive) and whose name matches pattern (when not
+// empty), see filepath.Match (admin scope).
+func (c *Client) PurgeEpubs(ctx context.Context, olderThan time.Duration, pattern string) (*PurgeResult, error) {
+	values := url.Values{}
+	if olderThan > 0 {
+		values.Set("older_than", olderThan.String())
+	}
+	if pattern != "" {
+		values.Set("pattern", pattern)
+	}
+	result := &PurgeResult{}
+	if err := c.do(ctx, http.MethodDelete, "/v1/admin/epubs", values, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func webhookPath(id uint) string {
+	return "/v1/webhooks/" + strconv.FormatUint(uint64(id), 10)
+}
+
+// CreateWebhook subscribes an endpoint to events (admin or webhooks scope).
+// The returned webhook is the only one holding the secret.
+func (c *Client) CreateWebhook(ctx context.Context, request WebhookRequest) (*Webhook, error) {
+	webhook := &Webhook{}
+	if err := c.do(ctx, http.MethodPost, "/v1/webhooks", nil, request, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// ListWebhooks returns every webhook (admin or webhooks scope).
+func (c *Client) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	var result struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/webhooks", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Webhooks, nil
+}
+
+// GetWebhook returns a webhook (admin or webhooks scope).
+func (c *Client) GetWebhook(ctx context.Context, id uint) (*Webhook, error) {
+	webhook := &Webhook{}
+	if err := c.do(ctx, http.MethodGet, webhookPath(id), nil, nil, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// UpdateWebhook replaces a webhook (admin or webhooks scope).
+func (c *Client) UpdateWebhook(ctx context.Context, id uint, request WebhookRequest) (*Webhook, error) {
+	webhook := &Webhook{}
+	if err := c.do(ctx, http.MethodPut, webhookPath(id), nil, request, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook (admin or webhooks scope).
+func (c *Client) DeleteWebhook(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, webhookPath(id), nil, nil, nil)
+}
@@ -0,0 +1,147 @@
+// Package client is a Go client of the Anna API v1.
+//
+//	c := client.New("https://anna.example.org", client.WithToken(token))
+//	result, err := c.Search(ctx, "9780140328721", nil)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client sends requests to an Anna API server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken sets the bearer token sent with every request, required by
+// downloads and admin operations when the server has authentication enabled.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to send requests, http.DefaultClient by default.
+// Downloads can take minutes when epubs are not stored yet, do not set a short timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New returns a client of the API served at baseURL, e.g. "https://anna.example.org".
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Error is returned when the API answers with an error status.
+type Error struct {
+	Status    int    `json:"status"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("anna api: %d %s: %s", e.Status, e.Code, e.Detail)
+	}
+	return fmt.Sprintf("anna api: %d %s", e.Status, http.StatusText(e.Status))
+}
+
+// request sends a request with an optional JSON body and returns the response,
+// or an *Error when its status is not 2xx.
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &Error{}
+		data, _ := io.ReadAll(resp.Body)
+		_ = json.Unmarshal(data, apiErr)
+		apiErr.Status = resp.StatusCode
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = resp.Header.Get("X-Request-ID")
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// do sends a request and decodes the JSON response into out, when not nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// raw sends a request and returns the response body.
+func (c *Client) raw(ctx context.Context, method, path string, query url.Values, body any) ([]byte, error) {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func recordPath(id string, suffix string) string {
+	return "/v1/records/" + url.PathEscape(id) + suffix
+}
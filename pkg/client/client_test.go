@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/search", r.URL.Path)
+		assert.Equal(t, "dune", r.URL.Query().Get("q"))
+		assert.Equal(t, "en,fr", r.URL.Query().Get("languages"))
+		assert.Equal(t, "5", r.URL.Query().Get("limit"))
+		assert.Equal(t, "2024-01-31", r.URL.Query().Get("added_after"))
+		assert.Equal(t, "added", r.URL.Query().Get("sort"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"mode":"text","total":1,"results":[{"id":"md5:abc","title":"Dune","addedAt":"2024-02-03T00:00:00Z","identifiers":[{"type":"isbn13","value":"9780441013593"}]}]}`)
+	}))
+	defer server.Close()
+
+	options := &SearchOptions{Languages: []string{"en", "fr"}, Limit: 5, AddedAfter: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), Sort: "added"}
+	result, err := New(server.URL+"/", WithToken("token")).Search(context.Background(), "dune", options)
+	assert.NoError(t, err)
+	assert.Equal(t, "text", result.Mode)
+	assert.Equal(t, int64(1), *result.Total)
+	assert.Equal(t, "Dune", result.Results[0].Title)
+	assert.Equal(t, []Identifier{{Type: "isbn13", Value: "9780441013593"}}, result.Results[0].Identifiers)
+	assert.Equal(t, time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC), *result.Results[0].AddedAt)
+}
+
+func TestError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"status":404,"title":"Not Found","detail":"record not found","code":"RECORD_NOT_FOUND","request_id":"req-1"}`)
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).GetRecord(context.Background(), "md5:missing")
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+	assert.Equal(t, "RECORD_NOT_FOUND", apiErr.Code)
+	assert.Equal(t, "req-1", apiErr.RequestID)
+}
+
+func TestDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/records/md5:abc/download", r.URL.Path)
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="md5:abc.pdf"`)
+		fmt.Fprint(w, "%PDF")
+	}))
+	defer server.Close()
+
+	file, err := New(server.URL).Download(context.Background(), "md5:abc")
+	assert.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "md5:abc.pdf", file.Name)
+	assert.Equal(t, "application/pdf", file.ContentType)
+	assert.Equal(t, "%PDF", string(data))
+}
+
+func TestPrefetchMany(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/records/prefetch", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"ids":["md5:abc","md5:missing"]}`, string(body))
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"queued":["md5:abc"],"rejected":[{"id":"md5:missing","code":"RECORD_NOT_FOUND","message":"record download info not found: md5:missing"}]}`)
+	}))
+	defer server.Close()
+
+	result, err := New(server.URL).PrefetchMany(context.Background(), []string{"md5:abc", "md5:missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"md5:abc"}, result.Queued)
+	assert.Len(t, result.Rejected, 1)
+	assert.Equal(t, "RECORD_NOT_FOUND", result.Rejected[0].Code)
+}
+
+func TestDownloadProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/records/md5:abc/download/events", r.URL.Path)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: progress\ndata: {\"status\":\"DOWNLOADING\",\"bytes_completed\":50,\"total_bytes\":100,\"percent\":50}\n\n")
+		fmt.Fprint(w, "event: progress\ndata: {\"status\":\"DOWNLOADED\",\"percent\":100}\n\n")
+	}))
+	defer server.Close()
+
+	events, wait, err := New(server.URL).DownloadProgress(context.Background(), "md5:abc")
+	assert.NoError(t, err)
+	var received []DownloadProgress
+	for event := range events {
+		received = append(received, event)
+	}
+	assert.NoError(t, wait())
+	assert.Equal(t, []DownloadProgress{
+		{Status: DownloadStatusDownloading, BytesCompleted: 50, TotalBytes: 100, Percent: 50},
+		{Status: DownloadStatusDownloaded, Percent: 100},
+	}, received)
+}
+
+func TestDownloadProgressError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "event: error\ndata: {\"message\":\"no active download found\"}\n\n")
+	}))
+	defer server.Close()
+
+	events, wait, err := New(server.URL).DownloadProgress(context.Background(), "md5:abc")
+	assert.NoError(t, err)
+	for range events {
+		t.Fatal("unexpected event")
+	}
+	assert.EqualError(t, wait(), "no active download found")
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DownloadStatus returns whether the file of a record is stored on the server.
+func (c *Client) DownloadStatus(ctx context.Context, id string) (DownloadStatus, error) {
+	var result struct {
+		Status DownloadStatus `json:"status"`
+	}
+	if err := c.do(ctx, http.MethodGet, recordPath(id, "/status"), nil, nil, &result); err != nil {
+		return "", err
+	}
+	return result.Status, nil
+}
+
+// Prefetch makes the server download the file of a record in the background,
+// follow it with DownloadProgress.
+func (c *Client) Prefetch(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, recordPath(id, "/prefetch"), nil, nil, nil)
+}
+
+// PrefetchMany starts downloading the files of several records on the server
+// in the background. Records that can't be downloaded are reported in the
+// result instead of failing the whole call.
+func (c *Client) PrefetchMany(ctx context.Context, ids []string) (*PrefetchResult, error) {
+	body := map[string]any{"ids": ids}
+	result := &PrefetchResult{}
+	if err := c.do(ctx, http.MethodPost, "/v1/records/prefetch", nil, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// File is a downloaded record file, which the caller must close.
+type File struct {
+	io.ReadCloser
+	// Name is the file name suggested by the server, e.g. md5:abc.pdf
+	Name        string
+	ContentType string
+}
+
+// Download returns the file of a record (epub, pdf, ...). The server downloads
+// it first when it is not stored yet, which can take minutes.
+func (c *Client) Download(ctx context.Context, id string) (*File, error) {
+	resp, err := c.request(ctx, http.MethodGet, recordPath(id, "/download"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{ReadCloser: resp.Body, ContentType: resp.Header.Get("Content-Type")}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		file.Name = params["filename"]
+	}
+	return file, nil
+}
+
+// DownloadArchive returns a ZIP archive of the files of several records, which
+// the caller must close. Files that failed to download are listed in an
+// errors.txt entry.
+func (c *Client) DownloadArchive(ctx context.Context, ids []string) (io.ReadCloser, error) {
+	resp, err := c.request(ctx, http.MethodPost, "/v1/records/download", nil, map[string]any{"ids": ids})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// DownloadProgress streams the progress of the download of the file of a
+// record, started by Prefetch. The channel is closed once the file is
+// downloaded, when ctx is done or when the stream ends, in which case the
+// error function tells why.
+func (c *Client) DownloadProgress(ctx context.Context, id string) (<-chan DownloadProgress, func() error, error) {
+	resp, err := c.request(ctx, http.MethodGet, recordPath(id, "/download/events"), nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := make(chan DownloadProgress)
+	var streamErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(events)
+		defer resp.Body.Close()
+		streamErr = readProgress(ctx, resp.Body, events)
+	}()
+
+	return events, func() error {
+		<-done
+		return streamErr
+	}, nil
+}
+
+// readProgress parses the Server-Sent Events of a download progress stream.
+func readProgress(ctx context.Context, r io.Reader, events chan<- DownloadProgress) error {
+	scanner := bufio.NewScanner(r)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data := []byte(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+			if event == "error" {
+				var e struct {
+					Message string `json:"message"`
+				}
+				_ = json.Unmarshal(data, &e)
+				return errors.New(e.Message)
+			}
+			var progress DownloadProgress
+			if err := json.Unmarshal(data, &progress); err != nil {
+				return err
+			}
+			select {
+			case events <- progress:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if progress.Status == DownloadStatusDownloaded {
+				return nil
+			}
+		case line == "":
+			event = ""
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,301 @@
+package client
+
+import "time"
+
+// Record is a book of Anna's Archive.
+type Record struct {
+	ID              string           `json:"id"`
+	Title           string           `json:"title"`
+	Publisher       string           `json:"publisher"`
+	Author          string           `json:"author"`
+	CoverURL        string           `json:"coverURL"`
+	Year            int              `json:"year"`
+	Languages       []string         `json:"languages"`
+	Description     string           `json:"description,omitempty"`
+	ContentType     string           `json:"contentType"`
+	Extension       string           `json:"extension"`
+	Filesize        int64            `json:"filesize,omitempty"`
+	MD5             string           `json:"md5,omitempty"`
+	AddedAt         *time.Time       `json:"addedAt,omitempty"`
+	Series          string           `json:"series,omitempty"`
+	SeriesIndex     float64          `json:"seriesIndex,omitempty"`
+	Identifiers     []Identifier     `json:"identifiers,omitempty"`
+	Classifications []Classification `json:"classifications,omitempty"`
+	CreatedAt       time.Time        `json:"createdAt"`
+	UpdatedAt       time.Time        `json:"updatedAt"`
+}
+
+// Identifier is an external ID of a record, e.g. isbn13:9780140328721.
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Classification is a subject or collection of a record, e.g. ddc:823.912.
+type Classification struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// SearchResult is a page of search results.
+type SearchResult struct {
+	// Mode is the strategy used to resolve a Search query: isbn, identifier or text
+	Mode string `json:"mode,omitempty"`
+	// Total is nil when counting was disabled with SearchOptions.Total
+	Total      *int64   `json:"total,omitempty"`
+	Estimated  bool     `json:"total_estimated,omitempty"`
+	Results    []Record `json:"results"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	// Matches holds the IDs of the records matching each ISBN, when searching several
+	Matches map[string][]string `json:"matches,omitempty"`
+}
+
+// Suggestion is a title or author completion.
+type Suggestion struct {
+	Type  string  `json:"type"`
+	Value string  `json:"value"`
+	Score float64 `json:"score"`
+}
+
+// RecordChange is an entry of the changes feed, Deleted when the record was
+// deleted.
+type RecordChange struct {
+	ID        string    `json:"id"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// DownloadStatus is the availability of the epub of a record on the server.
+type DownloadStatus string
+
+const (
+	DownloadStatusNotStarted  DownloadStatus = "NOT_STARTED"
+	DownloadStatusQueued      DownloadStatus = "QUEUED"
+	DownloadStatusDownloading DownloadStatus = "DOWNLOADING"
+	DownloadStatusDownloaded  DownloadStatus = "DOWNLOADED"
+)
+
+// DownloadProgress is a progress event of an epub download.
+type DownloadProgress struct {
+	Status DownloadStatus `json:"status"`
+	// Position is the position in the download queue while QUEUED
+	Position       int     `json:"position,omitempty"`
+	BytesCompleted int64   `json:"bytes_completed"`
+	TotalBytes     int64   `json:"total_bytes"`
+	Percent        float64 `json:"percent"`
+}
+
+// PrefetchResult reports the records queued by PrefetchMany.
+type PrefetchResult struct {
+	Queued   []string `json:"queued"`
+	Rejected []struct {
+		ID      string `json:"id"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"rejected"`
+}
+
+// TypeCount is a number of identifiers or classifications of a type.
+type TypeCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// YearCount is a number of records published in a year, or in the bucket of years starting with it.
+type YearCount struct {
+	Year  int `json:"year"`
+	Count int `json:"count"`
+}
+
+// Statistics describes the content of the database.
+type Statistics struct {
+	LastSync        string      `json:"lastSync"`
+	Base            string      `json:"base"`
+	Count           int         `json:"count"`
+	Identifiers     []TypeCount `json:"identifiers"`
+	Classifications []TypeCount `json:"classifications"`
+}
+
+// DownloadStatistics describes the downloads of the last days.
+type DownloadStatistics struct {
+	// Since is the first day covered, as YYYY-MM-DD
+	Since string `json:"since"`
+	Total int64  `json:"total"`
+	Top   []struct {
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Author string `json:"author"`
+		Count  int64  `json:"count"`
+	} `json:"top"`
+	Days []struct {
+		Day   string `json:"day"`
+		Count int64  `json:"count"`
+	} `json:"days"`
+}
+
+// SyncStatistics is the progress of the running sync.
+type SyncStatistics struct {
+	IsRunning bool           `json:"isRunning"`
+	Base      string         `json:"base"`
+	Files     []FileProgress `json:"files"`
+	// Throughputs and ETA of the files being processed, together
+	RecordsPerSecond float64   `json:"recordsPerSecond"`
+	BytesPerSecond   float64   `json:"bytesPerSecond"`
+	ETA              time.Time `json:"eta,omitzero"`
+	// DryRun is the result of the last dry-run sync, if any
+	DryRun *DryRunReport `json:"dryRun,omitempty"`
+	// Written counts the rows written by the running sync: records by
+	// content type, identifiers and classifications by their type
+	Written *struct {
+		Records         map[string]int64 `json:"records"`
+		Identifiers     map[string]int64 `json:"identifiers"`
+		Classifications map[string]int64 `json:"classifications"`
+	} `json:"written,omitempty"`
+	// Swarm is the download of the metadata torrent, while it's downloaded
+	Swarm *SwarmProgress `json:"swarm,omitempty"`
+}
+
+// SwarmProgress is the download of the metadata torrent from its swarm.
+type SwarmProgress struct {
+	Peers   int `json:"peers"`
+	Seeders int `json:"seeders"`
+	// Remaining are the bytes left to download, downloaded at BytesPerSecond
+	// over the last minute
+	Remaining      int64   `json:"remaining"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	// ETA is the estimated end of the download, zero when unknown
+	ETA time.Time `json:"eta,omitzero"`
+}
+
+// SyncHistoryEntry is a past sync.
+type SyncHistoryEntry struct {
+	Date     time.Time `json:"date"`
+	Base     string    `json:"base"`
+	Complete bool      `json:"complete"`
+	// Shard is the part of the metadata files synced by a sharded worker,
+	// e.g. 1/4: the sync of the last shard completing the base is complete
+	Shard string `json:"shard,omitempty"`
+	// StartedAt is zero for the syncs skipped because the base was already synced
+	StartedAt time.Time `json:"startedAt"`
+	Files     int       `json:"files"`
+	Records   int       `json:"records"`
+	// ParseErrors are the lines of the metadata files that could not be parsed
+	ParseErrors int    `json:"parseErrors"`
+	Failed      int64  `json:"failed"`
+	Error       string `json:"error,omitempty"`
+	// Partial is set when some metadata files, FailedFiles, could not be processed
+	Partial     bool     `json:"partial,omitempty"`
+	FailedFiles []string `json:"failedFiles,omitempty"`
+	// Duration is in seconds
+	Duration int64 `json:"duration,omitempty"`
+	// RolledBack is set once the records were restored as they were before
+	// the sync
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// DryRunReport counts the records a dry-run sync would write.
+type DryRunReport struct {
+	Base      string    `json:"base"`
+	Running   bool      `json:"running"`
+	StartedAt time.Time `json:"startedAt"`
+	Files     []struct {
+		Name    string `json:"name"`
+		Inserts int64  `json:"inserts"`
+		Updates int64  `json:"updates"`
+		Skips   int64  `json:"skips"`
+	} `json:"files"`
+}
+
+// FileProgress is the progress of a metadata file, in percents.
+type FileProgress struct {
+	Name       string  `json:"name"`
+	Downloaded float64 `json:"downloaded"`
+	Processed  float64 `json:"processed"`
+	// Error tells why the file could not be processed, if it could not
+	Error string `json:"error,omitempty"`
+	// Records processed so far, and lines that could not be parsed
+	Records     int64 `json:"records"`
+	ParseErrors int64 `json:"parseErrors"`
+	// Throughputs over the last minute, bytes being compressed
+	RecordsPerSecond float64 `json:"recordsPerSecond"`
+	BytesPerSecond   float64 `json:"bytesPerSecond"`
+	// ETA is the estimated completion time, zero when unknown
+	ETA time.Time `json:"eta,omitzero"`
+	// Verified is set once the downloaded file passed its hash checks,
+	// CorruptPieces are the torrent pieces that failed them
+	Verified      bool `json:"verified,omitempty"`
+	CorruptPieces int  `json:"corruptPieces,omitempty"`
+}
+
+// EpubCache describes the files stored on the server.
+type EpubCache struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+	// MaxSize is 0 when the storage is unlimited
+	MaxSize   int64 `json:"max_size,omitempty"`
+	Evictions int64 `json:"evictions"`
+	Janitor   struct {
+		// Retention is empty when files are kept forever
+		Retention      string     `json:"retention,omitempty"`
+		LastCleanup    *time.Time `json:"last_cleanup,omitempty"`
+		NextCleanup    *time.Time `json:"next_cleanup,omitempty"`
+		RemovedFiles   int        `json:"removed_files"`
+		ReclaimedBytes int64      `json:"reclaimed_bytes"`
+	} `json:"janitor"`
+}
+
+// SeedingTorrent is a torrent seeded by the server after a download.
+type SeedingTorrent struct {
+	InfoHash string    `json:"info_hash"`
+	Name     string    `json:"name"`
+	Since    time.Time `json:"since"`
+	Uploaded int64     `json:"uploaded"`
+	Size     int64     `json:"size"`
+	Ratio    float64   `json:"ratio"`
+	Peers    int       `json:"peers"`
+}
+
+// RollbackResult reports the sync rolled back by RollbackSync.
+type RollbackResult struct {
+	// From is the base of the sync rolled back
+	From string `json:"from"`
+	// To is the base the records are back to
+	To string `json:"to"`
+	// Records is the number of records restored or deleted
+	Records int64 `json:"records"`
+}
+
+// PurgeResult reports the epubs removed by PurgeEpubs.
+type PurgeResult struct {
+	Files      int   `json:"files"`
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// Webhook events
+const (
+	EventSyncStarted       = "sync.started"
+	EventSyncCompleted     = "sync.completed"
+	EventPrefetchCompleted = "prefetch.completed"
+	EventDownloadFailed    = "download.failed"
+)
+
+// Webhook is an endpoint subscribed to API events. Secret is only returned on creation.
+type Webhook struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WebhookRequest creates or updates a webhook.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is generated by the server when empty
+	Secret string `json:"secret,omitempty"`
+	// Active defaults to true
+	Active *bool `json:"active,omitempty"`
+}
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchOptions holds the filters and pagination shared by searches, the zero
+// value uses the server defaults.
+type SearchOptions struct {
+	Languages []string
+	// LanguageMode is exact (default), any or all
+	LanguageMode        string
+	ContentTypes        []string
+	ExcludeContentTypes []string
+	// Formats are file extensions, e.g. epub or pdf
+	Formats             []string
+	Series              string
+	ClassificationType  string
+	ClassificationValue string
+	// AddedAfter and AddedBefore restrict the records to those added to
+	// Anna's Archive in between, when not zero
+	AddedAfter  time.Time
+	AddedBefore time.Time
+
+	Limit  int
+	Offset int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	// Total is exact (default), estimated or none
+	Total string
+	// Sort is id (default) or added, recently added first. Pages sorted by
+	// added date are fetched with Offset
+	Sort string
+	// Fields limits the returned record fields
+	Fields []string
+}
+
+func (o *SearchOptions) values() url.Values {
+	values := url.Values{}
+	if o == nil {
+		return values
+	}
+	set := func(key, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	set("languages", strings.Join(o.Languages, ","))
+	set("language_mode", o.LanguageMode)
+	set("content_type", strings.Join(o.ContentTypes, ","))
+	set("exclude_content_type", strings.Join(o.ExcludeContentTypes, ","))
+	set("format", strings.Join(o.Formats, ","))
+	set("series", o.Series)
+	set("classification_type", o.ClassificationType)
+	set("classification_value", o.ClassificationValue)
+	if !o.AddedAfter.IsZero() {
+		values.Set("added_after", o.AddedAfter.Format("2006-01-02"))
+	}
+	if !o.AddedBefore.IsZero() {
+		values.Set("added_before", o.AddedBefore.Format("2006-01-02"))
+	}
+	if o.Limit > 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		values.Set("offset", strconv.Itoa(o.Offset))
+	}
+	set("cursor", o.Cursor)
+	set("total", o.Total)
+	set("sort", o.Sort)
+	set("fields", strings.Join(o.Fields, ","))
+	return values
+}
+
+// Search finds records with a single query: ISBNs and identifiers (type:value)
+// are detected, anything else is matched against title and author.
+func (c *Client) Search(ctx context.Context, query string, options *SearchOptions) (*SearchResult, error) {
+	values := options.values()
+	values.Set("q", query)
+	result := &SearchResult{}
+	if err := c.do(ctx, http.MethodGet, "/v1/search", values, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SearchByISBN finds the records of ISBN10 or ISBN13 codes. With several ISBNs,
+// the result tells which records match each of them.
+func (c *Client) SearchByISBN(ctx context.Context, isbns []string, options *SearchOptions) (*SearchResult, error) {
+	values := options.values()
+	values.Set("isbn", strings.Join(isbns, ","))
+	result := &SearchResult{}
+	if err := c.do(ctx, http.MethodGet, "/v1/search/isbn", values, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// TextQuery holds the criteria of SearchByText, at least one must be set.
+type TextQuery struct {
+	Title       string
+	Author      string
+	Publisher   string
+	Description string
+	// Fuzzy tolerates typos, results are then ordered by similarity
+	Fuzzy bool
+	// Threshold is the minimum similarity of fuzzy matches, 0.3 when nil
+	Threshold *float64
+}
+
+// SearchByText finds records by title, author, publisher or description.
+func (c *Client) SearchByText(ctx context.Context, query TextQuery, options *SearchOptions) (*SearchResult, error) {
+	values := options.values()
+	for key, value := range map[string]string{
+		"title":       query.Title,
+		"author":      query.Author,
+		"publisher":   query.Publisher,
+		"description": query.Description,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	if query.Fuzzy {
+		values.Set("fuzzy", "true")
+	}
+	if query.Threshold != nil {
+		values.Set("threshold", strconv.FormatFloat(*query.Threshold, 'f', -1, 64))
+	}
+	result := &SearchResult{}
+	if err := c.do(ctx, http.MethodGet, "/v1/search/text", values, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Suggest returns title and author completions of a partial query.
+func (c *Client) Suggest(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	values := url.Values{"q": {query}}
+	if limit > 0 {
+		values.Set("limit", strconv.Itoa(limit))
+	}
+	var result struct {
+		Suggestions []Suggestion `json:"suggestions"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/search/suggest", values, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Suggestions, nil
+}
+
+// Series lists the records of a series, ordered by their position in it.
+// Cursor and Total options are ignored.
+func (c *Client) Series(ctx context.Context, name string, options *SearchOptions) (*SearchResult, error) {
+	result := &SearchResult{}
+	if err := c.do(ctx, http.MethodGet, "/v1/series/"+url.PathEscape(name), options.values(), nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetRecord returns a record with its identifiers and classifications.
+func (c *Client) GetRecord(ctx context.Context, id string) (*Record, error) {
+	record := &Record{}
+	if err := c.do(ctx, http.MethodGet, recordPath(id, ""), nil, nil, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Export formats
+const (
+	FormatBibTeX  = "bibtex"
+	FormatRIS     = "ris"
+	FormatMARCXML = "marcxml"
+	FormatONIX    = "onix"
+	FormatJSONLD  = "jsonld"
+)
+
+// ExportRecord returns a record in one of the export formats.
+func (c *Client) ExportRecord(ctx context.Context, id, format string) ([]byte, error) {
+	return c.raw(ctx, http.MethodGet, recordPath(id, "/export"), url.Values{"format": {format}}, nil)
+}
+
+// ExportRecords returns several records in a single document of one of the
+// export formats, unknown IDs are skipped.
+func (c *Client) ExportRecords(ctx context.Context, ids []string, format string) ([]byte, error) {
+	body := map[string]any{"ids": ids, "format": format}
+	return c.raw(ctx, http.MethodPost, "/v1/records/export", nil, body)
+}
+
+// Cover returns the cover of a record as a JPEG, scaled down to width pixels
+// (160, 320 or 640, 0 keeps the original size).
+func (c *Client) Cover(ctx context.Context, id string, width int) ([]byte, error) {
+	return c.raw(ctx, http.MethodGet, recordPath(id, "/cover"), url.Values{"width": {strconv.Itoa(width)}}, nil)
+}
+
+// Changes calls fn with every record created, updated or deleted after since,
+// ordered by update time. It stops at the first error returned by fn.
+func (c *Client) Changes(ctx context.Context, since time.Time, fn func(RecordChange) error) error {
+	resp, err := c.request(ctx, http.MethodGet, "/v1/records/changes", url.Values{"since": {since.Format(time.RFC3339Nano)}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var change RecordChange
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			return fmt.Errorf("failed to decode change: %w", err)
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
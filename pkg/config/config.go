@@ -0,0 +1,788 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the whole configuration of the API. It is read from the YAML file
+// at ANNA_CONFIG_FILE, if any, then each value can be overridden by the
+// environment variable in its env tag.
+type Config struct {
+	LogLevel string `yaml:"log_level" env:"LOG_LEVEL"`
+	// Role is what the process runs: RoleAll, RoleAPI or RoleSync. The
+	// --role flag overrides it.
+	Role      string    `yaml:"role" env:"ANNA_ROLE"`
+	Postgres  Postgres  `yaml:"postgres"`
+	API       API       `yaml:"api"`
+	TLS       TLS       `yaml:"tls"`
+	Anna      Anna      `yaml:"anna"`
+	Auth      Auth      `yaml:"auth"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Quota     Quota     `yaml:"quota"`
+	Notify    Notify    `yaml:"notify"`
+	Export    Export    `yaml:"export"`
+}
+
+// Roles of a process: API replicas serve the requests and report the progress
+// of the sync worker, which runs the syncs, sharing the database.
+const (
+	RoleAll  = "all"
+	RoleAPI  = "api"
+	RoleSync = "sync"
+)
+
+// RunsAPI returns whether the process serves the API, over HTTP and gRPC, and
+// runs its background work: resumed downloads, cache warming and seeding.
+// Sync workers only serve the health checks and the admin operations.
+func (c *Config) RunsAPI() bool {
+	return c.Role != RoleSync
+}
+
+// RunsSync returns whether the process runs the syncs.
+func (c *Config) RunsSync() bool {
+	return c.Role != RoleAPI
+}
+
+// DownloadsTorrents returns whether the process downloads from torrents: the
+// files served by the API, or the metadata torrent synced when neither
+// ANNA_SYNC_DUMP_DIR nor ANNA_SYNC_METADATA_URL is set.
+func (c *Config) DownloadsTorrents() bool {
+	return c.RunsAPI() || c.Anna.SyncDumpDir == "" && c.Anna.SyncMetadataURL == ""
+}
+
+type Postgres struct {
+	Host     string `yaml:"host" env:"POSTGRES_HOST"`
+	Port     string `yaml:"port" env:"POSTGRES_PORT"`
+	User     string `yaml:"user" env:"POSTGRES_USER"`
+	Password string `yaml:"password" env:"POSTGRES_PASSWORD"`
+	Database string `yaml:"database" env:"POSTGRES_DATABASE"`
+}
+
+type API struct {
+	// Port defaults to 80, or 443 when TLS is enabled
+	Port            string        `yaml:"port" env:"API_PORT"`
+	Host            string        `yaml:"host" env:"API_HOST"`
+	GRPCPort        string        `yaml:"grpc_port" env:"API_GRPC_PORT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"API_SHUTDOWN_TIMEOUT"`
+	// AccelRedirect is the nginx internal location serving the storage
+	// directory, stored files are then sent by nginx through X-Accel-Redirect
+	AccelRedirect string `yaml:"accel_redirect" env:"API_ACCEL_REDIRECT"`
+}
+
+type TLS struct {
+	CertFile     string   `yaml:"cert_file" env:"API_TLS_CERT_FILE"`
+	KeyFile      string   `yaml:"key_file" env:"API_TLS_KEY_FILE"`
+	ACMEDomains  []string `yaml:"acme_domains" env:"API_ACME_DOMAINS"`
+	ACMEEmail    string   `yaml:"acme_email" env:"API_ACME_EMAIL"`
+	ACMECacheDir string   `yaml:"acme_cache_dir" env:"API_ACME_CACHE_DIR"`
+	ACMEHTTPPort string   `yaml:"acme_http_port" env:"API_ACME_HTTP_PORT"`
+}
+
+// Enabled returns whether the API is served over HTTPS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || len(t.ACMEDomains) > 0
+}
+
+type Anna struct {
+	Domain string `yaml:"domain" env:"ANNA_DOMAIN"`
+	// ArchiveID restricts the sync to the aarecords__N metadata files whose
+	// N is in the ranges (e.g. 0-5,12), so that workers can each sync their
+	// own files
+	ArchiveID       Ranges `yaml:"archive_id" env:"ANNA_ARCHIVE_ID"`
+	DisableSync     bool   `yaml:"disable_sync" env:"ANNA_DISABLE_SYNC"`
+	KeepFiles       bool   `yaml:"keep_files" env:"ANNA_KEEP_FILES"`
+	TorrentDataDir  string `yaml:"torrent_data_dir" env:"ANNA_TORRENT_DATA_DIR"`
+	TorrentPort     int    `yaml:"torrent_port" env:"ANNA_TORRENT_PORT"`
+	EpubStorageDir  string `yaml:"epub_storage_dir" env:"ANNA_EPUB_STORAGE_DIR"`
+	CoverStorageDir string `yaml:"cover_storage_dir" env:"ANNA_COVER_STORAGE_DIR"`
+	// EpubCacheMaxSize caps the storage directory, 0 means unlimited
+	EpubCacheMaxSize ByteSize `yaml:"epub_cache_max_size" env:"ANNA_EPUB_CACHE_MAX_SIZE"`
+	// EpubRetention removes files downloaded longer ago, 0 keeps them forever
+	EpubRetention       time.Duration `yaml:"epub_retention" env:"ANNA_EPUB_RETENTION"`
+	EpubCleanupInterval time.Duration `yaml:"epub_cleanup_interval" env:"ANNA_EPUB_CLEANUP_INTERVAL"`
+	// MaxActiveDownloads is the number of files downloaded at once, the others are queued. 0 means unlimited
+	MaxActiveDownloads int `yaml:"max_active_downloads" env:"ANNA_MAX_ACTIVE_DOWNLOADS"`
+	// TorrentStallTimeout is how long a torrent may make no progress before
+	// falling back to the mirrors, 0 waits forever
+	TorrentStallTimeout time.Duration `yaml:"torrent_stall_timeout" env:"ANNA_TORRENT_STALL_TIMEOUT"`
+	// Mirrors are URL templates of HTTP mirrors of the files, e.g.
+	// https://ipfs.io/ipfs/{ipfs_cid}, see anna.MirrorURLs
+	Mirrors []string `yaml:"mirrors" env:"ANNA_MIRRORS"`
+	// TorrentDisableDHT and TorrentDisablePEX turn off peer discovery through
+	// the DHT and peer exchange, leaving the trackers only
+	TorrentDisableDHT bool `yaml:"torrent_disable_dht" env:"ANNA_TORRENT_DISABLE_DHT"`
+	TorrentDisablePEX bool `yaml:"torrent_disable_pex" env:"ANNA_TORRENT_DISABLE_PEX"`
+	// TorrentUploadRate caps the upload bandwidth per second, 0 means unlimited
+	TorrentUploadRate ByteSize `yaml:"torrent_upload_rate" env:"ANNA_TORRENT_UPLOAD_RATE"`
+	// TorrentMaxConnections is the number of peers connected per torrent, 0 keeps the client default
+	TorrentMaxConnections int `yaml:"torrent_max_connections" env:"ANNA_TORRENT_MAX_CONNECTIONS"`
+	// TorrentTrackers are announce URLs added to every torrent
+	TorrentTrackers []string `yaml:"torrent_trackers" env:"ANNA_TORRENT_TRACKERS"`
+	// MetadataWebseeds and TorrentWebseeds are HTTP seeds (BEP 19) added to
+	// the metadata torrents and to the torrents of the files
+	MetadataWebseeds []string `yaml:"metadata_webseeds" env:"ANNA_METADATA_WEBSEEDS"`
+	TorrentWebseeds  []string `yaml:"torrent_webseeds" env:"ANNA_TORRENT_WEBSEEDS"`
+	// Seed keeps the torrents of downloaded files in the swarm until
+	// SeedRatio or SeedTime is reached, 0 meaning no limit for either
+	Seed      bool          `yaml:"seed" env:"ANNA_SEED"`
+	SeedRatio float64       `yaml:"seed_ratio" env:"ANNA_SEED_RATIO"`
+	SeedTime  time.Duration `yaml:"seed_time" env:"ANNA_SEED_TIME"`
+	// WarmCacheTop is the number of most downloaded records of the last
+	// WarmCacheDays prefetched every day at WarmCacheHour (UTC), 0 disables it
+	WarmCacheTop  int `yaml:"warm_cache_top" env:"ANNA_WARM_CACHE_TOP"`
+	WarmCacheDays int `yaml:"warm_cache_days" env:"ANNA_WARM_CACHE_DAYS"`
+	WarmCacheHour int `yaml:"warm_cache_hour" env:"ANNA_WARM_CACHE_HOUR"`
+	// TorrentMetadataTimeout is how long to wait for the info of a torrent, 0 waits forever
+	TorrentMetadataTimeout time.Duration `yaml:"torrent_metadata_timeout" env:"ANNA_TORRENT_METADATA_TIMEOUT"`
+	// TorrentRetries is the number of extra attempts at a torrent that timed out
+	TorrentRetries int `yaml:"torrent_retries" env:"ANNA_TORRENT_RETRIES"`
+	// DownloadTimeout bounds the download of a file from all its sources, 0 means no limit
+	DownloadTimeout time.Duration `yaml:"download_timeout" env:"ANNA_DOWNLOAD_TIMEOUT"`
+	// DownloadRate caps the bandwidth per second of all the file downloads
+	// from torrents, and DownloadRatePerFile the one of each download. The
+	// metadata sync is not limited, 0 means unlimited
+	DownloadRate        ByteSize `yaml:"download_rate" env:"ANNA_DOWNLOAD_RATE"`
+	DownloadRatePerFile ByteSize `yaml:"download_rate_per_file" env:"ANNA_DOWNLOAD_RATE_PER_FILE"`
+	// StorageKey is a base64 AES key (16, 24 or 32 bytes) encrypting the
+	// files written to EpubStorageDir, e.g. provided by a KMS. The torrent
+	// data of a download is deleted from TorrentDataDir once its torrent is
+	// dropped, so plaintext copies remain there only while it is seeded
+	StorageKey string `yaml:"storage_key" env:"ANNA_STORAGE_KEY"`
+	// SyncCopy writes the synced records by batches of SyncBatchSize with
+	// COPY instead of upserting them one by one
+	SyncCopy      bool `yaml:"sync_copy" env:"ANNA_SYNC_COPY"`
+	SyncBatchSize int  `yaml:"sync_batch_size" env:"ANNA_SYNC_BATCH_SIZE"`
+	// SyncWriters is the number of goroutines writing the synced records,
+	// fed by a queue of SyncQueueSize records
+	SyncWriters   int `yaml:"sync_writers" env:"ANNA_SYNC_WRITERS"`
+	SyncQueueSize int `yaml:"sync_queue_size" env:"ANNA_SYNC_QUEUE_SIZE"`
+	// SyncFiles restricts the sync to the aarecords__N metadata files whose
+	// N is in the ranges, when set, and SyncExcludeFiles skips some of them
+	SyncFiles        Ranges `yaml:"sync_files" env:"ANNA_SYNC_FILES"`
+	SyncExcludeFiles Ranges `yaml:"sync_exclude_files" env:"ANNA_SYNC_EXCLUDE_FILES"`
+	// SyncShard splits the syncs between workers sharing the database, each
+	// syncing every n-th metadata file (e.g. 0/4 to 3/4)
+	SyncShard Shard `yaml:"sync_shard" env:"ANNA_SYNC_SHARD"`
+	// SyncLanguages restricts the synced records to those in one of these
+	// language codes (e.g. fr, en), when set
+	SyncLanguages []string `yaml:"sync_languages" env:"ANNA_SYNC_LANGUAGES"`
+	// SyncFormats are the file extensions of the synced records, the ebook
+	// formats by default
+	SyncFormats []string `yaml:"sync_formats" env:"ANNA_SYNC_FORMATS"`
+	// SyncContentTypes are content types (e.g. audiobook, book_comic,
+	// magazine) whose records are synced whatever their file extension
+	SyncContentTypes []string `yaml:"sync_content_types" env:"ANNA_SYNC_CONTENT_TYPES"`
+	// SyncCollections restricts the synced files to those of these
+	// collections (e.g. zlib, libgen_li, libgen_rs, ia), found in the torrent
+	// paths of the records, when set, and SyncExcludeCollections skips some
+	SyncCollections        []string `yaml:"sync_collections" env:"ANNA_SYNC_COLLECTIONS"`
+	SyncExcludeCollections []string `yaml:"sync_exclude_collections" env:"ANNA_SYNC_EXCLUDE_COLLECTIONS"`
+	// SyncPrune deletes the records absent from the base after a complete
+	// sync of all the metadata files
+	SyncPrune bool `yaml:"sync_prune" env:"ANNA_SYNC_PRUNE"`
+	// SyncLeaderElection elects one instance sharing the database to run the
+	// syncs, through a PostgreSQL advisory lock. The others report its progress.
+	SyncLeaderElection bool `yaml:"sync_leader_election" env:"ANNA_SYNC_LEADER_ELECTION"`
+	// SyncDumpDir is a directory holding the aarecords__N.json.gz metadata
+	// files, synced instead of the metadata torrent when set
+	SyncDumpDir string `yaml:"sync_dump_dir" env:"ANNA_SYNC_DUMP_DIR"`
+	// SyncMetadataURL is the URL of a sha256sum checksum file listing the
+	// metadata files of a base on an HTTP mirror, next to it. The files are
+	// downloaded from there instead of the metadata torrent when set
+	SyncMetadataURL string `yaml:"sync_metadata_url" env:"ANNA_SYNC_METADATA_URL"`
+	// SyncMemoryLimit is the heap size above which the sync pauses parsing
+	// and reads ahead less until memory is freed, 0 means unlimited
+	SyncMemoryLimit ByteSize `yaml:"sync_memory_limit" env:"ANNA_SYNC_MEMORY_LIMIT"`
+	// SyncRetainPrevious keeps the previous version of the records changed
+	// or pruned by the last sync, so that it can be rolled back
+	SyncRetainPrevious bool `yaml:"sync_retain_previous" env:"ANNA_SYNC_RETAIN_PREVIOUS"`
+	// SyncStaging writes the syncs to a copy of the record tables, swapped
+	// with them once the sync completes: searches never see a sync half done
+	SyncStaging bool `yaml:"sync_staging" env:"ANNA_SYNC_STAGING"`
+}
+
+// Ranges is a list of numbers and ranges of numbers, written as
+// comma-separated values (e.g. 3,7,10-12).
+type Ranges [][2]int
+
+// ParseRanges parses ranges such as 3,7,10-12.
+func ParseRanges(raw string) (Ranges, error) {
+	var ranges Ranges
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(value, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		end := start
+		if err == nil && isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+		if err != nil || start < 0 || end < start {
+			return nil, fmt.Errorf("invalid range %q", value)
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+// Contains returns whether n is in one of the ranges.
+func (r Ranges) Contains(n int) bool {
+	for _, bounds := range r {
+		if n >= bounds[0] && n <= bounds[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Ranges) UnmarshalYAML(node *yaml.Node) error {
+	raw := node.Value
+	if node.Kind == yaml.SequenceNode {
+		values := make([]string, len(node.Content))
+		for i, value := range node.Content {
+			values[i] = value.Value
+		}
+		raw = strings.Join(values, ",")
+	}
+	ranges, err := ParseRanges(raw)
+	if err != nil {
+		return err
+	}
+	*r = ranges
+	return nil
+}
+
+// Shard is the part of the metadata files synced by a worker among Count,
+// written as i/n: the files whose number modulo n is i. The zero Shard syncs
+// every file.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// ParseShard parses a shard such as 1/4.
+func ParseShard(raw string) (Shard, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Shard{}, nil
+	}
+	index, count, found := strings.Cut(raw, "/")
+	i, err := strconv.Atoi(strings.TrimSpace(index))
+	n, errCount := strconv.Atoi(strings.TrimSpace(count))
+	if !found || err != nil || errCount != nil || n < 1 || i < 0 || i >= n {
+		return Shard{}, fmt.Errorf("invalid shard %q, expected i/n with 0 <= i < n", raw)
+	}
+	return Shard{Index: i, Count: n}, nil
+}
+
+// Enabled returns whether the syncs are sharded.
+func (s Shard) Enabled() bool {
+	return s.Count > 0
+}
+
+// Contains returns whether the metadata file n is in the shard.
+func (s Shard) Contains(n int) bool {
+	return !s.Enabled() || n%s.Count == s.Index
+}
+
+// String returns the shard as i/n, or an empty string when not sharded.
+func (s Shard) String() string {
+	if !s.Enabled() {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+func (s *Shard) UnmarshalYAML(node *yaml.Node) error {
+	shard, err := ParseShard(node.Value)
+	if err != nil {
+		return err
+	}
+	*s = shard
+	return nil
+}
+
+// ByteSize is a number of bytes, written as a number with an optional unit
+// (e.g. 512MB, 10GiB).
+type ByteSize int64
+
+var byteUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// ParseByteSize parses a size such as 1024, 512MB or 10GiB.
+func ParseByteSize(raw string) (ByteSize, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+	i := strings.IndexFunc(raw, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(raw)
+	}
+	n, err := strconv.ParseFloat(raw[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(raw[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", raw)
+	}
+	return ByteSize(n * float64(unit)), nil
+}
+
+func (s *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	size, err := ParseByteSize(node.Value)
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+type Auth struct {
+	JWTSecret           string        `yaml:"jwt_secret" env:"ANNA_JWT_SECRET"`
+	JWTIssuer           string        `yaml:"jwt_issuer" env:"ANNA_JWT_ISSUER"`
+	JWTAudience         string        `yaml:"jwt_audience" env:"ANNA_JWT_AUDIENCE"`
+	JWKSURL             string        `yaml:"jwks_url" env:"ANNA_JWKS_URL"`
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval" env:"ANNA_JWKS_REFRESH_INTERVAL"`
+}
+
+// Notify holds the sinks notified when a sync completes, partially or not,
+// or fails.
+type Notify struct {
+	// WebhookURL receives the result of the sync as JSON
+	WebhookURL string `yaml:"webhook_url" env:"ANNA_NOTIFY_WEBHOOK_URL"`
+	// SlackURL is a Slack incoming webhook
+	SlackURL string `yaml:"slack_url" env:"ANNA_NOTIFY_SLACK_URL"`
+	// Email are the addresses mailed through the SMTP server
+	Email        []string `yaml:"email" env:"ANNA_NOTIFY_EMAIL"`
+	SMTPAddr     string   `yaml:"smtp_addr" env:"ANNA_SMTP_ADDR"` // host:port
+	SMTPUser     string   `yaml:"smtp_user" env:"ANNA_SMTP_USER"`
+	SMTPPassword string   `yaml:"smtp_password" env:"ANNA_SMTP_PASSWORD"`
+	SMTPFrom     string   `yaml:"smtp_from" env:"ANNA_SMTP_FROM"`
+}
+
+// Export holds the sink the records of each sync are written to, for
+// analytics systems.
+type Export struct {
+	// Path is a directory or an s3://bucket/prefix URL, the records are not
+	// exported when empty
+	Path string `yaml:"path" env:"ANNA_EXPORT_PATH"`
+	// Format is ndjson (gzipped) or parquet
+	Format string `yaml:"format" env:"ANNA_EXPORT_FORMAT"`
+	// S3Endpoint is the URL of the S3 compatible store, and the credentials
+	// default to the AWS environment variables and instance role
+	S3Endpoint  string `yaml:"s3_endpoint" env:"ANNA_EXPORT_S3_ENDPOINT"`
+	S3Region    string `yaml:"s3_region" env:"ANNA_EXPORT_S3_REGION"`
+	S3AccessKey string `yaml:"s3_access_key" env:"ANNA_EXPORT_S3_ACCESS_KEY"`
+	S3SecretKey string `yaml:"s3_secret_key" env:"ANNA_EXPORT_S3_SECRET_KEY"`
+}
+
+// RateLimit holds requests per second and bursts, a zero rate disables the limit.
+type RateLimit struct {
+	PerSecond         float64 `yaml:"per_second" env:"ANNA_RATE_LIMIT"`
+	Burst             int     `yaml:"burst" env:"ANNA_RATE_LIMIT_BURST"`
+	DownloadPerSecond float64 `yaml:"download_per_second" env:"ANNA_DOWNLOAD_RATE_LIMIT"`
+	DownloadBurst     int     `yaml:"download_burst" env:"ANNA_DOWNLOAD_RATE_LIMIT_BURST"`
+	// DownloadConcurrency is the number of downloads a JWT subject can run at once, 0 means unlimited
+	DownloadConcurrency int `yaml:"download_concurrency" env:"ANNA_DOWNLOAD_CONCURRENCY"`
+}
+
+// Quota holds the number of epubs a tenant can download per day, 0 means unlimited.
+type Quota struct {
+	Daily int `yaml:"daily" env:"ANNA_TENANT_DAILY_QUOTA"`
+	// Tenants overrides Daily per tenant, e.g. "acme=500,demo=10" in the environment
+	Tenants map[string]int `yaml:"tenants" env:"ANNA_TENANT_QUOTAS"`
+}
+
+// C is the configuration of the running process.
+var C *Config
+
+func init() {
+	path := os.Getenv("ANNA_CONFIG_FILE")
+	var err error
+	C, err = Load(path)
+	if err != nil {
+		panic(err)
+	}
+	if path != "" {
+		slog.Info("Loaded configuration file", "path", path)
+	}
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() *Config {
+	return &Config{
+		Role:     RoleAll,
+		Postgres: Postgres{Port: "5432"},
+		API:      API{ShutdownTimeout: 30 * time.Second},
+		TLS: TLS{
+			ACMECacheDir: "/tmp/anna-acme",
+			ACMEHTTPPort: "80",
+		},
+		Anna: Anna{
+			TorrentDataDir:         "/tmp/anna-torrents",
+			TorrentPort:            42069,
+			EpubStorageDir:         "/tmp/anna-epubs",
+			CoverStorageDir:        "/tmp/anna-covers",
+			EpubCleanupInterval:    time.Hour,
+			MaxActiveDownloads:     4,
+			TorrentStallTimeout:    5 * time.Minute,
+			Mirrors:                []string{"https://ipfs.io/ipfs/{ipfs_cid}?filename={filename}"},
+			WarmCacheDays:          30,
+			WarmCacheHour:          3,
+			TorrentMetadataTimeout: 2 * time.Minute,
+			TorrentRetries:         1,
+			SyncBatchSize:          5000,
+			SyncWriters:            4,
+			SyncQueueSize:          10000,
+		},
+		Auth:   Auth{JWKSRefreshInterval: time.Hour},
+		Export: Export{Format: "ndjson", S3Endpoint: "https://s3.amazonaws.com"},
+	}
+}
+
+// Load returns the default configuration overridden by the YAML file at path,
+// when not empty, then by the environment.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read configuration file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(c).Elem(), os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// applyEnv sets the fields of v having an env tag from the variables found by lookup.
+func applyEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		// Sections are structs, values such as Shard have their own variable
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) && field.Tag.Get("env") == "" {
+			errs = append(errs, applyEnv(value, lookup))
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		raw, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := setValue(value, strings.TrimSpace(raw)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %w", key, raw, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setValue(v reflect.Value, raw string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(raw)
+	case bool:
+		// Unset-like values are false, as when the variables were compared to "true"
+		b, err := strconv.ParseBool(raw)
+		if err != nil && raw != "" {
+			return err
+		}
+		v.SetBool(b)
+	case int:
+		if raw == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case float64:
+		if raw == "" {
+			v.SetFloat(0)
+			return nil
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case ByteSize:
+		size, err := ParseByteSize(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(size))
+	case Ranges:
+		ranges, err := ParseRanges(raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(ranges))
+	case Shard:
+		shard, err := ParseShard(raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(shard))
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+	case map[string]int:
+		values := map[string]int{}
+		for _, entry := range strings.Split(raw, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			key, value, found := strings.Cut(entry, "=")
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if !found || err != nil {
+				return fmt.Errorf("entry %q is not key=number", entry)
+			}
+			values[strings.TrimSpace(key)] = n
+		}
+		v.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// Validate checks that required values are set and that values are consistent,
+// reporting every problem along with the variable fixing it.
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(value, name, key string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required (%s)", name, key))
+		}
+	}
+	required(c.Postgres.Host, "postgres.host", "POSTGRES_HOST")
+	required(c.Postgres.User, "postgres.user", "POSTGRES_USER")
+	required(c.Postgres.Database, "postgres.database", "POSTGRES_DATABASE")
+	required(c.Anna.Domain, "anna.domain", "ANNA_DOMAIN")
+
+	switch strings.ToLower(c.LogLevel) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log_level must be debug, info, warn or error (LOG_LEVEL), got %q", c.LogLevel))
+	}
+	switch c.Role {
+	case RoleAll, RoleAPI, RoleSync:
+	default:
+		errs = append(errs, fmt.Errorf("role must be all, api or sync (ANNA_ROLE), got %q", c.Role))
+	}
+	if c.API.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("api.shutdown_timeout must be positive (API_SHUTDOWN_TIMEOUT)"))
+	}
+	if c.API.AccelRedirect != "" && !strings.HasPrefix(c.API.AccelRedirect, "/") {
+		errs = append(errs, fmt.Errorf("api.accel_redirect must be a location starting with / (API_ACCEL_REDIRECT)"))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls.cert_file and tls.key_file must be set together (API_TLS_CERT_FILE, API_TLS_KEY_FILE)"))
+	}
+	if c.TLS.CertFile != "" && len(c.TLS.ACMEDomains) > 0 {
+		errs = append(errs, fmt.Errorf("tls.cert_file and tls.acme_domains are mutually exclusive (API_TLS_CERT_FILE, API_ACME_DOMAINS)"))
+	}
+	if c.Auth.JWKSURL != "" && c.Auth.JWKSRefreshInterval <= 0 {
+		errs = append(errs, fmt.Errorf("auth.jwks_refresh_interval must be positive (ANNA_JWKS_REFRESH_INTERVAL)"))
+	}
+	if c.RateLimit.PerSecond < 0 || c.RateLimit.DownloadPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("rate limits cannot be negative (ANNA_RATE_LIMIT, ANNA_DOWNLOAD_RATE_LIMIT)"))
+	}
+	if c.Anna.TorrentPort < 0 || c.Anna.TorrentPort > 65535 {
+		errs = append(errs, fmt.Errorf("anna.torrent_port must be a port number (ANNA_TORRENT_PORT)"))
+	}
+	if c.Anna.TorrentUploadRate < 0 || c.Anna.TorrentMaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("torrent limits cannot be negative (ANNA_TORRENT_UPLOAD_RATE, ANNA_TORRENT_MAX_CONNECTIONS)"))
+	}
+	if c.Anna.StorageKey != "" {
+		key, err := base64.StdEncoding.DecodeString(c.Anna.StorageKey)
+		if err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+			errs = append(errs, fmt.Errorf("anna.storage_key must be a base64 encoded 16, 24 or 32 bytes key (ANNA_STORAGE_KEY)"))
+		}
+		if c.API.AccelRedirect != "" {
+			errs = append(errs, fmt.Errorf("api.accel_redirect cannot serve encrypted files (API_ACCEL_REDIRECT, ANNA_STORAGE_KEY)"))
+		}
+	}
+	if c.Anna.DownloadRate < 0 || c.Anna.DownloadRatePerFile < 0 {
+		errs = append(errs, fmt.Errorf("download rates cannot be negative (ANNA_DOWNLOAD_RATE, ANNA_DOWNLOAD_RATE_PER_FILE)"))
+	}
+	if c.Anna.SeedRatio < 0 || c.Anna.SeedTime < 0 {
+		errs = append(errs, fmt.Errorf("seeding limits cannot be negative (ANNA_SEED_RATIO, ANNA_SEED_TIME)"))
+	}
+	if c.Anna.WarmCacheTop < 0 {
+		errs = append(errs, fmt.Errorf("anna.warm_cache_top cannot be negative (ANNA_WARM_CACHE_TOP)"))
+	}
+	if c.Anna.WarmCacheTop > 0 && (c.Anna.WarmCacheDays < 1 || c.Anna.WarmCacheDays > 365) {
+		errs = append(errs, fmt.Errorf("anna.warm_cache_days must be between 1 and 365 (ANNA_WARM_CACHE_DAYS)"))
+	}
+	if c.Anna.WarmCacheHour < 0 || c.Anna.WarmCacheHour > 23 {
+		errs = append(errs, fmt.Errorf("anna.warm_cache_hour must be between 0 and 23 (ANNA_WARM_CACHE_HOUR)"))
+	}
+	if c.Anna.SyncMemoryLimit < 0 {
+		errs = append(errs, fmt.Errorf("anna.sync_memory_limit cannot be negative (ANNA_SYNC_MEMORY_LIMIT)"))
+	}
+	if c.Anna.EpubCacheMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("anna.epub_cache_max_size cannot be negative (ANNA_EPUB_CACHE_MAX_SIZE)"))
+	}
+	if c.Anna.EpubRetention < 0 {
+		errs = append(errs, fmt.Errorf("anna.epub_retention cannot be negative (ANNA_EPUB_RETENTION)"))
+	}
+	if c.Anna.EpubRetention > 0 && c.Anna.EpubCleanupInterval <= 0 {
+		errs = append(errs, fmt.Errorf("anna.epub_cleanup_interval must be positive (ANNA_EPUB_CLEANUP_INTERVAL)"))
+	}
+	if c.Anna.MaxActiveDownloads < 0 {
+		errs = append(errs, fmt.Errorf("anna.max_active_downloads cannot be negative (ANNA_MAX_ACTIVE_DOWNLOADS)"))
+	}
+	if c.Anna.TorrentStallTimeout < 0 {
+		errs = append(errs, fmt.Errorf("anna.torrent_stall_timeout cannot be negative (ANNA_TORRENT_STALL_TIMEOUT)"))
+	}
+	if c.Anna.TorrentMetadataTimeout < 0 {
+		errs = append(errs, fmt.Errorf("anna.torrent_metadata_timeout cannot be negative (ANNA_TORRENT_METADATA_TIMEOUT)"))
+	}
+	if c.Anna.TorrentRetries < 0 || c.Anna.TorrentRetries > 10 {
+		errs = append(errs, fmt.Errorf("anna.torrent_retries must be between 0 and 10 (ANNA_TORRENT_RETRIES)"))
+	}
+	if c.Anna.DownloadTimeout < 0 {
+		errs = append(errs, fmt.Errorf("anna.download_timeout cannot be negative (ANNA_DOWNLOAD_TIMEOUT)"))
+	}
+	if c.Anna.SyncCopy && c.Anna.SyncBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("anna.sync_batch_size must be positive (ANNA_SYNC_BATCH_SIZE)"))
+	}
+	if c.Anna.SyncWriters < 1 || c.Anna.SyncWriters > 64 {
+		errs = append(errs, fmt.Errorf("anna.sync_writers must be between 1 and 64 (ANNA_SYNC_WRITERS)"))
+	}
+	if c.Anna.SyncQueueSize < 0 {
+		errs = append(errs, fmt.Errorf("anna.sync_queue_size cannot be negative (ANNA_SYNC_QUEUE_SIZE)"))
+	}
+	for _, format := range c.Anna.SyncFormats {
+		if format == "" || strings.IndexFunc(format, func(r rune) bool { return (r < 'a' || r > 'z') && (r < '0' || r > '9') }) >= 0 {
+			errs = append(errs, fmt.Errorf("anna.sync_formats must be lowercase file extensions, got %q (ANNA_SYNC_FORMATS)", format))
+		}
+	}
+	for _, contentType := range c.Anna.SyncContentTypes {
+		if contentType == "" || strings.IndexFunc(contentType, func(r rune) bool { return (r < 'a' || r > 'z') && r != '_' }) >= 0 {
+			errs = append(errs, fmt.Errorf("anna.sync_content_types must be content types such as audiobook, got %q (ANNA_SYNC_CONTENT_TYPES)", contentType))
+		}
+	}
+	for _, collections := range []struct {
+		values   []string
+		key, env string
+	}{
+		{c.Anna.SyncCollections, "sync_collections", "ANNA_SYNC_COLLECTIONS"},
+		{c.Anna.SyncExcludeCollections, "sync_exclude_collections", "ANNA_SYNC_EXCLUDE_COLLECTIONS"},
+	} {
+		for _, collection := range collections.values {
+			if collection == "" || strings.IndexFunc(collection, func(r rune) bool { return (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' && r != '.' }) >= 0 {
+				errs = append(errs, fmt.Errorf("anna.%s must be collections such as zlib or libgen_li, got %q (%s)", collections.key, collection, collections.env))
+			}
+		}
+	}
+	if c.Anna.SyncPrune && (len(c.Anna.ArchiveID) > 0 || len(c.Anna.SyncFiles) > 0 || len(c.Anna.SyncExcludeFiles) > 0) {
+		errs = append(errs, fmt.Errorf("anna.sync_prune needs every metadata file to be synced (ANNA_SYNC_PRUNE, ANNA_ARCHIVE_ID, ANNA_SYNC_FILES, ANNA_SYNC_EXCLUDE_FILES)"))
+	}
+	if c.Anna.SyncShard.Enabled() && c.Anna.SyncStaging {
+		errs = append(errs, fmt.Errorf("anna.sync_shard and anna.sync_staging are mutually exclusive, the shards write to the same tables (ANNA_SYNC_SHARD, ANNA_SYNC_STAGING)"))
+	}
+	if c.Anna.SyncMetadataURL != "" && !isHTTPURL(c.Anna.SyncMetadataURL) {
+		errs = append(errs, fmt.Errorf("anna.sync_metadata_url must be an HTTP URL (ANNA_SYNC_METADATA_URL)"))
+	}
+	if c.Anna.SyncMetadataURL != "" && c.Anna.SyncDumpDir != "" {
+		errs = append(errs, fmt.Errorf("anna.sync_metadata_url and anna.sync_dump_dir are mutually exclusive (ANNA_SYNC_METADATA_URL, ANNA_SYNC_DUMP_DIR)"))
+	}
+	for _, sink := range []struct{ url, key string }{
+		{c.Notify.WebhookURL, "ANNA_NOTIFY_WEBHOOK_URL"},
+		{c.Notify.SlackURL, "ANNA_NOTIFY_SLACK_URL"},
+	} {
+		if sink.url != "" && !isHTTPURL(sink.url) {
+			errs = append(errs, fmt.Errorf("notification URLs must be HTTP URLs, got %q (%s)", sink.url, sink.key))
+		}
+	}
+	if len(c.Notify.Email) > 0 && (c.Notify.SMTPAddr == "" || c.Notify.SMTPFrom == "") {
+		errs = append(errs, fmt.Errorf("notify.email needs an SMTP server and sender (ANNA_SMTP_ADDR, ANNA_SMTP_FROM)"))
+	}
+	if c.Export.Path != "" {
+		switch c.Export.Format {
+		case "ndjson", "parquet":
+		default:
+			errs = append(errs, fmt.Errorf("export.format must be ndjson or parquet (ANNA_EXPORT_FORMAT), got %q", c.Export.Format))
+		}
+		if bucket, ok := strings.CutPrefix(c.Export.Path, "s3://"); ok {
+			if bucket, _, _ = strings.Cut(bucket, "/"); bucket == "" {
+				errs = append(errs, fmt.Errorf("export.path must name a bucket, e.g. s3://bucket/prefix (ANNA_EXPORT_PATH)"))
+			}
+			if !isHTTPURL(c.Export.S3Endpoint) {
+				errs = append(errs, fmt.Errorf("export.s3_endpoint must be an HTTP URL (ANNA_EXPORT_S3_ENDPOINT)"))
+			}
+		}
+	}
+	for _, mirror := range c.Anna.Mirrors {
+		if !isHTTPURL(mirror) {
+			errs = append(errs, fmt.Errorf("anna.mirrors must be HTTP URLs, got %q (ANNA_MIRRORS)", mirror))
+		}
+	}
+	for _, webseed := range c.Anna.MetadataWebseeds {
+		if !isHTTPURL(webseed) {
+			errs = append(errs, fmt.Errorf("anna.metadata_webseeds must be HTTP URLs, got %q (ANNA_METADATA_WEBSEEDS)", webseed))
+		}
+	}
+	for _, webseed := range c.Anna.TorrentWebseeds {
+		if !isHTTPURL(webseed) {
+			errs = append(errs, fmt.Errorf("anna.torrent_webseeds must be HTTP URLs, got %q (ANNA_TORRENT_WEBSEEDS)", webseed))
+		}
+	}
+	if c.RateLimit.DownloadConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.download_concurrency cannot be negative (ANNA_DOWNLOAD_CONCURRENCY)"))
+	}
+	if c.Quota.Daily < 0 {
+		errs = append(errs, fmt.Errorf("quota.daily cannot be negative (ANNA_TENANT_DAILY_QUOTA)"))
+	}
+	return errors.Join(errs...)
+}
+
+func isHTTPURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+postgres:
+  host: db
+  user: anna
+  database: anna
+anna:
+  domain: example.org
+  disable_sync: true
+  epub_cache_max_size: 10GiB
+  sync_exclude_files: [3, 7-8]
+api:
+  shutdown_timeout: 10s
+quota:
+  tenants:
+    acme: 500
+`), 0644))
+
+	t.Setenv("POSTGRES_HOST", "override")
+	t.Setenv("ANNA_TENANT_QUOTAS", "demo=10, other=2")
+	t.Setenv("API_ACME_DOMAINS", "a.example.org,b.example.org")
+	t.Setenv("ANNA_TORRENT_UPLOAD_RATE", "1MB")
+	t.Setenv("ANNA_SYNC_FILES", "0-20")
+	t.Setenv("ANNA_ARCHIVE_ID", "0-5,12")
+	t.Setenv("ANNA_SYNC_SHARD", "1/4")
+
+	c, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "override", c.Postgres.Host)
+	assert.Equal(t, "anna", c.Postgres.User)
+	assert.Equal(t, "5432", c.Postgres.Port)
+	assert.True(t, c.Anna.DisableSync)
+	assert.Equal(t, 10*time.Second, c.API.ShutdownTimeout)
+	assert.Equal(t, map[string]int{"demo": 10, "other": 2}, c.Quota.Tenants)
+	assert.Equal(t, []string{"a.example.org", "b.example.org"}, c.TLS.ACMEDomains)
+	assert.Equal(t, 42069, c.Anna.TorrentPort)
+	assert.Equal(t, ByteSize(10<<30), c.Anna.EpubCacheMaxSize)
+	assert.Equal(t, ByteSize(1e6), c.Anna.TorrentUploadRate)
+	assert.Equal(t, Ranges{{3, 3}, {7, 8}}, c.Anna.SyncExcludeFiles)
+	assert.Equal(t, Ranges{{0, 20}}, c.Anna.SyncFiles)
+	assert.Equal(t, Ranges{{0, 5}, {12, 12}}, c.Anna.ArchiveID)
+	assert.Equal(t, Shard{Index: 1, Count: 4}, c.Anna.SyncShard)
+	assert.NoError(t, c.Validate())
+}
+
+func TestLoadInvalidEnv(t *testing.T) {
+	t.Setenv("ANNA_TORRENT_PORT", "abc")
+	t.Setenv("API_SHUTDOWN_TIMEOUT", "soon")
+
+	_, err := Load("")
+	assert.ErrorContains(t, err, "ANNA_TORRENT_PORT")
+	assert.ErrorContains(t, err, "API_SHUTDOWN_TIMEOUT")
+}
+
+func TestParseByteSize(t *testing.T) {
+	for raw, expected := range map[string]ByteSize{
+		"":       0,
+		"1024":   1024,
+		"512MB":  512e6,
+		"1.5 GB": 1.5e9,
+		"10GiB":  10 << 30,
+		"2kib":   2048,
+	} {
+		size, err := ParseByteSize(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, expected, size, raw)
+	}
+
+	_, err := ParseByteSize("10PB")
+	assert.Error(t, err)
+	_, err = ParseByteSize("GB")
+	assert.Error(t, err)
+}
+
+func TestParseRanges(t *testing.T) {
+	ranges, err := ParseRanges("3, 7,10-12")
+	assert.NoError(t, err)
+	assert.Equal(t, Ranges{{3, 3}, {7, 7}, {10, 12}}, ranges)
+	for n, expected := range map[int]bool{3: true, 4: false, 7: true, 10: true, 11: true, 12: true, 13: false} {
+		assert.Equal(t, expected, ranges.Contains(n), n)
+	}
+
+	ranges, err = ParseRanges("")
+	assert.NoError(t, err)
+	assert.False(t, ranges.Contains(0))
+
+	for _, raw := range []string{"a", "5-2", "-3", "1-"} {
+		_, err := ParseRanges(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	shard, err := ParseShard(" 2/3 ")
+	assert.NoError(t, err)
+	assert.Equal(t, "2/3", shard.String())
+	for n, expected := range map[int]bool{0: false, 2: true, 5: true, 7: false} {
+		assert.Equal(t, expected, shard.Contains(n), n)
+	}
+
+	shard, err = ParseShard("")
+	assert.NoError(t, err)
+	assert.False(t, shard.Enabled())
+	assert.True(t, shard.Contains(7))
+
+	for _, raw := range []string{"1", "3/3", "-1/2", "0/0", "a/b"} {
+		_, err := ParseShard(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.TLS.CertFile = "cert.pem"
+	c.LogLevel = "verbose"
+	c.Role = "worker"
+	c.Anna.Mirrors = []string{"ftp://mirror.example.org/{md5}"}
+	c.Anna.TorrentRetries = 20
+	c.Anna.StorageKey = "c2hvcnQ="
+	c.Anna.SyncCopy = true
+	c.Anna.SyncBatchSize = 0
+	c.Anna.SyncWriters = 0
+	c.Anna.SyncFormats = []string{"epub", ".PDF"}
+	c.Anna.SyncContentTypes = []string{"audiobook", "book comic"}
+	c.Anna.SyncExcludeCollections = []string{"libgen.li", "Z-Lib"}
+	c.Anna.SyncPrune = true
+	c.Anna.SyncFiles = Ranges{{0, 3}}
+	c.Anna.SyncMetadataURL = "ftp://mirror.example.org/SHA256SUMS"
+	c.Anna.SyncMemoryLimit = -1
+	c.Anna.SyncShard = Shard{Index: 0, Count: 2}
+	c.Anna.SyncStaging = true
+	c.Notify.SlackURL = "hooks.slack.com/services/x"
+	c.Notify.Email = []string{"ops@example.org"}
+	c.Export.Path = "s3:///records"
+	c.Export.Format = "csv"
+
+	err := c.Validate()
+	assert.ErrorContains(t, err, "postgres.host is required (POSTGRES_HOST)")
+	assert.ErrorContains(t, err, "anna.domain is required (ANNA_DOMAIN)")
+	assert.ErrorContains(t, err, "tls.cert_file and tls.key_file must be set together")
+	assert.ErrorContains(t, err, "log_level")
+	assert.ErrorContains(t, err, `(ANNA_ROLE), got "worker"`)
+	assert.ErrorContains(t, err, "ANNA_MIRRORS")
+	assert.ErrorContains(t, err, "ANNA_TORRENT_RETRIES")
+	assert.ErrorContains(t, err, "(ANNA_STORAGE_KEY)")
+	assert.ErrorContains(t, err, "ANNA_SYNC_BATCH_SIZE")
+	assert.ErrorContains(t, err, "ANNA_SYNC_WRITERS")
+	assert.ErrorContains(t, err, `got ".PDF" (ANNA_SYNC_FORMATS)`)
+	assert.ErrorContains(t, err, `got "book comic" (ANNA_SYNC_CONTENT_TYPES)`)
+	assert.ErrorContains(t, err, `got "Z-Lib" (ANNA_SYNC_EXCLUDE_COLLECTIONS)`)
+	assert.NotContains(t, err.Error(), `"libgen.li"`)
+	assert.ErrorContains(t, err, "anna.sync_prune needs every metadata file to be synced")
+	assert.ErrorContains(t, err, "(ANNA_SYNC_METADATA_URL)")
+	assert.ErrorContains(t, err, "(ANNA_SYNC_SHARD, ANNA_SYNC_STAGING)")
+	assert.ErrorContains(t, err, `(ANNA_EXPORT_FORMAT), got "csv"`)
+	assert.ErrorContains(t, err, "export.path must name a bucket")
+	assert.ErrorContains(t, err, "(ANNA_SYNC_MEMORY_LIMIT)")
+	assert.ErrorContains(t, err, "(ANNA_NOTIFY_SLACK_URL)")
+	assert.ErrorContains(t, err, "(ANNA_SMTP_ADDR, ANNA_SMTP_FROM)")
+}
+
+func TestRoles(t *testing.T) {
+	c := Default()
+	assert.True(t, c.RunsAPI())
+	assert.True(t, c.RunsSync())
+	assert.True(t, c.DownloadsTorrents())
+
+	c.Role = RoleSync
+	assert.False(t, c.RunsAPI())
+	assert.True(t, c.DownloadsTorrents())
+	c.Anna.SyncDumpDir = "/data/aarecords"
+	assert.False(t, c.DownloadsTorrents())
+
+	c.Role = RoleAPI
+	assert.False(t, c.RunsSync())
+	assert.True(t, c.DownloadsTorrents())
+}
@@ -0,0 +1,161 @@
+// Package cover fetches record cover images from their external hosts,
+// resizes them and keeps them in a local cache.
+package cover
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+// ContentType is the MIME type of the covers served, they are always re-encoded as JPEG.
+const ContentType = "image/jpeg"
+
+// maxSourceSize bounds the size of the images downloaded from cover hosts.
+const maxSourceSize = 20 << 20
+
+// maxSourcePixels bounds the dimensions of the images decoded, a small file
+// can declare a huge image.
+const maxSourcePixels = 50_000_000
+
+var (
+	StorageDir = config.C.Anna.CoverStorageDir
+	g          singleflight.Group
+	client     = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+)
+
+// ErrUnavailable is returned when the cover host does not serve a usable image.
+var ErrUnavailable = errors.New("cover unavailable")
+
+// Get returns the cover found at url as a JPEG, scaled down to width pixels
+// (0 keeps the original size). Covers are cached on disk by URL and width.
+func Get(ctx context.Context, url string, width int) ([]byte, error) {
+	sum := sha256.Sum256([]byte(url))
+	filename := hex.EncodeToString(sum[:]) + "-" + strconv.Itoa(width) + ".jpg"
+	path := filepath.Join(StorageDir, filename)
+
+	if data, err := os.ReadFile(path); err == nil {
+		return data, nil
+	}
+
+	v, err, _ := g.Do(filename, func() (any, error) {
+		// Detach from the request so that a cancelled client does not fail the others waiting on this cover
+		data, err := fetch(context.WithoutCancel(ctx), url, width)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(StorageDir, 0755); err != nil {
+			slog.Warn("Failed to create cover storage directory", "dir", StorageDir, "error", err)
+			return data, nil
+		}
+		// Write to a temporary file first so readers never see a partial cover
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			slog.Warn("Failed to cache cover", "path", path, "error", err)
+			return data, nil
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			slog.Warn("Failed to cache cover", "path", path, "error", err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// fetch downloads, decodes and resizes a cover.
+func fetch(ctx context.Context, url string, width int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid url: %v", ErrUnavailable, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrUnavailable, resp.StatusCode)
+	}
+
+	src, err := decode(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(src, width)
+}
+
+// decode reads an image of up to maxSourceSize bytes, checking its dimensions
+// before decoding it.
+func decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSourceSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read image: %v", ErrUnavailable, err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode image: %v", ErrUnavailable, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxSourcePixels {
+		return nil, fmt.Errorf("%w: image too large (%dx%d)", ErrUnavailable, cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode image: %v", ErrUnavailable, err)
+	}
+	return src, nil
+}
+
+// encode resizes an image and encodes it as JPEG.
+func encode(src image.Image, width int) ([]byte, error) {
+	img := resize(src, width)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("failed to encode cover: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales src down to the given width, keeping its aspect ratio.
+// Images that are already narrower are returned unchanged.
+func resize(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if width <= 0 || bounds.Dx() <= width {
+		return src
+	}
+
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
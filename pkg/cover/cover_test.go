@@ -0,0 +1,127 @@
+package cover
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 600, 900))
+
+	assert.Equal(t, image.Rect(0, 0, 200, 300), resize(src, 200).Bounds())
+	assert.Equal(t, src.Bounds(), resize(src, 0).Bounds())
+	assert.Equal(t, src.Bounds(), resize(src, 800).Bounds())
+}
+
+func TestGet(t *testing.T) {
+	StorageDir = t.TempDir()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/cover.png" {
+			http.NotFound(w, r)
+			return
+		}
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 400, 600)))
+	}))
+	defer server.Close()
+
+	data, err := Get(context.Background(), server.URL+"/cover.png", 160)
+	assert.NoError(t, err)
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 160, 240), img.Bounds())
+
+	// Served from the disk cache
+	cached, err := Get(context.Background(), server.URL+"/cover.png", 160)
+	assert.NoError(t, err)
+	assert.Equal(t, data, cached)
+	assert.Equal(t, 1, requests)
+
+	_, err = Get(context.Background(), server.URL+"/missing.png", 160)
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func writeEpub(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		fw, err := w.Create(name)
+		assert.NoError(t, err)
+		fw.Write(data)
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestFromEpub(t *testing.T) {
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 400, 600)))
+
+	container := []byte(`<?xml version="1.0"?>
+<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container" version="1.0">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`)
+
+	// EPUB 2 cover meta
+	epub := writeEpub(t, map[string][]byte{
+		"META-INF/container.xml": container,
+		"OEBPS/content.opf": []byte(`<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata><meta name="cover" content="img1"/></metadata>
+  <manifest><item id="img1" href="images/front%20page.png" media-type="image/png"/></manifest>
+</package>`),
+		"OEBPS/images/front page.png": img.Bytes(),
+	})
+	data, err := FromEpub(epub, 160)
+	assert.NoError(t, err)
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 160, 240), decoded.Bounds())
+
+	// EPUB 3 cover-image property
+	epub = writeEpub(t, map[string][]byte{
+		"META-INF/container.xml": container,
+		"OEBPS/content.opf": []byte(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <manifest>
+    <item id="other" href="other.png" media-type="image/png"/>
+    <item id="c" href="c.png" media-type="image/png" properties="cover-image"/>
+  </manifest>
+</package>`),
+		"OEBPS/c.png": img.Bytes(),
+	})
+	_, err = FromEpub(epub, 0)
+	assert.NoError(t, err)
+
+	// No cover
+	epub = writeEpub(t, map[string][]byte{
+		"META-INF/container.xml": container,
+		"OEBPS/content.opf":      []byte(`<package><manifest><item id="text" href="text.xhtml" media-type="application/xhtml+xml"/></manifest></package>`),
+	})
+	_, err = FromEpub(epub, 0)
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	// A GIF header declaring a 65535x65535 screen, without any image data
+	header := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+
+	_, err := decode(bytes.NewReader(header))
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorContains(t, err, "image too large (65535x65535)")
+
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 60))))
+	src, err := decode(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 40, 60), src.Bounds())
+}
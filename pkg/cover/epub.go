@@ -0,0 +1,126 @@
+package cover
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Metas []struct {
+		Name    string `xml:"name,attr"`
+		Content string `xml:"content,attr"`
+	} `xml:"metadata>meta"`
+	Items []epubItem `xml:"manifest>item"`
+}
+
+type epubItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+// FromEpub extracts the cover of an epub as a JPEG, scaled down to
+// width pixels (0 keeps the original size). The cover is the manifest item
+// marked as cover-image (EPUB 3), the item named by the cover meta (EPUB 2),
+// or else the first image whose id or name mentions a cover.
+func FromEpub(epub []byte, width int) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(epub), int64(len(epub)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open epub: %v", ErrUnavailable, err)
+	}
+
+	name, err := epubCoverPath(r)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open %s: %v", ErrUnavailable, name, err)
+	}
+	defer file.Close()
+
+	src, err := decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return encode(src, width)
+}
+
+// epubCoverPath returns the path in the epub archive of its cover image.
+func epubCoverPath(r *zip.Reader) (string, error) {
+	var container epubContainer
+	if err := decodeXML(r, "META-INF/container.xml", &container); err != nil {
+		return "", err
+	}
+	if len(container.Rootfiles) == 0 {
+		return "", fmt.Errorf("%w: epub has no package document", ErrUnavailable)
+	}
+	opf := container.Rootfiles[0].FullPath
+
+	var pkg epubPackage
+	if err := decodeXML(r, opf, &pkg); err != nil {
+		return "", err
+	}
+
+	item := pkg.coverItem()
+	if item == nil {
+		return "", fmt.Errorf("%w: epub has no cover", ErrUnavailable)
+	}
+	href, err := url.PathUnescape(item.Href)
+	if err != nil {
+		href = item.Href
+	}
+	return path.Join(path.Dir(opf), href), nil
+}
+
+func (p *epubPackage) coverItem() *epubItem {
+	for i, item := range p.Items {
+		if isImage(item) && strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			return &p.Items[i]
+		}
+	}
+	for _, meta := range p.Metas {
+		if meta.Name != "cover" {
+			continue
+		}
+		for i, item := range p.Items {
+			if item.ID == meta.Content && isImage(item) {
+				return &p.Items[i]
+			}
+		}
+	}
+	for i, item := range p.Items {
+		if isImage(item) && (strings.Contains(strings.ToLower(item.ID), "cover") || strings.Contains(strings.ToLower(item.Href), "cover")) {
+			return &p.Items[i]
+		}
+	}
+	return nil
+}
+
+func isImage(item epubItem) bool {
+	return strings.HasPrefix(item.MediaType, "image/")
+}
+
+func decodeXML(r *zip.Reader, name string, v any) error {
+	file, err := r.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: failed to open %s: %v", ErrUnavailable, name, err)
+	}
+	defer file.Close()
+	if err := xml.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("%w: failed to parse %s: %v", ErrUnavailable, name, err)
+	}
+	return nil
+}
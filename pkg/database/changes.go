@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ChangesBatchSize is the number of changed records read from the database at once.
+const ChangesBatchSize = 1000
+
+// RecordChange tells that a record was created or updated, or deleted.
+type RecordChange struct {
+	ID        string    `json:"id"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// changesSQL lists the records with their update time, and the tombstones of
+// the deleted records with their deletion time.
+const changesSQL = `SELECT id, updated_at, false AS deleted FROM anna_records
+	UNION ALL SELECT id, deleted_at, true FROM anna_record_tombstones`
+
+// RecordChanges calls fn with batches of records updated or deleted after
+// since, ordered by time then ID. Batches are read with a keyset on
+// (updated_at, id, deleted) so the whole table can be walked without holding a
+// cursor open.
+func RecordChanges(ctx context.Context, since time.Time, fn func([]RecordChange) error) error {
+	last := RecordChange{UpdatedAt: since}
+	first := true
+
+	for {
+		q := DB.WithContext(ctx).Table("("+changesSQL+") AS changes").Select("id", "updated_at", "deleted")
+		if first {
+			q = q.Where("updated_at > ?", last.UpdatedAt)
+		} else {
+			q = q.Where("(updated_at, id, deleted) > (?, ?, ?)", last.UpdatedAt, last.ID, last.Deleted)
+		}
+
+		var changes []RecordChange
+		if err := q.Order("updated_at").Order("id").Order("deleted").Limit(ChangesBatchSize).Find(&changes).Error; err != nil {
+			return fmt.Errorf("failed to list record changes: %w", err)
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		if err := fn(changes); err != nil {
+			return err
+		}
+		if len(changes) < ChangesBatchSize {
+			return nil
+		}
+
+		last = changes[len(changes)-1]
+		first = false
+	}
+}
+
+// buryRecords writes the tombstones of the records of the IDs selected by ids,
+// before they are deleted.
+func buryRecords(tx *gorm.DB, ids *gorm.DB) error {
+	if err := tx.Exec(`INSERT INTO anna_record_tombstones (id, deleted_at) SELECT id, now() FROM (?) AS d(id)
+		ON CONFLICT (id) DO UPDATE SET deleted_at = EXCLUDED.deleted_at`, ids).Error; err != nil {
+		return fmt.Errorf("failed to record the deleted records: %w", err)
+	}
+	return nil
+}
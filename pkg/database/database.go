@@ -2,16 +2,20 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"log/slog"
-	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/series"
 	"github.com/lib/pq"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -30,17 +34,34 @@ func Ready() bool {
 	return ready.Load()
 }
 
-func init() {
+// Connect opens the connection pool of DB. It is called at startup, once the
+// configuration was validated.
+func Connect() error {
 	var err error
+	DB, err = open("")
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Database connection established")
+	return nil
+}
 
-	DB, err = gorm.Open(postgres.Open(fmt.Sprintf(
+// open connects to the database, with the given search_path when not empty.
+func open(searchPath string) (*gorm.DB, error) {
+	pg := config.C.Postgres
+	dsn := fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		os.Getenv("POSTGRES_HOST"),
-		os.Getenv("POSTGRES_USER"),
-		os.Getenv("POSTGRES_PASSWORD"),
-		os.Getenv("POSTGRES_DATABASE"),
-		os.Getenv("POSTGRES_PORT"),
-	)), &gorm.Config{
+		pg.Host,
+		pg.User,
+		pg.Password,
+		pg.Database,
+		pg.Port,
+	)
+	if searchPath != "" {
+		dsn += " search_path=" + searchPath
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
 		Logger: logger.New(
 			log.Default(),
 			logger.Config{
@@ -54,23 +75,19 @@ func init() {
 			TablePrefix: "anna_",
 		},
 	})
-
 	if err != nil {
-		slog.Error("Failed to connect to database", "error", err)
-		os.Exit(1)
+		return nil, err
 	}
 
 	// Configure connection pool
-	sqlDB, err := DB.DB()
+	sqlDB, err := db.DB()
 	if err != nil {
-		slog.Error("Failed to get underlying DB", "error", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to get underlying DB: %w", err)
 	}
 	sqlDB.SetMaxOpenConns(25)
 	sqlDB.SetMaxIdleConns(5)
 	sqlDB.SetConnMaxLifetime(5 * time.Minute)
-
-	slog.Info("Database connection established")
+	return db, nil
 }
 
 // AutoMigrate runs automatic migration for all models
@@ -90,12 +107,29 @@ func AutoMigrate() error {
 	// Create a custom text search configuration that strips diacritics.
 	DB.Exec("DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'simple_unaccent') THEN CREATE TEXT SEARCH CONFIGURATION simple_unaccent (COPY = simple); ALTER TEXT SEARCH CONFIGURATION simple_unaccent ALTER MAPPING FOR word, numword, asciiword, numhword, asciihword, hword, hword_numpart, hword_part, hword_asciipart WITH unaccent, simple; END IF; END $$")
 
+	// The hash of the synced values was first stored as hash
+	if DB.Migrator().HasColumn(&Record{}, "hash") && !DB.Migrator().HasColumn(&Record{}, "source_hash") {
+		if err := DB.Migrator().RenameColumn(&Record{}, "hash", "source_hash"); err != nil {
+			return fmt.Errorf("failed to rename the record hash column: %w", err)
+		}
+	}
+
 	err := DB.AutoMigrate(
 		&Record{},
 		&RecordIdentifier{},
 		&RecordClassification{},
 		&Synchronization{},
+		&SyncCheckpoint{},
+		&SyncProgress{},
+		&RecordVersion{},
+		&RecordTombstone{},
 		&Torrent{},
+		&TenantDownload{},
+		&RecordSource{},
+		&RecordBlob{},
+		&Download{},
+		&RecordDownload{},
+		&Webhook{},
 	)
 
 	if err != nil {
@@ -104,11 +138,18 @@ func AutoMigrate() error {
 
 	// Create functional GIN indexes for full-text search on text columns.
 	// These use to_tsvector('simple_unaccent', ...) to match the @@ expressions
-	// in SearchByText and handle diacritics transparently.
+	// in SearchByText and SearchByQuery and handle diacritics transparently.
+	// Titles, authors and publishers are indexed by their search version.
 	ftsIndexes := []string{
-		"CREATE INDEX IF NOT EXISTS idx_record_title_fts ON anna_records USING gin (to_tsvector('simple_unaccent', coalesce(title, '')))",
-		"CREATE INDEX IF NOT EXISTS idx_record_author_fts ON anna_records USING gin (to_tsvector('simple_unaccent', coalesce(author, '')))",
-		"CREATE INDEX IF NOT EXISTS idx_record_publisher_fts ON anna_records USING gin (to_tsvector('simple_unaccent', coalesce(publisher, '')))",
+		"DROP INDEX IF EXISTS idx_record_title_fts",
+		"DROP INDEX IF EXISTS idx_record_author_fts",
+		"DROP INDEX IF EXISTS idx_record_publisher_fts",
+		"DROP INDEX IF EXISTS idx_record_title_author_fts",
+		"CREATE INDEX IF NOT EXISTS idx_record_search_title_fts ON anna_records USING gin (to_tsvector('simple_unaccent', " + ftsTitle + "))",
+		"CREATE INDEX IF NOT EXISTS idx_record_search_author_fts ON anna_records USING gin (to_tsvector('simple_unaccent', " + ftsAuthor + "))",
+		"CREATE INDEX IF NOT EXISTS idx_record_search_publisher_fts ON anna_records USING gin (to_tsvector('simple_unaccent', " + ftsPublisher + "))",
+		"CREATE INDEX IF NOT EXISTS idx_record_description_fts ON anna_records USING gin (to_tsvector('simple_unaccent', coalesce(description, '')))",
+		"CREATE INDEX IF NOT EXISTS idx_record_search_title_author_fts ON anna_records USING gin (to_tsvector('simple_unaccent', " + ftsTitle + " || ' ' || " + ftsAuthor + "))",
 	}
 	for _, ddl := range ftsIndexes {
 		if err := DB.Exec(ddl).Error; err != nil {
@@ -116,11 +157,25 @@ func AutoMigrate() error {
 		}
 	}
 
+	// Index used by the changes feed, which walks records by (updated_at, id)
+	if err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_record_updated_at ON anna_records (updated_at, id)").Error; err != nil {
+		return fmt.Errorf("failed to create updated_at index: %w", err)
+	}
+
 	slog.Info("Auto migration completed successfully")
 	ready.Store(true)
 	return nil
 }
 
+// Close closes the database connection pool.
+func Close() error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
 // Ping checks the database connection
 func Ping() error {
 	sqlDB, err := DB.DB()
@@ -165,14 +220,28 @@ func sanitizeString(s string) string {
 	return strings.ReplaceAll(s, "\x00", "")
 }
 
-// UpsertRecordAndIdentifiers creates or updates a record and its identifiers from an Anna record
-func UpsertRecordAndIdentifiers(ctx context.Context, annaRecord *anna.Record) error {
+// recordRows is an Anna record converted to the rows of the records,
+// identifiers and classifications tables.
+type recordRows struct {
+	record          Record
+	identifiers     []RecordIdentifier
+	classifications []RecordClassification
+}
+
+// newRecordRows converts an Anna record written by the sync of the given
+// generation, returning false when the record is not synced.
+func newRecordRows(annaRecord *anna.Record, generation string) (*recordRows, bool) {
 	if annaRecord == nil {
-		return nil
+		return nil, false
 	}
 
-	if annaRecord.Source.FileUnifiedData.ExtensionBest != "epub" {
-		return nil
+	extension := annaRecord.Source.FileUnifiedData.ExtensionBest
+	if !anna.IsSynced(extension, annaRecord.Source.FileUnifiedData.ContentTypeBest) {
+		return nil, false
+	}
+
+	if !syncedLanguage(annaRecord.Source.FileUnifiedData.LanguageCodes) {
+		return nil, false
 	}
 
 	year, _ := strconv.Atoi(annaRecord.Source.FileUnifiedData.YearBest)
@@ -183,33 +252,42 @@ func UpsertRecordAndIdentifiers(ctx context.Context, annaRecord *anna.Record) er
 		languages[i] = sanitizeString(lang)
 	}
 
-	record := Record{
-		ID:        sanitizeString(annaRecord.ID),
-		Title:     sanitizeString(annaRecord.Source.FileUnifiedData.TitleBest),
-		Publisher: sanitizeString(annaRecord.Source.FileUnifiedData.PublisherBest),
-		Author:    sanitizeString(annaRecord.Source.FileUnifiedData.AuthorBest),
-		CoverURL:  sanitizeString(annaRecord.Source.FileUnifiedData.CoverURLBest),
-		Year:      year,
-		Languages: pq.StringArray(languages),
+	rows := &recordRows{
+		record: Record{
+			ID:        sanitizeString(annaRecord.ID),
+			Title:     sanitizeString(annaRecord.Source.FileUnifiedData.TitleBest),
+			Publisher: sanitizeString(annaRecord.Source.FileUnifiedData.PublisherBest),
+			Author:    sanitizeString(annaRecord.Source.FileUnifiedData.AuthorBest),
+			CoverURL:  sanitizeString(annaRecord.Source.FileUnifiedData.CoverURLBest),
+			Year:      year,
+			Languages: pq.StringArray(languages),
+
+			ContentType: sanitizeString(annaRecord.Source.FileUnifiedData.ContentTypeBest),
+			Extension:   extension,
+			Filesize:    max(annaRecord.Source.FileUnifiedData.FilesizeBest, 0),
+			MD5:         sanitizeString(recordMD5(annaRecord)),
+			AddedAt:     addedDate(annaRecord.Source.SearchOnlyFields.SearchAddedDate),
+
+			SearchTitle:     sanitizeString(annaRecord.Source.SearchOnlyFields.SearchTitle),
+			SearchAuthor:    sanitizeString(annaRecord.Source.SearchOnlyFields.SearchAuthor),
+			SearchPublisher: sanitizeString(annaRecord.Source.SearchOnlyFields.SearchPublisher),
+			Generation:      generation,
+		},
 	}
+	record := &rows.record
 
-	if annaRecord.Source.FileUnifiedData.StrippedDescriptionBest != "" {
-		record.Description = sanitizeString(annaRecord.Source.FileUnifiedData.StrippedDescriptionBest)
+	if name, index, ok := series.Parse(record.Title); ok {
+		record.Series = name
+		record.SeriesIndex = index
 	}
 
-	// Upsert the record using ON CONFLICT
-	if err := DB.WithContext(ctx).Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"title", "publisher", "author", "cover_url", "year", "languages", "description", "updated_at"}),
-	}).Create(&record).Error; err != nil {
-		return fmt.Errorf("failed to upsert record: %w", err)
+	if annaRecord.Source.FileUnifiedData.StrippedDescriptionBest != "" {
+		record.Description = sanitizeString(annaRecord.Source.FileUnifiedData.StrippedDescriptionBest)
 	}
 
-	// Batch upsert identifiers
-	var identifiers []RecordIdentifier
 	for identifierType, values := range annaRecord.Source.FileUnifiedData.IdentifiersUnified {
 		for _, value := range values {
-			identifiers = append(identifiers, RecordIdentifier{
+			rows.identifiers = append(rows.identifiers, RecordIdentifier{
 				Record: record.ID,
 				Type:   sanitizeString(identifierType),
 				Value:  sanitizeString(value),
@@ -217,20 +295,18 @@ func UpsertRecordAndIdentifiers(ctx context.Context, annaRecord *anna.Record) er
 		}
 	}
 
-	if len(identifiers) > 0 {
-		if err := DB.WithContext(ctx).Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "record"}, {Name: "type"}, {Name: "value"}},
-			DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
-		}).Create(&identifiers).Error; err != nil {
-			return fmt.Errorf("failed to upsert identifiers: %w", err)
-		}
-	}
-
-	// Batch upsert classifications
-	var classifications []RecordClassification
+	torrents, skipped := 0, 0
 	for classificationType, values := range annaRecord.Source.FileUnifiedData.ClassificationsUnified {
 		for _, value := range values {
-			classifications = append(classifications, RecordClassification{
+			// The files of skipped collections are not downloaded from their torrents
+			if classificationType == "torrent" && anna.FiltersCollections() {
+				if !anna.IsSyncedCollection(anna.TorrentCollection(value)) {
+					skipped++
+					continue
+				}
+				torrents++
+			}
+			rows.classifications = append(rows.classifications, RecordClassification{
 				Record: record.ID,
 				Type:   sanitizeString(classificationType),
 				Value:  sanitizeString(value),
@@ -238,14 +314,172 @@ func UpsertRecordAndIdentifiers(ctx context.Context, annaRecord *anna.Record) er
 		}
 	}
 
-	if len(classifications) > 0 {
-		if err := DB.WithContext(ctx).Clauses(clause.OnConflict{
+	// Records outside the synced collections are dropped; with excluded
+	// collections only, so are the records whose torrents were all excluded,
+	// the records without torrents being kept.
+	if torrents == 0 && (len(config.C.Anna.SyncCollections) > 0 || skipped > 0) {
+		return nil, false
+	}
+
+	record.SourceHash = rows.hash()
+	return rows, true
+}
+
+// recordMD5 returns the md5 of the file of an Anna record, from its ID or its
+// md5 identifier.
+func recordMD5(annaRecord *anna.Record) string {
+	if md5, ok := strings.CutPrefix(annaRecord.ID, "md5:"); ok {
+		return strings.ToLower(md5)
+	}
+	if values := annaRecord.Source.FileUnifiedData.IdentifiersUnified["md5"]; len(values) > 0 {
+		return strings.ToLower(values[0])
+	}
+	return ""
+}
+
+// addedDate parses the date a file was added to Anna's Archive, e.g.
+// 2023-08-12, returning nil when unknown.
+func addedDate(value string) *time.Time {
+	if len(value) < len("2006-01-02") {
+		return nil
+	}
+	date, err := time.Parse("2006-01-02", value[:len("2006-01-02")])
+	if err != nil {
+		return nil
+	}
+	return &date
+}
+
+// hash returns a hash of the synced values of the rows, the generation aside.
+func (rows *recordRows) hash() string {
+	r := rows.record
+	fields := []string{r.ID, r.Title, r.Publisher, r.Author, r.CoverURL, strconv.Itoa(r.Year), strings.Join(r.Languages, ","),
+		r.Description, r.ContentType, r.Extension, r.Series, strconv.FormatFloat(r.SeriesIndex, 'g', -1, 64),
+		strconv.FormatInt(r.Filesize, 10), r.MD5, r.SearchTitle, r.SearchAuthor, r.SearchPublisher}
+	if r.AddedAt != nil {
+		fields = append(fields, r.AddedAt.Format("2006-01-02"))
+	}
+
+	// Identifiers and classifications come from maps, in random order
+	var values []string
+	for _, identifier := range rows.identifiers {
+		values = append(values, "i\x00"+identifier.Type+"\x00"+identifier.Value)
+	}
+	for _, classification := range rows.classifications {
+		values = append(values, "c\x00"+classification.Type+"\x00"+classification.Value)
+	}
+	slices.Sort(values)
+
+	hash := sha256.New()
+	for _, value := range append(fields, values...) {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// syncedLanguage returns whether a record in the given languages is synced,
+// according to ANNA_SYNC_LANGUAGES. Records without language are skipped when
+// languages are set.
+func syncedLanguage(languages []string) bool {
+	if len(config.C.Anna.SyncLanguages) == 0 {
+		return true
+	}
+	for _, language := range languages {
+		for _, synced := range config.C.Anna.SyncLanguages {
+			if strings.EqualFold(language, synced) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordColumns are the columns of a record updated by a sync.
+var recordColumns = []string{"title", "publisher", "author", "cover_url", "year", "languages", "description", "content_type", "extension", "filesize", "md5", "added_at", "series", "series_index", "search_title", "search_author", "search_publisher", "generation", "source_hash", "updated_at"}
+
+// UpsertRecordAndIdentifiers creates or updates a record and its identifiers
+// from an Anna record, synced from the base named generation. The rows
+// written are counted in written, if not nil
+func UpsertRecordAndIdentifiers(ctx context.Context, annaRecord *anna.Record, generation string, written *Written) error {
+	rows, ok := newRecordRows(annaRecord, generation)
+	if !ok {
+		return nil
+	}
+
+	// An unchanged record only gets the generation of the sync
+	result := writer().WithContext(ctx).Model(&Record{}).Where("id = ? AND source_hash = ?", rows.record.ID, rows.record.SourceHash).UpdateColumn("generation", generation)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update record generation: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	if retainVersions() {
+		if err := writer().WithContext(ctx).Exec(versionIDsSQL, generation, pq.StringArray{rows.record.ID}).Error; err != nil {
+			return fmt.Errorf("failed to save the previous version of the record: %w", err)
+		}
+	}
+
+	// Upsert the record using ON CONFLICT
+	if err := writer().WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns(recordColumns),
+	}).Create(&rows.record).Error; err != nil {
+		return fmt.Errorf("failed to upsert record: %w", err)
+	}
+
+	// Batch upsert identifiers
+	if len(rows.identifiers) > 0 {
+		if err := writer().WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "record"}, {Name: "type"}, {Name: "value"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
+		}).Create(&rows.identifiers).Error; err != nil {
+			return fmt.Errorf("failed to upsert identifiers: %w", err)
+		}
+	}
+
+	// Batch upsert classifications
+	if len(rows.classifications) > 0 {
+		if err := writer().WithContext(ctx).Clauses(clause.OnConflict{
 			Columns:   []clause.Column{{Name: "record"}, {Name: "type"}, {Name: "value"}},
 			DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
-		}).Create(&classifications).Error; err != nil {
+		}).Create(&rows.classifications).Error; err != nil {
 			return fmt.Errorf("failed to upsert classifications: %w", err)
 		}
 	}
 
+	written.add(rows)
 	return nil
 }
+
+// PruneRecords deletes the records, and their identifiers and classifications,
+// not written by the sync of the given generation. It returns the number of
+// records deleted.
+func PruneRecords(ctx context.Context, generation string) (int64, error) {
+	var pruned int64
+	err := writer().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if retainVersions() {
+			if err := tx.Exec(versionStaleSQL, generation).Error; err != nil {
+				return fmt.Errorf("failed to save the pruned records: %w", err)
+			}
+		}
+		stale := tx.Model(&Record{}).Select("id").Where("generation <> ?", generation)
+		if err := buryRecords(tx, stale); err != nil {
+			return err
+		}
+		if err := tx.Where("record IN (?)", stale).Delete(&RecordIdentifier{}).Error; err != nil {
+			return fmt.Errorf("failed to prune identifiers: %w", err)
+		}
+		if err := tx.Where("record IN (?)", stale).Delete(&RecordClassification{}).Error; err != nil {
+			return fmt.Errorf("failed to prune classifications: %w", err)
+		}
+		result := tx.Where("generation <> ?", generation).Delete(&Record{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to prune records: %w", result.Error)
+		}
+		pruned = result.RowsAffected
+		return nil
+	})
+	return pruned, err
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+	"gorm.io/gorm/clause"
+)
+
+// StartDownload records that the file of a record is being downloaded, to
+// resume the download if the process restarts before it completes.
+func StartDownload(ctx context.Context, file, record string) error {
+	err := DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Download{File: file, Record: record, Status: string(anna.DownloadStatusQueued)}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record download: %w", err)
+	}
+	return nil
+}
+
+// UpdateDownload saves the progress of a download.
+func UpdateDownload(ctx context.Context, file string, progress anna.DownloadProgressEvent) error {
+	err := DB.WithContext(ctx).Model(&Download{}).Where("file = ?", file).Updates(map[string]any{
+		"status":          string(progress.Status),
+		"bytes_completed": progress.BytesCompleted,
+		"total_bytes":     progress.TotalBytes,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update download: %w", err)
+	}
+	return nil
+}
+
+// FinishDownload forgets a download once it succeeded or failed.
+func FinishDownload(ctx context.Context, file string) error {
+	if err := DB.WithContext(ctx).Where("file = ?", file).Delete(&Download{}).Error; err != nil {
+		return fmt.Errorf("failed to remove download: %w", err)
+	}
+	return nil
+}
+
+// ListDownloads returns the downloads that did not finish, the oldest first.
+func ListDownloads(ctx context.Context) ([]Download, error) {
+	var downloads []Download
+	if err := DB.WithContext(ctx).Order("created_at").Find(&downloads).Error; err != nil {
+		return nil, fmt.Errorf("failed to list downloads: %w", err)
+	}
+	return downloads, nil
+}
+
+// CountRecordDownload records a completed download of a record today.
+func CountRecordDownload(ctx context.Context, id string) error {
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	err := DB.WithContext(ctx).Exec(`
+		INSERT INTO anna_record_downloads (record, day, count) VALUES (?, ?, 1)
+		ON CONFLICT (record, day) DO UPDATE SET count = anna_record_downloads.count + 1`, id, day).Error
+	if err != nil {
+		return fmt.Errorf("failed to count record download: %w", err)
+	}
+	return nil
+}
+
+// RecordDownloadCount is the number of downloads of a record.
+type RecordDownloadCount struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Count  int64  `json:"count"`
+}
+
+// DayDownloadCount is the number of downloads of a day (UTC).
+type DayDownloadCount struct {
+	Day   string `json:"day" doc:"Day as YYYY-MM-DD"`
+	Count int64  `json:"count"`
+}
+
+// DownloadStatistics returns the limit most downloaded records since a day,
+// the number of downloads of each day and their total.
+func DownloadStatistics(ctx context.Context, since time.Time, limit int) ([]RecordDownloadCount, []DayDownloadCount, int64, error) {
+	top := []RecordDownloadCount{}
+	err := DB.WithContext(ctx).Raw(`
+		SELECT d.record AS id, coalesce(r.title, '') AS title, coalesce(r.author, '') AS author, SUM(d.count) AS count
+		FROM anna_record_downloads d LEFT JOIN anna_records r ON r.id = d.record
+		WHERE d.day >= ?
+		GROUP BY d.record, r.title, r.author
+		ORDER BY count DESC, d.record
+		LIMIT ?`, since, limit).Scan(&top).Error
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to get most downloaded records: %w", err)
+	}
+
+	days := []DayDownloadCount{}
+	err = DB.WithContext(ctx).Raw(`
+		SELECT to_char(day, 'YYYY-MM-DD') AS day, SUM(count) AS count
+		FROM anna_record_downloads
+		WHERE day >= ?
+		GROUP BY day
+		ORDER BY day`, since).Scan(&days).Error
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to get downloads per day: %w", err)
+	}
+
+	var total int64
+	for _, day := range days {
+		total += day.Count
+	}
+	return top, days, total, nil
+}
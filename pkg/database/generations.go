@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/iziplay/anna-api/pkg/config"
+	"gorm.io/gorm"
+)
+
+// ErrNoPreviousGeneration is returned by RollbackGeneration when the records
+// of the previous generation were not retained.
+var ErrNoPreviousGeneration = errors.New("no previous generation to roll back to")
+
+// versionRecordsSQL saves the current version of the records of the IDs
+// selected by %s before the sync of generation $1 changes or deletes them.
+// Records that don't exist yet are saved without version, so that a rollback
+// deletes them. The first version saved for a generation is kept.
+const versionRecordsSQL = `INSERT INTO anna_record_versions (generation, id, record, identifiers, classifications, created_at)
+	SELECT $1, u.id, to_jsonb(r),
+		(SELECT jsonb_agg(to_jsonb(i)) FROM anna_record_identifiers i WHERE i.record = u.id),
+		(SELECT jsonb_agg(to_jsonb(c)) FROM anna_record_classifications c WHERE c.record = u.id),
+		now()
+	FROM (%s) AS u(id) LEFT JOIN anna_records r ON r.id = u.id
+	ORDER BY u.id
+	ON CONFLICT (generation, id) DO NOTHING`
+
+var (
+	// versionIDsSQL versions the records of the IDs $2
+	versionIDsSQL = fmt.Sprintf(versionRecordsSQL, "SELECT unnest($2::text[])")
+	// versionStaleSQL versions the records of other generations, before they are pruned
+	versionStaleSQL = fmt.Sprintf(versionRecordsSQL, "SELECT id FROM anna_records WHERE generation <> $1")
+)
+
+// completeSyncs are the conditions of the complete syncs that can be rolled back
+const completeSyncs = "complete AND NOT coalesce(partial, false) AND NOT coalesce(rolled_back, false) AND coalesce(error, '') = ''"
+
+// retainVersions returns whether the previous version of the records is kept
+// for rollbacks.
+func retainVersions() bool {
+	return config.C.Anna.SyncRetainPrevious
+}
+
+// PruneVersions deletes the record versions saved by the syncs of other
+// generations: only the last one can be rolled back.
+func PruneVersions(ctx context.Context, generation string) error {
+	return DB.WithContext(ctx).Where("generation <> ?", generation).Delete(&RecordVersion{}).Error
+}
+
+// RollbackGeneration restores the records as they were before the last
+// complete sync, from the versions it saved, and marks it as rolled back. It
+// returns the generations rolled back from and to, and the number of records
+// restored or deleted.
+func RollbackGeneration(ctx context.Context) (from, to string, restored int64, err error) {
+	var last, previous Synchronization
+	if err := DB.WithContext(ctx).Where(completeSyncs).Order("date DESC").First(&last).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", 0, ErrNoPreviousGeneration
+		}
+		return "", "", 0, err
+	}
+	err = DB.WithContext(ctx).Where(completeSyncs+" AND base <> ? AND date < ?", last.Base, last.Date).
+		Order("date DESC").First(&previous).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", "", 0, ErrNoPreviousGeneration
+	}
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	var versions int64
+	if err := DB.WithContext(ctx).Model(&RecordVersion{}).Where("generation = ?", last.Base).Count(&versions).Error; err != nil {
+		return "", "", 0, err
+	}
+	if versions == 0 {
+		return "", "", 0, ErrNoPreviousGeneration
+	}
+
+	err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		changed := tx.Model(&RecordVersion{}).Select("id").Where("generation = ?", last.Base)
+		for _, model := range []any{&RecordIdentifier{}, &RecordClassification{}} {
+			if err := tx.Where("record IN (?)", changed).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to delete the rows of the changed records: %w", err)
+			}
+		}
+
+		// Records added by the sync
+		inserted := tx.Model(&RecordVersion{}).Select("id").Where("generation = ? AND record IS NULL", last.Base)
+		if err := buryRecords(tx, inserted); err != nil {
+			return err
+		}
+		if err := tx.Where("id IN (?)", inserted).Delete(&Record{}).Error; err != nil {
+			return fmt.Errorf("failed to delete the added records: %w", err)
+		}
+
+		// Records updated or pruned by the sync
+		if err := tx.Exec(`INSERT INTO anna_records SELECT r.*
+			FROM anna_record_versions v, jsonb_populate_record(null::anna_records, v.record) r
+			WHERE v.generation = ? AND v.record IS NOT NULL ORDER BY r.id
+			ON CONFLICT (id) DO UPDATE SET `+restoreColumns(), last.Base).Error; err != nil {
+			return fmt.Errorf("failed to restore records: %w", err)
+		}
+		// The pruned records are inserted with their saved update time, and are
+		// not deleted anymore
+		restored := tx.Model(&RecordVersion{}).Select("id").Where("generation = ? AND record IS NOT NULL", last.Base)
+		if err := tx.Model(&Record{}).Where("id IN (?)", restored).UpdateColumn("updated_at", gorm.Expr("now()")).Error; err != nil {
+			return fmt.Errorf("failed to restore records: %w", err)
+		}
+		if err := tx.Where("id IN (?)", restored).Delete(&RecordTombstone{}).Error; err != nil {
+			return fmt.Errorf("failed to restore records: %w", err)
+		}
+		for column, table := range map[string]string{"identifiers": "anna_record_identifiers", "classifications": "anna_record_classifications"} {
+			if err := tx.Exec(`INSERT INTO `+table+` SELECT x.*
+				FROM anna_record_versions v, jsonb_populate_recordset(null::`+table+`, v.`+column+`) x
+				WHERE v.generation = ?`, last.Base).Error; err != nil {
+				return fmt.Errorf("failed to restore %s: %w", column, err)
+			}
+		}
+
+		// Records the sync didn't change only got its generation
+		if err := tx.Model(&Record{}).Where("generation = ?", last.Base).UpdateColumn("generation", previous.Base).Error; err != nil {
+			return fmt.Errorf("failed to restore the generation of unchanged records: %w", err)
+		}
+
+		if err := tx.Where("generation = ?", last.Base).Delete(&RecordVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Synchronization{}).Where("base = ?", last.Base).Update("rolled_back", true).Error
+	})
+	if err != nil {
+		return "", "", 0, err
+	}
+	return last.Base, previous.Base, versions, nil
+}
+
+// restoreColumns returns the SET clause restoring every column of a record
+// from the inserted row. The record is marked updated now, for the changes
+// feed to report the rollback.
+func restoreColumns() string {
+	clause := "created_at = EXCLUDED.created_at"
+	for _, column := range recordColumns {
+		if column == "updated_at" {
+			clause += ", updated_at = now()"
+			continue
+		}
+		clause += ", " + column + " = EXCLUDED." + column
+	}
+	return clause
+}
@@ -0,0 +1,315 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// RecordBatch buffers Anna records and writes them in batches: each batch is
+// copied with COPY into temporary staging tables, then merged into the
+// records, identifiers and classifications tables with one INSERT ... ON
+// CONFLICT per table. This is much faster than upserting records one by one
+// on a full sync. Add and Flush can be called concurrently.
+type RecordBatch struct {
+	size       int
+	generation string
+	written    *Written
+
+	mu   sync.Mutex
+	rows []*recordRows
+	// copying is read locked while a full batch is written
+	copying sync.RWMutex
+}
+
+// NewRecordBatch returns a batch written every size records, synced from the
+// base named generation. The rows written are counted in written, if not nil.
+func NewRecordBatch(size int, generation string, written *Written) *RecordBatch {
+	return &RecordBatch{size: max(size, 1), generation: generation, written: written}
+}
+
+// Add buffers a record, and writes the batch once it is full.
+func (b *RecordBatch) Add(ctx context.Context, annaRecord *anna.Record) error {
+	rows, ok := newRecordRows(annaRecord, b.generation)
+	if !ok {
+		return nil
+	}
+
+	b.mu.Lock()
+	b.rows = append(b.rows, rows)
+	var full []*recordRows
+	if len(b.rows) >= b.size {
+		full, b.rows = b.rows, nil
+		b.copying.RLock()
+	}
+	b.mu.Unlock()
+
+	if full == nil {
+		return nil
+	}
+	defer b.copying.RUnlock()
+	return copyRecords(ctx, full, b.written)
+}
+
+// Flush writes the buffered records, and waits for the full batches being
+// written by Add: every record added before the call is saved on return.
+func (b *RecordBatch) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	rows := b.rows
+	b.rows = nil
+	b.mu.Unlock()
+
+	var err error
+	if len(rows) > 0 {
+		err = copyRecords(ctx, rows, b.written)
+	}
+	b.copying.Lock()
+	b.copying.Unlock()
+	return err
+}
+
+var (
+	stagingRecordColumns = append([]string{"id", "created_at"}, recordColumns...)
+	stagingValueColumns  = []string{"record", "type", "value", "created_at", "updated_at"}
+)
+
+// copyRecords writes records in a single transaction through staging tables.
+// The records that didn't change only get the generation of the sync.
+func copyRecords(ctx context.Context, batch []*recordRows, written *Written) error {
+	now := time.Now()
+
+	// A record can appear twice in a batch, the last one wins as with upserts
+	hashes := make(map[string]string, len(batch))
+	for _, rows := range batch {
+		hashes[rows.record.ID] = rows.record.SourceHash
+	}
+
+	sqlDB, err := writer().DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		tx, err := driverConn.(*stdlib.Conn).Conn().Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		unchanged, err := updateUnchanged(ctx, tx, hashes, batch[0].record.Generation)
+		if err != nil {
+			return fmt.Errorf("failed to update unchanged records: %w", err)
+		}
+
+		index := make(map[string]int, len(batch))
+		var records, identifiers, classifications [][]any
+		var changed []*recordRows
+		for _, rows := range batch {
+			r := rows.record
+			if unchanged[r.ID] {
+				continue
+			}
+			changed = append(changed, rows)
+			row := []any{r.ID, now, r.Title, r.Publisher, r.Author, r.CoverURL, r.Year, []string(r.Languages), r.Description, r.ContentType, r.Extension, r.Filesize, r.MD5, r.AddedAt, r.Series, r.SeriesIndex, r.SearchTitle, r.SearchAuthor, r.SearchPublisher, r.Generation, r.SourceHash, now}
+			if i, ok := index[r.ID]; ok {
+				records[i] = row
+			} else {
+				index[r.ID] = len(records)
+				records = append(records, row)
+			}
+			for _, identifier := range rows.identifiers {
+				identifiers = append(identifiers, []any{identifier.Record, identifier.Type, identifier.Value, now, now})
+			}
+			for _, classification := range rows.classifications {
+				classifications = append(classifications, []any{classification.Record, classification.Type, classification.Value, now, now})
+			}
+		}
+
+		if retainVersions() && len(records) > 0 {
+			ids := make([]string, len(records))
+			for i, row := range records {
+				ids[i] = row[0].(string)
+			}
+			if _, err := tx.Exec(ctx, versionIDsSQL, batch[0].record.Generation, ids); err != nil {
+				return fmt.Errorf("failed to save the previous version of records: %w", err)
+			}
+		}
+
+		updates := make([]string, len(recordColumns))
+		for i, column := range recordColumns {
+			updates[i] = column + " = EXCLUDED." + column
+		}
+		if err := mergeStaging(ctx, tx, "anna_records", stagingRecordColumns, records,
+			"ORDER BY id ON CONFLICT (id) DO UPDATE SET "+strings.Join(updates, ", ")); err != nil {
+			return fmt.Errorf("failed to upsert records: %w", err)
+		}
+
+		// Rows are sorted so that concurrent batches lock them in the same order
+		values := "ORDER BY record, type, value ON CONFLICT (record, type, value) DO UPDATE SET updated_at = EXCLUDED.updated_at"
+		if err := mergeStaging(ctx, tx, "anna_record_identifiers", stagingValueColumns, identifiers, values); err != nil {
+			return fmt.Errorf("failed to upsert identifiers: %w", err)
+		}
+		if err := mergeStaging(ctx, tx, "anna_record_classifications", stagingValueColumns, classifications, values); err != nil {
+			return fmt.Errorf("failed to upsert classifications: %w", err)
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		written.add(changed...)
+		return nil
+	})
+}
+
+// updateUnchanged sets the generation of the stored records whose hash is
+// the one given by ID, and returns their IDs.
+func updateUnchanged(ctx context.Context, tx pgx.Tx, hashes map[string]string, generation string) (map[string]bool, error) {
+	// Sorted as the staging rows, so that concurrent batches lock them in the same order
+	ids := slices.Sorted(maps.Keys(hashes))
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = hashes[id]
+	}
+
+	rows, err := tx.Query(ctx, `UPDATE anna_records AS r SET generation = $3
+		FROM unnest($1::text[], $2::text[]) AS u(id, source_hash)
+		WHERE r.id = u.id AND r.source_hash = u.source_hash
+		RETURNING r.id`, ids, values, generation)
+	if err != nil {
+		return nil, err
+	}
+	unchanged, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(unchanged))
+	for _, id := range unchanged {
+		result[id] = true
+	}
+	return result, nil
+}
+
+// mergeStaging copies rows into a staging table shaped like table, then
+// inserts them into table with the given ORDER BY and ON CONFLICT clauses.
+// Identical rows are merged.
+func mergeStaging(ctx context.Context, tx pgx.Tx, table string, columns []string, rows [][]any, conflict string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	staging := table + "_staging"
+	if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s) ON COMMIT DROP", staging, table)); err != nil {
+		return err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{staging}, columns, pgx.CopyFromRows(rows)); err != nil {
+		return err
+	}
+
+	list := strings.Join(columns, ", ")
+	_, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT DISTINCT %s FROM %s %s", table, list, list, staging, conflict))
+	return err
+}
+
+// Written counts the rows written by a sync by type: records by content
+// type, identifiers and classifications by their own type. It's safe for
+// concurrent use, and a nil Written counts nothing.
+type Written struct {
+	mu     sync.Mutex
+	counts WrittenCounts
+}
+
+// WrittenCounts are the counts of a Written.
+type WrittenCounts struct {
+	Records         map[string]int64 `json:"records"`
+	Identifiers     map[string]int64 `json:"identifiers"`
+	Classifications map[string]int64 `json:"classifications"`
+}
+
+// NewWritten returns an empty Written.
+func NewWritten() *Written {
+	return &Written{counts: WrittenCounts{
+		Records:         map[string]int64{},
+		Identifiers:     map[string]int64{},
+		Classifications: map[string]int64{},
+	}}
+}
+
+func (w *Written) add(batch ...*recordRows) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, rows := range batch {
+		w.counts.Records[rows.record.ContentType]++
+		for _, identifier := range rows.identifiers {
+			w.counts.Identifiers[identifier.Type]++
+		}
+		for _, classification := range rows.classifications {
+			w.counts.Classifications[classification.Type]++
+		}
+	}
+}
+
+// Counts returns a copy of the counts.
+func (w *Written) Counts() WrittenCounts {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return WrittenCounts{
+		Records:         maps.Clone(w.counts.Records),
+		Identifiers:     maps.Clone(w.counts.Identifiers),
+		Classifications: maps.Clone(w.counts.Classifications),
+	}
+}
+
+// SyncedRecordID returns the ID an Anna record is stored with and the hash of
+// its synced values, and false when the record is not synced.
+func SyncedRecordID(annaRecord *anna.Record) (id, sourceHash string, ok bool) {
+	rows, ok := newRecordRows(annaRecord, "")
+	if !ok {
+		return "", "", false
+	}
+	return rows.record.ID, rows.record.SourceHash, true
+}
+
+// NormalizedRecord returns an Anna record as the sync of the given generation
+// stores it, with its identifiers and classifications, and false when the
+// record is not synced.
+func NormalizedRecord(annaRecord *anna.Record, generation string) (*Record, bool) {
+	rows, ok := newRecordRows(annaRecord, generation)
+	if !ok {
+		return nil, false
+	}
+	rows.record.Identifiers = rows.identifiers
+	rows.record.Classifications = rows.classifications
+	return &rows.record, true
+}
+
+// CountExistingRecords returns how many of the records of the given IDs and
+// source hashes are stored, and how many of them are stored with the same
+// hash, which a sync leaves unchanged.
+func CountExistingRecords(ctx context.Context, ids, sourceHashes []string) (existing, unchanged int64, err error) {
+	var counts struct {
+		Existing  int64
+		Unchanged int64
+	}
+	err = DB.WithContext(ctx).Raw(`SELECT count(*) AS existing, count(*) FILTER (WHERE r.source_hash = u.source_hash) AS unchanged
+		FROM anna_records r JOIN unnest(?::text[], ?::text[]) AS u(id, source_hash) ON r.id = u.id`,
+		pq.StringArray(ids), pq.StringArray(sourceHashes)).Scan(&counts).Error
+	return counts.Existing, counts.Unchanged, err
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Page describes which slice of a search result to return.
+//
+// Results are ordered by record ID. When Cursor is set, the page starts right
+// after the record it points to and Offset is ignored: unlike offsets, cursors
+// stay fast on deep pages and do not skip or repeat rows when a sync inserts
+// records between two requests.
+type Page struct {
+	Limit  int
+	Offset int
+	Cursor string
+	// Count selects how the total number of matches is computed, defaults to CountModeExact.
+	Count CountMode
+	// Sort orders the matches, by ID by default. Fuzzy searches are ordered
+	// by similarity.
+	Sort Sort
+}
+
+// Sort describes how searches order their matches.
+type Sort string
+
+const (
+	// SortID orders the records by ID, the only order cursors can page.
+	SortID Sort = "id"
+	// SortAdded orders the records added last to Anna's Archive first.
+	SortAdded Sort = "added"
+)
+
+// Cursors returns whether the page can be followed with a cursor.
+func (p Page) Cursors() bool {
+	return p.Sort != SortAdded
+}
+
+// CountMode describes how searches compute the total number of matches.
+type CountMode string
+
+const (
+	// CountModeExact runs a COUNT(*), which is the slowest part of broad searches.
+	CountModeExact CountMode = "exact"
+	// CountModeEstimated uses the row estimate of the query planner.
+	CountModeEstimated CountMode = "estimated"
+	// CountModeNone skips counting, the total is reported as 0.
+	CountModeNone CountMode = "none"
+)
+
+// EncodeCursor returns the opaque cursor pointing right after the given record ID.
+func EncodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// decodeCursor returns the record ID an opaque cursor points to.
+func decodeCursor(cursor string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(id) == 0 {
+		return "", fmt.Errorf("invalid cursor: %w", errValidation)
+	}
+	return string(id), nil
+}
+
+// NextCursor returns the cursor of the page following the given results, or
+// an empty string if the results do not fill a page (i.e. this is the last one).
+func NextCursor(records []Record, limit int) string {
+	if limit <= 0 || len(records) < limit {
+		return ""
+	}
+	return EncodeCursor(records[len(records)-1].ID)
+}
+
+// apply orders the query and restricts it to the page.
+func (p Page) apply(q *gorm.DB) (*gorm.DB, error) {
+	if !p.Cursors() {
+		if p.Cursor != "" {
+			return nil, fmt.Errorf("cursor pagination is only supported for searches sorted by ID, use offset instead: %w", errValidation)
+		}
+		// Records without date last
+		return q.Order("added_at DESC NULLS LAST").Order("id").Limit(p.Limit).Offset(p.Offset), nil
+	}
+
+	q = q.Order("id").Limit(p.Limit)
+	if p.Cursor == "" {
+		return q.Offset(p.Offset), nil
+	}
+
+	after, err := decodeCursor(p.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	return q.Where("id > ?", after), nil
+}
+
+// count returns the number of rows matched by q according to the count mode.
+// It must be called before the page and the projection are applied.
+func (p Page) count(q *gorm.DB) (int64, error) {
+	switch p.Count {
+	case CountModeNone:
+		return 0, nil
+	case CountModeEstimated:
+		return estimateCount(q)
+	default:
+		var total int64
+		err := q.Count(&total).Error
+		return total, err
+	}
+}
+
+// estimateCount returns the number of rows the query planner expects q to match.
+func estimateCount(q *gorm.DB) (int64, error) {
+	stmt := q.Session(&gorm.Session{DryRun: true}).Find(&[]Record{}).Statement
+
+	var raw []byte
+	if err := q.Statement.ConnPool.
+		QueryRowContext(q.Statement.Context, "EXPLAIN (FORMAT JSON) "+stmt.SQL.String(), stmt.Vars...).
+		Scan(&raw); err != nil {
+		return 0, fmt.Errorf("failed to explain query: %w", err)
+	}
+
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil || len(plans) == 0 {
+		return 0, fmt.Errorf("failed to parse query plan: %w", err)
+	}
+
+	return int64(plans[0].Plan.Rows), nil
+}
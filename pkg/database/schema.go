@@ -20,11 +20,30 @@ type Record struct {
 	Author      string         `json:"author" gorm:"index:idx_record_author_trgm,type:gin,expression:author gin_trgm_ops"`
 	CoverURL    string         `json:"coverURL"`
 	Year        int            `json:"year"`
-	Languages   pq.StringArray `json:"languages" gorm:"type:text[]"`
+	Languages   pq.StringArray `json:"languages" gorm:"type:text[];index:idx_record_languages,type:gin"`
 	Description string         `json:"description,omitempty"`
+	ContentType string         `json:"contentType" gorm:"index"`
+	Extension   string         `json:"extension" gorm:"index;default:epub"`
+	Filesize    int64          `json:"filesize,omitempty"`
+	MD5         string         `json:"md5,omitempty" gorm:"column:md5;index"`
+	AddedAt     *time.Time     `json:"addedAt,omitempty" gorm:"type:date;index"`
+	Series      string         `json:"series,omitempty" gorm:"index:idx_record_series,expression:lower(series)"`
+	SeriesIndex float64        `json:"seriesIndex,omitempty"`
+	// SearchTitle, SearchAuthor and SearchPublisher are the fields as
+	// normalized by Anna's Archive for its search, matched by the full-text
+	// searches instead of the displayed ones when set
+	SearchTitle     string `json:"-"`
+	SearchAuthor    string `json:"-"`
+	SearchPublisher string `json:"-"`
+	// Generation is the base of the last sync that wrote the record
+	Generation string `json:"-" gorm:"index"`
+	// SourceHash is a hash of the synced fields, identifiers and
+	// classifications of the record: a sync doesn't rewrite records whose
+	// source didn't change, nor their identifiers and classifications
+	SourceHash string `json:"-"`
 
-	Identifiers     []RecordIdentifier     `json:"identifiers" gorm:"foreignKey:Record;references:ID"`
-	Classifications []RecordClassification `json:"classifications" gorm:"foreignKey:Record;references:ID"`
+	Identifiers     []RecordIdentifier     `json:"identifiers,omitempty" gorm:"foreignKey:Record;references:ID"`
+	Classifications []RecordClassification `json:"classifications,omitempty" gorm:"foreignKey:Record;references:ID"`
 }
 
 type RecordIdentifier struct {
@@ -57,7 +76,119 @@ type Torrent struct {
 }
 
 type Synchronization struct {
-	Date     time.Time `gorm:"primaryKey;type:timestamptz"`
-	Base     string    // the database used for this sync, e.g.: "aa_derived_mirror_metadata_20240612.torrent"
-	Complete bool
+	Date     time.Time `json:"date" gorm:"primaryKey;type:timestamptz"`
+	Base     string    `json:"base"` // the database used for this sync, e.g.: "aa_derived_mirror_metadata_20240612.torrent"
+	Complete bool      `json:"complete"`
+	// Shard is the part of the metadata files synced, with ANNA_SYNC_SHARD:
+	// the sync of the last shard completing the base is complete
+	Shard string `json:"shard,omitempty" doc:"Shard of the metadata files synced, e.g. 1/4"`
+
+	// StartedAt is zero for the syncs skipped because the base was already synced
+	StartedAt   time.Time `json:"startedAt,omitzero" gorm:"type:timestamptz"`
+	Files       int       `json:"files" doc:"Metadata files processed"`
+	Records     int       `json:"records" doc:"Records read from the metadata files"`
+	ParseErrors int       `json:"parseErrors" doc:"Lines of the metadata files that could not be parsed"`
+	Failed      int64     `json:"failed" doc:"Records, or batches of records, that could not be written"`
+	Error       string    `json:"error,omitempty" doc:"Why the sync failed"`
+	// FailedFiles are the metadata files that could not be processed, the
+	// sync is then partial: the next one resumes the same base
+	Partial     bool           `json:"partial,omitempty"`
+	FailedFiles pq.StringArray `json:"failedFiles,omitempty" gorm:"type:text[]"`
+	// RolledBack is set once the records were restored as they were before
+	// the sync
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// RecordVersion is a record, with its identifiers and classifications, as it
+// was before the sync of Generation changed or deleted it, as JSON rows. Record
+// is null for the records the sync added. Versions are only kept for the last
+// sync, with ANNA_SYNC_RETAIN_PREVIOUS.
+type RecordVersion struct {
+	Generation      string `gorm:"primaryKey"`
+	ID              string `gorm:"primaryKey"`
+	Record          []byte `gorm:"type:jsonb"`
+	Identifiers     []byte `gorm:"type:jsonb"`
+	Classifications []byte `gorm:"type:jsonb"`
+	CreatedAt       time.Time
+}
+
+// RecordTombstone tells that a record was deleted, by a prune or a rollback,
+// for the changes feed to report it.
+type RecordTombstone struct {
+	ID        string    `gorm:"primaryKey"`
+	DeletedAt time.Time `gorm:"not null;index"`
+}
+
+// SyncCheckpoint is the progress of a sync in a metadata file, a sync of the
+// same base interrupted by a restart resumes from it.
+type SyncCheckpoint struct {
+	Base        string `gorm:"primaryKey"`
+	File        string `gorm:"primaryKey"`
+	Lines       int
+	Records     int
+	ParseErrors int
+	Done        bool
+	UpdatedAt   time.Time
+}
+
+// SyncProgress is the progress of the sync of the leader instance, published
+// for the other instances when ANNA_SYNC_LEADER_ELECTION is set. There is a
+// single row, or one per shard with ANNA_SYNC_SHARD.
+type SyncProgress struct {
+	ID        int    `gorm:"primaryKey"`
+	Stats     []byte `gorm:"type:jsonb"`
+	UpdatedAt time.Time
+}
+
+// RecordSource is the source that last served the file of a record, tried
+// first on the next download.
+type RecordSource struct {
+	Record    string `gorm:"primaryKey"`
+	Source    string // a torrent classification or a mirror URL
+	UpdatedAt time.Time
+}
+
+// Download is a file being downloaded, kept to report its progress and
+// resume it after a restart.
+type Download struct {
+	Model
+
+	File           string `gorm:"primaryKey"` // the name of the file in the storage directory
+	Record         string
+	Status         string
+	BytesCompleted int64
+	TotalBytes     int64
+}
+
+// RecordBlob maps a record to the md5 of its downloaded file. Files are
+// stored once per md5, records sharing a file share the stored file.
+type RecordBlob struct {
+	Record    string `gorm:"primaryKey"`
+	Blob      string `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// RecordDownload counts the completed downloads of a record in a day (UTC).
+type RecordDownload struct {
+	Record string    `gorm:"primaryKey"`
+	Day    time.Time `gorm:"primaryKey;type:date;index"`
+	Count  int64
+}
+
+// TenantDownload counts the epubs downloaded by a tenant in a day (UTC).
+type TenantDownload struct {
+	Tenant string    `gorm:"primaryKey"`
+	Day    time.Time `gorm:"primaryKey;type:date"`
+	Count  int
+}
+
+// Webhook is an external endpoint subscribed to API events.
+type Webhook struct {
+	Model
+
+	ID     uint           `json:"id" gorm:"primaryKey"`
+	URL    string         `json:"url"`
+	Secret string         `json:"secret,omitempty"`
+	Events pq.StringArray `json:"events" gorm:"type:text[]"`
+	Active bool           `json:"active"`
 }
@@ -5,11 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/iziplay/anna-api/pkg/isbn"
 	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var errValidation = errors.New("validation error")
@@ -19,16 +26,156 @@ func IsValidationError(err error) bool {
 	return errors.Is(err, errValidation)
 }
 
-// SearchByISBN finds records matching an ISBN10 or ISBN13 value.
-// It also computes the alternate ISBN form and searches for both.
-func SearchByISBN(ctx context.Context, isbnCode string, languages []string, limit, offset int) ([]Record, int64, error) {
-	isbnCode = strings.TrimSpace(isbnCode)
+// LanguageMode describes how SearchFilters.Languages is matched against the record languages.
+type LanguageMode string
+
+const (
+	// LanguageModeExact keeps records whose languages are exactly the given ones.
+	LanguageModeExact LanguageMode = "exact"
+	// LanguageModeAny keeps records having at least one of the given languages.
+	LanguageModeAny LanguageMode = "any"
+	// LanguageModeAll keeps records having all of the given languages, and possibly others.
+	LanguageModeAll LanguageMode = "all"
+)
+
+// SearchFilters holds the filters shared by every search function.
+type SearchFilters struct {
+	// Languages keeps records matching the given languages according to LanguageMode.
+	Languages []string
+	// LanguageMode defaults to LanguageModeExact.
+	LanguageMode LanguageMode
+	// ContentTypes keeps records of one of the given content types (e.g. "book_fiction").
+	ContentTypes []string
+	// ExcludeContentTypes drops records of any of the given content types (e.g. "magazine").
+	ExcludeContentTypes []string
+	// Formats keeps records of one of the given file extensions (e.g. "pdf").
+	Formats []string
+	// Series keeps records of the given series (case-insensitive).
+	Series string
+	// ClassificationType and ClassificationValue keep records having a matching
+	// classification (e.g. "ddc" and "823.912"). Either one can be used alone.
+	ClassificationType  string
+	ClassificationValue string
+	// AddedAfter and AddedBefore keep records added to Anna's Archive on or
+	// after, and before, the given dates when not zero.
+	AddedAfter  time.Time
+	AddedBefore time.Time
+}
+
+// apply adds the filters to a query on the records table.
+func (f SearchFilters) apply(q *gorm.DB) *gorm.DB {
+	if len(f.Languages) > 0 {
+		// && and @> are served by the GIN index on languages
+		switch f.LanguageMode {
+		case LanguageModeAny:
+			q = q.Where("languages && ?", pq.StringArray(f.Languages))
+		case LanguageModeAll:
+			q = q.Where("languages @> ?", pq.StringArray(f.Languages))
+		default:
+			q = q.Where("languages = ?", pq.StringArray(f.Languages))
+		}
+	}
+	if len(f.ContentTypes) > 0 {
+		q = q.Where("content_type IN ?", f.ContentTypes)
+	}
+	if len(f.ExcludeContentTypes) > 0 {
+		// Records synced before content types were stored have none
+		q = q.Where("(content_type IS NULL OR content_type NOT IN ?)", f.ExcludeContentTypes)
+	}
+	if len(f.Formats) > 0 {
+		q = q.Where("extension IN ?", f.Formats)
+	}
+	if !f.AddedAfter.IsZero() {
+		q = q.Where("added_at >= ?", f.AddedAfter)
+	}
+	if !f.AddedBefore.IsZero() {
+		q = q.Where("added_at < ?", f.AddedBefore)
+	}
+	if f.Series != "" {
+		q = q.Where("lower(series) = lower(?)", f.Series)
+	}
+	if f.ClassificationType != "" || f.ClassificationValue != "" {
+		sub := DB.Model(&RecordClassification{}).Select("1").Where("anna_record_classifications.record = anna_records.id")
+		if f.ClassificationType != "" {
+			sub = sub.Where("anna_record_classifications.type = ?", f.ClassificationType)
+		}
+		if f.ClassificationValue != "" {
+			sub = sub.Where("anna_record_classifications.value = ?", f.ClassificationValue)
+		}
+		q = q.Where("EXISTS (?)", sub)
+	}
+	return q
+}
+
+// projectionColumns maps the JSON field names of Record to their column.
+var projectionColumns = map[string]string{
+	"id":          "id",
+	"createdAt":   "created_at",
+	"updatedAt":   "updated_at",
+	"title":       "title",
+	"publisher":   "publisher",
+	"author":      "author",
+	"coverURL":    "cover_url",
+	"year":        "year",
+	"languages":   "languages",
+	"description": "description",
+	"contentType": "content_type",
+	"extension":   "extension",
+	"filesize":    "filesize",
+	"md5":         "md5",
+	"addedAt":     "added_at",
+	"series":      "series",
+	"seriesIndex": "series_index",
+}
+
+// projectionRelations maps the JSON field names of Record relations to their GORM association.
+var projectionRelations = map[string]string{
+	"identifiers":     "Identifiers",
+	"classifications": "Classifications",
+}
+
+// Projection selects which record fields a search loads.
+type Projection struct {
+	// Fields lists the JSON field names to load (e.g. "title", "coverURL",
+	// "identifiers"). The ID is always loaded. An empty list loads everything.
+	Fields []string
+}
+
+// apply restricts the selected columns and preloaded relations of a query on the records table.
+// It must be called after counting, since GORM cannot count a multi-column select.
+func (p Projection) apply(q *gorm.DB) (*gorm.DB, error) {
+	if len(p.Fields) == 0 {
+		return q.Preload("Identifiers").Preload("Classifications"), nil
+	}
+
+	columns := []string{"id"}
+	for _, field := range p.Fields {
+		if column, ok := projectionColumns[field]; ok {
+			if column != "id" {
+				columns = append(columns, column)
+			}
+			continue
+		}
+		if relation, ok := projectionRelations[field]; ok {
+			q = q.Preload(relation)
+			continue
+		}
+		return nil, fmt.Errorf("unknown field %q: %w", field, errValidation)
+	}
+
+	return q.Select(columns), nil
+}
 
+// MaxISBNs is the maximum number of ISBNs that SearchByISBNs resolves at once.
+const MaxISBNs = 100
+
+// isbnVariants validates an ISBN10 or ISBN13 code and returns it along with its
+// alternate form, when there is one.
+func isbnVariants(isbnCode string) ([]string, error) {
 	if len(isbnCode) != 10 && len(isbnCode) != 13 {
-		return nil, 0, fmt.Errorf("invalid ISBN length: expected 10 or 13 characters, got %d: %w", len(isbnCode), errValidation)
+		return nil, fmt.Errorf("invalid ISBN length for %q: expected 10 or 13 characters, got %d: %w", isbnCode, len(isbnCode), errValidation)
 	}
 
-	// Build the set of ISBNs to search for
 	isbns := []string{isbnCode}
 	if len(isbnCode) == 10 {
 		if isbn13 := isbn.To13(isbnCode); isbn13 != "" {
@@ -39,17 +186,139 @@ func SearchByISBN(ctx context.Context, isbnCode string, languages []string, limi
 			isbns = append(isbns, isbn10)
 		}
 	}
+	return isbns, nil
+}
 
-	slog.DebugContext(ctx, "Searching by ISBN", "input", isbnCode, "search_isbns", isbns, "languages", languages, "limit", limit, "offset", offset)
+// SearchByISBN finds records matching an ISBN10 or ISBN13 value.
+// It also computes the alternate ISBN form and searches for both.
+func SearchByISBN(ctx context.Context, isbnCode string, filters SearchFilters, page Page, projection Projection) ([]Record, int64, error) {
+	records, total, _, err := SearchByISBNs(ctx, []string{isbnCode}, filters, page, projection)
+	return records, total, err
+}
+
+// SearchByISBNs finds records matching any of the given ISBN10 or ISBN13 values,
+// e.g. a batch of scanned barcodes. Besides the page of records, it returns the
+// IDs of all the records matching each input ISBN.
+func SearchByISBNs(ctx context.Context, isbnCodes []string, filters SearchFilters, page Page, projection Projection) ([]Record, int64, map[string][]string, error) {
+	if len(isbnCodes) == 0 {
+		return nil, 0, nil, fmt.Errorf("at least one ISBN is required: %w", errValidation)
+	}
+	if len(isbnCodes) > MaxISBNs {
+		return nil, 0, nil, fmt.Errorf("too many ISBNs: expected at most %d, got %d: %w", MaxISBNs, len(isbnCodes), errValidation)
+	}
+
+	// Build the set of ISBNs to search for, remembering which inputs each one comes from
+	inputs := make(map[string][]string)
+	var isbns []string
+	for _, isbnCode := range isbnCodes {
+		isbnCode = strings.TrimSpace(isbnCode)
+		variants, err := isbnVariants(isbnCode)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		for _, variant := range variants {
+			if _, ok := inputs[variant]; !ok {
+				isbns = append(isbns, variant)
+			}
+			inputs[variant] = append(inputs[variant], isbnCode)
+		}
+	}
+
+	slog.DebugContext(ctx, "Searching by ISBN", "input", isbnCodes, "search_isbns", isbns, "filters", filters, "page", page)
 
 	var identifiers []RecordIdentifier
 	if err := DB.
 		WithContext(ctx).
 		Where("type IN ? AND value IN ?", []string{"isbn10", "isbn13"}, isbns).
 		Find(&identifiers).Error; err != nil {
+		return nil, 0, nil, err
+	}
+
+	records, total, err := findRecordsByIdentifiers(ctx, identifiers, filters, page, projection)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	matches, err := isbnMatches(ctx, identifiers, inputs, filters)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	// List ISBNs without matches too, so that callers can tell which ones are unknown
+	for _, variants := range inputs {
+		for _, input := range variants {
+			if _, ok := matches[input]; !ok {
+				matches[input] = []string{}
+			}
+		}
+	}
+
+	return records, total, matches, nil
+}
+
+// isbnMatches groups the IDs of the records owning the given identifiers by
+// input ISBN, leaving out the records excluded by the filters.
+func isbnMatches(ctx context.Context, identifiers []RecordIdentifier, inputs map[string][]string, filters SearchFilters) (map[string][]string, error) {
+	matches := make(map[string][]string)
+	if len(identifiers) == 0 {
+		return matches, nil
+	}
+
+	recordIDs := make([]string, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		recordIDs = append(recordIDs, identifier.Record)
+	}
+
+	var kept []string
+	if err := filters.apply(DB.WithContext(ctx).Model(&Record{}).Where("id IN ?", recordIDs)).Pluck("id", &kept).Error; err != nil {
+		return nil, err
+	}
+	keptSet := make(map[string]struct{}, len(kept))
+	for _, id := range kept {
+		keptSet[id] = struct{}{}
+	}
+
+	for _, identifier := range identifiers {
+		if _, ok := keptSet[identifier.Record]; !ok {
+			continue
+		}
+		for _, input := range inputs[identifier.Value] {
+			if !slices.Contains(matches[input], identifier.Record) {
+				matches[input] = append(matches[input], identifier.Record)
+			}
+		}
+	}
+	for _, ids := range matches {
+		sort.Strings(ids)
+	}
+
+	return matches, nil
+}
+
+// SearchByIdentifier finds records having an identifier of the given type and value
+// (e.g. "oclc" and "1234567").
+func SearchByIdentifier(ctx context.Context, identifierType, value string, filters SearchFilters, page Page, projection Projection) ([]Record, int64, error) {
+	identifierType = strings.ToLower(strings.TrimSpace(identifierType))
+	value = strings.TrimSpace(value)
+
+	if identifierType == "" || value == "" {
+		return nil, 0, fmt.Errorf("identifier type and value are required: %w", errValidation)
+	}
+
+	slog.DebugContext(ctx, "Searching by identifier", "type", identifierType, "value", value, "filters", filters, "page", page)
+
+	var identifiers []RecordIdentifier
+	if err := DB.
+		WithContext(ctx).
+		Where("type = ? AND value = ?", identifierType, value).
+		Find(&identifiers).Error; err != nil {
 		return nil, 0, err
 	}
 
+	return findRecordsByIdentifiers(ctx, identifiers, filters, page, projection)
+}
+
+// findRecordsByIdentifiers loads the records owning the given identifiers.
+func findRecordsByIdentifiers(ctx context.Context, identifiers []RecordIdentifier, filters SearchFilters, page Page, projection Projection) ([]Record, int64, error) {
 	if len(identifiers) == 0 {
 		return []Record{}, 0, nil
 	}
@@ -66,20 +335,25 @@ func SearchByISBN(ctx context.Context, isbnCode string, languages []string, limi
 
 	q := DB.Model(&Record{}).WithContext(ctx).Where("id IN ?", recordIDs)
 
-	if len(languages) > 0 {
-		q = q.Where("languages = ?", pq.StringArray(languages))
+	q = filters.apply(q)
+
+	total, err := page.count(q)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	var total int64
-	q.Count(&total)
+	q, err = page.apply(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q, err = projection.apply(q)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	var records []Record
-	if err := q.
-		Preload("Identifiers").
-		Preload("Classifications").
-		Limit(limit).
-		Offset(offset).
-		Find(&records).Error; err != nil {
+	if err := q.Find(&records).Error; err != nil {
 		return nil, 0, err
 	}
 
@@ -109,31 +383,337 @@ func ftsQuery(input string) string {
 	return strings.Join(parts, " & ")
 }
 
-// SearchByText finds records matching the given title, author, and/or publisher
-// filters (AND logic) using PostgreSQL full-text search for fast lookups.
-func SearchByText(ctx context.Context, title, author, publisher string, languages []string, limit, offset int) ([]Record, int64, error) {
+// DefaultFuzzyThreshold is the word similarity threshold used by fuzzy text
+// searches when none is given.
+const DefaultFuzzyThreshold = 0.3
+
+// TextQuery holds the criteria of a text search.
+type TextQuery struct {
+	Title       string
+	Author      string
+	Publisher   string
+	Description string
+
+	// Fuzzy switches from full-text matching to trigram word similarity,
+	// which tolerates typos ("Tolkein") at the cost of precision.
+	Fuzzy bool
+	// Threshold is the minimum word similarity (0-1) for fuzzy matches,
+	// DefaultFuzzyThreshold when nil. 0 matches anything.
+	Threshold *float64
+}
+
+// SearchByText finds records matching the given title, author, publisher and/or
+// description filters (AND logic) using PostgreSQL full-text search for fast
+// lookups, or trigram similarity when query.Fuzzy is set. Descriptions are
+// always matched with full-text search, they are too long for trigrams.
+func SearchByText(ctx context.Context, query TextQuery, filters SearchFilters, page Page, projection Projection) ([]Record, int64, error) {
+	if strings.TrimSpace(query.Title+query.Author+query.Publisher+query.Description) == "" {
+		return nil, 0, fmt.Errorf("at least one of title, author, publisher or description is required: %w", errValidation)
+	}
+
+	if query.Fuzzy {
+		return searchByTextFuzzy(ctx, query, filters, page, projection)
+	}
+
 	q := DB.WithContext(ctx).Model(&Record{})
 
-	if tsq := ftsQuery(title); tsq != "" {
-		q = q.Where("to_tsvector('simple_unaccent', coalesce(title, '')) @@ to_tsquery('simple_unaccent', ?)", tsq)
+	if tsq := ftsQuery(query.Title); tsq != "" {
+		q = q.Where("to_tsvector('simple_unaccent', "+ftsTitle+") @@ to_tsquery('simple_unaccent', ?)", tsq)
 	}
-	if tsq := ftsQuery(author); tsq != "" {
-		q = q.Where("to_tsvector('simple_unaccent', coalesce(author, '')) @@ to_tsquery('simple_unaccent', ?)", tsq)
+	if tsq := ftsQuery(query.Author); tsq != "" {
+		q = q.Where("to_tsvector('simple_unaccent', "+ftsAuthor+") @@ to_tsquery('simple_unaccent', ?)", tsq)
 	}
-	if tsq := ftsQuery(publisher); tsq != "" {
-		q = q.Where("to_tsvector('simple_unaccent', coalesce(publisher, '')) @@ to_tsquery('simple_unaccent', ?)", tsq)
+	if tsq := ftsQuery(query.Publisher); tsq != "" {
+		q = q.Where("to_tsvector('simple_unaccent', "+ftsPublisher+") @@ to_tsquery('simple_unaccent', ?)", tsq)
 	}
-	if len(languages) > 0 {
-		q = q.Where("languages = ?", pq.StringArray(languages))
+	q = whereDescription(q, query.Description)
+	q = filters.apply(q)
+
+	total, err := page.count(q)
+	if err != nil {
+		return nil, 0, err
 	}
 
+	q, err = page.apply(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q, err = projection.apply(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []Record
+	if err := q.Find(&records).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return records, total, nil
+}
+
+// searchByTextFuzzy matches each filter with the pg_trgm "%>" word similarity
+// operator, which is served by the trigram indexes, and orders results by
+// decreasing similarity.
+func searchByTextFuzzy(ctx context.Context, query TextQuery, filters SearchFilters, page Page, projection Projection) ([]Record, int64, error) {
+	if page.Cursor != "" {
+		return nil, 0, fmt.Errorf("cursor pagination is not supported for fuzzy searches, use offset instead: %w", errValidation)
+	}
+
+	threshold := DefaultFuzzyThreshold
+	if query.Threshold != nil {
+		threshold = *query.Threshold
+		if threshold < 0 || threshold > 1 {
+			return nil, 0, fmt.Errorf("threshold must be between 0 and 1: %w", errValidation)
+		}
+	}
+
+	var records []Record
+	var total int64
+
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The %> operator reads its threshold from this setting, scoped to the transaction.
+		if err := tx.Exec("SELECT set_config('pg_trgm.word_similarity_threshold', ?, true)", strconv.FormatFloat(threshold, 'f', -1, 64)).Error; err != nil {
+			return fmt.Errorf("failed to set similarity threshold: %w", err)
+		}
+
+		q := tx.Model(&Record{})
+
+		var scores []string
+		var scoreVars []any
+		for _, filter := range []struct{ column, value string }{
+			{"title", query.Title},
+			{"author", query.Author},
+			{"publisher", query.Publisher},
+		} {
+			value := strings.TrimSpace(filter.value)
+			if value == "" {
+				continue
+			}
+			q = q.Where(filter.column+" %> ?", value)
+			scores = append(scores, "word_similarity(?, "+filter.column+")")
+			scoreVars = append(scoreVars, value)
+		}
+		q = whereDescription(q, query.Description)
+		q = filters.apply(q)
+
+		var err error
+		if total, err = page.count(q); err != nil {
+			return err
+		}
+
+		if len(scores) > 0 {
+			q = q.Order(clause.OrderBy{Expression: clause.Expr{
+				SQL:                strings.Join(scores, " + ") + " DESC",
+				Vars:               scoreVars,
+				WithoutParentheses: true,
+			}})
+		}
+
+		if q, err = projection.apply(q); err != nil {
+			return err
+		}
+
+		return q.
+			Order("id").
+			Limit(page.Limit).
+			Offset(page.Offset).
+			Find(&records).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return records, total, nil
+}
+
+// whereDescription restricts q to records whose description matches every word of description.
+func whereDescription(q *gorm.DB, description string) *gorm.DB {
+	if tsq := ftsQuery(description); tsq != "" {
+		q = q.Where("to_tsvector('simple_unaccent', coalesce(description, '')) @@ to_tsquery('simple_unaccent', ?)", tsq)
+	}
+	return q
+}
+
+// The full-text searches match the fields normalized by Anna's Archive for its
+// search, or the displayed ones for the records synced before they were
+// stored. The indexes are built on the same expressions.
+const (
+	ftsTitle     = "coalesce(nullif(search_title, ''), title, '')"
+	ftsAuthor    = "coalesce(nullif(search_author, ''), author, '')"
+	ftsPublisher = "coalesce(nullif(search_publisher, ''), publisher, '')"
+)
+
+// SearchByQuery finds records whose title and author, taken together, match
+// every word of the query. "tolkien hobbit" matches a record titled
+// "The Hobbit" written by J.R.R. Tolkien.
+func SearchByQuery(ctx context.Context, query string, filters SearchFilters, page Page, projection Projection) ([]Record, int64, error) {
+	tsq := ftsQuery(query)
+	if tsq == "" {
+		return []Record{}, 0, nil
+	}
+
+	q := DB.WithContext(ctx).Model(&Record{}).
+		Where("to_tsvector('simple_unaccent', "+ftsTitle+" || ' ' || "+ftsAuthor+") @@ to_tsquery('simple_unaccent', ?)", tsq)
+	q = filters.apply(q)
+
+	total, err := page.count(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q, err = page.apply(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q, err = projection.apply(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []Record
+	if err := q.Find(&records).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return records, total, nil
+}
+
+// SearchMode describes which search strategy was used by Search.
+type SearchMode string
+
+const (
+	SearchModeISBN       SearchMode = "isbn"
+	SearchModeIdentifier SearchMode = "identifier"
+	SearchModeText       SearchMode = "text"
+)
+
+// identifierPattern matches "type:value" queries such as "oclc:1234567" or "md5:abc123".
+var identifierPattern = regexp.MustCompile(`^([a-z0-9_]+):(\S+)$`)
+
+// normalizeISBN strips hyphens and spaces from the query and returns it if it
+// looks like an ISBN10 or ISBN13, or an empty string otherwise.
+func normalizeISBN(query string) string {
+	candidate := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(query))
+	if len(candidate) != 10 && len(candidate) != 13 {
+		return ""
+	}
+	for i, r := range candidate {
+		if r >= '0' && r <= '9' {
+			continue
+		}
+		if r == 'X' && len(candidate) == 10 && i == 9 {
+			continue
+		}
+		return ""
+	}
+	return candidate
+}
+
+// Search inspects a free-form query and dispatches it to the most relevant search:
+// ISBNs go to SearchByISBN, "type:value" queries go to SearchByIdentifier and
+// everything else goes to SearchByQuery. Identifier queries without results fall
+// back to a text search, since titles may legitimately contain a colon.
+func Search(ctx context.Context, query string, filters SearchFilters, page Page, projection Projection) ([]Record, int64, SearchMode, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, 0, "", fmt.Errorf("query must not be empty: %w", errValidation)
+	}
+
+	if code := normalizeISBN(query); code != "" {
+		records, total, err := SearchByISBN(ctx, code, filters, page, projection)
+		return records, total, SearchModeISBN, err
+	}
+
+	if matches := identifierPattern.FindStringSubmatch(strings.ToLower(query)); matches != nil {
+		// Keep the original casing of the value, only the type is case-insensitive
+		value := query[len(matches[1])+1:]
+		records, total, err := SearchByIdentifier(ctx, matches[1], value, filters, page, projection)
+		if err != nil || total > 0 || len(records) > 0 {
+			return records, total, SearchModeIdentifier, err
+		}
+	}
+
+	records, total, err := SearchByQuery(ctx, query, filters, page, projection)
+	return records, total, SearchModeText, err
+}
+
+// MaxSuggestions is the maximum number of suggestions returned by Suggest.
+const MaxSuggestions = 10
+
+// Suggestion is a title or author completion for a partial query.
+type Suggestion struct {
+	Type  string  `json:"type" enum:"title,author"`
+	Value string  `json:"value"`
+	Score float64 `json:"score"`
+}
+
+// likeEscaper escapes the LIKE wildcards of user input.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Suggest returns the titles and authors containing the given partial query,
+// most similar first. Matching uses ILIKE, which is served by the trigram indexes.
+func Suggest(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []Suggestion{}, nil
+	}
+	if limit <= 0 || limit > MaxSuggestions {
+		limit = MaxSuggestions
+	}
+
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	suggestions := make([]Suggestion, 0, 2*limit)
+	for _, column := range []string{"title", "author"} {
+		var found []Suggestion
+		if err := DB.WithContext(ctx).
+			Model(&Record{}).
+			Select("? AS type, "+column+" AS value, similarity("+column+", ?) AS score", column, query).
+			Where(column+" ILIKE ?", pattern).
+			Group(column).
+			Order("score DESC").
+			Limit(limit).
+			Scan(&found).Error; err != nil {
+			return nil, fmt.Errorf("failed to suggest %ss: %w", column, err)
+		}
+		suggestions = append(suggestions, found...)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Score > suggestions[j].Score
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
+
+// ListSeries returns the records of a series (case-insensitive) ordered by
+// their position in the series.
+func ListSeries(ctx context.Context, name string, filters SearchFilters, projection Projection, limit, offset int) ([]Record, int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, 0, fmt.Errorf("series name is required: %w", errValidation)
+	}
+	filters.Series = name
+
+	q := filters.apply(DB.WithContext(ctx).Model(&Record{}))
+
 	var total int64
 	q.Count(&total)
 
+	q, err := projection.apply(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	var records []Record
 	if err := q.
-		Preload("Identifiers").
-		Preload("Classifications").
+		Order("series_index").
+		Order("id").
 		Limit(limit).
 		Offset(offset).
 		Find(&records).Error; err != nil {
@@ -143,7 +723,8 @@ func SearchByText(ctx context.Context, title, author, publisher string, language
 	return records, total, nil
 }
 
-// GetRecordByID returns a single record by its ID, or nil if not found.
+// GetRecordByID returns a single record by its ID with its identifiers and classifications.
+// It returns gorm.ErrRecordNotFound if no record matches.
 func GetRecordByID(ctx context.Context, id string) (*Record, error) {
 	var record Record
 	if err := DB.
@@ -157,14 +738,110 @@ func GetRecordByID(ctx context.Context, id string) (*Record, error) {
 	return &record, nil
 }
 
-// RecordDownloadInfo contains the information needed to download a record's file.
-type RecordDownloadInfo struct {
+// GetRecordsByIDs returns the records matching the given IDs, in the same order.
+// Unknown IDs are skipped.
+func GetRecordsByIDs(ctx context.Context, ids []string) ([]Record, error) {
+	var found []Record
+	if err := DB.
+		WithContext(ctx).
+		Preload("Identifiers").
+		Preload("Classifications").
+		Where("id IN ?", ids).
+		Find(&found).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]Record, len(found))
+	for _, record := range found {
+		byID[record.ID] = record
+	}
+	records := make([]Record, 0, len(found))
+	for _, id := range ids {
+		if record, ok := byID[id]; ok {
+			records = append(records, record)
+			delete(byID, id)
+		}
+	}
+	return records, nil
+}
+
+// DownloadSource is a torrent holding the file of a record.
+type DownloadSource struct {
 	TorrentClassification string // e.g., "managed_by_aa/zlib/pilimi-zlib-6160000-7229999.torrent"
 	ServerPath            string // e.g., "g5/zlib1/zlib1/pilimi-zlib-6160000-7229999/7225029"
 }
 
-// GetRecordDownloadInfo retrieves the torrent classification and server_path for downloading a record's file.
+// RecordDownloadInfo contains the information needed to download a record's file.
+type RecordDownloadInfo struct {
+	// Sources are the torrents holding the file, the one that last served it first
+	Sources []DownloadSource
+	// LastSource is the source that last served the file, empty when unknown
+	LastSource string
+	Extension  string // e.g., "epub"
+	Filesize   int64  // 0 when unknown
+	// Blob is the md5 of the file, empty when unknown, see GetRecordBlob
+	Blob string
+	// Identifiers holds the first value of each identifier type of the record, to build mirror URLs
+	Identifiers map[string]string
+}
+
+// GetRecordExtension returns the file extension of a record (e.g. "epub").
+func GetRecordExtension(ctx context.Context, id string) (string, error) {
+	var record Record
+	if err := DB.WithContext(ctx).Select("extension").Where("id = ?", id).Take(&record).Error; err != nil {
+		return "", fmt.Errorf("record lookup failed: %w", err)
+	}
+	return record.Extension, nil
+}
+
+// GetRecordBlob returns the md5 of the file of a record: the one of the file
+// downloaded for it if any, else its md5 ID or column, or its identifier for
+// the records not synced since the column was added. It is empty when
+// unknown.
+func GetRecordBlob(ctx context.Context, id string) (string, error) {
+	var blob RecordBlob
+	if err := DB.WithContext(ctx).Where("record = ?", id).Limit(1).Find(&blob).Error; err != nil {
+		return "", fmt.Errorf("blob lookup failed: %w", err)
+	}
+	if blob.Blob != "" {
+		return blob.Blob, nil
+	}
+	if md5, ok := strings.CutPrefix(id, "md5:"); ok {
+		return md5, nil
+	}
+	var record Record
+	if err := DB.WithContext(ctx).Select("md5").Where("id = ?", id).Limit(1).Find(&record).Error; err != nil {
+		return "", fmt.Errorf("record lookup failed: %w", err)
+	}
+	if record.MD5 != "" {
+		return record.MD5, nil
+	}
+	var identifier RecordIdentifier
+	if err := DB.WithContext(ctx).Where("record = ? AND type = ?", id, "md5").Limit(1).Find(&identifier).Error; err != nil {
+		return "", fmt.Errorf("identifier lookup failed: %w", err)
+	}
+	return identifier.Value, nil
+}
+
+// SetRecordBlob records the md5 of the file downloaded for a record.
+func SetRecordBlob(ctx context.Context, id, blob string) error {
+	err := DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "record"}},
+		DoUpdates: clause.AssignmentColumns([]string{"blob"}),
+	}).Create(&RecordBlob{Record: id, Blob: blob}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record blob: %w", err)
+	}
+	return nil
+}
+
+// GetRecordDownloadInfo retrieves the torrent classifications and server_paths for downloading a record's file.
 func GetRecordDownloadInfo(ctx context.Context, id string) (*RecordDownloadInfo, error) {
+	var record Record
+	if err := DB.WithContext(ctx).Select("extension", "filesize").Where("id = ?", id).Take(&record).Error; err != nil {
+		return nil, fmt.Errorf("record lookup failed: %w", err)
+	}
+
 	var torrentClasses []RecordClassification
 	if err := DB.WithContext(ctx).Where("record = ? AND type = ?", id, "torrent").Find(&torrentClasses).Error; err != nil {
 		return nil, fmt.Errorf("torrent classifications lookup failed: %w", err)
@@ -173,15 +850,31 @@ func GetRecordDownloadInfo(ctx context.Context, id string) (*RecordDownloadInfo,
 		return nil, fmt.Errorf("no torrent classifications found")
 	}
 
+	var identifiers []RecordIdentifier
+	if err := DB.WithContext(ctx).Where("record = ?", id).Find(&identifiers).Error; err != nil {
+		return nil, fmt.Errorf("identifiers lookup failed: %w", err)
+	}
+	identifierValues := make(map[string]string, len(identifiers))
 	var serverPathIdents []RecordIdentifier
-	if err := DB.WithContext(ctx).Where("record = ? AND type = ?", id, "server_path").Find(&serverPathIdents).Error; err != nil {
-		return nil, fmt.Errorf("server_path identifiers lookup failed: %w", err)
+	for _, identifier := range identifiers {
+		if identifier.Type == "server_path" {
+			serverPathIdents = append(serverPathIdents, identifier)
+		}
+		if _, ok := identifierValues[identifier.Type]; !ok {
+			identifierValues[identifier.Type] = identifier.Value
+		}
 	}
 	if len(serverPathIdents) == 0 {
 		return nil, fmt.Errorf("no server_path identifiers found")
 	}
 
-	// Try to find a matching pair where the server path contains the torrent filename (without extension)
+	blob, err := GetRecordBlob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	info := &RecordDownloadInfo{Extension: record.Extension, Filesize: record.Filesize, Blob: blob, Identifiers: identifierValues}
+
+	// Find the matching pairs where the server path contains the torrent filename (without extension)
 	for _, tc := range torrentClasses {
 		// tc.Value example: "managed_by_aa/zlib/pilimi-zlib-6160000-7229999.torrent"
 
@@ -194,19 +887,44 @@ func GetRecordDownloadInfo(ctx context.Context, id string) (*RecordDownloadInfo,
 			// sp.Value example: "g5/zlib1/zlib1/pilimi-zlib-6160000-7229999/7225029"
 
 			if strings.Contains(sp.Value, baseName) {
-				return &RecordDownloadInfo{
-					TorrentClassification: tc.Value,
-					ServerPath:            sp.Value,
-				}, nil
+				info.Sources = append(info.Sources, DownloadSource{TorrentClassification: tc.Value, ServerPath: sp.Value})
+				break
 			}
 		}
 	}
 
-	// Fallback to the first available pair if no clear match is found
-	return &RecordDownloadInfo{
-		TorrentClassification: torrentClasses[0].Value,
-		ServerPath:            serverPathIdents[0].Value,
-	}, nil
+	if len(info.Sources) == 0 {
+		// Fallback to the first available pair if no clear match is found
+		info.Sources = []DownloadSource{{TorrentClassification: torrentClasses[0].Value, ServerPath: serverPathIdents[0].Value}}
+	}
+
+	// Try the source that served the file last time first
+	var recorded RecordSource
+	if err := DB.WithContext(ctx).Where("record = ?", id).Take(&recorded).Error; err == nil {
+		info.LastSource = recorded.Source
+		if i := slices.IndexFunc(info.Sources, func(source DownloadSource) bool { return source.TorrentClassification == recorded.Source }); i > 0 {
+			source := info.Sources[i]
+			info.Sources = slices.Delete(info.Sources, i, i+1)
+			info.Sources = slices.Insert(info.Sources, 0, source)
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("record source lookup failed: %w", err)
+	}
+
+	return info, nil
+}
+
+// SetRecordSource records the source that served the file of a record, a
+// torrent classification or a mirror URL.
+func SetRecordSource(ctx context.Context, id, source string) error {
+	err := DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "record"}},
+		DoUpdates: clause.AssignmentColumns([]string{"source", "updated_at"}),
+	}).Create(&RecordSource{Record: id, Source: source}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record source: %w", err)
+	}
+	return nil
 }
 
 // GetTorrentByClassification finds a torrent whose URL ends with the given classification value.
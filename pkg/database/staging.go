@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// stagingSchema holds the copy of the record tables a sync writes to
+	stagingSchema = "anna_staging"
+	// previousSchema holds the record tables replaced by a swap until they
+	// are dropped
+	previousSchema = "anna_previous"
+)
+
+// stagedTables are the tables written by the syncs, in the order they are
+// created: the identifiers and classifications reference the records.
+var stagedTables = []string{"anna_records", "anna_record_identifiers", "anna_record_classifications"}
+
+var (
+	// staging is set while the syncs write to the staging tables
+	staging atomic.Bool
+
+	stagingOnce sync.Once
+	stagingDB   *gorm.DB
+	stagingErr  error
+)
+
+// writer returns the database the syncs write the records to: the staging
+// tables between BeginStaging and EndStaging, the tables otherwise.
+func writer() *gorm.DB {
+	if staging.Load() {
+		return stagingDB
+	}
+	return DB
+}
+
+// BeginStaging makes the syncs write to a copy of the record tables in the
+// staging schema, swapped with the tables by SwapStaging. The copy made for a
+// generation is reused to resume its sync, any other is replaced.
+func BeginStaging(ctx context.Context, generation string) error {
+	// Unqualified table names resolve to the staging tables first
+	stagingOnce.Do(func() {
+		stagingDB, stagingErr = open(stagingSchema + ",public")
+	})
+	if stagingErr != nil {
+		return fmt.Errorf("failed to connect to the staging schema: %w", stagingErr)
+	}
+
+	var current string
+	err := DB.WithContext(ctx).Raw("SELECT coalesce(obj_description(oid, 'pg_namespace'), '') FROM pg_namespace WHERE nspname = ?", stagingSchema).Scan(&current).Error
+	if err != nil {
+		return err
+	}
+	if current == generation {
+		slog.Info("Resuming the sync in the staging tables", "base", generation)
+		staging.Store(true)
+		return nil
+	}
+
+	slog.Info("Copying the record tables to the staging schema", "base", generation)
+	err = stagingDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DROP SCHEMA IF EXISTS " + stagingSchema + " CASCADE").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("CREATE SCHEMA " + stagingSchema).Error; err != nil {
+			return err
+		}
+		// Indexes are built once the rows are copied, much faster than along
+		for _, table := range stagedTables {
+			if err := tx.Exec(fmt.Sprintf("CREATE TABLE %s.%s (LIKE public.%s INCLUDING ALL EXCLUDING INDEXES)", stagingSchema, table, table)).Error; err != nil {
+				return fmt.Errorf("failed to create the staging table %s: %w", table, err)
+			}
+			if err := tx.Exec(fmt.Sprintf("INSERT INTO %s.%s SELECT * FROM public.%s", stagingSchema, table, table)).Error; err != nil {
+				return fmt.Errorf("failed to copy %s: %w", table, err)
+			}
+		}
+		for _, table := range stagedTables {
+			if err := copyIndexes(ctx, tx, table); err != nil {
+				return fmt.Errorf("failed to index the staging table %s: %w", table, err)
+			}
+		}
+		// Set last: an interrupted copy is started over
+		return tx.Exec(fmt.Sprintf("COMMENT ON SCHEMA %s IS %s", stagingSchema, quoteLiteral(generation))).Error
+	})
+	if err != nil {
+		return err
+	}
+	staging.Store(true)
+	return nil
+}
+
+// copyIndexes creates on the staging table the constraints and indexes of the
+// public one, with the same names. They are read through DB, so that the
+// tables they reference are not qualified and resolve to the staging ones in
+// tx.
+func copyIndexes(ctx context.Context, tx *gorm.DB, table string) error {
+	var constraints []struct {
+		Name       string
+		Definition string
+	}
+	// Primary keys and unique constraints first, foreign keys reference them
+	err := DB.WithContext(ctx).Raw(`SELECT conname AS name, pg_get_constraintdef(oid) AS definition FROM pg_constraint
+		WHERE conrelid = ?::regclass AND contype IN ('p', 'u', 'f') ORDER BY contype = 'f', conname`, "public."+table).
+		Scan(&constraints).Error
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, c := range constraints {
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s.%s ADD CONSTRAINT %s %s", stagingSchema, table, quoteIdentifier(c.Name), c.Definition)).Error; err != nil {
+			return err
+		}
+		names[c.Name] = true
+	}
+
+	var indexes []struct {
+		Indexname string
+		Indexdef  string
+	}
+	if err := DB.WithContext(ctx).Raw("SELECT indexname, indexdef FROM pg_indexes WHERE schemaname = 'public' AND tablename = ?", table).Scan(&indexes).Error; err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if names[index.Indexname] {
+			continue
+		}
+		ddl := strings.Replace(index.Indexdef, " ON public."+table+" ", " ON "+stagingSchema+"."+table+" ", 1)
+		if err := tx.Exec(ddl).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SwapStaging replaces the record tables with the staging ones at once, then
+// drops the replaced tables. The syncs write to the tables again afterwards.
+func SwapStaging(ctx context.Context) error {
+	staging.Store(false)
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DROP SCHEMA IF EXISTS " + previousSchema + " CASCADE").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("CREATE SCHEMA " + previousSchema).Error; err != nil {
+			return err
+		}
+		for _, table := range stagedTables {
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE public.%s SET SCHEMA %s", table, previousSchema)).Error; err != nil {
+				return fmt.Errorf("failed to move %s out: %w", table, err)
+			}
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s.%s SET SCHEMA public", stagingSchema, table)).Error; err != nil {
+				return fmt.Errorf("failed to move the staging table %s in: %w", table, err)
+			}
+		}
+		return tx.Exec("DROP SCHEMA " + stagingSchema).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := DB.WithContext(ctx).Exec("DROP SCHEMA " + previousSchema + " CASCADE").Error; err != nil {
+		slog.Warn("Failed to drop the replaced record tables", "schema", previousSchema, "error", err)
+	}
+	return nil
+}
+
+// EndStaging makes the syncs write to the tables again, keeping the staging
+// tables for the sync to be resumed.
+func EndStaging() {
+	staging.Store(false)
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
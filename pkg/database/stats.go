@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -14,6 +16,13 @@ type TypeCount struct {
 	Count int    `json:"count"`
 }
 
+// YearCount represents the number of records published in a year, or in the
+// bucket of years starting with it
+type YearCount struct {
+	Year  int `json:"year"`
+	Count int `json:"count"`
+}
+
 // CachedStats holds the cached database statistics
 type CachedStats struct {
 	LastSync        string      `json:"lastSync"`
@@ -21,6 +30,28 @@ type CachedStats struct {
 	Count           int         `json:"count"`
 	Identifiers     []TypeCount `json:"identifiers"`
 	Classifications []TypeCount `json:"classifications"`
+	// Years is served separately, see YearHistogram
+	Years []YearCount `json:"-"`
+}
+
+// YearHistogram returns the number of records by publication year, grouped in
+// buckets of the given number of years (1 for years, 10 for decades).
+// Records without a known year are left out.
+func (s *CachedStats) YearHistogram(bucket int) []YearCount {
+	if bucket <= 1 {
+		return s.Years
+	}
+
+	histogram := []YearCount{}
+	for _, year := range s.Years {
+		start := year.Year - year.Year%bucket
+		if n := len(histogram); n > 0 && histogram[n-1].Year == start {
+			histogram[n-1].Count += year.Count
+			continue
+		}
+		histogram = append(histogram, YearCount{Year: start, Count: year.Count})
+	}
+	return histogram
 }
 
 // statsCache holds the singleton instance
@@ -57,7 +88,7 @@ func ComputeAndCacheStats(force bool) *CachedStats {
 
 	// Get last full sync date
 	var lastSync Synchronization
-	err := DB.Where("complete = ?", true).Order("date DESC").First(&lastSync).Error
+	err := DB.Where("complete AND NOT coalesce(rolled_back, false)").Order("date DESC").First(&lastSync).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		// never synchronized, cannot compute stats
 		return nil
@@ -84,6 +115,14 @@ func ComputeAndCacheStats(force bool) *CachedStats {
 		Group("type").
 		Scan(&stats.Classifications)
 
+	// Count records by publication year
+	DB.Model(&Record{}).
+		Select("year, COUNT(*) as count").
+		Where("year > 0").
+		Group("year").
+		Order("year").
+		Scan(&stats.Years)
+
 	cache.stats = stats
 	return cache.stats
 }
@@ -101,3 +140,26 @@ func HasCachedStats() bool {
 	defer cache.mu.RUnlock()
 	return cache.stats != nil
 }
+
+// SyncHistoryEntry is a past sync with its duration.
+type SyncHistoryEntry struct {
+	Synchronization
+	Duration int64 `json:"duration,omitempty" doc:"Duration of the sync in seconds"`
+}
+
+// SyncHistory returns the last limit syncs, most recent first.
+func SyncHistory(ctx context.Context, limit int) ([]SyncHistoryEntry, error) {
+	var syncs []Synchronization
+	if err := DB.WithContext(ctx).Order("date DESC").Limit(limit).Find(&syncs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list syncs: %w", err)
+	}
+
+	history := make([]SyncHistoryEntry, len(syncs))
+	for i, s := range syncs {
+		history[i].Synchronization = s
+		if !s.StartedAt.IsZero() {
+			history[i].Duration = int64(s.Date.Sub(s.StartedAt).Seconds())
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrQuotaExceeded is returned when a tenant has used up its daily download quota.
+var ErrQuotaExceeded = errors.New("daily download quota exceeded")
+
+// CountTenantDownload records a download for tenant today. When quota is
+// positive and the tenant already reached it, nothing is recorded and
+// ErrQuotaExceeded is returned. It returns the number of downloads of the day.
+func CountTenantDownload(ctx context.Context, tenant string, quota int) (int, error) {
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	// The check and the increment happen in the same statement, so concurrent
+	// downloads cannot exceed the quota
+	var counts []int
+	err := DB.WithContext(ctx).Raw(`
+		INSERT INTO anna_tenant_downloads (tenant, day, count) VALUES (?, ?, 1)
+		ON CONFLICT (tenant, day) DO UPDATE SET count = anna_tenant_downloads.count + 1
+		WHERE ? <= 0 OR anna_tenant_downloads.count < ?
+		RETURNING count`, tenant, day, quota, quota).Scan(&counts).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tenant download: %w", err)
+	}
+	if len(counts) == 0 {
+		return quota, ErrQuotaExceeded
+	}
+	return counts[0], nil
+}
+
+// TenantDownloads returns the number of epubs downloaded by tenant today.
+func TenantDownloads(ctx context.Context, tenant string) (int, error) {
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	var download TenantDownload
+	err := DB.WithContext(ctx).Where("tenant = ? AND day = ?", tenant, day).First(&download).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get tenant downloads: %w", err)
+	}
+	return download.Count, nil
+}
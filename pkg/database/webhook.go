@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// CreateWebhook stores a new webhook subscription.
+func CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	if err := DB.WithContext(ctx).Create(webhook).Error; err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// ListWebhooks returns all the webhook subscriptions.
+func ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := DB.WithContext(ctx).Order("id").Find(&webhooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// GetWebhook returns a webhook subscription, or gorm.ErrRecordNotFound.
+func GetWebhook(ctx context.Context, id uint) (*Webhook, error) {
+	var webhook Webhook
+	if err := DB.WithContext(ctx).First(&webhook, id).Error; err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// UpdateWebhook saves every field of an existing webhook subscription.
+func UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	if err := DB.WithContext(ctx).Save(webhook).Error; err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook subscription, it returns false if there was none with this ID.
+func DeleteWebhook(ctx context.Context, id uint) (bool, error) {
+	result := DB.WithContext(ctx).Delete(&Webhook{}, id)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to delete webhook: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// WebhooksForEvent returns the active webhooks subscribed to an event.
+func WebhooksForEvent(ctx context.Context, event string) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := DB.WithContext(ctx).Where("active AND ? = ANY(events)", event).Find(&webhooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhooks for event %s: %w", event, err)
+	}
+	return webhooks, nil
+}
@@ -0,0 +1,82 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"{", `\{`,
+	"}", `\}`,
+	"&", `\&`,
+	"%", `\%`,
+	"$", `\$`,
+	"#", `\#`,
+	"_", `\_`,
+)
+
+// BibTeX renders a record as a BibTeX @book entry.
+func BibTeX(record *database.Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@book{%s,\n", bibtexKey(record))
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "  %s = {%s},\n", name, bibtexEscaper.Replace(value))
+		}
+	}
+
+	field("title", record.Title)
+	field("author", strings.Join(authors(record), " and "))
+	field("publisher", record.Publisher)
+	if record.Year > 0 {
+		field("year", strconv.Itoa(record.Year))
+	}
+	if isbns := isbns(record); len(isbns) > 0 {
+		field("isbn", isbns[0])
+	}
+	field("language", strings.Join(record.Languages, ", "))
+	if record.Series != "" {
+		field("series", record.Series)
+		field("number", strconv.FormatFloat(record.SeriesIndex, 'f', -1, 64))
+	}
+	field("note", "Anna's Archive record "+record.ID)
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// bibtexKey builds a citation key like "tolkien1954", falling back to the record ID.
+func bibtexKey(record *database.Record) string {
+	var key strings.Builder
+	if authors := authors(record); len(authors) > 0 {
+		// "Tolkien, J. R. R." and "J. R. R. Tolkien" both give "tolkien"
+		name := authors[0]
+		if comma := strings.Index(name, ","); comma >= 0 {
+			name = name[:comma]
+		} else if fields := strings.Fields(name); len(fields) > 0 {
+			name = fields[len(fields)-1]
+		}
+		for _, r := range strings.ToLower(name) {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				key.WriteRune(r)
+			}
+		}
+	}
+	if key.Len() > 0 && record.Year > 0 {
+		key.WriteString(strconv.Itoa(record.Year))
+	}
+	if key.Len() == 0 {
+		for _, r := range record.ID {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				key.WriteRune(r)
+			}
+		}
+	}
+	return key.String()
+}
@@ -0,0 +1,158 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// Format is a record export format.
+type Format string
+
+const (
+	FormatBibTeX  Format = "bibtex"
+	FormatRIS     Format = "ris"
+	FormatMARCXML Format = "marcxml"
+	FormatONIX    Format = "onix"
+	FormatJSONLD  Format = "jsonld"
+)
+
+// ContentType returns the MIME type of an export format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatBibTeX:
+		return "application/x-bibtex"
+	case FormatRIS:
+		return "application/x-research-info-systems"
+	case FormatMARCXML:
+		return "application/marcxml+xml"
+	case FormatONIX:
+		return "application/xml"
+	case FormatJSONLD:
+		return "application/ld+json"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Extension returns the usual file extension of an export format.
+func (f Format) Extension() string {
+	switch f {
+	case FormatBibTeX:
+		return "bib"
+	case FormatMARCXML:
+		return "marc.xml"
+	case FormatONIX:
+		return "onix.xml"
+	default:
+		return string(f)
+	}
+}
+
+// Record renders a single record in the given format.
+func Record(record *database.Record, format Format) ([]byte, error) {
+	return Records([]database.Record{*record}, format)
+}
+
+// Records renders records in the given format. Text formats concatenate entries,
+// XML formats wrap them in a single document (MARCXML collection, ONIX message)
+// and JSON-LD uses a @graph when there are several records.
+func Records(records []database.Record, format Format) ([]byte, error) {
+	switch format {
+	case FormatBibTeX:
+		var b strings.Builder
+		for i := range records {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(BibTeX(&records[i]))
+		}
+		return []byte(b.String()), nil
+	case FormatRIS:
+		var b strings.Builder
+		for i := range records {
+			b.WriteString(RIS(&records[i]))
+		}
+		return []byte(b.String()), nil
+	case FormatMARCXML:
+		return MARCXML(records)
+	case FormatONIX:
+		return ONIX(records)
+	case FormatJSONLD:
+		return jsonLDDocument(records)
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// isbns returns the ISBN13 then ISBN10 identifiers of a record.
+func isbns(record *database.Record) []string {
+	var isbn13, isbn10 []string
+	for _, identifier := range record.Identifiers {
+		switch identifier.Type {
+		case "isbn13":
+			isbn13 = append(isbn13, identifier.Value)
+		case "isbn10":
+			isbn10 = append(isbn10, identifier.Value)
+		}
+	}
+	return append(isbn13, isbn10...)
+}
+
+// identifiers returns the identifier values of a given type.
+func identifiers(record *database.Record, identifierType string) []string {
+	var values []string
+	for _, identifier := range record.Identifiers {
+		if identifier.Type == identifierType {
+			values = append(values, identifier.Value)
+		}
+	}
+	return values
+}
+
+// classifications returns the classification values of a given type.
+func classifications(record *database.Record, classificationType string) []string {
+	var values []string
+	for _, classification := range record.Classifications {
+		if classification.Type == classificationType {
+			values = append(values, classification.Value)
+		}
+	}
+	return values
+}
+
+// authors splits the author field of a record, where Anna separates multiple authors with semicolons.
+func authors(record *database.Record) []string {
+	var result []string
+	for _, author := range strings.Split(record.Author, ";") {
+		if author = strings.TrimSpace(author); author != "" {
+			result = append(result, author)
+		}
+	}
+	return result
+}
+
+// iso6392 maps the ISO 639-1 codes used by Anna to the ISO 639-2/B codes used
+// by MARC21 and ONIX. Unknown languages are left out of those exports.
+var iso6392 = map[string]string{
+	"ar": "ara", "bg": "bul", "ca": "cat", "cs": "cze", "da": "dan",
+	"de": "ger", "el": "gre", "en": "eng", "eo": "epo", "es": "spa",
+	"et": "est", "fa": "per", "fi": "fin", "fr": "fre", "he": "heb",
+	"hi": "hin", "hr": "hrv", "hu": "hun", "id": "ind", "it": "ita",
+	"ja": "jpn", "ko": "kor", "la": "lat", "lt": "lit", "lv": "lav",
+	"nl": "dut", "no": "nor", "pl": "pol", "pt": "por", "ro": "rum",
+	"ru": "rus", "sk": "slo", "sl": "slv", "sr": "srp", "sv": "swe",
+	"th": "tha", "tr": "tur", "uk": "ukr", "vi": "vie", "zh": "chi",
+}
+
+// languages returns the ISO 639-2/B codes of the record languages.
+func languages(record *database.Record) []string {
+	var codes []string
+	for _, language := range record.Languages {
+		if code, ok := iso6392[strings.ToLower(language)]; ok {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
@@ -0,0 +1,96 @@
+package export
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+const schemaOrgContext = "https://schema.org"
+
+// Book is a schema.org Book, as consumed by search engines and knowledge-graph tools.
+type Book struct {
+	Context       string          `json:"@context,omitempty"`
+	Type          string          `json:"@type"`
+	Identifier    []PropertyValue `json:"identifier,omitempty"`
+	Name          string          `json:"name"`
+	Author        []Thing         `json:"author,omitempty"`
+	Publisher     *Thing          `json:"publisher,omitempty"`
+	DatePublished string          `json:"datePublished,omitempty"`
+	InLanguage    []string        `json:"inLanguage,omitempty"`
+	ISBN          []string        `json:"isbn,omitempty"`
+	Image         string          `json:"image,omitempty"`
+	Description   string          `json:"description,omitempty"`
+	BookFormat    string          `json:"bookFormat"`
+	IsPartOf      *Thing          `json:"isPartOf,omitempty"`
+	Position      string          `json:"position,omitempty"`
+}
+
+// Thing is a minimal schema.org entity (Person, Organization, BookSeries).
+type Thing struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+// PropertyValue is a schema.org identifier with its type.
+type PropertyValue struct {
+	Type       string `json:"@type"`
+	PropertyID string `json:"propertyID"`
+	Value      string `json:"value"`
+}
+
+type bookGraph struct {
+	Context string `json:"@context"`
+	Graph   []Book `json:"@graph"`
+}
+
+// JSONLD returns the schema.org Book describing a record.
+func JSONLD(record *database.Record) Book {
+	book := Book{
+		Context:     schemaOrgContext,
+		Type:        "Book",
+		Name:        record.Title,
+		InLanguage:  record.Languages,
+		ISBN:        isbns(record),
+		Image:       record.CoverURL,
+		Description: record.Description,
+		// Every indexed format is an ebook
+		BookFormat: "https://schema.org/EBook",
+		Identifier: []PropertyValue{{Type: "PropertyValue", PropertyID: "anna", Value: record.ID}},
+	}
+
+	for _, identifier := range record.Identifiers {
+		book.Identifier = append(book.Identifier, PropertyValue{Type: "PropertyValue", PropertyID: identifier.Type, Value: identifier.Value})
+	}
+	for _, author := range authors(record) {
+		book.Author = append(book.Author, Thing{Type: "Person", Name: author})
+	}
+	if record.Publisher != "" {
+		book.Publisher = &Thing{Type: "Organization", Name: record.Publisher}
+	}
+	if record.Year > 0 {
+		book.DatePublished = strconv.Itoa(record.Year)
+	}
+	if record.Series != "" {
+		book.IsPartOf = &Thing{Type: "BookSeries", Name: record.Series}
+		book.Position = strconv.FormatFloat(record.SeriesIndex, 'f', -1, 64)
+	}
+
+	return book
+}
+
+// jsonLDDocument renders a single record as a Book, or several records as a @graph of books.
+func jsonLDDocument(records []database.Record) ([]byte, error) {
+	if len(records) == 1 {
+		return json.Marshal(JSONLD(&records[0]))
+	}
+
+	graph := bookGraph{Context: schemaOrgContext, Graph: make([]Book, len(records))}
+	for i := range records {
+		book := JSONLD(&records[i])
+		book.Context = ""
+		graph.Graph[i] = book
+	}
+	return json.Marshal(graph)
+}
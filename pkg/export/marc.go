@@ -0,0 +1,120 @@
+package export
+
+import (
+	"encoding/xml"
+	"strconv"
+
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// marcLeader describes a language material monograph with unknown cataloging level.
+const marcLeader = "00000nam a2200000uu 4500"
+
+type marcCollection struct {
+	XMLName xml.Name     `xml:"http://www.loc.gov/MARC21/slim collection"`
+	Records []marcRecord `xml:"record"`
+}
+
+type marcRecord struct {
+	Leader        string             `xml:"leader"`
+	ControlFields []marcControlField `xml:"controlfield"`
+	DataFields    []marcDataField    `xml:"datafield"`
+}
+
+type marcControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcDataField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []marcSubfield `xml:"subfield"`
+}
+
+type marcSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// MARCXML renders records as a MARC21 XML collection.
+func MARCXML(records []database.Record) ([]byte, error) {
+	collection := marcCollection{Records: make([]marcRecord, len(records))}
+	for i := range records {
+		collection.Records[i] = marcFromRecord(&records[i])
+	}
+
+	data, err := xml.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+func marcFromRecord(record *database.Record) marcRecord {
+	m := marcRecord{
+		Leader:        marcLeader,
+		ControlFields: []marcControlField{{Tag: "001", Value: record.ID}},
+	}
+
+	field := func(tag, ind1, ind2 string, subfields ...marcSubfield) {
+		var kept []marcSubfield
+		for _, subfield := range subfields {
+			if subfield.Value != "" {
+				kept = append(kept, subfield)
+			}
+		}
+		if len(kept) > 0 {
+			m.DataFields = append(m.DataFields, marcDataField{Tag: tag, Ind1: ind1, Ind2: ind2, Subfields: kept})
+		}
+	}
+
+	for _, lccn := range identifiers(record, "lccn") {
+		field("010", " ", " ", marcSubfield{"a", lccn})
+	}
+	for _, isbn := range isbns(record) {
+		field("020", " ", " ", marcSubfield{"a", isbn})
+	}
+	for _, oclc := range identifiers(record, "oclc") {
+		field("035", " ", " ", marcSubfield{"a", "(OCoLC)" + oclc})
+	}
+	var languageSubfields []marcSubfield
+	for _, code := range languages(record) {
+		languageSubfields = append(languageSubfields, marcSubfield{"a", code})
+	}
+	field("041", " ", " ", languageSubfields...)
+	for _, lcc := range classifications(record, "lcc") {
+		field("050", " ", "4", marcSubfield{"a", lcc})
+	}
+	for _, ddc := range classifications(record, "ddc") {
+		field("082", " ", "4", marcSubfield{"a", ddc})
+	}
+
+	authors := authors(record)
+	titleIndicator := "0"
+	if len(authors) > 0 {
+		field("100", "1", " ", marcSubfield{"a", authors[0]})
+		titleIndicator = "1"
+	}
+	field("245", titleIndicator, "0", marcSubfield{"a", record.Title})
+
+	year := ""
+	if record.Year > 0 {
+		year = strconv.Itoa(record.Year)
+	}
+	field("264", " ", "1", marcSubfield{"b", record.Publisher}, marcSubfield{"c", year})
+
+	if record.Series != "" {
+		field("490", "0", " ", marcSubfield{"a", record.Series}, marcSubfield{"v", strconv.FormatFloat(record.SeriesIndex, 'f', -1, 64)})
+	}
+	field("520", " ", " ", marcSubfield{"a", record.Description})
+	if len(authors) > 1 {
+		for _, author := range authors[1:] {
+			field("700", "1", " ", marcSubfield{"a", author})
+		}
+	}
+	field("856", "4", "2", marcSubfield{"u", record.CoverURL}, marcSubfield{"3", "Cover image"})
+
+	return m
+}
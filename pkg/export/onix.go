@@ -0,0 +1,265 @@
+package export
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// The ONIX 3.0 messages use the reference tag names. Code values come from the
+// EDItEUR code lists, noted next to each constant.
+const (
+	onixNotificationConfirmed = "03"   // List 1: notification confirmed on publication
+	onixIDProprietary         = "01"   // List 5: proprietary identifier
+	onixIDISBN10              = "02"   // List 5: ISBN-10
+	onixIDISBN13              = "15"   // List 5: ISBN-13
+	onixCompositionSingleItem = "00"   // List 2: single-component retail product
+	onixFormDigitalDownload   = "ED"   // List 150: digital download
+	onixFormDetailEPUB        = "E101" // List 175: EPUB
+	onixFormDetailPDF         = "E107" // List 175: PDF
+	onixFormDetailKindle      = "E116" // List 175: Amazon Kindle
+	onixFormDetailMobipocket  = "E127" // List 175: Mobipocket
+	onixCollectionPublisher   = "10"   // List 148: publisher collection
+	onixTitleDistinctive      = "01"   // List 15: distinctive title
+	onixTitleLevelProduct     = "01"   // List 149: product level
+	onixTitleLevelCollection  = "02"   // List 149: collection level
+	onixRoleAuthor            = "A01"  // List 17: by (author)
+	onixLanguageOfText        = "01"   // List 22: language of text
+	onixSubjectDewey          = "01"   // List 27: Dewey
+	onixSubjectLCC            = "03"   // List 27: LC classification
+	onixTextDescription       = "03"   // List 153: description
+	onixAudienceAny           = "00"   // List 154: unrestricted
+	onixResourceCover         = "01"   // List 158: front cover
+	onixResourceModeImage     = "03"   // List 159: image
+	onixResourceFormLink      = "02"   // List 161: linkable resource
+	onixPublisherRole         = "01"   // List 45: publisher
+	onixDatePublication       = "01"   // List 163: publication date
+	onixDateFormatYear        = "05"   // List 55: YYYY
+)
+
+type onixMessage struct {
+	XMLName  xml.Name      `xml:"http://ns.editeur.org/onix/3.0/reference ONIXMessage"`
+	Release  string        `xml:"release,attr"`
+	Header   onixHeader    `xml:"Header"`
+	Products []onixProduct `xml:"Product"`
+}
+
+type onixHeader struct {
+	SenderName   string `xml:"Sender>SenderName"`
+	SentDateTime string `xml:"SentDateTime"`
+}
+
+type onixProduct struct {
+	RecordReference    string                  `xml:"RecordReference"`
+	NotificationType   string                  `xml:"NotificationType"`
+	ProductIdentifiers []onixProductIdentifier `xml:"ProductIdentifier"`
+	DescriptiveDetail  onixDescriptiveDetail   `xml:"DescriptiveDetail"`
+	CollateralDetail   *onixCollateralDetail   `xml:"CollateralDetail,omitempty"`
+	PublishingDetail   *onixPublishingDetail   `xml:"PublishingDetail,omitempty"`
+}
+
+type onixProductIdentifier struct {
+	ProductIDType string `xml:"ProductIDType"`
+	IDTypeName    string `xml:"IDTypeName,omitempty"`
+	IDValue       string `xml:"IDValue"`
+}
+
+type onixDescriptiveDetail struct {
+	ProductComposition string            `xml:"ProductComposition"`
+	ProductForm        string            `xml:"ProductForm"`
+	ProductFormDetail  string            `xml:"ProductFormDetail,omitempty"`
+	Collections        []onixCollection  `xml:"Collection"`
+	TitleDetail        onixTitleDetail   `xml:"TitleDetail"`
+	Contributors       []onixContributor `xml:"Contributor"`
+	Languages          []onixLanguage    `xml:"Language"`
+	Subjects           []onixSubject     `xml:"Subject"`
+}
+
+type onixCollection struct {
+	CollectionType string          `xml:"CollectionType"`
+	TitleDetail    onixTitleDetail `xml:"TitleDetail"`
+}
+
+type onixTitleDetail struct {
+	TitleType    string           `xml:"TitleType"`
+	TitleElement onixTitleElement `xml:"TitleElement"`
+}
+
+type onixTitleElement struct {
+	TitleElementLevel string `xml:"TitleElementLevel"`
+	PartNumber        string `xml:"PartNumber,omitempty"`
+	TitleText         string `xml:"TitleText"`
+}
+
+type onixContributor struct {
+	SequenceNumber  int    `xml:"SequenceNumber"`
+	ContributorRole string `xml:"ContributorRole"`
+	PersonName      string `xml:"PersonName"`
+}
+
+type onixLanguage struct {
+	LanguageRole string `xml:"LanguageRole"`
+	LanguageCode string `xml:"LanguageCode"`
+}
+
+type onixSubject struct {
+	SubjectSchemeIdentifier string `xml:"SubjectSchemeIdentifier"`
+	SubjectCode             string `xml:"SubjectCode"`
+}
+
+type onixCollateralDetail struct {
+	TextContents        []onixTextContent        `xml:"TextContent"`
+	SupportingResources []onixSupportingResource `xml:"SupportingResource"`
+}
+
+type onixTextContent struct {
+	TextType        string `xml:"TextType"`
+	ContentAudience string `xml:"ContentAudience"`
+	Text            string `xml:"Text"`
+}
+
+type onixSupportingResource struct {
+	ResourceContentType string `xml:"ResourceContentType"`
+	ContentAudience     string `xml:"ContentAudience"`
+	ResourceMode        string `xml:"ResourceMode"`
+	ResourceForm        string `xml:"ResourceVersion>ResourceForm"`
+	ResourceLink        string `xml:"ResourceVersion>ResourceLink"`
+}
+
+type onixPublishingDetail struct {
+	PublisherRole   string               `xml:"Publisher>PublishingRole,omitempty"`
+	PublisherName   string               `xml:"Publisher>PublisherName,omitempty"`
+	PublishingDates []onixPublishingDate `xml:"PublishingDate"`
+}
+
+type onixPublishingDate struct {
+	PublishingDateRole string   `xml:"PublishingDateRole"`
+	Date               onixDate `xml:"Date"`
+}
+
+type onixDate struct {
+	Format string `xml:"dateformat,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// ONIX renders records as an ONIX for Books 3.0 message.
+func ONIX(records []database.Record) ([]byte, error) {
+	message := onixMessage{
+		Release: "3.0",
+		Header: onixHeader{
+			SenderName:   "Anna API",
+			SentDateTime: time.Now().UTC().Format("20060102T1504Z"),
+		},
+		Products: make([]onixProduct, len(records)),
+	}
+	for i := range records {
+		message.Products[i] = onixFromRecord(&records[i])
+	}
+
+	data, err := xml.MarshalIndent(message, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// onixFormDetails maps record extensions to their product form detail.
+var onixFormDetails = map[string]string{
+	"epub": onixFormDetailEPUB,
+	"pdf":  onixFormDetailPDF,
+	"mobi": onixFormDetailMobipocket,
+	"azw3": onixFormDetailKindle,
+}
+
+func onixFromRecord(record *database.Record) onixProduct {
+	product := onixProduct{
+		RecordReference:  record.ID,
+		NotificationType: onixNotificationConfirmed,
+		ProductIdentifiers: []onixProductIdentifier{
+			{ProductIDType: onixIDProprietary, IDTypeName: "Anna's Archive", IDValue: record.ID},
+		},
+		DescriptiveDetail: onixDescriptiveDetail{
+			ProductComposition: onixCompositionSingleItem,
+			ProductForm:        onixFormDigitalDownload,
+			// List 175 has no code for cbz and djvu files
+			ProductFormDetail: onixFormDetails[record.Extension],
+			TitleDetail: onixTitleDetail{
+				TitleType: onixTitleDistinctive,
+				TitleElement: onixTitleElement{
+					TitleElementLevel: onixTitleLevelProduct,
+					TitleText:         record.Title,
+				},
+			},
+		},
+	}
+
+	for _, isbn := range identifiers(record, "isbn13") {
+		product.ProductIdentifiers = append(product.ProductIdentifiers, onixProductIdentifier{ProductIDType: onixIDISBN13, IDValue: isbn})
+	}
+	for _, isbn := range identifiers(record, "isbn10") {
+		product.ProductIdentifiers = append(product.ProductIdentifiers, onixProductIdentifier{ProductIDType: onixIDISBN10, IDValue: isbn})
+	}
+
+	detail := &product.DescriptiveDetail
+	if record.Series != "" {
+		detail.Collections = append(detail.Collections, onixCollection{
+			CollectionType: onixCollectionPublisher,
+			TitleDetail: onixTitleDetail{
+				TitleType: onixTitleDistinctive,
+				TitleElement: onixTitleElement{
+					TitleElementLevel: onixTitleLevelCollection,
+					PartNumber:        strconv.FormatFloat(record.SeriesIndex, 'f', -1, 64),
+					TitleText:         record.Series,
+				},
+			},
+		})
+	}
+	for i, author := range authors(record) {
+		detail.Contributors = append(detail.Contributors, onixContributor{SequenceNumber: i + 1, ContributorRole: onixRoleAuthor, PersonName: author})
+	}
+	for _, code := range languages(record) {
+		detail.Languages = append(detail.Languages, onixLanguage{LanguageRole: onixLanguageOfText, LanguageCode: code})
+	}
+	for _, ddc := range classifications(record, "ddc") {
+		detail.Subjects = append(detail.Subjects, onixSubject{SubjectSchemeIdentifier: onixSubjectDewey, SubjectCode: ddc})
+	}
+	for _, lcc := range classifications(record, "lcc") {
+		detail.Subjects = append(detail.Subjects, onixSubject{SubjectSchemeIdentifier: onixSubjectLCC, SubjectCode: lcc})
+	}
+
+	if record.Description != "" || record.CoverURL != "" {
+		collateral := &onixCollateralDetail{}
+		if record.Description != "" {
+			collateral.TextContents = append(collateral.TextContents, onixTextContent{TextType: onixTextDescription, ContentAudience: onixAudienceAny, Text: record.Description})
+		}
+		if record.CoverURL != "" {
+			collateral.SupportingResources = append(collateral.SupportingResources, onixSupportingResource{
+				ResourceContentType: onixResourceCover,
+				ContentAudience:     onixAudienceAny,
+				ResourceMode:        onixResourceModeImage,
+				ResourceForm:        onixResourceFormLink,
+				ResourceLink:        record.CoverURL,
+			})
+		}
+		product.CollateralDetail = collateral
+	}
+
+	if record.Publisher != "" || record.Year > 0 {
+		publishing := &onixPublishingDetail{}
+		if record.Publisher != "" {
+			publishing.PublisherRole = onixPublisherRole
+			publishing.PublisherName = record.Publisher
+		}
+		if record.Year > 0 {
+			publishing.PublishingDates = append(publishing.PublishingDates, onixPublishingDate{
+				PublishingDateRole: onixDatePublication,
+				Date:               onixDate{Format: onixDateFormatYear, Value: strconv.Itoa(record.Year)},
+			})
+		}
+		product.PublishingDetail = publishing
+	}
+
+	return product
+}
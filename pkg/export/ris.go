@@ -0,0 +1,46 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// RIS renders a record as a RIS entry, as imported by Zotero and other reference managers.
+func RIS(record *database.Record) string {
+	var b strings.Builder
+
+	tag := func(name, value string) {
+		// RIS is line-based, values cannot span multiple lines
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			fmt.Fprintf(&b, "%s  - %s\r\n", name, value)
+		}
+	}
+
+	tag("TY", "BOOK")
+	tag("ID", record.ID)
+	tag("TI", record.Title)
+	for _, author := range authors(record) {
+		tag("AU", author)
+	}
+	tag("PB", record.Publisher)
+	if record.Year > 0 {
+		tag("PY", strconv.Itoa(record.Year))
+	}
+	for _, isbn := range isbns(record) {
+		tag("SN", isbn)
+	}
+	for _, language := range record.Languages {
+		tag("LA", language)
+	}
+	if record.Series != "" {
+		tag("T2", record.Series)
+	}
+	tag("AB", record.Description)
+	b.WriteString("ER  - \r\n")
+
+	return b.String()
+}
@@ -0,0 +1,303 @@
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// sinkRowGroup is the number of records of each Parquet row group,
+	// buffered in memory until written
+	sinkRowGroup = 100_000
+	// sinkPartSize is the size of the parts of the S3 multipart uploads, up
+	// to 640 GB per file
+	sinkPartSize = 64 << 20
+)
+
+// SinkRecord is a record as written by the sink: as stored, with its
+// identifiers and classifications.
+type SinkRecord struct {
+	ID              string      `json:"id" parquet:"id"`
+	Title           string      `json:"title" parquet:"title"`
+	Author          string      `json:"author" parquet:"author"`
+	Publisher       string      `json:"publisher" parquet:"publisher"`
+	Year            int         `json:"year" parquet:"year"`
+	Languages       []string    `json:"languages" parquet:"languages,list"`
+	Description     string      `json:"description,omitempty" parquet:"description"`
+	CoverURL        string      `json:"coverURL" parquet:"cover_url"`
+	ContentType     string      `json:"contentType" parquet:"content_type"`
+	Extension       string      `json:"extension" parquet:"extension"`
+	Filesize        int64       `json:"filesize,omitempty" parquet:"filesize"`
+	MD5             string      `json:"md5,omitempty" parquet:"md5"`
+	AddedDate       string      `json:"addedDate,omitempty" parquet:"added_date,optional"`
+	Series          string      `json:"series,omitempty" parquet:"series"`
+	SeriesIndex     float64     `json:"seriesIndex,omitempty" parquet:"series_index"`
+	Identifiers     []SinkValue `json:"identifiers" parquet:"identifiers,list"`
+	Classifications []SinkValue `json:"classifications" parquet:"classifications,list"`
+	// Base is the metadata base the record was synced from
+	Base string `json:"base" parquet:"base"`
+}
+
+// SinkValue is an identifier or a classification of a SinkRecord.
+type SinkValue struct {
+	Type  string `json:"type" parquet:"type"`
+	Value string `json:"value" parquet:"value"`
+}
+
+// Sink writes the records of each sync to a file, in a directory or an S3
+// bucket, as gzipped NDJSON or Parquet. Each sync, resumed syncs included,
+// writes its own file named after the base and the time it started: the
+// records of a base can be spread over several files, and appear in more than
+// one. It's meant to be registered as a sync hook.
+type Sink struct {
+	format string
+	// dir is the directory the files are written to, or the key prefix in
+	// bucket when writing to S3
+	dir    string
+	s3     *minio.Client
+	bucket string
+
+	mu   sync.Mutex
+	base string
+	name string
+	// write writes a record, given as JSON with NDJSON files
+	write   func(record *SinkRecord, line []byte) error
+	close   func() error
+	records int64
+}
+
+// NewSink returns the sink configured by c.
+func NewSink(c config.Export) (*Sink, error) {
+	sink := &Sink{format: c.Format, dir: c.Path}
+	rest, ok := strings.CutPrefix(c.Path, "s3://")
+	if !ok {
+		return sink, nil
+	}
+	sink.bucket, sink.dir, _ = strings.Cut(rest, "/")
+
+	endpoint, err := url.Parse(c.S3Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{&credentials.EnvAWS{}, &credentials.IAM{}})
+	if c.S3AccessKey != "" {
+		creds = credentials.NewStaticV4(c.S3AccessKey, c.S3SecretKey, "")
+	}
+	sink.s3, err = minio.New(endpoint.Host, &minio.Options{
+		Creds:  creds,
+		Secure: endpoint.Scheme == "https",
+		Region: c.S3Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the S3 client: %w", err)
+	}
+	return sink, nil
+}
+
+// Start opens the file the records of the sync of base are written to.
+func (s *Sink) Start(ctx context.Context, base string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	extension := "ndjson.gz"
+	if s.format == "parquet" {
+		extension = "parquet"
+	}
+	name := path.Join(s.dir, base, fmt.Sprintf("records-%s.%s", time.Now().UTC().Format("20060102T150405Z"), extension))
+
+	output, err := s.open(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+
+	switch s.format {
+	case "parquet":
+		writer := parquet.NewGenericWriter[SinkRecord](output, parquet.Compression(&parquet.Zstd), parquet.MaxRowsPerRowGroup(sinkRowGroup))
+		s.write = func(record *SinkRecord, _ []byte) error {
+			_, err := writer.Write([]SinkRecord{*record})
+			return err
+		}
+		s.close = func() error { return closeAll(writer, output) }
+	default:
+		writer := gzip.NewWriter(output)
+		s.write = func(_ *SinkRecord, line []byte) error {
+			_, err := writer.Write(line)
+			return err
+		}
+		s.close = func() error { return closeAll(writer, output) }
+	}
+	s.base, s.name, s.records = base, name, 0
+	slog.Info("Exporting the synced records", "file", s.location())
+	return nil
+}
+
+// open opens the file name for writing, locally or in the bucket. A local file
+// is renamed once complete, an upload is completed when closed.
+func (s *Sink) open(ctx context.Context, name string) (io.WriteCloser, error) {
+	if s.s3 == nil {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return nil, err
+		}
+		file, err := os.Create(name + ".tmp")
+		if err != nil {
+			return nil, err
+		}
+		return &localFile{File: file, name: name}, nil
+	}
+
+	contentType := "application/gzip"
+	if s.format == "parquet" {
+		contentType = "application/vnd.apache.parquet"
+	}
+	reader, writer := io.Pipe()
+	upload := &upload{PipeWriter: writer, done: make(chan error, 1)}
+	go func() {
+		// The upload of the records written so far completes when the sync is canceled
+		_, err := s.s3.PutObject(context.WithoutCancel(ctx), s.bucket, name, reader, -1,
+			minio.PutObjectOptions{ContentType: contentType, PartSize: sinkPartSize})
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+	return upload, nil
+}
+
+// Record writes a record, skipped when it's not synced. Records are converted,
+// and encoded as JSON, concurrently: only their writes are serialized.
+func (s *Sink) Record(_ context.Context, record *anna.Record) error {
+	s.mu.Lock()
+	base := s.base
+	s.mu.Unlock()
+
+	stored, ok := database.NormalizedRecord(record, base)
+	if !ok {
+		return nil
+	}
+	sinkRecord := newSinkRecord(stored)
+	var line []byte
+	if s.format != "parquet" {
+		data, err := json.Marshal(sinkRecord)
+		if err != nil {
+			return err
+		}
+		line = append(data, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.write == nil {
+		return nil
+	}
+	if err := s.write(sinkRecord, line); err != nil {
+		return err
+	}
+	s.records++
+	return nil
+}
+
+// End closes the file of the sync, holding the records written whether the
+// sync completed or not.
+func (s *Sink) End(_ context.Context, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.close == nil {
+		return
+	}
+	if closeErr := s.close(); closeErr != nil {
+		slog.Error("Failed to write the exported records", "file", s.location(), "error", closeErr)
+	} else {
+		slog.Info("Exported the synced records", "file", s.location(), "records", s.records, "complete", err == nil)
+	}
+	s.write, s.close = nil, nil
+}
+
+// location returns the URL or path of the current file.
+func (s *Sink) location() string {
+	if s.s3 != nil {
+		return "s3://" + s.bucket + "/" + s.name
+	}
+	return s.name
+}
+
+func newSinkRecord(record *database.Record) *SinkRecord {
+	r := &SinkRecord{
+		ID:              record.ID,
+		Title:           record.Title,
+		Author:          record.Author,
+		Publisher:       record.Publisher,
+		Year:            record.Year,
+		Languages:       record.Languages,
+		Description:     record.Description,
+		CoverURL:        record.CoverURL,
+		ContentType:     record.ContentType,
+		Extension:       record.Extension,
+		Filesize:        record.Filesize,
+		MD5:             record.MD5,
+		Series:          record.Series,
+		SeriesIndex:     record.SeriesIndex,
+		Identifiers:     make([]SinkValue, len(record.Identifiers)),
+		Classifications: make([]SinkValue, len(record.Classifications)),
+		Base:            record.Generation,
+	}
+	if record.AddedAt != nil {
+		r.AddedDate = record.AddedAt.Format("2006-01-02")
+	}
+	for i, identifier := range record.Identifiers {
+		r.Identifiers[i] = SinkValue{Type: identifier.Type, Value: identifier.Value}
+	}
+	for i, classification := range record.Classifications {
+		r.Classifications[i] = SinkValue{Type: classification.Type, Value: classification.Value}
+	}
+	return r
+}
+
+// closeAll closes the writer then the file it writes to.
+func closeAll(writer io.Closer, output io.Closer) error {
+	err := writer.Close()
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// localFile is written next to its name, and renamed once closed.
+type localFile struct {
+	*os.File
+	name string
+}
+
+func (f *localFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.File.Name(), f.name)
+}
+
+// upload streams a file to S3, and waits for the upload to complete once
+// closed.
+type upload struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (u *upload) Close() error {
+	u.PipeWriter.Close()
+	return <-u.done
+}
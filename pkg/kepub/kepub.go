@@ -0,0 +1,174 @@
+// Package kepub converts epubs to kepubs, the epub flavour of Kobo e-readers.
+// Kobo devices only report reading statistics, page numbers and footnotes for
+// books whose text is split in koboSpan elements.
+package kepub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ContentType is the MIME type of kepub files.
+const ContentType = "application/kepub+zip"
+
+// Extension is the file extension of kepubs, Kobo devices only handle them as
+// kepubs with it.
+const Extension = "kepub.epub"
+
+// style keeps the book wrappers from adding margins to the pages.
+const style = `<style type="text/css">div#book-inner{margin-top:0;margin-bottom:0;}</style>`
+
+// Convert returns the kepub of an epub: the text of its content documents is
+// wrapped in koboSpan elements, sentence by sentence, and their body in the
+// book-columns and book-inner divs expected by Kobo devices. The other files
+// are copied as is.
+func Convert(epub []byte) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(epub), int64(len(epub)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open epub: %w", err)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, file := range r.File {
+		if !isContentDocument(file.Name) {
+			if err := w.Copy(file); err != nil {
+				return nil, fmt.Errorf("failed to copy %s: %w", file.Name, err)
+			}
+			continue
+		}
+
+		data, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		header := file.FileHeader
+		header.Method = zip.Deflate
+		fw, err := w.CreateHeader(&header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+		if _, err := fw.Write(transform(data)); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write kepub: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func isContentDocument(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".xhtml", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+func readFile(file *zip.File) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	return data, nil
+}
+
+// transform adds the Kobo spans and wrappers to a content document. The
+// document is rewritten token by token so that its markup is kept as is.
+func transform(document []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(document) + len(document)/4)
+
+	z := html.NewTokenizer(bytes.NewReader(document))
+	inBody := false
+	skip := 0 // depth of the elements whose text is not displayed
+	paragraph, segment := 0, 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := z.Raw()
+
+		switch tt {
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			switch {
+			case tt == html.EndTagToken && tag == "head":
+				out.WriteString(style)
+			case tt == html.EndTagToken && tag == "body":
+				out.WriteString("</div></div>")
+				inBody = false
+			case tag == "script" || tag == "style":
+				if tt == html.StartTagToken {
+					skip++
+				} else if skip > 0 {
+					skip--
+				}
+			case tt == html.StartTagToken && inBody:
+				paragraph++
+				segment = 0
+			}
+			out.Write(raw)
+			if tt == html.StartTagToken && tag == "body" {
+				out.WriteString(`<div id="book-columns"><div id="book-inner">`)
+				inBody = true
+			}
+		case html.TextToken:
+			if !inBody || skip > 0 || len(bytes.TrimSpace(raw)) == 0 {
+				out.Write(raw)
+				continue
+			}
+			if paragraph == 0 {
+				paragraph++
+			}
+			for _, sentence := range sentences(string(raw)) {
+				segment++
+				fmt.Fprintf(&out, `<span class="koboSpan" id="kobo.%d.%d">%s</span>`, paragraph, segment, sentence)
+			}
+		default:
+			out.Write(raw)
+		}
+	}
+	return out.Bytes()
+}
+
+// sentences splits text after each ".", "!" or "?" followed by spaces, the
+// spaces staying with the sentence they end.
+func sentences(text string) []string {
+	var result []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] != '.' && text[i] != '!' && text[i] != '?' {
+			continue
+		}
+		end := i + 1
+		for end < len(text) && isSpace(text[end]) {
+			end++
+		}
+		if end == i+1 || end == len(text) {
+			continue
+		}
+		result = append(result, text[start:end])
+		start = end
+		i = end - 1
+	}
+	return append(result, text[start:])
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,56 @@
+package kepub
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSentences(t *testing.T) {
+	assert.Equal(t, []string{"One. ", "Two! ", "Three?"}, sentences("One. Two! Three?"))
+	assert.Equal(t, []string{"3.14 is pi"}, sentences("3.14 is pi"))
+	assert.Equal(t, []string{"Trailing. "}, sentences("Trailing. "))
+}
+
+func TestTransform(t *testing.T) {
+	document := `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Title</title></head>
+<body class="c"><p>First. Second.</p><br/><p>A &amp; <em>B</em></p><script>x = 1;</script></body></html>`
+
+	assert.Equal(t, `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Title</title>`+style+`</head>
+<body class="c"><div id="book-columns"><div id="book-inner">`+
+		`<p><span class="koboSpan" id="kobo.1.1">First. </span><span class="koboSpan" id="kobo.1.2">Second.</span></p><br/>`+
+		`<p><span class="koboSpan" id="kobo.2.1">A &amp; </span><em><span class="koboSpan" id="kobo.3.1">B</span></em></p>`+
+		`<script>x = 1;</script></div></div></body></html>`, string(transform([]byte(document))))
+}
+
+func TestConvert(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, _ := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	fw.Write([]byte("application/epub+zip"))
+	fw, _ = w.Create("OEBPS/chapter.xhtml")
+	fw.Write([]byte("<html><body><p>Text</p></body></html>"))
+	assert.NoError(t, w.Close())
+
+	data, err := Convert(buf.Bytes())
+	assert.NoError(t, err)
+
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	assert.Len(t, r.File, 2)
+	assert.Equal(t, "mimetype", r.File[0].Name)
+	assert.Equal(t, zip.Store, r.File[0].Method)
+
+	rc, err := r.File[1].Open()
+	assert.NoError(t, err)
+	chapter, _ := io.ReadAll(rc)
+	assert.Contains(t, string(chapter), `<span class="koboSpan" id="kobo.1.1">Text</span>`)
+
+	_, err = Convert([]byte("not a zip"))
+	assert.Error(t, err)
+}
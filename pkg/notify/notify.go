@@ -0,0 +1,131 @@
+// Package notify tells operators how a sync ended, through the sinks of the
+// configuration: a generic webhook, Slack and email.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Status is how a sync ended.
+type Status string
+
+const (
+	StatusCompleted Status = "completed"
+	StatusPartial   Status = "partial"
+	StatusFailed    Status = "failed"
+)
+
+// SyncResult is the outcome of a sync, as sent to the generic webhook.
+type SyncResult struct {
+	// Base is empty when the sync failed to find it
+	Base        string   `json:"base"`
+	Status      Status   `json:"status"`
+	Files       int      `json:"files"`
+	Records     int      `json:"records"`
+	FailedFiles []string `json:"failedFiles,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	// Duration is in seconds
+	Duration int64 `json:"duration"`
+}
+
+// Summary describes the result in a sentence.
+func (r SyncResult) Summary() string {
+	duration := (time.Duration(r.Duration) * time.Second).String()
+	if r.Base == "" {
+		return fmt.Sprintf("Sync failed: %s", r.Error)
+	}
+	switch r.Status {
+	case StatusCompleted:
+		return fmt.Sprintf("Sync of %s completed: %d records from %d files in %s", r.Base, r.Records, r.Files, duration)
+	case StatusPartial:
+		return fmt.Sprintf("Sync of %s completed partially: %d records from %d files in %s, failed files: %s", r.Base, r.Records, r.Files, duration, strings.Join(r.FailedFiles, ", "))
+	default:
+		return fmt.Sprintf("Sync of %s failed after %s: %s", r.Base, duration, r.Error)
+	}
+}
+
+var client = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// Sync sends the result of a sync to the configured sinks in the background.
+// Failures are logged.
+func Sync(ctx context.Context, result SyncResult) {
+	ctx = context.WithoutCancel(ctx)
+	c := config.C.Notify
+	send := func(sink string, fn func() error) {
+		go func() {
+			if err := fn(); err != nil {
+				slog.Warn("Failed to send the sync notification", "sink", sink, "error", err)
+			}
+		}()
+	}
+
+	if c.WebhookURL != "" {
+		send("webhook", func() error { return postJSON(ctx, c.WebhookURL, result) })
+	}
+	if c.SlackURL != "" {
+		send("slack", func() error { return postJSON(ctx, c.SlackURL, map[string]string{"text": result.Summary()}) })
+	}
+	if len(c.Email) > 0 {
+		send("email", func() error { return sendEmail(c, result) })
+	}
+}
+
+func postJSON(ctx context.Context, url string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "anna-api-notifications")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func sendEmail(c config.Notify, result SyncResult) error {
+	var auth smtp.Auth
+	if c.SMTPUser != "" {
+		host, _, _ := net.SplitHostPort(c.SMTPAddr)
+		auth = smtp.PlainAuth("", c.SMTPUser, c.SMTPPassword, host)
+	}
+	return smtp.SendMail(c.SMTPAddr, auth, c.SMTPFrom, c.Email, emailMessage(c, result))
+}
+
+// emailMessage returns the mail sent for a result, headers included.
+func emailMessage(c config.Notify, result SyncResult) []byte {
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", c.SMTPFrom)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(c.Email, ", "))
+	fmt.Fprintf(&message, "Subject: [anna-api] Sync %s\r\n", result.Status)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(result.Summary() + "\r\n")
+	return message.Bytes()
+}
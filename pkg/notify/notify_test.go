@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary(t *testing.T) {
+	assert.Equal(t, "Sync of base completed: 12 records from 2 files in 1m30s",
+		SyncResult{Base: "base", Status: StatusCompleted, Files: 2, Records: 12, Duration: 90}.Summary())
+	assert.Equal(t, "Sync of base completed partially: 12 records from 2 files in 1m30s, failed files: aarecords__1.json.gz",
+		SyncResult{Base: "base", Status: StatusPartial, Files: 2, Records: 12, FailedFiles: []string{"aarecords__1.json.gz"}, Duration: 90}.Summary())
+	assert.Equal(t, "Sync of base failed after 5s: failed to write records",
+		SyncResult{Base: "base", Status: StatusFailed, Error: "failed to write records", Duration: 5}.Summary())
+	assert.Equal(t, "Sync failed: no metadata torrent found",
+		SyncResult{Status: StatusFailed, Error: "no metadata torrent found"}.Summary())
+}
+
+func TestPostJSON(t *testing.T) {
+	var received SyncResult
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	result := SyncResult{Base: "base", Status: StatusCompleted, Files: 2, Records: 12, Duration: 90}
+	assert.NoError(t, postJSON(context.Background(), server.URL, result))
+	assert.Equal(t, result, received)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer failing.Close()
+	assert.Error(t, postJSON(context.Background(), failing.URL, result))
+}
+
+func TestEmailMessage(t *testing.T) {
+	c := config.Notify{Email: []string{"a@example.org", "b@example.org"}, SMTPFrom: "anna@example.org"}
+	message := string(emailMessage(c, SyncResult{Base: "base", Status: StatusFailed, Error: "boom"}))
+
+	assert.Contains(t, message, "To: a@example.org, b@example.org\r\n")
+	assert.Contains(t, message, "Subject: [anna-api] Sync failed\r\n")
+	assert.Contains(t, message, "\r\n\r\nSync of base failed after 0s: boom\r\n")
+}
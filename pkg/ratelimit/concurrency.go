@@ -0,0 +1,56 @@
+package ratelimit
+
+import "sync"
+
+// Concurrency limits the number of operations each client key runs at once.
+type Concurrency struct {
+	limit int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// NewConcurrency returns a limiter allowing each key limit operations at once.
+// A zero limit disables limiting.
+func NewConcurrency(limit int) *Concurrency {
+	return &Concurrency{limit: limit, active: make(map[string]int)}
+}
+
+// Enabled reports whether the limiter limits anything.
+func (c *Concurrency) Enabled() bool {
+	return c.limit > 0
+}
+
+// Acquire starts an operation for key. It returns false when key already runs
+// the maximum number of operations, otherwise a function to call once the
+// operation is over.
+func (c *Concurrency) Acquire(key string) (func(), bool) {
+	if !c.Enabled() {
+		return func() {}, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active[key] >= c.limit {
+		return nil, false
+	}
+	c.active[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.active[key]--; c.active[key] <= 0 {
+				delete(c.active, key)
+			}
+		})
+	}, true
+}
+
+// Active returns the number of operations key runs.
+func (c *Concurrency) Active(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active[key]
+}
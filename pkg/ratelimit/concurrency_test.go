@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquire(t *testing.T) {
+	concurrency := NewConcurrency(2)
+
+	release1, ok := concurrency.Acquire("a")
+	assert.True(t, ok)
+	_, ok = concurrency.Acquire("a")
+	assert.True(t, ok)
+	_, ok = concurrency.Acquire("a")
+	assert.False(t, ok)
+	assert.Equal(t, 2, concurrency.Active("a"))
+
+	// Other clients have their own slots
+	_, ok = concurrency.Acquire("b")
+	assert.True(t, ok)
+
+	// Releasing twice frees a single slot
+	release1()
+	release1()
+	assert.Equal(t, 1, concurrency.Active("a"))
+	_, ok = concurrency.Acquire("a")
+	assert.True(t, ok)
+	_, ok = concurrency.Acquire("a")
+	assert.False(t, ok)
+}
+
+func TestConcurrencyDisabled(t *testing.T) {
+	concurrency := NewConcurrency(0)
+	assert.False(t, concurrency.Enabled())
+	for range 100 {
+		_, ok := concurrency.Acquire("a")
+		assert.True(t, ok)
+	}
+}
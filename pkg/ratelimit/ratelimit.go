@@ -0,0 +1,88 @@
+// Package ratelimit implements per-client token bucket rate limiting and
+// concurrency limiting.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long a client bucket is kept after its last request.
+const idleTimeout = 10 * time.Minute
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter holds one token bucket per client key (IP address, JWT subject...).
+type Limiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPurge time.Time
+}
+
+// New returns a limiter allowing each key perSecond requests on average, with bursts of up to burst requests.
+// A zero perSecond disables limiting.
+func New(perSecond float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(perSecond)))
+	}
+	return &Limiter{
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Enabled returns whether the limiter restricts anything.
+func (l *Limiter) Enabled() bool {
+	return l != nil && l.limit > 0
+}
+
+// Allow consumes a token for key. When the bucket is empty, it returns false
+// and how long the client should wait before retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if !l.Enabled() {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.purge(now)
+	l.mu.Unlock()
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// purge forgets the buckets of idle clients, they are full again anyway.
+// It must be called with l.mu held.
+func (l *Limiter) purge(now time.Time) {
+	if now.Sub(l.lastPurge) < idleTimeout {
+		return
+	}
+	l.lastPurge = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	limiter := New(1, 2)
+
+	ok, _ := limiter.Allow("a")
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("a")
+	assert.True(t, ok)
+
+	ok, retryAfter := limiter.Allow("a")
+	assert.False(t, ok)
+	assert.Greater(t, retryAfter.Seconds(), 0.0)
+
+	// Other clients have their own bucket
+	ok, _ = limiter.Allow("b")
+	assert.True(t, ok)
+}
+
+func TestDisabled(t *testing.T) {
+	limiter := New(0, 0)
+	assert.False(t, limiter.Enabled())
+	for range 100 {
+		ok, _ := limiter.Allow("a")
+		assert.True(t, ok)
+	}
+}
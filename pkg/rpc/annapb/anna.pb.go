@@ -0,0 +1,1398 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: anna/v1/anna.proto
+
+package annapb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type LanguageMode int32
+
+const (
+	LanguageMode_LANGUAGE_MODE_UNSPECIFIED LanguageMode = 0
+	LanguageMode_LANGUAGE_MODE_EXACT       LanguageMode = 1
+	LanguageMode_LANGUAGE_MODE_ANY         LanguageMode = 2
+	LanguageMode_LANGUAGE_MODE_ALL         LanguageMode = 3
+)
+
+// Enum value maps for LanguageMode.
+var (
+	LanguageMode_name = map[int32]string{
+		0: "LANGUAGE_MODE_UNSPECIFIED",
+		1: "LANGUAGE_MODE_EXACT",
+		2: "LANGUAGE_MODE_ANY",
+		3: "LANGUAGE_MODE_ALL",
+	}
+	LanguageMode_value = map[string]int32{
+		"LANGUAGE_MODE_UNSPECIFIED": 0,
+		"LANGUAGE_MODE_EXACT":       1,
+		"LANGUAGE_MODE_ANY":         2,
+		"LANGUAGE_MODE_ALL":         3,
+	}
+)
+
+func (x LanguageMode) Enum() *LanguageMode {
+	p := new(LanguageMode)
+	*p = x
+	return p
+}
+
+func (x LanguageMode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (LanguageMode) Descriptor() protoreflect.EnumDescriptor {
+	return file_anna_v1_anna_proto_enumTypes[0].Descriptor()
+}
+
+func (LanguageMode) Type() protoreflect.EnumType {
+	return &file_anna_v1_anna_proto_enumTypes[0]
+}
+
+func (x LanguageMode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use LanguageMode.Descriptor instead.
+func (LanguageMode) EnumDescriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{0}
+}
+
+type CountMode int32
+
+const (
+	CountMode_COUNT_MODE_UNSPECIFIED CountMode = 0
+	CountMode_COUNT_MODE_EXACT       CountMode = 1
+	CountMode_COUNT_MODE_ESTIMATED   CountMode = 2
+	CountMode_COUNT_MODE_NONE        CountMode = 3
+)
+
+// Enum value maps for CountMode.
+var (
+	CountMode_name = map[int32]string{
+		0: "COUNT_MODE_UNSPECIFIED",
+		1: "COUNT_MODE_EXACT",
+		2: "COUNT_MODE_ESTIMATED",
+		3: "COUNT_MODE_NONE",
+	}
+	CountMode_value = map[string]int32{
+		"COUNT_MODE_UNSPECIFIED": 0,
+		"COUNT_MODE_EXACT":       1,
+		"COUNT_MODE_ESTIMATED":   2,
+		"COUNT_MODE_NONE":        3,
+	}
+)
+
+func (x CountMode) Enum() *CountMode {
+	p := new(CountMode)
+	*p = x
+	return p
+}
+
+func (x CountMode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CountMode) Descriptor() protoreflect.EnumDescriptor {
+	return file_anna_v1_anna_proto_enumTypes[1].Descriptor()
+}
+
+func (CountMode) Type() protoreflect.EnumType {
+	return &file_anna_v1_anna_proto_enumTypes[1]
+}
+
+func (x CountMode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CountMode.Descriptor instead.
+func (CountMode) EnumDescriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{1}
+}
+
+type Sort int32
+
+const (
+	Sort_SORT_UNSPECIFIED Sort = 0
+	Sort_SORT_ID          Sort = 1
+	// Recently added to Anna's Archive first, paginated with offset only.
+	Sort_SORT_ADDED Sort = 2
+)
+
+// Enum value maps for Sort.
+var (
+	Sort_name = map[int32]string{
+		0: "SORT_UNSPECIFIED",
+		1: "SORT_ID",
+		2: "SORT_ADDED",
+	}
+	Sort_value = map[string]int32{
+		"SORT_UNSPECIFIED": 0,
+		"SORT_ID":          1,
+		"SORT_ADDED":       2,
+	}
+)
+
+func (x Sort) Enum() *Sort {
+	p := new(Sort)
+	*p = x
+	return p
+}
+
+func (x Sort) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Sort) Descriptor() protoreflect.EnumDescriptor {
+	return file_anna_v1_anna_proto_enumTypes[2].Descriptor()
+}
+
+func (Sort) Type() protoreflect.EnumType {
+	return &file_anna_v1_anna_proto_enumTypes[2]
+}
+
+func (x Sort) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Sort.Descriptor instead.
+func (Sort) EnumDescriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{2}
+}
+
+type DownloadStatus int32
+
+const (
+	DownloadStatus_DOWNLOAD_STATUS_UNSPECIFIED DownloadStatus = 0
+	DownloadStatus_DOWNLOAD_STATUS_NOT_STARTED DownloadStatus = 1
+	DownloadStatus_DOWNLOAD_STATUS_DOWNLOADING DownloadStatus = 2
+	DownloadStatus_DOWNLOAD_STATUS_DOWNLOADED  DownloadStatus = 3
+	DownloadStatus_DOWNLOAD_STATUS_QUEUED      DownloadStatus = 4
+)
+
+// Enum value maps for DownloadStatus.
+var (
+	DownloadStatus_name = map[int32]string{
+		0: "DOWNLOAD_STATUS_UNSPECIFIED",
+		1: "DOWNLOAD_STATUS_NOT_STARTED",
+		2: "DOWNLOAD_STATUS_DOWNLOADING",
+		3: "DOWNLOAD_STATUS_DOWNLOADED",
+		4: "DOWNLOAD_STATUS_QUEUED",
+	}
+	DownloadStatus_value = map[string]int32{
+		"DOWNLOAD_STATUS_UNSPECIFIED": 0,
+		"DOWNLOAD_STATUS_NOT_STARTED": 1,
+		"DOWNLOAD_STATUS_DOWNLOADING": 2,
+		"DOWNLOAD_STATUS_DOWNLOADED":  3,
+		"DOWNLOAD_STATUS_QUEUED":      4,
+	}
+)
+
+func (x DownloadStatus) Enum() *DownloadStatus {
+	p := new(DownloadStatus)
+	*p = x
+	return p
+}
+
+func (x DownloadStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (DownloadStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_anna_v1_anna_proto_enumTypes[3].Descriptor()
+}
+
+func (DownloadStatus) Type() protoreflect.EnumType {
+	return &file_anna_v1_anna_proto_enumTypes[3]
+}
+
+func (x DownloadStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use DownloadStatus.Descriptor instead.
+func (DownloadStatus) EnumDescriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{3}
+}
+
+type Identifier struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Identifier) Reset() {
+	*x = Identifier{}
+	mi := &file_anna_v1_anna_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Identifier) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Identifier) ProtoMessage() {}
+
+func (x *Identifier) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Identifier.ProtoReflect.Descriptor instead.
+func (*Identifier) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Identifier) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Identifier) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type Classification struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Classification) Reset() {
+	*x = Classification{}
+	mi := &file_anna_v1_anna_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Classification) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Classification) ProtoMessage() {}
+
+func (x *Classification) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Classification.ProtoReflect.Descriptor instead.
+func (*Classification) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Classification) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Classification) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type Record struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Title           string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Publisher       string                 `protobuf:"bytes,3,opt,name=publisher,proto3" json:"publisher,omitempty"`
+	Author          string                 `protobuf:"bytes,4,opt,name=author,proto3" json:"author,omitempty"`
+	CoverUrl        string                 `protobuf:"bytes,5,opt,name=cover_url,json=coverUrl,proto3" json:"cover_url,omitempty"`
+	Year            int32                  `protobuf:"varint,6,opt,name=year,proto3" json:"year,omitempty"`
+	Languages       []string               `protobuf:"bytes,7,rep,name=languages,proto3" json:"languages,omitempty"`
+	Description     string                 `protobuf:"bytes,8,opt,name=description,proto3" json:"description,omitempty"`
+	ContentType     string                 `protobuf:"bytes,9,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Series          string                 `protobuf:"bytes,10,opt,name=series,proto3" json:"series,omitempty"`
+	SeriesIndex     float64                `protobuf:"fixed64,11,opt,name=series_index,json=seriesIndex,proto3" json:"series_index,omitempty"`
+	Identifiers     []*Identifier          `protobuf:"bytes,12,rep,name=identifiers,proto3" json:"identifiers,omitempty"`
+	Classifications []*Classification      `protobuf:"bytes,13,rep,name=classifications,proto3" json:"classifications,omitempty"`
+	CreatedAt       *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt       *timestamppb.Timestamp `protobuf:"bytes,15,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	Extension       string                 `protobuf:"bytes,16,opt,name=extension,proto3" json:"extension,omitempty"`
+	// Size of the file in bytes, 0 when unknown.
+	Filesize int64  `protobuf:"varint,17,opt,name=filesize,proto3" json:"filesize,omitempty"`
+	Md5      string `protobuf:"bytes,18,opt,name=md5,proto3" json:"md5,omitempty"`
+	// Date the file was added to Anna's Archive, absent when unknown.
+	AddedAt       *timestamppb.Timestamp `protobuf:"bytes,19,opt,name=added_at,json=addedAt,proto3" json:"added_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Record) Reset() {
+	*x = Record{}
+	mi := &file_anna_v1_anna_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Record) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Record) ProtoMessage() {}
+
+func (x *Record) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Record.ProtoReflect.Descriptor instead.
+func (*Record) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Record) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Record) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Record) GetPublisher() string {
+	if x != nil {
+		return x.Publisher
+	}
+	return ""
+}
+
+func (x *Record) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *Record) GetCoverUrl() string {
+	if x != nil {
+		return x.CoverUrl
+	}
+	return ""
+}
+
+func (x *Record) GetYear() int32 {
+	if x != nil {
+		return x.Year
+	}
+	return 0
+}
+
+func (x *Record) GetLanguages() []string {
+	if x != nil {
+		return x.Languages
+	}
+	return nil
+}
+
+func (x *Record) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Record) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *Record) GetSeries() string {
+	if x != nil {
+		return x.Series
+	}
+	return ""
+}
+
+func (x *Record) GetSeriesIndex() float64 {
+	if x != nil {
+		return x.SeriesIndex
+	}
+	return 0
+}
+
+func (x *Record) GetIdentifiers() []*Identifier {
+	if x != nil {
+		return x.Identifiers
+	}
+	return nil
+}
+
+func (x *Record) GetClassifications() []*Classification {
+	if x != nil {
+		return x.Classifications
+	}
+	return nil
+}
+
+func (x *Record) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Record) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *Record) GetExtension() string {
+	if x != nil {
+		return x.Extension
+	}
+	return ""
+}
+
+func (x *Record) GetFilesize() int64 {
+	if x != nil {
+		return x.Filesize
+	}
+	return 0
+}
+
+func (x *Record) GetMd5() string {
+	if x != nil {
+		return x.Md5
+	}
+	return ""
+}
+
+func (x *Record) GetAddedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.AddedAt
+	}
+	return nil
+}
+
+type SearchFilters struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	Languages           []string               `protobuf:"bytes,1,rep,name=languages,proto3" json:"languages,omitempty"`
+	LanguageMode        LanguageMode           `protobuf:"varint,2,opt,name=language_mode,json=languageMode,proto3,enum=anna.v1.LanguageMode" json:"language_mode,omitempty"`
+	ContentTypes        []string               `protobuf:"bytes,3,rep,name=content_types,json=contentTypes,proto3" json:"content_types,omitempty"`
+	ExcludeContentTypes []string               `protobuf:"bytes,4,rep,name=exclude_content_types,json=excludeContentTypes,proto3" json:"exclude_content_types,omitempty"`
+	Series              string                 `protobuf:"bytes,5,opt,name=series,proto3" json:"series,omitempty"`
+	ClassificationType  string                 `protobuf:"bytes,6,opt,name=classification_type,json=classificationType,proto3" json:"classification_type,omitempty"`
+	ClassificationValue string                 `protobuf:"bytes,7,opt,name=classification_value,json=classificationValue,proto3" json:"classification_value,omitempty"`
+	Formats             []string               `protobuf:"bytes,8,rep,name=formats,proto3" json:"formats,omitempty"`
+	// Records added to Anna's Archive on or after added_after, and before added_before.
+	AddedAfter    *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=added_after,json=addedAfter,proto3" json:"added_after,omitempty"`
+	AddedBefore   *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=added_before,json=addedBefore,proto3" json:"added_before,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchFilters) Reset() {
+	*x = SearchFilters{}
+	mi := &file_anna_v1_anna_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchFilters) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchFilters) ProtoMessage() {}
+
+func (x *SearchFilters) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchFilters.ProtoReflect.Descriptor instead.
+func (*SearchFilters) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SearchFilters) GetLanguages() []string {
+	if x != nil {
+		return x.Languages
+	}
+	return nil
+}
+
+func (x *SearchFilters) GetLanguageMode() LanguageMode {
+	if x != nil {
+		return x.LanguageMode
+	}
+	return LanguageMode_LANGUAGE_MODE_UNSPECIFIED
+}
+
+func (x *SearchFilters) GetContentTypes() []string {
+	if x != nil {
+		return x.ContentTypes
+	}
+	return nil
+}
+
+func (x *SearchFilters) GetExcludeContentTypes() []string {
+	if x != nil {
+		return x.ExcludeContentTypes
+	}
+	return nil
+}
+
+func (x *SearchFilters) GetSeries() string {
+	if x != nil {
+		return x.Series
+	}
+	return ""
+}
+
+func (x *SearchFilters) GetClassificationType() string {
+	if x != nil {
+		return x.ClassificationType
+	}
+	return ""
+}
+
+func (x *SearchFilters) GetClassificationValue() string {
+	if x != nil {
+		return x.ClassificationValue
+	}
+	return ""
+}
+
+func (x *SearchFilters) GetFormats() []string {
+	if x != nil {
+		return x.Formats
+	}
+	return nil
+}
+
+func (x *SearchFilters) GetAddedAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.AddedAfter
+	}
+	return nil
+}
+
+func (x *SearchFilters) GetAddedBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.AddedBefore
+	}
+	return nil
+}
+
+type Page struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Defaults to 20, capped at 100.
+	Limit  int32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset int32 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	// Opaque cursor returned as next_cursor by the previous page, offset is ignored when set.
+	Cursor        string    `protobuf:"bytes,3,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	Count         CountMode `protobuf:"varint,4,opt,name=count,proto3,enum=anna.v1.CountMode" json:"count,omitempty"`
+	Sort          Sort      `protobuf:"varint,5,opt,name=sort,proto3,enum=anna.v1.Sort" json:"sort,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Page) Reset() {
+	*x = Page{}
+	mi := &file_anna_v1_anna_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Page) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Page) ProtoMessage() {}
+
+func (x *Page) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Page.ProtoReflect.Descriptor instead.
+func (*Page) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Page) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *Page) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *Page) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *Page) GetCount() CountMode {
+	if x != nil {
+		return x.Count
+	}
+	return CountMode_COUNT_MODE_UNSPECIFIED
+}
+
+func (x *Page) GetSort() Sort {
+	if x != nil {
+		return x.Sort
+	}
+	return Sort_SORT_UNSPECIFIED
+}
+
+type SearchRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Query   string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Filters *SearchFilters         `protobuf:"bytes,2,opt,name=filters,proto3" json:"filters,omitempty"`
+	Page    *Page                  `protobuf:"bytes,3,opt,name=page,proto3" json:"page,omitempty"`
+	// Record fields to return, using the HTTP API JSON names. Everything is returned when empty.
+	Fields        []string `protobuf:"bytes,4,rep,name=fields,proto3" json:"fields,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchRequest) Reset() {
+	*x = SearchRequest{}
+	mi := &file_anna_v1_anna_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchRequest) ProtoMessage() {}
+
+func (x *SearchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchRequest.ProtoReflect.Descriptor instead.
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SearchRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetFilters() *SearchFilters {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetPage() *Page {
+	if x != nil {
+		return x.Page
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetFields() []string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+type SearchByISBNRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Isbn          string                 `protobuf:"bytes,1,opt,name=isbn,proto3" json:"isbn,omitempty"`
+	Filters       *SearchFilters         `protobuf:"bytes,2,opt,name=filters,proto3" json:"filters,omitempty"`
+	Page          *Page                  `protobuf:"bytes,3,opt,name=page,proto3" json:"page,omitempty"`
+	Fields        []string               `protobuf:"bytes,4,rep,name=fields,proto3" json:"fields,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchByISBNRequest) Reset() {
+	*x = SearchByISBNRequest{}
+	mi := &file_anna_v1_anna_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchByISBNRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchByISBNRequest) ProtoMessage() {}
+
+func (x *SearchByISBNRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchByISBNRequest.ProtoReflect.Descriptor instead.
+func (*SearchByISBNRequest) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SearchByISBNRequest) GetIsbn() string {
+	if x != nil {
+		return x.Isbn
+	}
+	return ""
+}
+
+func (x *SearchByISBNRequest) GetFilters() *SearchFilters {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *SearchByISBNRequest) GetPage() *Page {
+	if x != nil {
+		return x.Page
+	}
+	return nil
+}
+
+func (x *SearchByISBNRequest) GetFields() []string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+type SearchByTextRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Title     string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Author    string                 `protobuf:"bytes,2,opt,name=author,proto3" json:"author,omitempty"`
+	Publisher string                 `protobuf:"bytes,3,opt,name=publisher,proto3" json:"publisher,omitempty"`
+	Fuzzy     bool                   `protobuf:"varint,4,opt,name=fuzzy,proto3" json:"fuzzy,omitempty"`
+	Threshold float64                `protobuf:"fixed64,5,opt,name=threshold,proto3" json:"threshold,omitempty"`
+	Filters   *SearchFilters         `protobuf:"bytes,6,opt,name=filters,proto3" json:"filters,omitempty"`
+	Page      *Page                  `protobuf:"bytes,7,opt,name=page,proto3" json:"page,omitempty"`
+	Fields    []string               `protobuf:"bytes,8,rep,name=fields,proto3" json:"fields,omitempty"`
+	// Matched with full-text search even when fuzzy is set.
+	Description   string `protobuf:"bytes,9,opt,name=description,proto3" json:"description,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchByTextRequest) Reset() {
+	*x = SearchByTextRequest{}
+	mi := &file_anna_v1_anna_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchByTextRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchByTextRequest) ProtoMessage() {}
+
+func (x *SearchByTextRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchByTextRequest.ProtoReflect.Descriptor instead.
+func (*SearchByTextRequest) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SearchByTextRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *SearchByTextRequest) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *SearchByTextRequest) GetPublisher() string {
+	if x != nil {
+		return x.Publisher
+	}
+	return ""
+}
+
+func (x *SearchByTextRequest) GetFuzzy() bool {
+	if x != nil {
+		return x.Fuzzy
+	}
+	return false
+}
+
+func (x *SearchByTextRequest) GetThreshold() float64 {
+	if x != nil {
+		return x.Threshold
+	}
+	return 0
+}
+
+func (x *SearchByTextRequest) GetFilters() *SearchFilters {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *SearchByTextRequest) GetPage() *Page {
+	if x != nil {
+		return x.Page
+	}
+	return nil
+}
+
+func (x *SearchByTextRequest) GetFields() []string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+func (x *SearchByTextRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+type SearchResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Search strategy used to resolve the query, only set by Search.
+	Mode string `protobuf:"bytes,1,opt,name=mode,proto3" json:"mode,omitempty"`
+	// Absent when counting is disabled.
+	Total          *int64    `protobuf:"varint,2,opt,name=total,proto3,oneof" json:"total,omitempty"`
+	TotalEstimated bool      `protobuf:"varint,3,opt,name=total_estimated,json=totalEstimated,proto3" json:"total_estimated,omitempty"`
+	Results        []*Record `protobuf:"bytes,4,rep,name=results,proto3" json:"results,omitempty"`
+	NextCursor     string    `protobuf:"bytes,5,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *SearchResponse) Reset() {
+	*x = SearchResponse{}
+	mi := &file_anna_v1_anna_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchResponse) ProtoMessage() {}
+
+func (x *SearchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchResponse.ProtoReflect.Descriptor instead.
+func (*SearchResponse) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SearchResponse) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *SearchResponse) GetTotal() int64 {
+	if x != nil && x.Total != nil {
+		return *x.Total
+	}
+	return 0
+}
+
+func (x *SearchResponse) GetTotalEstimated() bool {
+	if x != nil {
+		return x.TotalEstimated
+	}
+	return false
+}
+
+func (x *SearchResponse) GetResults() []*Record {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *SearchResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+type GetRecordRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRecordRequest) Reset() {
+	*x = GetRecordRequest{}
+	mi := &file_anna_v1_anna_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRecordRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRecordRequest) ProtoMessage() {}
+
+func (x *GetRecordRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRecordRequest.ProtoReflect.Descriptor instead.
+func (*GetRecordRequest) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetRecordRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type GetDownloadStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDownloadStatusRequest) Reset() {
+	*x = GetDownloadStatusRequest{}
+	mi := &file_anna_v1_anna_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDownloadStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDownloadStatusRequest) ProtoMessage() {}
+
+func (x *GetDownloadStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDownloadStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetDownloadStatusRequest) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetDownloadStatusRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type GetDownloadStatusResponse struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Status DownloadStatus         `protobuf:"varint,1,opt,name=status,proto3,enum=anna.v1.DownloadStatus" json:"status,omitempty"`
+	// Position in the download queue, only set while queued
+	Position      int32 `protobuf:"varint,2,opt,name=position,proto3" json:"position,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDownloadStatusResponse) Reset() {
+	*x = GetDownloadStatusResponse{}
+	mi := &file_anna_v1_anna_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDownloadStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDownloadStatusResponse) ProtoMessage() {}
+
+func (x *GetDownloadStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_anna_v1_anna_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDownloadStatusResponse.ProtoReflect.Descriptor instead.
+func (*GetDownloadStatusResponse) Descriptor() ([]byte, []int) {
+	return file_anna_v1_anna_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GetDownloadStatusResponse) GetStatus() DownloadStatus {
+	if x != nil {
+		return x.Status
+	}
+	return DownloadStatus_DOWNLOAD_STATUS_UNSPECIFIED
+}
+
+func (x *GetDownloadStatusResponse) GetPosition() int32 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+var File_anna_v1_anna_proto protoreflect.FileDescriptor
+
+const file_anna_v1_anna_proto_rawDesc = "" +
+	"\n" +
+	"\x12anna/v1/anna.proto\x12\aanna.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"6\n" +
+	"\n" +
+	"Identifier\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\":\n" +
+	"\x0eClassification\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\"\xa6\x05\n" +
+	"\x06Record\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x1c\n" +
+	"\tpublisher\x18\x03 \x01(\tR\tpublisher\x12\x16\n" +
+	"\x06author\x18\x04 \x01(\tR\x06author\x12\x1b\n" +
+	"\tcover_url\x18\x05 \x01(\tR\bcoverUrl\x12\x12\n" +
+	"\x04year\x18\x06 \x01(\x05R\x04year\x12\x1c\n" +
+	"\tlanguages\x18\a \x03(\tR\tlanguages\x12 \n" +
+	"\vdescription\x18\b \x01(\tR\vdescription\x12!\n" +
+	"\fcontent_type\x18\t \x01(\tR\vcontentType\x12\x16\n" +
+	"\x06series\x18\n" +
+	" \x01(\tR\x06series\x12!\n" +
+	"\fseries_index\x18\v \x01(\x01R\vseriesIndex\x125\n" +
+	"\videntifiers\x18\f \x03(\v2\x13.anna.v1.IdentifierR\videntifiers\x12A\n" +
+	"\x0fclassifications\x18\r \x03(\v2\x17.anna.v1.ClassificationR\x0fclassifications\x129\n" +
+	"\n" +
+	"created_at\x18\x0e \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x0f \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12\x1c\n" +
+	"\textension\x18\x10 \x01(\tR\textension\x12\x1a\n" +
+	"\bfilesize\x18\x11 \x01(\x03R\bfilesize\x12\x10\n" +
+	"\x03md5\x18\x12 \x01(\tR\x03md5\x125\n" +
+	"\badded_at\x18\x13 \x01(\v2\x1a.google.protobuf.TimestampR\aaddedAt\"\xd4\x03\n" +
+	"\rSearchFilters\x12\x1c\n" +
+	"\tlanguages\x18\x01 \x03(\tR\tlanguages\x12:\n" +
+	"\rlanguage_mode\x18\x02 \x01(\x0e2\x15.anna.v1.LanguageModeR\flanguageMode\x12#\n" +
+	"\rcontent_types\x18\x03 \x03(\tR\fcontentTypes\x122\n" +
+	"\x15exclude_content_types\x18\x04 \x03(\tR\x13excludeContentTypes\x12\x16\n" +
+	"\x06series\x18\x05 \x01(\tR\x06series\x12/\n" +
+	"\x13classification_type\x18\x06 \x01(\tR\x12classificationType\x121\n" +
+	"\x14classification_value\x18\a \x01(\tR\x13classificationValue\x12\x18\n" +
+	"\aformats\x18\b \x03(\tR\aformats\x12;\n" +
+	"\vadded_after\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"addedAfter\x12=\n" +
+	"\fadded_before\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\vaddedBefore\"\x99\x01\n" +
+	"\x04Page\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x05R\x06offset\x12\x16\n" +
+	"\x06cursor\x18\x03 \x01(\tR\x06cursor\x12(\n" +
+	"\x05count\x18\x04 \x01(\x0e2\x12.anna.v1.CountModeR\x05count\x12!\n" +
+	"\x04sort\x18\x05 \x01(\x0e2\r.anna.v1.SortR\x04sort\"\x92\x01\n" +
+	"\rSearchRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\x120\n" +
+	"\afilters\x18\x02 \x01(\v2\x16.anna.v1.SearchFiltersR\afilters\x12!\n" +
+	"\x04page\x18\x03 \x01(\v2\r.anna.v1.PageR\x04page\x12\x16\n" +
+	"\x06fields\x18\x04 \x03(\tR\x06fields\"\x96\x01\n" +
+	"\x13SearchByISBNRequest\x12\x12\n" +
+	"\x04isbn\x18\x01 \x01(\tR\x04isbn\x120\n" +
+	"\afilters\x18\x02 \x01(\v2\x16.anna.v1.SearchFiltersR\afilters\x12!\n" +
+	"\x04page\x18\x03 \x01(\v2\r.anna.v1.PageR\x04page\x12\x16\n" +
+	"\x06fields\x18\x04 \x03(\tR\x06fields\"\xa4\x02\n" +
+	"\x13SearchByTextRequest\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x16\n" +
+	"\x06author\x18\x02 \x01(\tR\x06author\x12\x1c\n" +
+	"\tpublisher\x18\x03 \x01(\tR\tpublisher\x12\x14\n" +
+	"\x05fuzzy\x18\x04 \x01(\bR\x05fuzzy\x12\x1c\n" +
+	"\tthreshold\x18\x05 \x01(\x01R\tthreshold\x120\n" +
+	"\afilters\x18\x06 \x01(\v2\x16.anna.v1.SearchFiltersR\afilters\x12!\n" +
+	"\x04page\x18\a \x01(\v2\r.anna.v1.PageR\x04page\x12\x16\n" +
+	"\x06fields\x18\b \x03(\tR\x06fields\x12 \n" +
+	"\vdescription\x18\t \x01(\tR\vdescription\"\xbe\x01\n" +
+	"\x0eSearchResponse\x12\x12\n" +
+	"\x04mode\x18\x01 \x01(\tR\x04mode\x12\x19\n" +
+	"\x05total\x18\x02 \x01(\x03H\x00R\x05total\x88\x01\x01\x12'\n" +
+	"\x0ftotal_estimated\x18\x03 \x01(\bR\x0etotalEstimated\x12)\n" +
+	"\aresults\x18\x04 \x03(\v2\x0f.anna.v1.RecordR\aresults\x12\x1f\n" +
+	"\vnext_cursor\x18\x05 \x01(\tR\n" +
+	"nextCursorB\b\n" +
+	"\x06_total\"\"\n" +
+	"\x10GetRecordRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"*\n" +
+	"\x18GetDownloadStatusRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"h\n" +
+	"\x19GetDownloadStatusResponse\x12/\n" +
+	"\x06status\x18\x01 \x01(\x0e2\x17.anna.v1.DownloadStatusR\x06status\x12\x1a\n" +
+	"\bposition\x18\x02 \x01(\x05R\bposition*t\n" +
+	"\fLanguageMode\x12\x1d\n" +
+	"\x19LANGUAGE_MODE_UNSPECIFIED\x10\x00\x12\x17\n" +
+	"\x13LANGUAGE_MODE_EXACT\x10\x01\x12\x15\n" +
+	"\x11LANGUAGE_MODE_ANY\x10\x02\x12\x15\n" +
+	"\x11LANGUAGE_MODE_ALL\x10\x03*l\n" +
+	"\tCountMode\x12\x1a\n" +
+	"\x16COUNT_MODE_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10COUNT_MODE_EXACT\x10\x01\x12\x18\n" +
+	"\x14COUNT_MODE_ESTIMATED\x10\x02\x12\x13\n" +
+	"\x0fCOUNT_MODE_NONE\x10\x03*9\n" +
+	"\x04Sort\x12\x14\n" +
+	"\x10SORT_UNSPECIFIED\x10\x00\x12\v\n" +
+	"\aSORT_ID\x10\x01\x12\x0e\n" +
+	"\n" +
+	"SORT_ADDED\x10\x02*\xaf\x01\n" +
+	"\x0eDownloadStatus\x12\x1f\n" +
+	"\x1bDOWNLOAD_STATUS_UNSPECIFIED\x10\x00\x12\x1f\n" +
+	"\x1bDOWNLOAD_STATUS_NOT_STARTED\x10\x01\x12\x1f\n" +
+	"\x1bDOWNLOAD_STATUS_DOWNLOADING\x10\x02\x12\x1e\n" +
+	"\x1aDOWNLOAD_STATUS_DOWNLOADED\x10\x03\x12\x1a\n" +
+	"\x16DOWNLOAD_STATUS_QUEUED\x10\x042\xeb\x02\n" +
+	"\vAnnaService\x129\n" +
+	"\x06Search\x12\x16.anna.v1.SearchRequest\x1a\x17.anna.v1.SearchResponse\x12E\n" +
+	"\fSearchByISBN\x12\x1c.anna.v1.SearchByISBNRequest\x1a\x17.anna.v1.SearchResponse\x12E\n" +
+	"\fSearchByText\x12\x1c.anna.v1.SearchByTextRequest\x1a\x17.anna.v1.SearchResponse\x127\n" +
+	"\tGetRecord\x12\x19.anna.v1.GetRecordRequest\x1a\x0f.anna.v1.Record\x12Z\n" +
+	"\x11GetDownloadStatus\x12!.anna.v1.GetDownloadStatusRequest\x1a\".anna.v1.GetDownloadStatusResponseB3Z1github.com/iziplay/anna-api/pkg/rpc/annapb;annapbb\x06proto3"
+
+var (
+	file_anna_v1_anna_proto_rawDescOnce sync.Once
+	file_anna_v1_anna_proto_rawDescData []byte
+)
+
+func file_anna_v1_anna_proto_rawDescGZIP() []byte {
+	file_anna_v1_anna_proto_rawDescOnce.Do(func() {
+		file_anna_v1_anna_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_anna_v1_anna_proto_rawDesc), len(file_anna_v1_anna_proto_rawDesc)))
+	})
+	return file_anna_v1_anna_proto_rawDescData
+}
+
+var file_anna_v1_anna_proto_enumTypes = make([]protoimpl.EnumInfo, 4)
+var file_anna_v1_anna_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_anna_v1_anna_proto_goTypes = []any{
+	(LanguageMode)(0),                 // 0: anna.v1.LanguageMode
+	(CountMode)(0),                    // 1: anna.v1.CountMode
+	(Sort)(0),                         // 2: anna.v1.Sort
+	(DownloadStatus)(0),               // 3: anna.v1.DownloadStatus
+	(*Identifier)(nil),                // 4: anna.v1.Identifier
+	(*Classification)(nil),            // 5: anna.v1.Classification
+	(*Record)(nil),                    // 6: anna.v1.Record
+	(*SearchFilters)(nil),             // 7: anna.v1.SearchFilters
+	(*Page)(nil),                      // 8: anna.v1.Page
+	(*SearchRequest)(nil),             // 9: anna.v1.SearchRequest
+	(*SearchByISBNRequest)(nil),       // 10: anna.v1.SearchByISBNRequest
+	(*SearchByTextRequest)(nil),       // 11: anna.v1.SearchByTextRequest
+	(*SearchResponse)(nil),            // 12: anna.v1.SearchResponse
+	(*GetRecordRequest)(nil),          // 13: anna.v1.GetRecordRequest
+	(*GetDownloadStatusRequest)(nil),  // 14: anna.v1.GetDownloadStatusRequest
+	(*GetDownloadStatusResponse)(nil), // 15: anna.v1.GetDownloadStatusResponse
+	(*timestamppb.Timestamp)(nil),     // 16: google.protobuf.Timestamp
+}
+var file_anna_v1_anna_proto_depIdxs = []int32{
+	4,  // 0: anna.v1.Record.identifiers:type_name -> anna.v1.Identifier
+	5,  // 1: anna.v1.Record.classifications:type_name -> anna.v1.Classification
+	16, // 2: anna.v1.Record.created_at:type_name -> google.protobuf.Timestamp
+	16, // 3: anna.v1.Record.updated_at:type_name -> google.protobuf.Timestamp
+	16, // 4: anna.v1.Record.added_at:type_name -> google.protobuf.Timestamp
+	0,  // 5: anna.v1.SearchFilters.language_mode:type_name -> anna.v1.LanguageMode
+	16, // 6: anna.v1.SearchFilters.added_after:type_name -> google.protobuf.Timestamp
+	16, // 7: anna.v1.SearchFilters.added_before:type_name -> google.protobuf.Timestamp
+	1,  // 8: anna.v1.Page.count:type_name -> anna.v1.CountMode
+	2,  // 9: anna.v1.Page.sort:type_name -> anna.v1.Sort
+	7,  // 10: anna.v1.SearchRequest.filters:type_name -> anna.v1.SearchFilters
+	8,  // 11: anna.v1.SearchRequest.page:type_name -> anna.v1.Page
+	7,  // 12: anna.v1.SearchByISBNRequest.filters:type_name -> anna.v1.SearchFilters
+	8,  // 13: anna.v1.SearchByISBNRequest.page:type_name -> anna.v1.Page
+	7,  // 14: anna.v1.SearchByTextRequest.filters:type_name -> anna.v1.SearchFilters
+	8,  // 15: anna.v1.SearchByTextRequest.page:type_name -> anna.v1.Page
+	6,  // 16: anna.v1.SearchResponse.results:type_name -> anna.v1.Record
+	3,  // 17: anna.v1.GetDownloadStatusResponse.status:type_name -> anna.v1.DownloadStatus
+	9,  // 18: anna.v1.AnnaService.Search:input_type -> anna.v1.SearchRequest
+	10, // 19: anna.v1.AnnaService.SearchByISBN:input_type -> anna.v1.SearchByISBNRequest
+	11, // 20: anna.v1.AnnaService.SearchByText:input_type -> anna.v1.SearchByTextRequest
+	13, // 21: anna.v1.AnnaService.GetRecord:input_type -> anna.v1.GetRecordRequest
+	14, // 22: anna.v1.AnnaService.GetDownloadStatus:input_type -> anna.v1.GetDownloadStatusRequest
+	12, // 23: anna.v1.AnnaService.Search:output_type -> anna.v1.SearchResponse
+	12, // 24: anna.v1.AnnaService.SearchByISBN:output_type -> anna.v1.SearchResponse
+	12, // 25: anna.v1.AnnaService.SearchByText:output_type -> anna.v1.SearchResponse
+	6,  // 26: anna.v1.AnnaService.GetRecord:output_type -> anna.v1.Record
+	15, // 27: anna.v1.AnnaService.GetDownloadStatus:output_type -> anna.v1.GetDownloadStatusResponse
+	23, // [23:28] is the sub-list for method output_type
+	18, // [18:23] is the sub-list for method input_type
+	18, // [18:18] is the sub-list for extension type_name
+	18, // [18:18] is the sub-list for extension extendee
+	0,  // [0:18] is the sub-list for field type_name
+}
+
+func init() { file_anna_v1_anna_proto_init() }
+func file_anna_v1_anna_proto_init() {
+	if File_anna_v1_anna_proto != nil {
+		return
+	}
+	file_anna_v1_anna_proto_msgTypes[8].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_anna_v1_anna_proto_rawDesc), len(file_anna_v1_anna_proto_rawDesc)),
+			NumEnums:      4,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_anna_v1_anna_proto_goTypes,
+		DependencyIndexes: file_anna_v1_anna_proto_depIdxs,
+		EnumInfos:         file_anna_v1_anna_proto_enumTypes,
+		MessageInfos:      file_anna_v1_anna_proto_msgTypes,
+	}.Build()
+	File_anna_v1_anna_proto = out.File
+	file_anna_v1_anna_proto_goTypes = nil
+	file_anna_v1_anna_proto_depIdxs = nil
+}
@@ -0,0 +1,291 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: anna/v1/anna.proto
+
+package annapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	AnnaService_Search_FullMethodName            = "/anna.v1.AnnaService/Search"
+	AnnaService_SearchByISBN_FullMethodName      = "/anna.v1.AnnaService/SearchByISBN"
+	AnnaService_SearchByText_FullMethodName      = "/anna.v1.AnnaService/SearchByText"
+	AnnaService_GetRecord_FullMethodName         = "/anna.v1.AnnaService/GetRecord"
+	AnnaService_GetDownloadStatus_FullMethodName = "/anna.v1.AnnaService/GetDownloadStatus"
+)
+
+// AnnaServiceClient is the client API for AnnaService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// AnnaService mirrors the search, record lookup and download status
+// operations of the HTTP API for internal consumers.
+type AnnaServiceClient interface {
+	// Search dispatches a single query to an ISBN, identifier or text search.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// SearchByISBN finds records matching an ISBN10 or ISBN13 code.
+	SearchByISBN(ctx context.Context, in *SearchByISBNRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// SearchByText finds records by title, author, publisher and description.
+	SearchByText(ctx context.Context, in *SearchByTextRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// GetRecord returns a single record by its ID.
+	GetRecord(ctx context.Context, in *GetRecordRequest, opts ...grpc.CallOption) (*Record, error)
+	// GetDownloadStatus returns the status of the file download of a record.
+	// It requires a bearer token in the authorization metadata when authentication is enabled.
+	GetDownloadStatus(ctx context.Context, in *GetDownloadStatusRequest, opts ...grpc.CallOption) (*GetDownloadStatusResponse, error)
+}
+
+type annaServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAnnaServiceClient(cc grpc.ClientConnInterface) AnnaServiceClient {
+	return &annaServiceClient{cc}
+}
+
+func (c *annaServiceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, AnnaService_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *annaServiceClient) SearchByISBN(ctx context.Context, in *SearchByISBNRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, AnnaService_SearchByISBN_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *annaServiceClient) SearchByText(ctx context.Context, in *SearchByTextRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, AnnaService_SearchByText_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *annaServiceClient) GetRecord(ctx context.Context, in *GetRecordRequest, opts ...grpc.CallOption) (*Record, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Record)
+	err := c.cc.Invoke(ctx, AnnaService_GetRecord_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *annaServiceClient) GetDownloadStatus(ctx context.Context, in *GetDownloadStatusRequest, opts ...grpc.CallOption) (*GetDownloadStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetDownloadStatusResponse)
+	err := c.cc.Invoke(ctx, AnnaService_GetDownloadStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AnnaServiceServer is the server API for AnnaService service.
+// All implementations must embed UnimplementedAnnaServiceServer
+// for forward compatibility.
+//
+// AnnaService mirrors the search, record lookup and download status
+// operations of the HTTP API for internal consumers.
+type AnnaServiceServer interface {
+	// Search dispatches a single query to an ISBN, identifier or text search.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// SearchByISBN finds records matching an ISBN10 or ISBN13 code.
+	SearchByISBN(context.Context, *SearchByISBNRequest) (*SearchResponse, error)
+	// SearchByText finds records by title, author, publisher and description.
+	SearchByText(context.Context, *SearchByTextRequest) (*SearchResponse, error)
+	// GetRecord returns a single record by its ID.
+	GetRecord(context.Context, *GetRecordRequest) (*Record, error)
+	// GetDownloadStatus returns the status of the file download of a record.
+	// It requires a bearer token in the authorization metadata when authentication is enabled.
+	GetDownloadStatus(context.Context, *GetDownloadStatusRequest) (*GetDownloadStatusResponse, error)
+	mustEmbedUnimplementedAnnaServiceServer()
+}
+
+// UnimplementedAnnaServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAnnaServiceServer struct{}
+
+func (UnimplementedAnnaServiceServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedAnnaServiceServer) SearchByISBN(context.Context, *SearchByISBNRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchByISBN not implemented")
+}
+func (UnimplementedAnnaServiceServer) SearchByText(context.Context, *SearchByTextRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchByText not implemented")
+}
+func (UnimplementedAnnaServiceServer) GetRecord(context.Context, *GetRecordRequest) (*Record, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRecord not implemented")
+}
+func (UnimplementedAnnaServiceServer) GetDownloadStatus(context.Context, *GetDownloadStatusRequest) (*GetDownloadStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDownloadStatus not implemented")
+}
+func (UnimplementedAnnaServiceServer) mustEmbedUnimplementedAnnaServiceServer() {}
+func (UnimplementedAnnaServiceServer) testEmbeddedByValue()                     {}
+
+// UnsafeAnnaServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AnnaServiceServer will
+// result in compilation errors.
+type UnsafeAnnaServiceServer interface {
+	mustEmbedUnimplementedAnnaServiceServer()
+}
+
+func RegisterAnnaServiceServer(s grpc.ServiceRegistrar, srv AnnaServiceServer) {
+	// If the following call pancis, it indicates UnimplementedAnnaServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&AnnaService_ServiceDesc, srv)
+}
+
+func _AnnaService_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnnaServiceServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnnaService_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnnaServiceServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnnaService_SearchByISBN_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchByISBNRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnnaServiceServer).SearchByISBN(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnnaService_SearchByISBN_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnnaServiceServer).SearchByISBN(ctx, req.(*SearchByISBNRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnnaService_SearchByText_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchByTextRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnnaServiceServer).SearchByText(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnnaService_SearchByText_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnnaServiceServer).SearchByText(ctx, req.(*SearchByTextRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnnaService_GetRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRecordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnnaServiceServer).GetRecord(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnnaService_GetRecord_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnnaServiceServer).GetRecord(ctx, req.(*GetRecordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnnaService_GetDownloadStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDownloadStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnnaServiceServer).GetDownloadStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnnaService_GetDownloadStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnnaServiceServer).GetDownloadStatus(ctx, req.(*GetDownloadStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AnnaService_ServiceDesc is the grpc.ServiceDesc for AnnaService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AnnaService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "anna.v1.AnnaService",
+	HandlerType: (*AnnaServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Search",
+			Handler:    _AnnaService_Search_Handler,
+		},
+		{
+			MethodName: "SearchByISBN",
+			Handler:    _AnnaService_SearchByISBN_Handler,
+		},
+		{
+			MethodName: "SearchByText",
+			Handler:    _AnnaService_SearchByText_Handler,
+		},
+		{
+			MethodName: "GetRecord",
+			Handler:    _AnnaService_GetRecord_Handler,
+		},
+		{
+			MethodName: "GetDownloadStatus",
+			Handler:    _AnnaService_GetDownloadStatus_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "anna/v1/anna.proto",
+}
@@ -0,0 +1,260 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/auth"
+	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/rpc/annapb"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// protectedMethods lists the methods requiring a bearer token, like their HTTP counterparts.
+var protectedMethods = map[string]bool{
+	annapb.AnnaService_GetDownloadStatus_FullMethodName: true,
+}
+
+type server struct {
+	annapb.UnimplementedAnnaServiceServer
+}
+
+// NewServer returns a gRPC server exposing the AnnaService.
+func NewServer() *grpc.Server {
+	s := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.UnaryInterceptor(authInterceptor),
+	)
+	annapb.RegisterAnnaServiceServer(s, &server{})
+	return s
+}
+
+// authInterceptor verifies the bearer token of protected methods when authentication is enabled.
+func authInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !protectedMethods[info.FullMethod] || !auth.Enabled() {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var tokenString string
+	if values := md.Get("authorization"); len(values) > 0 {
+		tokenString = strings.TrimPrefix(values[0], "Bearer ")
+	}
+
+	if _, err := auth.ParseToken(tokenString); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	return handler(ctx, req)
+}
+
+func (*server) Search(ctx context.Context, req *annapb.SearchRequest) (*annapb.SearchResponse, error) {
+	page := toPage(req.GetPage())
+	records, total, mode, err := database.Search(ctx, req.GetQuery(), toFilters(req.GetFilters()), page, database.Projection{Fields: req.GetFields()})
+	if err != nil {
+		return nil, toStatus(err, "failed to search")
+	}
+	resp := toSearchResponse(records, total, page)
+	resp.Mode = string(mode)
+	return resp, nil
+}
+
+func (*server) SearchByISBN(ctx context.Context, req *annapb.SearchByISBNRequest) (*annapb.SearchResponse, error) {
+	page := toPage(req.GetPage())
+	records, total, err := database.SearchByISBN(ctx, req.GetIsbn(), toFilters(req.GetFilters()), page, database.Projection{Fields: req.GetFields()})
+	if err != nil {
+		return nil, toStatus(err, "failed to search by ISBN")
+	}
+	return toSearchResponse(records, total, page), nil
+}
+
+func (*server) SearchByText(ctx context.Context, req *annapb.SearchByTextRequest) (*annapb.SearchResponse, error) {
+	page := toPage(req.GetPage())
+	records, total, err := database.SearchByText(ctx, database.TextQuery{
+		Title:       req.GetTitle(),
+		Author:      req.GetAuthor(),
+		Publisher:   req.GetPublisher(),
+		Description: req.GetDescription(),
+		Fuzzy:       req.GetFuzzy(),
+		Threshold:   toThreshold(req.GetThreshold()),
+	}, toFilters(req.GetFilters()), page, database.Projection{Fields: req.GetFields()})
+	if err != nil {
+		return nil, toStatus(err, "failed to search by text")
+	}
+	resp := toSearchResponse(records, total, page)
+	if req.GetFuzzy() {
+		// Fuzzy results are ordered by similarity and can only be paginated with offsets
+		resp.NextCursor = ""
+	}
+	return resp, nil
+}
+
+func (*server) GetRecord(ctx context.Context, req *annapb.GetRecordRequest) (*annapb.Record, error) {
+	record, err := database.GetRecordByID(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "record not found")
+		}
+		return nil, toStatus(err, "failed to get record")
+	}
+	return toRecord(record), nil
+}
+
+func (*server) GetDownloadStatus(ctx context.Context, req *annapb.GetDownloadStatusRequest) (*annapb.GetDownloadStatusResponse, error) {
+	extension, err := database.GetRecordExtension(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "record not found")
+		}
+		return nil, toStatus(err, "failed to get record")
+	}
+
+	blob, err := database.GetRecordBlob(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "failed to get record")
+	}
+
+	progress := anna.GetDownloadProgress(anna.StoredFilename(req.GetId(), blob, extension))
+	resp := &annapb.GetDownloadStatusResponse{}
+	switch progress.Status {
+	case anna.DownloadStatusNotStarted:
+		resp.Status = annapb.DownloadStatus_DOWNLOAD_STATUS_NOT_STARTED
+	case anna.DownloadStatusQueued:
+		resp.Status = annapb.DownloadStatus_DOWNLOAD_STATUS_QUEUED
+		resp.Position = int32(progress.Position)
+	case anna.DownloadStatusDownloading:
+		resp.Status = annapb.DownloadStatus_DOWNLOAD_STATUS_DOWNLOADING
+	case anna.DownloadStatusDownloaded:
+		resp.Status = annapb.DownloadStatus_DOWNLOAD_STATUS_DOWNLOADED
+	}
+	return resp, nil
+}
+
+// toStatus maps database errors to gRPC status errors.
+func toStatus(err error, message string) error {
+	if database.IsValidationError(err) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Errorf(codes.Internal, "%s: %v", message, err)
+}
+
+func toFilters(f *annapb.SearchFilters) database.SearchFilters {
+	filters := database.SearchFilters{
+		Languages:           f.GetLanguages(),
+		ContentTypes:        f.GetContentTypes(),
+		ExcludeContentTypes: f.GetExcludeContentTypes(),
+		Formats:             f.GetFormats(),
+		Series:              f.GetSeries(),
+		ClassificationType:  f.GetClassificationType(),
+		ClassificationValue: f.GetClassificationValue(),
+	}
+	if f.GetAddedAfter() != nil {
+		filters.AddedAfter = f.GetAddedAfter().AsTime()
+	}
+	if f.GetAddedBefore() != nil {
+		filters.AddedBefore = f.GetAddedBefore().AsTime()
+	}
+	switch f.GetLanguageMode() {
+	case annapb.LanguageMode_LANGUAGE_MODE_ANY:
+		filters.LanguageMode = database.LanguageModeAny
+	case annapb.LanguageMode_LANGUAGE_MODE_ALL:
+		filters.LanguageMode = database.LanguageModeAll
+	default:
+		filters.LanguageMode = database.LanguageModeExact
+	}
+	return filters
+}
+
+// toThreshold returns the similarity threshold of a fuzzy search, nil for the
+// default: proto3 can't tell an unset threshold from 0.
+func toThreshold(threshold float64) *float64 {
+	if threshold == 0 {
+		return nil
+	}
+	return &threshold
+}
+
+// toPage applies the same defaults and bounds as the HTTP API.
+func toPage(p *annapb.Page) database.Page {
+	page := database.Page{
+		Limit:  int(p.GetLimit()),
+		Offset: int(max(p.GetOffset(), 0)),
+		Cursor: p.GetCursor(),
+	}
+	if page.Limit <= 0 {
+		page.Limit = 20
+	}
+	page.Limit = min(page.Limit, 100)
+	switch p.GetCount() {
+	case annapb.CountMode_COUNT_MODE_ESTIMATED:
+		page.Count = database.CountModeEstimated
+	case annapb.CountMode_COUNT_MODE_NONE:
+		page.Count = database.CountModeNone
+	default:
+		page.Count = database.CountModeExact
+	}
+	if p.GetSort() == annapb.Sort_SORT_ADDED {
+		page.Sort = database.SortAdded
+	}
+	return page
+}
+
+func toSearchResponse(records []database.Record, total int64, page database.Page) *annapb.SearchResponse {
+	resp := &annapb.SearchResponse{
+		Results: make([]*annapb.Record, len(records)),
+	}
+	if page.Cursors() {
+		resp.NextCursor = database.NextCursor(records, page.Limit)
+	}
+	for i := range records {
+		resp.Results[i] = toRecord(&records[i])
+	}
+	if page.Count != database.CountModeNone {
+		resp.Total = &total
+		resp.TotalEstimated = page.Count == database.CountModeEstimated
+	}
+	return resp
+}
+
+func toRecord(r *database.Record) *annapb.Record {
+	record := &annapb.Record{
+		Id:          r.ID,
+		Title:       r.Title,
+		Publisher:   r.Publisher,
+		Author:      r.Author,
+		CoverUrl:    r.CoverURL,
+		Year:        int32(r.Year),
+		Languages:   r.Languages,
+		Description: r.Description,
+		ContentType: r.ContentType,
+		Extension:   r.Extension,
+		Filesize:    r.Filesize,
+		Md5:         r.MD5,
+		Series:      r.Series,
+		SeriesIndex: r.SeriesIndex,
+	}
+	if !r.CreatedAt.IsZero() {
+		record.CreatedAt = timestamppb.New(r.CreatedAt)
+	}
+	if !r.UpdatedAt.IsZero() {
+		record.UpdatedAt = timestamppb.New(r.UpdatedAt)
+	}
+	if r.AddedAt != nil {
+		record.AddedAt = timestamppb.New(*r.AddedAt)
+	}
+	for _, identifier := range r.Identifiers {
+		record.Identifiers = append(record.Identifiers, &annapb.Identifier{Type: identifier.Type, Value: identifier.Value})
+	}
+	for _, classification := range r.Classifications {
+		record.Classifications = append(record.Classifications, &annapb.Classification{Type: classification.Type, Value: classification.Value})
+	}
+	return record
+}
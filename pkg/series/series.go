@@ -0,0 +1,33 @@
+package series
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// hashPattern matches suffixes like "(The Lord of the Rings #1)" or "(Discworld, #5)".
+	hashPattern = regexp.MustCompile(`\(\s*([^()#]+?),?\s*#\s*(\d+(?:\.\d+)?)\s*\)\s*$`)
+	// volumePattern matches suffixes like "(Harry Potter, Book 1)" or "(Les Rougon-Macquart, Tome 3)".
+	volumePattern = regexp.MustCompile(`(?i)\(\s*([^()]+?),?\s+(?:book|volume|vol\.?|tome|band|t\.)\s*(\d+(?:\.\d+)?)\s*\)\s*$`)
+)
+
+// Parse extracts the series name and position from a title ending with a series
+// suffix such as "The Fellowship of the Ring (The Lord of the Rings #1)".
+// It returns false if the title has no recognizable series suffix.
+func Parse(title string) (name string, index float64, ok bool) {
+	for _, pattern := range []*regexp.Regexp{hashPattern, volumePattern} {
+		matches := pattern.FindStringSubmatch(title)
+		if matches == nil {
+			continue
+		}
+		name = strings.TrimSpace(matches[1])
+		position, err := strconv.ParseFloat(matches[2], 64)
+		if name == "" || err != nil {
+			continue
+		}
+		return name, position, true
+	}
+	return "", 0, false
+}
@@ -0,0 +1,47 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	name, index, ok := Parse("The Fellowship of the Ring (The Lord of the Rings #1)")
+	assert.True(t, ok)
+	assert.Equal(t, "The Lord of the Rings", name)
+	assert.Equal(t, 1.0, index)
+
+	name, index, ok = Parse("Guards! Guards! (Discworld, #8)")
+	assert.True(t, ok)
+	assert.Equal(t, "Discworld", name)
+	assert.Equal(t, 8.0, index)
+
+	name, index, ok = Parse("Novella (Series #2.5)")
+	assert.True(t, ok)
+	assert.Equal(t, "Series", name)
+	assert.Equal(t, 2.5, index)
+
+	name, index, ok = Parse("Harry Potter and the Philosopher's Stone (Harry Potter, Book 1)")
+	assert.True(t, ok)
+	assert.Equal(t, "Harry Potter", name)
+	assert.Equal(t, 1.0, index)
+
+	name, index, ok = Parse("L'Assommoir (Les Rougon-Macquart, Tome 7)")
+	assert.True(t, ok)
+	assert.Equal(t, "Les Rougon-Macquart", name)
+	assert.Equal(t, 7.0, index)
+}
+
+func TestParseNoSeries(t *testing.T) {
+	for _, title := range []string{
+		"",
+		"The Hobbit",
+		"Nineteen Eighty-Four (Penguin Modern Classics)",
+		"Catch #22",
+		"(#3)",
+	} {
+		_, _, ok := Parse(title)
+		assert.False(t, ok, title)
+	}
+}
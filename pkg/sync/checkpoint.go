@@ -0,0 +1,53 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/database"
+	"gorm.io/gorm/clause"
+)
+
+// Checkpoint saves the progress of a file once the records before it are written.
+func (p *annaProcessor) Checkpoint(ctx context.Context, path string, checkpoint anna.Checkpoint) {
+	p.writers.wait(path)
+	if p.batch != nil {
+		if err := p.batch.Flush(ctx); err != nil {
+			p.failed.Add(1)
+			slog.Warn("Failed to write records, checkpoint skipped", "file", path, "error", err)
+			return
+		}
+	}
+
+	err := database.DB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&database.SyncCheckpoint{
+		Base:        p.base,
+		File:        path,
+		Lines:       checkpoint.Lines,
+		Records:     checkpoint.Records,
+		ParseErrors: checkpoint.ParseErrors,
+		Done:        checkpoint.Done,
+	}).Error
+	if err != nil {
+		slog.Warn("Failed to save sync checkpoint", "file", path, "error", err)
+	}
+}
+
+// Resume returns the checkpoint of a file saved by an interrupted sync of the same base.
+func (p *annaProcessor) Resume(ctx context.Context, path string) anna.Checkpoint {
+	var checkpoint database.SyncCheckpoint
+	err := database.DB.WithContext(ctx).Where("base = ? AND file = ?", p.base, path).Limit(1).Find(&checkpoint).Error
+	if err != nil {
+		slog.Warn("Failed to read sync checkpoint, processing the whole file", "file", path, "error", err)
+		return anna.Checkpoint{}
+	}
+	return anna.Checkpoint{Lines: checkpoint.Lines, Records: checkpoint.Records, ParseErrors: checkpoint.ParseErrors, Done: checkpoint.Done}
+}
+
+// clearCheckpoints removes the checkpoints of the bases other than base, or
+// all of them when base is empty.
+func clearCheckpoints(ctx context.Context, base string) {
+	if err := database.DB.WithContext(ctx).Where("base <> ?", base).Delete(&database.SyncCheckpoint{}).Error; err != nil {
+		slog.Warn("Failed to clear sync checkpoints", "error", err)
+	}
+}
@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// dryRunBatchSize is the number of record IDs looked up at once
+const dryRunBatchSize = 1000
+
+// runDryRun downloads and parses the metadata files of t, counting the
+// records that a sync would insert, update or skip without writing anything.
+func runDryRun(ctx context.Context, t *anna.TorrentsResponse) error {
+	slog.Info("Starting dry-run sync", "base", t.DisplayName, "magnet", t.MagnetLink)
+	syncBase = t.DisplayName
+
+	processor := &dryRunProcessor{annaProcessor: &annaProcessor{base: t.DisplayName}}
+	results, err := processRecords(ctx, t, syncDryRun, processor)
+	GetStatsInstance().EndSync()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !config.C.Anna.KeepFiles {
+		anna.CleanupFiles()
+	}
+	slog.Info("Dry-run sync completed", "files", len(results))
+	return nil
+}
+
+// dryRunProcessor counts the records instead of writing them. The records of
+// each file are looked up by batches.
+type dryRunProcessor struct {
+	*annaProcessor
+
+	// pending are the synced records of each file not looked up yet. The map
+	// is only read once built by Files, each file's records are only accessed
+	// by the goroutine processing it
+	pending map[string]*dryRunBatch
+}
+
+// dryRunBatch are the IDs and source hashes of records to look up.
+type dryRunBatch struct {
+	ids    []string
+	hashes []string
+}
+
+func (p *dryRunProcessor) Files(ctx context.Context, paths []string) {
+	p.annaProcessor.Files(ctx, paths)
+	GetStatsInstance().StartDryRun(p.base, paths)
+	p.pending = make(map[string]*dryRunBatch, len(paths))
+	for _, path := range paths {
+		p.pending[path] = &dryRunBatch{}
+	}
+}
+
+func (p *dryRunProcessor) Record(ctx context.Context, path string, record *anna.Record) {
+	id, hash, ok := database.SyncedRecordID(record)
+	if !ok {
+		GetStatsInstance().CountDryRun(path, 0, 0, 1)
+		return
+	}
+	pending := p.pending[path]
+	if pending == nil {
+		return
+	}
+	pending.ids = append(pending.ids, id)
+	pending.hashes = append(pending.hashes, hash)
+	if len(pending.ids) >= dryRunBatchSize {
+		p.lookup(ctx, path)
+	}
+}
+
+// lookup counts the pending records of a file as inserts, updates, or skips
+// when they are stored unchanged.
+func (p *dryRunProcessor) lookup(ctx context.Context, path string) {
+	pending := p.pending[path]
+	if pending == nil || len(pending.ids) == 0 {
+		return
+	}
+	ids, hashes := pending.ids, pending.hashes
+	pending.ids, pending.hashes = ids[:0], hashes[:0]
+
+	existing, unchanged, err := database.CountExistingRecords(ctx, ids, hashes)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("Failed to look up records, not counted", "file", path, "records", len(ids), "error", err)
+		}
+		return
+	}
+	GetStatsInstance().CountDryRun(path, int64(len(ids))-existing, existing-unchanged, unchanged)
+}
+
+func (p *dryRunProcessor) Checkpoint(ctx context.Context, path string, checkpoint anna.Checkpoint) {
+	if checkpoint.Done {
+		p.lookup(ctx, path)
+	}
+}
+
+// Resume processes every file from the start, checkpoints are left for the syncs.
+func (p *dryRunProcessor) Resume(ctx context.Context, path string) anna.Checkpoint {
+	return anna.Checkpoint{}
+}
@@ -0,0 +1,69 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+)
+
+// Hook receives the records of the syncs alongside the database, e.g. to push
+// them to a search engine or a message queue. Hooks are registered with
+// RegisterHook by programs embedding the server, before the first sync.
+type Hook interface {
+	// Start is called when the sync of base starts, dry runs aside. The sync
+	// goes on without the hook when it returns an error.
+	Start(ctx context.Context, base string) error
+	// Record is called for each record parsed from the metadata files, those
+	// not synced to the database (e.g. of another format) included, from the
+	// writer goroutines: it must be safe for concurrent use. A resumed sync
+	// calls it again for the records after the last saved progress. Errors
+	// are logged, the sync goes on.
+	Record(ctx context.Context, record *anna.Record) error
+	// End is called once the sync is over, err being nil when it completed.
+	End(ctx context.Context, err error)
+}
+
+var (
+	hooksMu sync.Mutex
+	hooks   = map[string]Hook{}
+)
+
+// RegisterHook registers a hook under name, replacing the one registered
+// under the same name if any.
+func RegisterHook(name string, hook Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks[name] = hook
+}
+
+// namedHook is a hook started for a sync.
+type namedHook struct {
+	name string
+	Hook
+}
+
+// startHooks starts the registered hooks for the sync of base, returning those
+// that started.
+func startHooks(ctx context.Context, base string) []namedHook {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	var started []namedHook
+	for name, hook := range hooks {
+		if err := hook.Start(ctx, base); err != nil {
+			slog.Error("Failed to start sync hook, syncing without it", "hook", name, "error", err)
+			continue
+		}
+		started = append(started, namedHook{name: name, Hook: hook})
+	}
+	return started
+}
+
+// endHooks tells the hooks that the sync is over.
+func endHooks(ctx context.Context, started []namedHook, err error) {
+	for _, hook := range started {
+		hook.End(context.WithoutCancel(ctx), err)
+	}
+}
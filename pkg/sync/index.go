@@ -5,11 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/config"
 	"github.com/iziplay/anna-api/pkg/database"
+	"github.com/iziplay/anna-api/pkg/notify"
+	"github.com/iziplay/anna-api/pkg/webhook"
 	"go.opentelemetry.io/otel"
 	"gorm.io/gorm"
 )
@@ -19,13 +24,16 @@ var tracer = otel.Tracer("github.com/iziplay/anna-api/pkg/sync")
 // syncBase holds the current torrent display name for stats reporting
 var syncBase string
 
-// GetLastSync returns the last sync from database
+// GetLastSync returns the last sync from database, of the shard of this
+// worker when the syncs are sharded
 func GetLastSync(ctx context.Context) (*database.Synchronization, error) {
 	ctx, span := tracer.Start(ctx, "GetLastSync")
 	defer span.End()
 
+	// Failed syncs are only kept for the history
 	var sync *database.Synchronization
-	err := database.DB.WithContext(ctx).Order("date DESC").First(&sync).Error
+	err := database.DB.WithContext(ctx).Where("coalesce(error, '') = '' AND coalesce(shard, '') = ?", config.C.Anna.SyncShard.String()).
+		Order("date DESC").First(&sync).Error
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, err
 	}
@@ -33,7 +41,200 @@ func GetLastSync(ctx context.Context) (*database.Synchronization, error) {
 	return sync, nil
 }
 
+// ErrAlreadyRunning is returned when a sync is requested while another one is running.
+var ErrAlreadyRunning = errors.New("a sync is already running")
+
+// ErrStopped is returned when a sync is requested after Stop.
+var ErrStopped = errors.New("syncing is stopped")
+
+var (
+	// running is set while a sync is in progress, whatever triggered it
+	running atomic.Bool
+	// stopped is set by Stop, no sync starts afterwards
+	stopped atomic.Bool
+
+	cancelMu sync.Mutex
+	cancel   context.CancelFunc
+
+	// failing is set while syncs fail, so that a failure is notified once
+	// until a sync succeeds again
+	failing atomic.Bool
+)
+
+// acquire marks a sync as running and returns its context, which Stop cancels.
+func acquire(ctx context.Context) (context.Context, error) {
+	if stopped.Load() {
+		return nil, ErrStopped
+	}
+	if !IsLeader() {
+		return nil, ErrNotLeader
+	}
+	if !running.CompareAndSwap(false, true) {
+		return nil, ErrAlreadyRunning
+	}
+
+	cancelMu.Lock()
+	defer cancelMu.Unlock()
+	// Stop may have cancelled the previous sync since the first check, this
+	// one would never be cancelled
+	if stopped.Load() {
+		running.Store(false)
+		return nil, ErrStopped
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	return ctx, nil
+}
+
+func release() {
+	cancelMu.Lock()
+	cancel()
+	cancelMu.Unlock()
+	running.Store(false)
+}
+
+// Stop cancels the running sync, if any, and waits for it to return or for ctx
+// to be done. A cancelled sync is not recorded as complete, so the next start
+// runs it again. No sync can be started after Stop.
+func Stop(ctx context.Context) error {
+	stopped.Store(true)
+
+	cancelMu.Lock()
+	if cancel != nil {
+		cancel()
+	}
+	cancelMu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for running.Load() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Disabled returns whether syncing was disabled in the configuration.
+func Disabled() bool {
+	return config.C.Anna.DisableSync
+}
+
+// Sync synchronizes the database with the last metadata torrent of Anna's Archive.
+// It returns ErrAlreadyRunning if a sync is already in progress.
 func Sync(ctx context.Context) error {
+	ctx, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return runSync(ctx, syncNormal)
+}
+
+// Force synchronizes the database like Sync, even when the last metadata
+// torrent was already synced: every metadata file is processed again.
+func Force(ctx context.Context) error {
+	ctx, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return runSync(ctx, syncForced)
+}
+
+// Start runs a sync in the background and returns immediately, or returns
+// ErrAlreadyRunning if a sync is already in progress.
+func Start(ctx context.Context) error {
+	ctx, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer release()
+		if err := runSync(ctx, syncNormal); err != nil {
+			slog.Error("Sync failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// StartForced runs a forced sync, see Force, in the background and returns
+// immediately, or returns ErrAlreadyRunning if a sync is already in progress.
+func StartForced(ctx context.Context) error {
+	ctx, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer release()
+		if err := runSync(ctx, syncForced); err != nil {
+			slog.Error("Forced sync failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// StartDryRun runs a dry-run sync in the background and returns immediately,
+// or returns ErrAlreadyRunning if a sync is already in progress. The metadata
+// torrent is downloaded and parsed but nothing is written: the records that
+// would be inserted, updated or skipped are counted in the sync stats.
+func StartDryRun(ctx context.Context) error {
+	ctx, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer release()
+		if err := runSync(ctx, syncDryRun); err != nil {
+			slog.Error("Dry-run sync failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// StartReprocess runs a sync in the background processing again the metadata
+// files of the last synced base kept on disk with ANNA_KEEP_FILES, without
+// downloading anything, e.g. after fixing how records are ingested, and
+// returns immediately. It returns anna.ErrNoKeptFiles when the files are not
+// on disk, or ErrAlreadyRunning if a sync is already in progress.
+func StartReprocess(ctx context.Context) error {
+	if _, err := keptBase(ctx); err != nil {
+		return err
+	}
+	ctx, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer release()
+		if err := runSync(ctx, syncReprocess); err != nil {
+			slog.Error("Reprocessing sync failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// syncMode is how runSync syncs
+type syncMode int
+
+const (
+	syncNormal syncMode = iota
+	// syncDryRun counts the records without writing them
+	syncDryRun
+	// syncForced processes the base again, even when already synced
+	syncForced
+	// syncReprocess processes the files of the last base kept on disk again
+	syncReprocess
+)
+
+func runSync(ctx context.Context, mode syncMode) error {
 	ctx, span := tracer.Start(ctx, "Sync")
 	defer span.End()
 
@@ -42,89 +243,348 @@ func Sync(ctx context.Context) error {
 		return fmt.Errorf("cannot sync: %w", err)
 	}
 
-	at, err := anna.FetchTorrentsList()
+	var t *anna.TorrentsResponse
+	if mode == syncReprocess {
+		t, err = keptBase(ctx)
+	} else {
+		t, err = lastBase(ctx)
+	}
 	if err != nil {
+		if ctx.Err() == nil && mode != syncDryRun {
+			notifySync(ctx, notify.SyncResult{Status: notify.StatusFailed, Error: err.Error()})
+		}
 		return err
 	}
-	slog.Info("Fetched torrents from Anna repository", "count", len(at))
-
-	// Upsert torrents into database
-	if err := database.UpsertTorrents(ctx, at); err != nil {
-		slog.Warn("Failed to upsert torrents to database", "error", err)
-	}
 
-	t := anna.GetLastMetadataTorrent(at)
-	if t == nil {
-		return fmt.Errorf("no metadata torrent found")
+	if Disabled() {
+		slog.Info("Sync disabled via environment variable")
+		<-ctx.Done()
+		return ctx.Err()
 	}
 
-	if os.Getenv("ANNA_DISABLE_SYNC") == "true" {
-		slog.Info("Sync disabled via environment variable")
-		for {
-			time.Sleep(1 * time.Hour)
-		}
+	if mode == syncDryRun {
+		return runDryRun(ctx, t)
 	}
 
-	if lastSync != nil && lastSync.Base == t.DisplayName {
+	// A partial sync is resumed to process the files that failed
+	if lastSync != nil && lastSync.Base == t.DisplayName && !lastSync.Partial && mode == syncNormal {
 		slog.Info("Sync already performed with this torrent", "torrent", t.DisplayName)
 		syncRecord := database.Synchronization{
-			Date: time.Now(),
-			Base: t.DisplayName,
+			Date:  time.Now(),
+			Base:  t.DisplayName,
+			Shard: config.C.Anna.SyncShard.String(),
 		}
 		err = database.DB.WithContext(ctx).Create(&syncRecord).Error
 		GetStatsInstance().EndSync()
 		return nil
 	}
 
-	slog.Info("Starting sync", "magnet", t.MagnetLink)
+	slog.Info("Starting sync", "base", t.DisplayName, "magnet", t.MagnetLink)
+	webhook.Publish(ctx, webhook.EventSyncStarted, map[string]any{"base": t.DisplayName})
+	syncRecord := database.Synchronization{
+		Base:      t.DisplayName,
+		Shard:     config.C.Anna.SyncShard.String(),
+		StartedAt: time.Now(),
+	}
 
 	// Store the base name for sync stats
 	syncBase = t.DisplayName
 
 	// Download and process records in parallel - reading gz while torrent is downloading
-	results, err := anna.DownloadAndProcessRecords(ctx, t, &annaProcessor{})
+	if mode != syncNormal && config.C.Anna.SyncShard.Enabled() {
+		// The other shards keep their progress
+		clearShardCheckpoints(ctx)
+	} else if mode != syncNormal {
+		// No file is skipped
+		clearCheckpoints(ctx, "")
+	} else {
+		clearCheckpoints(ctx, t.DisplayName)
+	}
+	if config.C.Anna.SyncRetainPrevious {
+		if err := database.PruneVersions(ctx, t.DisplayName); err != nil {
+			slog.Warn("Failed to delete the record versions of previous syncs", "error", err)
+		}
+	}
+	if config.C.Anna.SyncStaging {
+		if err := database.BeginStaging(ctx, t.DisplayName); err != nil {
+			err = fmt.Errorf("failed to prepare the staging tables: %w", err)
+			recordFailure(ctx, syncRecord, err)
+			GetStatsInstance().EndSync()
+			return err
+		}
+		defer database.EndStaging()
+	}
+	processor := &annaProcessor{base: t.DisplayName, written: database.NewWritten(), hooks: startHooks(ctx, t.DisplayName)}
+	if config.C.Anna.SyncCopy {
+		processor.batch = database.NewRecordBatch(config.C.Anna.SyncBatchSize, t.DisplayName, processor.written)
+	}
+	GetStatsInstance().CountWritten(processor.written)
+	processor.writers = startWriters(ctx, config.C.Anna.SyncWriters, config.C.Anna.SyncQueueSize, processor.write)
+	results, err := processRecords(ctx, t, mode, processor)
+	processor.writers.close()
+	if err == nil && processor.batch != nil {
+		err = processor.batch.Flush(ctx)
+	}
 
-	if err != nil {
-		GetStatsInstance().EndSync()
-		return err
+	if err == nil {
+		err = ctx.Err()
 	}
 
-	// Check for any file processing errors and count total records
+	// Count total records, the files that failed make the sync partial
 	totalRecords := 0
 	for _, result := range results {
+		totalRecords += result.RecordCount
+		syncRecord.ParseErrors += result.ParseErrors
 		if result.Error != nil {
-			GetStatsInstance().EndSync()
-			return fmt.Errorf("error processing file %s: %w", result.FilePath, result.Error)
+			syncRecord.FailedFiles = append(syncRecord.FailedFiles, result.FilePath)
 		}
-		totalRecords += result.RecordCount
 	}
+	syncRecord.Files = len(results)
+	syncRecord.Records = totalRecords
+	syncRecord.Failed = processor.failed.Load()
+	syncRecord.Partial = len(syncRecord.FailedFiles) > 0
 
-	slog.Info("Sync completed successfully", "records", totalRecords, "files", len(results))
+	if err != nil {
+		endHooks(ctx, processor.hooks, err)
+		recordFailure(ctx, syncRecord, err)
+		GetStatsInstance().EndSync()
+		return err
+	}
 
-	if os.Getenv("ANNA_KEEP_FILES") != "true" {
+	if syncRecord.Partial {
+		// Checkpoints are kept, the next sync only processes the failed files
+		slog.Warn("Sync completed partially", "records", totalRecords, "files", len(results), "failed", syncRecord.FailedFiles)
+	} else {
+		slog.Info("Sync completed successfully", "records", totalRecords, "files", len(results))
+		if config.C.Anna.SyncShard.Enabled() {
+			// The other shards may still need the checkpoints of the base
+			clearCheckpoints(ctx, t.DisplayName)
+		} else {
+			clearCheckpoints(ctx, "")
+		}
+	}
+
+	// The base is pruned once all its shards are synced
+	if !config.C.Anna.SyncShard.Enabled() {
+		prune(ctx, t.DisplayName, processor.failed.Load(), len(syncRecord.FailedFiles))
+	}
+
+	// Searches see the records of the new base at once
+	if config.C.Anna.SyncStaging {
+		if err := database.SwapStaging(ctx); err != nil {
+			err = fmt.Errorf("failed to swap the staging tables: %w", err)
+			endHooks(ctx, processor.hooks, err)
+			recordFailure(ctx, syncRecord, err)
+			GetStatsInstance().EndSync()
+			return err
+		}
+		slog.Info("Swapped the staging tables in")
+	}
+
+	if !config.C.Anna.KeepFiles {
 		anna.CleanupFiles()
 	}
 
-	syncRecord := database.Synchronization{
-		Date:     time.Now(),
-		Base:     t.DisplayName,
-		Complete: true,
+	syncRecord.Date = time.Now()
+	if config.C.Anna.SyncShard.Enabled() {
+		err = recordShard(ctx, &syncRecord)
+	} else {
+		syncRecord.Complete = true
+		err = database.DB.WithContext(ctx).Create(&syncRecord).Error
 	}
-	err = database.DB.WithContext(ctx).Create(&syncRecord).Error
+	endHooks(ctx, processor.hooks, err)
 	GetStatsInstance().EndSync()
+	if err == nil {
+		webhook.Publish(ctx, webhook.EventSyncCompleted, map[string]any{"base": t.DisplayName, "records": totalRecords, "failedFiles": syncRecord.FailedFiles})
+		notifySync(ctx, syncResult(syncRecord))
+	}
 	return err
 }
 
+// prune deletes the records absent from base with ANNA_SYNC_PRUNE, unless
+// records, or batches of records, or files could not be synced.
+func prune(ctx context.Context, base string, failed int64, failedFiles int) {
+	if !config.C.Anna.SyncPrune {
+		return
+	}
+	if failed > 0 || failedFiles > 0 {
+		slog.Warn("Records could not be synced, pruning skipped", "records", failed, "files", failedFiles)
+		return
+	}
+	pruned, err := database.PruneRecords(ctx, base)
+	if err != nil {
+		slog.Error("Failed to prune records", "error", err)
+	} else {
+		slog.Info("Pruned records absent from the base", "records", pruned)
+	}
+}
+
+// notifySync notifies the result of a sync when its status changed: a failure
+// after a success, or a success. Repeated failures are notified once.
+func notifySync(ctx context.Context, result notify.SyncResult) {
+	if result.Status == notify.StatusFailed {
+		if failing.Swap(true) {
+			return
+		}
+	} else {
+		failing.Store(false)
+	}
+	notify.Sync(ctx, result)
+}
+
+// syncResult returns the notification of a recorded sync.
+func syncResult(syncRecord database.Synchronization) notify.SyncResult {
+	result := notify.SyncResult{
+		Base:        syncRecord.Base,
+		Status:      notify.StatusCompleted,
+		Files:       syncRecord.Files,
+		Records:     syncRecord.Records,
+		FailedFiles: syncRecord.FailedFiles,
+		Error:       syncRecord.Error,
+		Duration:    int64(syncRecord.Date.Sub(syncRecord.StartedAt).Seconds()),
+	}
+	switch {
+	case syncRecord.Error != "":
+		result.Status = notify.StatusFailed
+	case syncRecord.Partial:
+		result.Status = notify.StatusPartial
+	}
+	return result
+}
+
+// lastBase returns the base to sync: the last metadata torrent of Anna's
+// Archive, the local dump directory, named after it, when ANNA_SYNC_DUMP_DIR
+// is set, or the base of the metadata mirror of ANNA_SYNC_METADATA_URL.
+func lastBase(ctx context.Context) (*anna.TorrentsResponse, error) {
+	if dir := config.C.Anna.SyncDumpDir; dir != "" {
+		return &anna.TorrentsResponse{DisplayName: filepath.Base(filepath.Clean(dir))}, nil
+	}
+	if sumsURL := config.C.Anna.SyncMetadataURL; sumsURL != "" {
+		return &anna.TorrentsResponse{DisplayName: anna.MetadataMirrorBase(sumsURL)}, nil
+	}
+
+	at, err := anna.FetchTorrentsList()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Fetched torrents from Anna repository", "count", len(at))
+
+	// Upsert torrents into database
+	if err := database.UpsertTorrents(ctx, at); err != nil {
+		slog.Warn("Failed to upsert torrents to database", "error", err)
+	}
+
+	t := anna.GetLastMetadataTorrent(at)
+	if t == nil {
+		return nil, fmt.Errorf("no metadata torrent found")
+	}
+	return t, nil
+}
+
+// RefreshTorrents fetches the torrents list of Anna's Archive and saves it,
+// without syncing the metadata, so that the files of newly released torrents
+// can be downloaded. It returns the number of torrents listed.
+func RefreshTorrents(ctx context.Context) (int, error) {
+	at, err := anna.FetchTorrentsList()
+	if err != nil {
+		return 0, err
+	}
+	if err := database.UpsertTorrents(ctx, at); err != nil {
+		return 0, err
+	}
+	slog.Info("Refreshed torrents from Anna repository", "count", len(at))
+	return len(at), nil
+}
+
+// keptBase returns the base of the last sync, to process its metadata files
+// again from disk: kept with ANNA_KEEP_FILES, or in ANNA_SYNC_DUMP_DIR.
+func keptBase(ctx context.Context) (*anna.TorrentsResponse, error) {
+	if config.C.Anna.SyncDumpDir != "" {
+		return lastBase(ctx)
+	}
+	lastSync, err := GetLastSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lastSync == nil {
+		return nil, anna.ErrNoKeptFiles
+	}
+	if _, err := anna.KeptFilesDir(lastSync.Base); err != nil {
+		return nil, err
+	}
+	return &anna.TorrentsResponse{DisplayName: lastSync.Base}, nil
+}
+
+// processRecords processes the metadata files of the base t, read from the
+// local dump directory or downloaded from the metadata mirror or torrent, or
+// read from disk again when reprocessing.
+func processRecords(ctx context.Context, t *anna.TorrentsResponse, mode syncMode, processor anna.Processor) ([]anna.FileResult, error) {
+	if dir := config.C.Anna.SyncDumpDir; dir != "" {
+		return anna.ProcessDump(ctx, dir, processor)
+	}
+	if mode == syncReprocess {
+		dir, err := anna.KeptFilesDir(t.DisplayName)
+		if err != nil {
+			return nil, err
+		}
+		return anna.ProcessDump(ctx, dir, processor)
+	}
+	if sumsURL := config.C.Anna.SyncMetadataURL; sumsURL != "" {
+		return anna.DownloadAndProcessMirror(ctx, sumsURL, processor)
+	}
+	return anna.DownloadAndProcessRecords(ctx, t, processor)
+}
+
+// recordFailure saves a failed or cancelled sync in the history.
+func recordFailure(ctx context.Context, syncRecord database.Synchronization, err error) {
+	syncRecord.Date = time.Now()
+	syncRecord.Error = err.Error()
+	if ctx.Err() != nil {
+		syncRecord.Error = "sync interrupted, resumed by the next one: " + err.Error()
+	}
+	if err := database.DB.WithContext(context.WithoutCancel(ctx)).Create(&syncRecord).Error; err != nil {
+		slog.Warn("Failed to save the failed sync", "error", err)
+	}
+	// An interrupted sync is resumed, only failures are notified
+	if ctx.Err() == nil {
+		notifySync(ctx, syncResult(syncRecord))
+	}
+}
+
 type annaProcessor struct {
 	anna.Processor
+
+	// base is the metadata torrent synced
+	base string
+
+	// writers write the records parsed
+	writers *writerPool
+	// batch buffers the records when they are written with COPY
+	batch *database.RecordBatch
+	// failed counts the records, or batches of records, that could not be written
+	failed atomic.Int64
+	// written counts the rows written by type
+	written *database.Written
+	// hooks receive the records alongside the database
+	hooks []namedHook
 }
 
 func (*annaProcessor) Files(ctx context.Context, paths []string) {
 	GetStatsInstance().StartSync(syncBase, paths)
 }
 
-func (*annaProcessor) Stats(ctx context.Context, filePath string, statsType anna.StatsType, percent float64) {
+func (*annaProcessor) FileError(ctx context.Context, path string, err error) {
+	GetStatsInstance().FailFile(path, err)
+}
+
+func (*annaProcessor) Stats(ctx context.Context, filePath string, statsType anna.StatsType, value float64) {
 	statsInstance := GetStatsInstance()
+	switch statsType {
+	case anna.StatsTypeSwarmPeers, anna.StatsTypeSwarmSeeders, anna.StatsTypeSwarmRemaining, anna.StatsTypeSwarmCompleted:
+		statsInstance.UpdateSwarm(statsType, value)
+		return
+	}
+
 	var fileIndex int = -1
 
 	statsInstance.mu.RLock()
@@ -142,12 +602,40 @@ func (*annaProcessor) Stats(ctx context.Context, filePath string, statsType anna
 
 	switch statsType {
 	case anna.StatsTypeFileDownload:
-		statsInstance.UpdateFileDownload(fileIndex, percent)
+		statsInstance.UpdateFileDownload(fileIndex, value)
 	case anna.StatsTypeFileProcessing:
-		statsInstance.UpdateFileProcessed(fileIndex, percent)
+		statsInstance.UpdateFileProcessed(fileIndex, value)
+	case anna.StatsTypeRecords:
+		statsInstance.UpdateFileRecords(fileIndex, int64(value))
+	case anna.StatsTypeBytes:
+		statsInstance.UpdateFileBytes(fileIndex, int64(value))
+	case anna.StatsTypeParseErrors:
+		statsInstance.UpdateFileParseErrors(fileIndex, int64(value))
+	case anna.StatsTypeVerification:
+		statsInstance.UpdateFileVerification(fileIndex, int(value))
 	}
 }
 
-func (*annaProcessor) Record(ctx context.Context, record *anna.Record) {
-	database.UpsertRecordAndIdentifiers(ctx, record)
+func (p *annaProcessor) Record(ctx context.Context, path string, record *anna.Record) {
+	p.writers.add(path, record)
+}
+
+// write writes a record to the database and passes it to the hooks, called by
+// the writers.
+func (p *annaProcessor) write(ctx context.Context, record *anna.Record) {
+	for _, hook := range p.hooks {
+		if err := hook.Record(ctx, record); err != nil && ctx.Err() == nil {
+			slog.Warn("Sync hook failed to process a record", "hook", hook.name, "record", record.ID, "error", err)
+		}
+	}
+	if p.batch == nil {
+		if err := database.UpsertRecordAndIdentifiers(ctx, record, p.base, p.written); err != nil && ctx.Err() == nil {
+			p.failed.Add(1)
+		}
+		return
+	}
+	if err := p.batch.Add(ctx, record); err != nil && ctx.Err() == nil {
+		p.failed.Add(1)
+		slog.Error("Failed to write a batch of records", "error", err)
+	}
 }
@@ -0,0 +1,187 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/database"
+	"gorm.io/gorm/clause"
+)
+
+// leaderLockKey is the key of the advisory lock held by the sync leader, of
+// the first shard when the syncs are sharded
+const leaderLockKey = 0x616e6e61 // "anna"
+
+// leaderInterval is how often followers try to become the leader and read its
+// progress, and how often the leader checks its lock and publishes its progress
+const leaderInterval = 5 * time.Second
+
+// ErrNotLeader is returned when a sync is requested on a follower instance.
+var ErrNotLeader = errors.New("another instance runs the syncs")
+
+var (
+	// leading is set while this instance holds the leader lock
+	leading atomic.Bool
+	// leaderStats are the stats last published by the leader, read by followers
+	leaderStats atomic.Pointer[SyncStats]
+)
+
+// shared returns whether the syncs are shared between instances, through
+// leader election or dedicated roles.
+func shared() bool {
+	return config.C.Anna.SyncLeaderElection || config.C.Role != config.RoleAll
+}
+
+// IsLeader returns whether this instance runs the syncs: never for API
+// replicas, otherwise always unless another instance holds the leader lock.
+func IsLeader() bool {
+	if !config.C.RunsSync() {
+		return false
+	}
+	return !shared() || leading.Load()
+}
+
+// Lead calls fn while this instance is the sync leader, until ctx is done.
+// When the syncs are not shared fn is simply called. Otherwise, sync instances
+// compete for a PostgreSQL advisory lock: the one holding it runs fn and
+// publishes its progress, the others, API replicas included, follow it and
+// sync instances take over when its connection is lost.
+func Lead(ctx context.Context, fn func(context.Context)) {
+	if !shared() {
+		fn(ctx)
+		return
+	}
+
+	for {
+		var conn *sql.Conn
+		if config.C.RunsSync() {
+			var err error
+			conn, err = tryLeaderLock(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to try the sync leader lock", "error", err)
+			}
+		}
+		if conn != nil {
+			slog.Info("Became the sync leader")
+			lead(ctx, conn, fn)
+			slog.Info("Stopped being the sync leader")
+		} else {
+			followLeader(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(leaderInterval):
+		}
+	}
+}
+
+// leaderLock returns the key of the leader lock: each shard has its leader.
+func leaderLock() int64 {
+	return leaderLockKey + int64(config.C.Anna.SyncShard.Index)
+}
+
+// progressID returns the row of the progress published by the leader of the
+// shard, API replicas following the first shard.
+func progressID() int {
+	return 1 + config.C.Anna.SyncShard.Index
+}
+
+// tryLeaderLock returns the connection holding the leader lock, or nil when
+// another instance holds it.
+func tryLeaderLock(ctx context.Context) (*sql.Conn, error) {
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", leaderLock()).Scan(&locked); err != nil || !locked {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// lead runs fn while conn holds the leader lock, publishing the progress.
+func lead(ctx context.Context, conn *sql.Conn, fn func(context.Context)) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	leading.Store(true)
+	defer leading.Store(false)
+
+	go func() {
+		ticker := time.NewTicker(leaderInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// The lock is released with the session, lost if the connection is
+			if err := conn.PingContext(ctx); err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Lost the sync leader connection", "error", err)
+					cancel()
+				}
+				return
+			}
+			publishStats(ctx)
+		}
+	}()
+
+	fn(ctx)
+
+	// Unlocking on the session releases the lock at once, the connection
+	// itself may be reused by the pool
+	if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", leaderLock()); err != nil {
+		conn.Raw(func(any) error { return driver.ErrBadConn }) // drop the session, releasing the lock
+	}
+	conn.Close()
+}
+
+// publishStats saves the stats of the leader for the followers.
+func publishStats(ctx context.Context) {
+	current := stats.snapshot()
+	data, err := json.Marshal(&current)
+	if err != nil {
+		return
+	}
+	err = database.DB.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&database.SyncProgress{ID: progressID(), Stats: data}).Error
+	if err != nil && ctx.Err() == nil {
+		slog.Warn("Failed to publish the sync progress", "error", err)
+	}
+}
+
+// followLeader reads the stats last published by the leader. Stats not
+// updated for a while are dropped, the leader being gone.
+func followLeader(ctx context.Context) {
+	var progress database.SyncProgress
+	err := database.DB.WithContext(ctx).Where("id = ? AND updated_at > ?", progressID(), time.Now().Add(-3*leaderInterval)).Limit(1).Find(&progress).Error
+	if err != nil || len(progress.Stats) == 0 {
+		leaderStats.Store(nil)
+		return
+	}
+
+	published := &SyncStats{}
+	if err := json.Unmarshal(progress.Stats, published); err != nil {
+		slog.Warn("Invalid sync progress published by the leader", "error", err)
+		return
+	}
+	leaderStats.Store(published)
+}
@@ -0,0 +1,40 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/iziplay/anna-api/pkg/database"
+)
+
+// RollbackResult is the outcome of a rollback.
+type RollbackResult struct {
+	// From is the base of the sync rolled back
+	From string `json:"from"`
+	// To is the base the records are back to
+	To string `json:"to"`
+	// Records is the number of records restored or deleted
+	Records int64 `json:"records"`
+}
+
+// Rollback restores the records as they were before the last complete sync,
+// when ANNA_SYNC_RETAIN_PREVIOUS was set during it. It returns
+// ErrAlreadyRunning if a sync is in progress and
+// database.ErrNoPreviousGeneration if there is nothing to roll back to. The
+// rolled back base isn't synced again until a new one is released.
+func Rollback(ctx context.Context) (*RollbackResult, error) {
+	ctx, err := acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	from, to, records, err := database.RollbackGeneration(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Warn("Rolled back the last sync", "from", from, "to", to, "records", records)
+
+	database.ComputeAndCacheStats(true)
+	return &RollbackResult{From: from, To: to, Records: records}, nil
+}
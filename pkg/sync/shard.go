@@ -0,0 +1,82 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+	"github.com/iziplay/anna-api/pkg/config"
+	"github.com/iziplay/anna-api/pkg/database"
+	"gorm.io/gorm"
+)
+
+// shardLockKey is the key of the advisory lock serializing the shards
+// recording their syncs, so that a single one completes the base
+const shardLockKey = 0x73687264 // "shrd"
+
+// recordShard saves the sync of the shard of this worker, marked as complete
+// when it completes the base: when the last sync of every shard of the base
+// succeeded. The base is then pruned with ANNA_SYNC_PRUNE, by this worker,
+// unless records of a shard could not be synced.
+func recordShard(ctx context.Context, syncRecord *database.Synchronization) error {
+	shard := config.C.Anna.SyncShard
+
+	var shards []database.Synchronization
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", shardLockKey).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(syncRecord).Error; err != nil {
+			return err
+		}
+		// The syncs skipped because the shard was already synced are ignored
+		return tx.Select("DISTINCT ON (shard) *").
+			Where("base = ? AND split_part(shard, '/', 2) = ? AND started_at > ? AND coalesce(error, '') = ''", syncRecord.Base, strconv.Itoa(shard.Count), time.Time{}).
+			Order("shard, date DESC").Find(&shards).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	complete := len(shards) == shard.Count
+	var failed int64
+	for _, s := range shards {
+		complete = complete && !s.Partial
+		failed += s.Failed
+	}
+	if !complete {
+		slog.Info("Shard synced, the base is complete once every shard is", "base", syncRecord.Base, "shard", syncRecord.Shard)
+		return nil
+	}
+
+	slog.Info("Every shard is synced, completing the base", "base", syncRecord.Base, "shards", shard.Count)
+	prune(ctx, syncRecord.Base, failed, 0)
+	syncRecord.Complete = true
+	return database.DB.WithContext(ctx).Model(syncRecord).Update("complete", true).Error
+}
+
+// clearShardCheckpoints removes the checkpoints of the files of the shard of
+// this worker, the other shards keeping theirs.
+func clearShardCheckpoints(ctx context.Context) {
+	var files []string
+	if err := database.DB.WithContext(ctx).Model(&database.SyncCheckpoint{}).Distinct("file").Pluck("file", &files).Error; err != nil {
+		slog.Warn("Failed to clear sync checkpoints", "error", err)
+		return
+	}
+
+	var shardFiles []string
+	for _, file := range files {
+		if index, err := anna.ExtractFileIndex(path.Base(file)); err == nil && config.C.Anna.SyncShard.Contains(index) {
+			shardFiles = append(shardFiles, file)
+		}
+	}
+	if len(shardFiles) == 0 {
+		return
+	}
+	if err := database.DB.WithContext(ctx).Where("file IN ?", shardFiles).Delete(&database.SyncCheckpoint{}).Error; err != nil {
+		slog.Warn("Failed to clear sync checkpoints", "error", err)
+	}
+}
@@ -2,7 +2,9 @@ package sync
 
 import (
 	"sync"
+	"time"
 
+	"github.com/iziplay/anna-api/pkg/anna"
 	"github.com/iziplay/anna-api/pkg/database"
 )
 
@@ -11,6 +13,109 @@ type FileProgress struct {
 	Name       string  `json:"name"`
 	Downloaded float64 `json:"downloaded"` // percentage 0-100
 	Processed  float64 `json:"processed"`  // percentage 0-100
+	Error      string  `json:"error,omitempty"`
+	// Records processed so far, and lines that could not be parsed
+	Records     int64 `json:"records"`
+	ParseErrors int64 `json:"parseErrors"`
+	// Throughputs over the last rateWindow, bytes being compressed
+	RecordsPerSecond float64 `json:"recordsPerSecond"`
+	BytesPerSecond   float64 `json:"bytesPerSecond"`
+	// ETA is the estimated completion time, zero when unknown
+	ETA time.Time `json:"eta,omitzero"`
+	// Verified is set once the downloaded file passed its hash checks,
+	// CorruptPieces are the torrent pieces that failed them
+	Verified      bool `json:"verified,omitempty"`
+	CorruptPieces int  `json:"corruptPieces,omitempty"`
+
+	// bytes read so far, and the samples within rateWindow
+	bytes   int64
+	samples []rateSample
+}
+
+// rateWindow is the duration over which throughputs are computed
+const rateWindow = time.Minute
+
+type rateSample struct {
+	at        time.Time
+	records   int64
+	bytes     int64
+	processed float64
+}
+
+// sample records the current progress, and updates the throughputs and ETA.
+func (f *FileProgress) sample(now time.Time) {
+	f.samples = append(f.samples, rateSample{at: now, records: f.Records, bytes: f.bytes, processed: f.Processed})
+	for len(f.samples) > 2 && now.Sub(f.samples[1].at) > rateWindow {
+		f.samples = f.samples[1:]
+	}
+
+	f.RecordsPerSecond, f.BytesPerSecond, f.ETA = 0, 0, time.Time{}
+	first := f.samples[0]
+	elapsed := now.Sub(first.at).Seconds()
+	if elapsed <= 0 || f.Processed >= 100 {
+		return
+	}
+	f.RecordsPerSecond = float64(f.Records-first.records) / elapsed
+	f.BytesPerSecond = float64(f.bytes-first.bytes) / elapsed
+	if rate := (f.Processed - first.processed) / elapsed; rate > 0 {
+		f.ETA = now.Add(time.Duration((100 - f.Processed) / rate * float64(time.Second)))
+	}
+}
+
+// SwarmProgress is the download of the metadata torrent from its swarm
+type SwarmProgress struct {
+	// Peers and seeders connected
+	Peers   int `json:"peers"`
+	Seeders int `json:"seeders"`
+	// Bytes of the files left to download, and download rate over the last
+	// rateWindow
+	Remaining      int64   `json:"remaining"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	// ETA is the estimated end of the download, zero when unknown: a stalled
+	// swarm has no ETA
+	ETA time.Time `json:"eta,omitzero"`
+
+	samples []swarmSample
+}
+
+type swarmSample struct {
+	at        time.Time
+	completed int64
+}
+
+// sample records the bytes downloaded so far, and updates the rate and ETA.
+func (p *SwarmProgress) sample(now time.Time, completed int64) {
+	p.samples = append(p.samples, swarmSample{at: now, completed: completed})
+	for len(p.samples) > 2 && now.Sub(p.samples[1].at) > rateWindow {
+		p.samples = p.samples[1:]
+	}
+
+	p.BytesPerSecond, p.ETA = 0, time.Time{}
+	first := p.samples[0]
+	elapsed := now.Sub(first.at).Seconds()
+	if elapsed <= 0 || p.Remaining == 0 {
+		return
+	}
+	p.BytesPerSecond = float64(completed-first.completed) / elapsed
+	if p.BytesPerSecond > 0 {
+		p.ETA = now.Add(time.Duration(float64(p.Remaining) / p.BytesPerSecond * float64(time.Second)))
+	}
+}
+
+// DryRunFile counts what a dry-run sync would write for a metadata file
+type DryRunFile struct {
+	Name    string `json:"name"`
+	Inserts int64  `json:"inserts"` // records not stored yet
+	Updates int64  `json:"updates"` // records already stored
+	Skips   int64  `json:"skips"`   // records not synced, e.g. of another format, or unchanged
+}
+
+// DryRunReport is the result of the last dry-run sync, kept once it ends
+type DryRunReport struct {
+	Base      string       `json:"base"`
+	Running   bool         `json:"running"`
+	StartedAt time.Time    `json:"startedAt"`
+	Files     []DryRunFile `json:"files"`
 }
 
 // SyncStats holds the current sync progress information
@@ -19,16 +124,91 @@ type SyncStats struct {
 	IsRunning bool           `json:"isRunning"`
 	Base      string         `json:"base"`
 	Files     []FileProgress `json:"files"`
+	// Throughputs and ETA of the files being processed, together
+	RecordsPerSecond float64       `json:"recordsPerSecond"`
+	BytesPerSecond   float64       `json:"bytesPerSecond"`
+	ETA              time.Time     `json:"eta,omitzero"`
+	DryRun           *DryRunReport `json:"dryRun,omitempty"`
+	// Written counts the rows written by the running sync by type
+	Written *database.WrittenCounts `json:"written,omitempty"`
+	// Swarm is the download of the metadata torrent, when it's downloaded
+	Swarm *SwarmProgress `json:"swarm,omitempty"`
+
+	// written are the live counts of the running sync, Written those
+	// published by the leader
+	written *database.Written
 }
 
 var stats *SyncStats = &SyncStats{}
 
-// GetStats returns a copy of current sync stats
+// GetStats returns a copy of current sync stats, the ones of the leader
+// instance when this one is a follower
 func GetStats() SyncStats {
-	stats.mu.RLock()
-	defer stats.mu.RUnlock()
+	if !IsLeader() {
+		if published := leaderStats.Load(); published != nil {
+			return published.snapshot()
+		}
+		return SyncStats{}
+	}
+	return stats.snapshot()
+}
+
+func (s *SyncStats) snapshot() SyncStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var dryRun *DryRunReport
+	if s.DryRun != nil {
+		report := *s.DryRun
+		report.Files = append([]DryRunFile(nil), report.Files...)
+		dryRun = &report
+	}
+
+	written := s.Written
+	if s.written != nil {
+		counts := s.written.Counts()
+		written = &counts
+	}
+
+	var swarm *SwarmProgress
+	if s.Swarm != nil {
+		progress := *s.Swarm
+		progress.samples = nil
+		swarm = &progress
+	}
+
+	files := make([]FileProgress, len(s.Files))
+	var recordsPerSecond, bytesPerSecond float64
+	var eta time.Time
+	unknown := false
+	for i, file := range s.Files {
+		file.samples = nil
+		files[i] = file
+		recordsPerSecond += file.RecordsPerSecond
+		bytesPerSecond += file.BytesPerSecond
+		// The sync ends with the last file processed
+		if file.Processed < 100 && file.Error == "" {
+			unknown = unknown || file.ETA.IsZero()
+			if file.ETA.After(eta) {
+				eta = file.ETA
+			}
+		}
+	}
+	if unknown {
+		eta = time.Time{}
+	}
 
-	return *stats
+	return SyncStats{
+		IsRunning:        s.IsRunning,
+		Base:             s.Base,
+		Files:            files,
+		RecordsPerSecond: recordsPerSecond,
+		BytesPerSecond:   bytesPerSecond,
+		ETA:              eta,
+		DryRun:           dryRun,
+		Written:          written,
+		Swarm:            swarm,
+	}
 }
 
 // GetStatsInstance returns the stats instance for updating
@@ -43,6 +223,7 @@ func (s *SyncStats) StartSync(base string, files []string) {
 
 	s.IsRunning = true
 	s.Base = base
+	s.Swarm = nil
 	s.Files = make([]FileProgress, len(files))
 	for i, name := range files {
 		s.Files[i] = FileProgress{
@@ -63,6 +244,18 @@ func (s *SyncStats) UpdateFileDownload(index int, percent float64) {
 	}
 }
 
+// FailFile records why a file could not be processed
+func (s *SyncStats) FailFile(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Files {
+		if s.Files[i].Name == name {
+			s.Files[i].Error = err.Error()
+		}
+	}
+}
+
 // UpdateFileProcessed updates processing progress for a file
 func (s *SyncStats) UpdateFileProcessed(index int, percent float64) {
 	s.mu.Lock()
@@ -70,9 +263,116 @@ func (s *SyncStats) UpdateFileProcessed(index int, percent float64) {
 
 	if index >= 0 && index < len(s.Files) {
 		s.Files[index].Processed = percent
+		s.Files[index].sample(time.Now())
 	}
 }
 
+// UpdateFileRecords updates the records processed of a file, sampled with its
+// next processing progress
+func (s *SyncStats) UpdateFileRecords(index int, records int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index >= 0 && index < len(s.Files) {
+		s.Files[index].Records = records
+	}
+}
+
+// UpdateFileBytes updates the compressed bytes read of a file, sampled with
+// its next processing progress
+func (s *SyncStats) UpdateFileBytes(index int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index >= 0 && index < len(s.Files) {
+		s.Files[index].bytes = bytes
+	}
+}
+
+// UpdateFileParseErrors updates the lines of a file that could not be parsed
+func (s *SyncStats) UpdateFileParseErrors(index int, parseErrors int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index >= 0 && index < len(s.Files) {
+		s.Files[index].ParseErrors = parseErrors
+	}
+}
+
+// UpdateFileVerification records the hash checks of a downloaded file
+func (s *SyncStats) UpdateFileVerification(index int, corrupt int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index >= 0 && index < len(s.Files) {
+		s.Files[index].Verified = corrupt == 0
+		s.Files[index].CorruptPieces = corrupt
+	}
+}
+
+// UpdateSwarm updates a gauge of the swarm of the metadata torrent, the
+// completed bytes being sampled
+func (s *SyncStats) UpdateSwarm(statsType anna.StatsType, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Swarm == nil {
+		s.Swarm = &SwarmProgress{}
+	}
+	switch statsType {
+	case anna.StatsTypeSwarmPeers:
+		s.Swarm.Peers = int(value)
+	case anna.StatsTypeSwarmSeeders:
+		s.Swarm.Seeders = int(value)
+	case anna.StatsTypeSwarmRemaining:
+		s.Swarm.Remaining = int64(value)
+	case anna.StatsTypeSwarmCompleted:
+		s.Swarm.sample(time.Now(), int64(value))
+	}
+}
+
+// StartDryRun resets the dry-run report for the given files
+func (s *SyncStats) StartDryRun(base string, files []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.DryRun = &DryRunReport{
+		Base:      base,
+		Running:   true,
+		StartedAt: time.Now(),
+		Files:     make([]DryRunFile, len(files)),
+	}
+	for i, name := range files {
+		s.DryRun.Files[i].Name = name
+	}
+}
+
+// CountDryRun adds to the counts of a file of the dry-run report
+func (s *SyncStats) CountDryRun(name string, inserts, updates, skips int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.DryRun == nil {
+		return
+	}
+	for i := range s.DryRun.Files {
+		if file := &s.DryRun.Files[i]; file.Name == name {
+			file.Inserts += inserts
+			file.Updates += updates
+			file.Skips += skips
+			return
+		}
+	}
+}
+
+// CountWritten reports the rows counted by written until the sync ends
+func (s *SyncStats) CountWritten(written *database.Written) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.written = written
+}
+
 // EndSync marks the sync as completed and refreshes the stats cache
 func (s *SyncStats) EndSync() {
 	s.mu.Lock()
@@ -81,6 +381,11 @@ func (s *SyncStats) EndSync() {
 	s.IsRunning = false
 	s.Base = ""
 	s.Files = nil
+	s.Swarm = nil
+	s.written = nil
+	if s.DryRun != nil {
+		s.DryRun.Running = false
+	}
 
 	database.ComputeAndCacheStats(true)
 }
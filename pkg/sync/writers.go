@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"context"
+	"sync"
+
+	"github.com/iziplay/anna-api/pkg/anna"
+)
+
+// writerPool writes the parsed records to the database from a bounded queue,
+// so that slow database round trips don't stall the reads of the torrent.
+// Parsing blocks when the queue is full. Queued records are still written once
+// the sync is cancelled, so that its progress can be saved.
+type writerPool struct {
+	queue chan queuedRecord
+
+	wg sync.WaitGroup
+	// pending counts the records of each file not written yet
+	mu      sync.Mutex
+	pending map[string]*sync.WaitGroup
+}
+
+type queuedRecord struct {
+	path   string
+	record *anna.Record
+}
+
+// startWriters starts n goroutines calling write for the records queued, at
+// most size of them waiting.
+func startWriters(ctx context.Context, n, size int, write func(context.Context, *anna.Record)) *writerPool {
+	p := &writerPool{
+		queue:   make(chan queuedRecord, max(size, 0)),
+		pending: map[string]*sync.WaitGroup{},
+	}
+	for range max(n, 1) {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			ctx := context.WithoutCancel(ctx)
+			for queued := range p.queue {
+				write(ctx, queued.record)
+				p.file(queued.path).Done()
+			}
+		}()
+	}
+	return p
+}
+
+func (p *writerPool) file(path string) *sync.WaitGroup {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wg, ok := p.pending[path]
+	if !ok {
+		wg = &sync.WaitGroup{}
+		p.pending[path] = wg
+	}
+	return wg
+}
+
+// add queues a record of the file at path, waiting for room in the queue.
+func (p *writerPool) add(path string, record *anna.Record) {
+	p.file(path).Add(1)
+	p.queue <- queuedRecord{path: path, record: record}
+}
+
+// wait waits for the records of the file at path queued so far to be written.
+// The records of a file must be added by a single goroutine, which calls wait.
+func (p *writerPool) wait(path string) {
+	p.file(path).Wait()
+}
+
+// close waits for the queued records to be written. No record can be added afterwards.
+func (p *writerPool) close() {
+	close(p.queue)
+	p.wg.Wait()
+}
@@ -0,0 +1,139 @@
+// Package webhook delivers API events to the subscribed external endpoints as
+// signed HTTP POST requests.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/iziplay/anna-api/pkg/database"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Event is the type of an event webhooks can subscribe to.
+type Event string
+
+const (
+	EventSyncStarted       Event = "sync.started"
+	EventSyncCompleted     Event = "sync.completed"
+	EventPrefetchCompleted Event = "prefetch.completed"
+	EventDownloadFailed    Event = "download.failed"
+)
+
+const (
+	// maxAttempts is the number of times a delivery is tried before giving up
+	maxAttempts = 5
+	// firstRetryDelay is doubled after each failed attempt
+	firstRetryDelay = 2 * time.Second
+)
+
+var client = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// Payload is the JSON body POSTed to webhooks.
+type Payload struct {
+	ID        string    `json:"id"`
+	Event     Event     `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// NewSecret returns a random secret to sign deliveries with.
+func NewSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sign returns the value of the X-Anna-Signature header of a delivery: the
+// hex-encoded HMAC-SHA256 of the body with the webhook secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish delivers an event to the webhooks subscribed to it in the background.
+func Publish(ctx context.Context, event Event, data any) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		webhooks, err := database.WebhooksForEvent(ctx, string(event))
+		if err != nil {
+			slog.Error("Failed to load webhooks", "event", event, "error", err)
+			return
+		}
+		if len(webhooks) == 0 {
+			return
+		}
+
+		id := make([]byte, 16)
+		rand.Read(id)
+		body, err := json.Marshal(Payload{
+			ID:        hex.EncodeToString(id),
+			Event:     event,
+			CreatedAt: time.Now().UTC(),
+			Data:      data,
+		})
+		if err != nil {
+			slog.Error("Failed to encode webhook payload", "event", event, "error", err)
+			return
+		}
+
+		for _, w := range webhooks {
+			go deliver(ctx, w, event, body)
+		}
+	}()
+}
+
+// deliver POSTs a payload to a webhook, retrying with exponential backoff.
+func deliver(ctx context.Context, w database.Webhook, event Event, body []byte) {
+	delay := firstRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := send(ctx, w, event, body)
+		if err == nil {
+			slog.Debug("Webhook delivered", "webhook", w.ID, "event", event, "attempt", attempt)
+			return
+		}
+		if attempt == maxAttempts {
+			slog.Warn("Giving up webhook delivery", "webhook", w.ID, "event", event, "attempts", attempt, "error", err)
+			return
+		}
+
+		slog.Debug("Webhook delivery failed, retrying", "webhook", w.ID, "event", event, "attempt", attempt, "in", delay, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func send(ctx context.Context, w database.Webhook, event Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "anna-api-webhooks")
+	req.Header.Set("X-Anna-Event", string(event))
+	req.Header.Set("X-Anna-Signature", Sign(w.Secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}